@@ -0,0 +1,230 @@
+// Package errors contains a catalog of the errors that the Stripe API can
+// return. It's used to simulate errors on request so that an integration's
+// error handling can be exercised without relying on special inputs like test
+// card numbers.
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//
+// Public types
+//
+
+// Error is an error that the Stripe API can return.
+type Error struct {
+	// Code is a short string identifying the error. Empty for errors that are
+	// only identified by their type.
+	Code string
+
+	// DeclineCode is the reason that a card was declined. Only set for some
+	// card errors.
+	DeclineCode string
+
+	// Message is a human-readable message describing the error.
+	Message string
+
+	// Status is the HTTP status code that the error is returned with.
+	Status int
+
+	// Type is the type of the error (e.g. `card_error`).
+	Type string
+}
+
+// IsPaymentFailure checks whether the error is a payment that failed, like a
+// declined card. The Stripe API includes the charge or PaymentIntent of the
+// failed payment in these errors.
+func (e *Error) IsPaymentFailure() bool {
+	return e.Type == typeCardError
+}
+
+//
+// Public functions
+//
+
+// Parse finds the error described by a string of the form `type:code` (e.g.
+// `card_error:card_declined`), or just `type` for errors that don't have a
+// code (e.g. `api_error`).
+//
+// Card errors may also specify a decline code as a third component (e.g.
+// `card_error:card_declined:insufficient_funds`). Without one, a card error's
+// most generic decline code is used.
+//
+// An error is returned if the string doesn't describe an error in the
+// catalog.
+func Parse(s string) (*Error, error) {
+	parts := strings.SplitN(s, ":", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	errorType, code, declineCode := parts[0], parts[1], parts[2]
+
+	for _, e := range catalog {
+		if e.Type != errorType || e.Code != code {
+			continue
+		}
+		if declineCode != "" && e.DeclineCode != declineCode {
+			continue
+		}
+
+		errCopy := e
+		return &errCopy, nil
+	}
+
+	return nil, fmt.Errorf("Unrecognized error: %s. Errors should look like "+
+		"`card_error:card_declined` or `api_error`.", s)
+}
+
+//
+// Private values
+//
+
+// catalog contains every error that can be simulated. Where several errors
+// share a type and code, the first one is used unless a decline code is
+// specified.
+var catalog = []Error{
+	//
+	// api_error
+	//
+
+	{
+		Message: "An unknown error occurred",
+		Status:  http.StatusInternalServerError,
+		Type:    typeAPIError,
+	},
+
+	//
+	// authentication_error
+	//
+
+	{
+		Message: "Invalid API Key provided: sk_test_****1234",
+		Status:  http.StatusUnauthorized,
+		Type:    typeAuthenticationError,
+	},
+
+	//
+	// card_error
+	//
+
+	{
+		Code:        "card_declined",
+		DeclineCode: "generic_decline",
+		Message:     "Your card was declined.",
+		Status:      http.StatusPaymentRequired,
+		Type:        typeCardError,
+	},
+	{
+		Code:        "card_declined",
+		DeclineCode: "fraudulent",
+		Message:     "Your card was declined.",
+		Status:      http.StatusPaymentRequired,
+		Type:        typeCardError,
+	},
+	{
+		Code:        "card_declined",
+		DeclineCode: "insufficient_funds",
+		Message:     "Your card has insufficient funds.",
+		Status:      http.StatusPaymentRequired,
+		Type:        typeCardError,
+	},
+	{
+		Code:        "card_declined",
+		DeclineCode: "lost_card",
+		Message:     "Your card was declined.",
+		Status:      http.StatusPaymentRequired,
+		Type:        typeCardError,
+	},
+	{
+		Code:        "card_declined",
+		DeclineCode: "stolen_card",
+		Message:     "Your card was declined.",
+		Status:      http.StatusPaymentRequired,
+		Type:        typeCardError,
+	},
+	{
+		Code:        "expired_card",
+		DeclineCode: "expired_card",
+		Message:     "Your card has expired.",
+		Status:      http.StatusPaymentRequired,
+		Type:        typeCardError,
+	},
+	{
+		Code:        "incorrect_cvc",
+		DeclineCode: "incorrect_cvc",
+		Message:     "Your card's security code is incorrect.",
+		Status:      http.StatusPaymentRequired,
+		Type:        typeCardError,
+	},
+	{
+		Code:    "incorrect_number",
+		Message: "Your card number is incorrect.",
+		Status:  http.StatusPaymentRequired,
+		Type:    typeCardError,
+	},
+	{
+		Code: "processing_error",
+		Message: "An error occurred while processing your card. Try again in " +
+			"a little bit.",
+		Status: http.StatusPaymentRequired,
+		Type:   typeCardError,
+	},
+
+	//
+	// idempotency_error
+	//
+
+	{
+		Message: "Keys for idempotent requests can only be used with the " +
+			"same parameters they were first used with.",
+		Status: http.StatusBadRequest,
+		Type:   typeIdempotencyError,
+	},
+
+	//
+	// invalid_request_error
+	//
+
+	{
+		Message: "Invalid request.",
+		Status:  http.StatusBadRequest,
+		Type:    typeInvalidRequestError,
+	},
+	{
+		Code:    "parameter_missing",
+		Message: "Missing required param.",
+		Status:  http.StatusBadRequest,
+		Type:    typeInvalidRequestError,
+	},
+	{
+		Code:    "resource_missing",
+		Message: "No such object.",
+		Status:  http.StatusNotFound,
+		Type:    typeInvalidRequestError,
+	},
+
+	//
+	// rate_limit_error
+	//
+
+	{
+		Code: "rate_limit",
+		Message: "Too many requests hit the API too quickly. We recommend an " +
+			"exponential backoff of your requests.",
+		Status: http.StatusTooManyRequests,
+		Type:   typeRateLimitError,
+	},
+}
+
+// Types of errors.
+const (
+	typeAPIError            = "api_error"
+	typeAuthenticationError = "authentication_error"
+	typeCardError           = "card_error"
+	typeIdempotencyError    = "idempotency_error"
+	typeInvalidRequestError = "invalid_request_error"
+	typeRateLimitError      = "rate_limit_error"
+)
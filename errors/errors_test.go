@@ -0,0 +1,75 @@
+package errors
+
+import (
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestParse(t *testing.T) {
+	e, err := Parse("card_error:card_declined")
+	assert.NoError(t, err)
+	assert.Equal(t, &Error{
+		Code:        "card_declined",
+		DeclineCode: "generic_decline",
+		Message:     "Your card was declined.",
+		Status:      http.StatusPaymentRequired,
+		Type:        "card_error",
+	}, e)
+
+	// With a decline code
+	e, err = Parse("card_error:card_declined:insufficient_funds")
+	assert.NoError(t, err)
+	assert.Equal(t, "card_declined", e.Code)
+	assert.Equal(t, "insufficient_funds", e.DeclineCode)
+	assert.Equal(t, "Your card has insufficient funds.", e.Message)
+
+	_, err = Parse("card_error:card_declined:not_a_decline_code")
+	assert.Error(t, err)
+
+	// Errors without a code
+	e, err = Parse("api_error")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, e.Status)
+	assert.Equal(t, "", e.Code)
+
+	e, err = Parse("rate_limit_error:rate_limit")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, e.Status)
+
+	// Unknown types and codes
+	_, err = Parse("card_error:not_a_code")
+	assert.Error(t, err)
+
+	_, err = Parse("not_a_type")
+	assert.Error(t, err)
+
+	// A code belongs to a particular type
+	_, err = Parse("invalid_request_error:card_declined")
+	assert.Error(t, err)
+}
+
+func TestError_IsPaymentFailure(t *testing.T) {
+	e, err := Parse("card_error:expired_card")
+	assert.NoError(t, err)
+	assert.True(t, e.IsPaymentFailure())
+
+	e, err = Parse("rate_limit_error:rate_limit")
+	assert.NoError(t, err)
+	assert.False(t, e.IsPaymentFailure())
+}
+
+func TestParse_ReturnsCopy(t *testing.T) {
+	e, err := Parse("card_error:card_declined")
+	assert.NoError(t, err)
+	e.Message = "changed"
+
+	e, err = Parse("card_error:card_declined")
+	assert.NoError(t, err)
+	assert.Equal(t, "Your card was declined.", e.Message)
+}
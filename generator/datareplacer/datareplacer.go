@@ -2,6 +2,8 @@ package datareplacer
 
 import (
 	"reflect"
+
+	"github.com/stripe/stripe-mock/spec"
 )
 
 // ReplaceData takes a generated response and replaces values in it that share
@@ -10,40 +12,50 @@ import (
 // This is designed to have the effect of making returned fixtures more
 // realistic while also staying a simple heuristic that doesn't require very
 // much maintenance.
-func ReplaceData(requestData map[string]interface{}, responseData map[string]interface{}) map[string]interface{} {
+//
+// schema is the schema of the response and may be nil. When given, scalar
+// parameters are also reflected into properties that are null or missing in
+// the response as long as the schema says they're of the right type. Fixtures
+// often leave optional fields like `description` null, but if one was given
+// to us with the request, we probably want to include it. Parameters that the
+// schema marks as write-only are never reflected.
+func ReplaceData(requestData map[string]interface{}, responseData map[string]interface{}, schema *spec.Schema) map[string]interface{} {
 	for k, requestValue := range requestData {
+		if subSchema := propertySchema(schema, k); subSchema != nil && subSchema.WriteOnly {
+			continue
+		}
+
 		responseValue, ok := responseData[k]
 
-		// Recursively call in to replace data, but only if the key is
-		// in both maps.
-		//
-		// A fairly obvious improvement here is if a key is in the
-		// request but not present in the response, then check the
-		// canonical schema to see if it's there. It might be an
-		// optional field that doesn't appear in the fixture, and if it
-		// was given to us with the request, we probably want to
-		// include it.
-		if ok {
-			requestKeyMap, requestKeyOK := requestValue.(map[string]interface{})
-			responseKeyMap, responseKeyOK := responseValue.(map[string]interface{})
-
-			if requestKeyOK && responseKeyOK {
-				responseData[k] = ReplaceData(requestKeyMap, responseKeyMap)
-			} else {
-				// In the non-map case, just set the respons key's value to
-				// what was in the request, but only if both values are the
-				// same type (this is to prevent problems where a field is set
-				// as an ID, but the response field is the hydrated object of
-				// that).
-				//
-				// While this will largely be "good enough", there's some
-				// obvious cases that aren't going to be handled correctly like
-				// index-based array updates (e.g.,
-				// `additional_owners[1][name]=...`). I'll have to iron out
-				// that rough edges later on.
-				if isSameType(requestValue, responseValue) {
-					responseData[k] = requestValue
-				}
+		if !ok || responseValue == nil {
+			if isScalarOfSchemaType(requestValue, propertySchema(schema, k)) {
+				responseData[k] = requestValue
+			}
+			continue
+		}
+
+		// Recursively call in to replace data, but only if the key is in
+		// both maps.
+		requestKeyMap, requestKeyOK := requestValue.(map[string]interface{})
+		responseKeyMap, responseKeyOK := responseValue.(map[string]interface{})
+
+		if requestKeyOK && responseKeyOK {
+			responseData[k] = ReplaceData(requestKeyMap, responseKeyMap,
+				propertySchema(schema, k))
+		} else {
+			// In the non-map case, just set the respons key's value to
+			// what was in the request, but only if both values are the
+			// same type (this is to prevent problems where a field is set
+			// as an ID, but the response field is the hydrated object of
+			// that).
+			//
+			// While this will largely be "good enough", there's some
+			// obvious cases that aren't going to be handled correctly like
+			// index-based array updates (e.g.,
+			// `additional_owners[1][name]=...`). I'll have to iron out
+			// that rough edges later on.
+			if isSameType(requestValue, responseValue) {
+				responseData[k] = requestValue
 			}
 		}
 	}
@@ -65,5 +77,72 @@ func isSameType(v1, v2 interface{}) bool {
 		return false
 	}
 
+	// Integers in fixtures are decoded from JSON as floats while integer
+	// parameters are coerced to ints, so treat all numbers as the same type.
+	if isNumber(v1Value) && isNumber(v2Value) {
+		return true
+	}
+
 	return v1Value.Type() == v2Value.Type()
 }
+
+// isNumber checks whether a value is any kind of integer or float.
+func isNumber(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// isScalarOfSchemaType checks whether a value is a scalar of the type that a
+// schema describes. A schema with `anyOf` matches when any of its branches
+// does, so an ID matches an expandable field like a charge's `customer`.
+// Returns false for a nil schema, and for one that's not a scalar type or
+// whose enum doesn't include the value.
+func isScalarOfSchemaType(v interface{}, schema *spec.Schema) bool {
+	if schema == nil {
+		return false
+	}
+
+	for _, branch := range schema.AnyOf {
+		if isScalarOfSchemaType(v, branch) {
+			return true
+		}
+	}
+
+	var ok bool
+	switch schema.Type {
+	case spec.TypeBoolean:
+		_, ok = v.(bool)
+	case spec.TypeInteger:
+		_, ok = v.(int)
+	case spec.TypeNumber:
+		ok = isNumber(reflect.ValueOf(v))
+	case spec.TypeString:
+		_, ok = v.(string)
+	}
+	if !ok {
+		return false
+	}
+
+	if len(schema.Enum) == 0 {
+		return true
+	}
+	for _, enumValue := range schema.Enum {
+		if enumValue == v {
+			return true
+		}
+	}
+	return false
+}
+
+// propertySchema gets the schema of a property from an object's schema.
+// Returns nil if the object's schema is nil or doesn't describe the property.
+func propertySchema(schema *spec.Schema, name string) *spec.Schema {
+	if schema == nil {
+		return nil
+	}
+	return schema.Properties[name]
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/spec"
 )
 
 //
@@ -17,7 +18,7 @@ func TestReplaceData_Basic(t *testing.T) {
 
 	ReplaceData(map[string]interface{}{
 		"foo": "request-value",
-	}, responseData)
+	}, responseData, nil)
 
 	assert.Equal(t, map[string]interface{}{
 		"foo": "request-value",
@@ -36,7 +37,7 @@ func TestReplaceData_Array(t *testing.T) {
 		"arr": []string{
 			"request-value",
 		},
-	}, responseData)
+	}, responseData, nil)
 
 	assert.Equal(t, map[string]interface{}{
 		"arr": []string{
@@ -56,7 +57,7 @@ func TestReplaceData_Recursive(t *testing.T) {
 		"map": map[string]interface{}{
 			"nested": "request-value",
 		},
-	}, responseData)
+	}, responseData, nil)
 
 	assert.Equal(t, map[string]interface{}{
 		"map": map[string]interface{}{
@@ -72,7 +73,7 @@ func TestReplaceData_DontReplaceOnDifferentFields(t *testing.T) {
 
 	ReplaceData(map[string]interface{}{
 		"foo": "request-value",
-	}, responseData)
+	}, responseData, nil)
 
 	assert.Equal(t, map[string]interface{}{
 		"other": "other-value",
@@ -86,9 +87,118 @@ func TestReplaceData_DontReplaceOnDifferentTypes(t *testing.T) {
 
 	ReplaceData(map[string]interface{}{
 		"foo": 7,
-	}, responseData)
+	}, responseData, nil)
 
 	assert.Equal(t, map[string]interface{}{
 		"foo": "response-value",
 	}, responseData)
 }
+
+func TestReplaceData_Numbers(t *testing.T) {
+	// Fixture integers are decoded as floats
+	responseData := map[string]interface{}{
+		"amount": 100.0,
+	}
+
+	ReplaceData(map[string]interface{}{
+		"amount": 123,
+	}, responseData, nil)
+
+	assert.Equal(t, map[string]interface{}{
+		"amount": 123,
+	}, responseData)
+}
+
+func TestReplaceData_WithSchema(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"description": {Nullable: true, Type: spec.TypeString},
+			"email":       {Type: spec.TypeString},
+			"interval": {
+				Enum: []interface{}{"day", "month"},
+				Type: spec.TypeString,
+			},
+			"quantity": {Type: spec.TypeInteger},
+		},
+		Type: spec.TypeObject,
+	}
+
+	responseData := map[string]interface{}{
+		"description": nil,
+		"interval":    nil,
+	}
+
+	ReplaceData(map[string]interface{}{
+		"description": "request-value",
+		"email":       "jenny.rosen@example.com",
+		"interval":    "year",
+		"quantity":    "not-an-integer",
+		"unknown":     "request-value",
+	}, responseData, schema)
+
+	assert.Equal(t, map[string]interface{}{
+		"description": "request-value",
+		"email":       "jenny.rosen@example.com",
+		"interval":    nil,
+	}, responseData)
+}
+
+func TestReplaceData_WithSchemaAnyOf(t *testing.T) {
+	// Like a charge's `customer`, which is an ID unless it's expanded
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"customer": {
+				AnyOf: []*spec.Schema{
+					{Type: spec.TypeString},
+					{Ref: "#/components/schemas/customer"},
+				},
+				Nullable: true,
+			},
+			"source": {
+				AnyOf: []*spec.Schema{
+					{Ref: "#/components/schemas/card"},
+				},
+				Nullable: true,
+			},
+		},
+		Type: spec.TypeObject,
+	}
+
+	responseData := map[string]interface{}{
+		"customer": nil,
+		"source":   nil,
+	}
+
+	ReplaceData(map[string]interface{}{
+		"customer": "cus_123",
+		"source":   "tok_123",
+	}, responseData, schema)
+
+	assert.Equal(t, map[string]interface{}{
+		"customer": "cus_123",
+		"source":   nil,
+	}, responseData)
+}
+
+func TestReplaceData_WriteOnly(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"last4":  {Type: spec.TypeString},
+			"number": {Type: spec.TypeString, WriteOnly: true},
+		},
+		Type: spec.TypeObject,
+	}
+
+	responseData := map[string]interface{}{
+		"last4": "4242",
+	}
+
+	ReplaceData(map[string]interface{}{
+		"last4":  "1881",
+		"number": "4012888888881881",
+	}, responseData, schema)
+
+	assert.Equal(t, map[string]interface{}{
+		"last4": "1881",
+	}, responseData)
+}
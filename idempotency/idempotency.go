@@ -0,0 +1,154 @@
+// Package idempotency provides a cache of responses keyed by the idempotency
+// keys of the requests that produced them. It allows stripe-mock to replay a
+// response when a request is retried with the same `Idempotency-Key` header,
+// like the Stripe API does.
+package idempotency
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//
+// Public values
+//
+
+// ErrParamsMismatch is returned by Cache.Lookup when an idempotency key is
+// reused with parameters other than the ones it was first used with.
+var ErrParamsMismatch = fmt.Errorf("idempotency key reused with different parameters")
+
+//
+// Public functions
+//
+
+// Fingerprint produces a string that identifies a set of request parameters.
+// It should be called before the parameters are coerced or otherwise
+// modified. Maps are printed with sorted keys, so equal parameters always
+// produce the same fingerprint.
+func Fingerprint(params map[string]interface{}) string {
+	return fmt.Sprintf("%#v", params)
+}
+
+//
+// Public types
+//
+
+// Cache is a concurrency-safe, in-memory cache of responses.
+//
+// Responses are keyed by idempotency key and request path so that a key
+// reused for a different endpoint doesn't produce a replay. They're also
+// scoped to whoever made the request, like in the Stripe API, so that users
+// that happen to use the same key don't see each other's responses. Each response
+// expires after the cache's TTL, after which its key may be used again.
+type Cache struct {
+	mu        sync.Mutex
+	responses map[cacheKey]*cachedResponse
+	ttl       time.Duration
+
+	// now returns the current time. It's a field so that it can be replaced
+	// in tests.
+	now func() time.Time
+}
+
+// NewCache initializes a new empty Cache whose responses expire after ttl.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		responses: make(map[cacheKey]*cachedResponse),
+		ttl:       ttl,
+		now:       time.Now,
+	}
+}
+
+// Lookup finds the response saved for an idempotency key and request path
+// within a scope. Returns nil if no unexpired response was found.
+//
+// scope identifies who made the request, like their API key and the account
+// that it was made on behalf of. It may be empty if requests aren't scoped.
+//
+// fingerprint identifies the parameters of the request being made (see
+// Fingerprint). If they differ from those of the request that produced the
+// saved response, ErrParamsMismatch is returned instead.
+func (c *Cache) Lookup(scope, key, path, fingerprint string) (*Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.responses[cacheKey{key: key, path: path, scope: scope}]
+	if !ok || c.expired(cached) {
+		return nil, nil
+	}
+
+	if cached.fingerprint != fingerprint {
+		return nil, ErrParamsMismatch
+	}
+
+	return cached.response, nil
+}
+
+// Reset removes all responses from the cache so that any idempotency key can
+// be used again.
+func (c *Cache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.responses = make(map[cacheKey]*cachedResponse)
+}
+
+// Save saves the response for an idempotency key and request path within a
+// scope (see Lookup) along with the fingerprint of the request that produced
+// it.
+func (c *Cache) Save(scope, key, path, fingerprint string, response *Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Take the opportunity to drop any expired responses so that the cache
+	// doesn't grow without bound.
+	for k, cached := range c.responses {
+		if c.expired(cached) {
+			delete(c.responses, k)
+		}
+	}
+
+	c.responses[cacheKey{key: key, path: path, scope: scope}] = &cachedResponse{
+		fingerprint: fingerprint,
+		response:    response,
+		savedAt:     c.now(),
+	}
+}
+
+// expired checks whether a cached response has outlived the cache's TTL. It
+// should only be called while holding the cache's lock.
+func (c *Cache) expired(cached *cachedResponse) bool {
+	return c.now().Sub(cached.savedAt) >= c.ttl
+}
+
+// Response is a response saved in a Cache.
+type Response struct {
+	// Data is the response's body before it's encoded.
+	Data interface{}
+
+	// Status is the response's HTTP status code.
+	Status int
+}
+
+//
+// Private types
+//
+
+// cacheKey is the key under which a response is stored in a Cache.
+type cacheKey struct {
+	key   string
+	path  string
+	scope string
+}
+
+// cachedResponse is a response stored in a Cache along with the information
+// needed to decide whether it can be replayed.
+type cachedResponse struct {
+	// fingerprint identifies the parameters of the request that produced the
+	// response.
+	fingerprint string
+
+	response *Response
+	savedAt  time.Time
+}
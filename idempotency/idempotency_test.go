@@ -0,0 +1,116 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestCache_LookupAndSave(t *testing.T) {
+	c := NewCache(time.Hour)
+	fingerprint := Fingerprint(map[string]interface{}{"amount": "123"})
+
+	response, err := c.Lookup("", "key_123", "/v1/charges", fingerprint)
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+
+	saved := &Response{Data: map[string]interface{}{"id": "ch_123"}, Status: 200}
+	c.Save("", "key_123", "/v1/charges", fingerprint, saved)
+
+	response, err = c.Lookup("", "key_123", "/v1/charges", fingerprint)
+	assert.NoError(t, err)
+	assert.Equal(t, saved, response)
+
+	// The same key on a different path is unrelated
+	response, err = c.Lookup("", "key_123", "/v1/customers", fingerprint)
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func TestCache_Scope(t *testing.T) {
+	c := NewCache(time.Hour)
+	fingerprint := Fingerprint(map[string]interface{}{"amount": "123"})
+
+	saved := &Response{Data: map[string]interface{}{"id": "ch_123"}, Status: 200}
+	c.Save("sk_test_123", "key_123", "/v1/charges", fingerprint, saved)
+
+	response, err := c.Lookup("sk_test_123", "key_123", "/v1/charges", fingerprint)
+	assert.NoError(t, err)
+	assert.Equal(t, saved, response)
+
+	// The same key in another scope is unrelated, even with other parameters
+	response, err = c.Lookup("sk_test_456", "key_123", "/v1/charges",
+		Fingerprint(map[string]interface{}{"amount": "456"}))
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func TestCache_ParamsMismatch(t *testing.T) {
+	c := NewCache(time.Hour)
+
+	c.Save("", "key_123", "/v1/charges",
+		Fingerprint(map[string]interface{}{"amount": "123"}),
+		&Response{Status: 200})
+
+	_, err := c.Lookup("", "key_123", "/v1/charges",
+		Fingerprint(map[string]interface{}{"amount": "456"}))
+	assert.Equal(t, ErrParamsMismatch, err)
+
+	_, err = c.Lookup("", "key_123", "/v1/charges", Fingerprint(nil))
+	assert.Equal(t, ErrParamsMismatch, err)
+}
+
+func TestCache_Expiry(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	c := NewCache(time.Hour)
+	c.now = func() time.Time { return now }
+
+	fingerprint := Fingerprint(map[string]interface{}{"amount": "123"})
+	c.Save("", "key_123", "/v1/charges", fingerprint, &Response{Status: 200})
+
+	now = now.Add(59 * time.Minute)
+	response, err := c.Lookup("", "key_123", "/v1/charges", fingerprint)
+	assert.NoError(t, err)
+	assert.NotNil(t, response)
+
+	// Once expired, the key can even be reused with different parameters
+	now = now.Add(time.Minute)
+	response, err = c.Lookup("", "key_123", "/v1/charges",
+		Fingerprint(map[string]interface{}{"amount": "456"}))
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+
+	// Expired responses are dropped on the next save
+	c.Save("", "key_456", "/v1/charges", fingerprint, &Response{Status: 200})
+	assert.Equal(t, 1, len(c.responses))
+}
+
+func TestCache_Reset(t *testing.T) {
+	c := NewCache(time.Hour)
+
+	c.Save("", "key_123", "/v1/charges",
+		Fingerprint(map[string]interface{}{"amount": "123"}),
+		&Response{Status: 200})
+	c.Reset()
+
+	response, err := c.Lookup("", "key_123", "/v1/charges",
+		Fingerprint(map[string]interface{}{"amount": "456"}))
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func TestFingerprint(t *testing.T) {
+	assert.Equal(t,
+		Fingerprint(map[string]interface{}{"a": "1", "b": []interface{}{"2"}}),
+		Fingerprint(map[string]interface{}{"b": []interface{}{"2"}, "a": "1"}))
+
+	assert.NotEqual(t,
+		Fingerprint(map[string]interface{}{"a": "1"}),
+		Fingerprint(map[string]interface{}{"a": "2"}))
+}
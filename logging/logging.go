@@ -0,0 +1,178 @@
+// Package logging writes stripe-mock's logs. Messages are written along with
+// fields that give them context, either as plain text or as one JSON object
+// per line so that they can be collected by a log aggregator.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a log message. Messages that are less severe
+// than the level chosen with -log-level aren't written.
+type logLevel int
+
+// The log levels, from most to least severe.
+const (
+	logLevelError logLevel = iota
+	logLevelInfo
+	logLevelDebug
+)
+
+// logLevelNames are the names of the log levels as accepted by -log-level and
+// written in logs.
+var logLevelNames = map[logLevel]string{
+	logLevelError: "error",
+	logLevelInfo:  "info",
+	logLevelDebug: "debug",
+}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// parseLogLevel parses the name of a log level like `debug`.
+func parseLogLevel(name string) (logLevel, error) {
+	for level, levelName := range logLevelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level: %s (expected error, info, or debug)", name)
+}
+
+// logger writes log messages along with fields that give them context. Messages
+// are written as plain text by default, or as one JSON object per line.
+type logger struct {
+	json  bool
+	level logLevel
+	mu    sync.Mutex
+	out   io.Writer
+
+	// now returns the current time. It's a field so that it can be replaced
+	// in tests.
+	now func() time.Time
+}
+
+// defaultLogger is the logger used by the logging functions below. It's
+// set up with Configure.
+var defaultLogger = &logger{level: logLevelInfo, now: time.Now, out: os.Stdout}
+
+// Configure sets up the logger used by the logging functions from the names
+// of a level and a format, like the values of -log-level and -log-format. JSON logs are written to stderr so that they can be collected
+// separately from stripe-mock's other output.
+func Configure(levelName, format string) error {
+	level, err := parseLogLevel(levelName)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		defaultLogger.json = true
+		defaultLogger.out = os.Stderr
+	case "text":
+	default:
+		return fmt.Errorf("Unknown log format: %s (expected text or json)", format)
+	}
+
+	defaultLogger.level = level
+	return nil
+}
+
+// enabled checks whether messages of the given level are written. It's useful
+// to avoid preparing fields that are expensive to produce.
+func (l *logger) enabled(level logLevel) bool {
+	return level <= l.level
+}
+
+// log writes a message of the given level if it's enabled. keyvals are
+// alternating field names and values like `"status", 200`.
+func (l *logger) log(level logLevel, message string, keyvals ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	var line string
+	if l.json {
+		line = l.formatJSON(level, message, keyvals)
+	} else {
+		line = formatText(message, keyvals)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.out, line)
+}
+
+// formatJSON formats a message as a JSON object that includes its time, level,
+// and fields.
+func (l *logger) formatJSON(level logLevel, message string, keyvals []interface{}) string {
+	entry := map[string]interface{}{
+		"level":   level.String(),
+		"message": message,
+		"time":    l.now().UTC().Format(time.RFC3339Nano),
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		entry[fmt.Sprint(keyvals[i])] = jsonLogValue(keyvals[i+1])
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		// Fields are meant to be simple values, but fall back to text rather
+		// than lose the message if one can't be encoded.
+		return formatText(message, keyvals)
+	}
+	return string(data)
+}
+
+// formatText formats a message and its fields as plain text like `Response
+// status=200 elapsed=1ms`.
+func formatText(message string, keyvals []interface{}) string {
+	var b strings.Builder
+	b.WriteString(message)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", keyvals[i], keyvals[i+1])
+	}
+	return b.String()
+}
+
+// jsonLogValue converts a field value to one that encodes usefully as JSON.
+// Errors and durations (which are Stringers) would otherwise be encoded as an
+// empty object and a number of nanoseconds.
+func jsonLogValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// Debug logs a message with the debug level. keyvals are alternating field
+// names and values like `"status", 200`.
+func Debug(message string, keyvals ...interface{}) {
+	defaultLogger.log(logLevelDebug, message, keyvals...)
+}
+
+// DebugEnabled checks whether debug messages are being logged. It's useful to
+// avoid preparing fields that are expensive to produce.
+func DebugEnabled() bool {
+	return defaultLogger.enabled(logLevelDebug)
+}
+
+// Error logs a message with the error level. See Debug.
+func Error(message string, keyvals ...interface{}) {
+	defaultLogger.log(logLevelError, message, keyvals...)
+}
+
+// Info logs a message with the info level. See Debug.
+func Info(message string, keyvals ...interface{}) {
+	defaultLogger.log(logLevelInfo, message, keyvals...)
+}
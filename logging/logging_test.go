@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestLogger_JSON(t *testing.T) {
+	var out bytes.Buffer
+	l := &logger{
+		json:  true,
+		level: logLevelInfo,
+		now:   func() time.Time { return time.Unix(1234567890, 0) },
+		out:   &out,
+	}
+
+	l.log(logLevelInfo, "Response", "status", 200,
+		"elapsed", 1500*time.Millisecond, "error", fmt.Errorf("broken"))
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(out.Bytes(), &entry)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"elapsed": "1.5s",
+		"error":   "broken",
+		"level":   "info",
+		"message": "Response",
+		"status":  200.0,
+		"time":    "2009-02-13T23:31:30Z",
+	}, entry)
+}
+
+func TestLogger_Level(t *testing.T) {
+	var out bytes.Buffer
+	l := &logger{level: logLevelInfo, now: time.Now, out: &out}
+
+	assert.True(t, l.enabled(logLevelError))
+	assert.True(t, l.enabled(logLevelInfo))
+	assert.False(t, l.enabled(logLevelDebug))
+
+	l.log(logLevelDebug, "Matched route")
+	assert.Equal(t, "", out.String())
+
+	l.log(logLevelError, "Couldn't generate response")
+	assert.Equal(t, "Couldn't generate response\n", out.String())
+}
+
+func TestLogger_Text(t *testing.T) {
+	var out bytes.Buffer
+	l := &logger{level: logLevelDebug, now: time.Now, out: &out}
+
+	l.log(logLevelInfo, "Request", "method", "GET", "path", "/v1/charges")
+	assert.Equal(t, "Request method=GET path=/v1/charges\n", out.String())
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := parseLogLevel("debug")
+	assert.NoError(t, err)
+	assert.Equal(t, logLevelDebug, level)
+
+	_, err = parseLogLevel("verbose")
+	assert.Error(t, err)
+}
@@ -1,32 +1,40 @@
-//go:generate go-bindata cert/cert.pem cert/key.pem openapi/openapi/fixtures3.json openapi/openapi/spec3.json
-
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"encoding/json"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/logging"
+	"github.com/stripe/stripe-mock/server"
 )
 
 const defaultPortHTTP = 12111
 const defaultPortHTTPS = 12112
 
-// verbose tracks whether the program is operating in verbose mode
-var verbose bool
+// ephemeralPort stands in for a port of 0 given as an option, which asks for a
+// port chosen by the OS. Options with a port of 0 are otherwise treated as not
+// having been given.
+const ephemeralPort = -1
+
+// defaultShutdownTimeout is the default length of time that requests in flight
+// are given to finish when stripe-mock is asked to stop.
+const defaultShutdownTimeout = 10 * time.Second
 
-// This is set to the actual version by GoReleaser (using `-ldflags "-X ..."`)
-// as it's run. Versions built from source will always show master.
-var version = "master"
+// defaultMetricsAddress is the default address that metrics are served on
+// with -metrics. It's the port after the default HTTP and HTTPS ports.
+const defaultMetricsAddress = ":12113"
 
 // ---
 
@@ -34,24 +42,95 @@ func main() {
 	var options options
 
 	flag.BoolVar(&options.http, "http", false, "Run with HTTP")
-	flag.IntVar(&options.httpPort, "http-port", 0, "Port to listen on for HTTP")
+	flag.IntVar(&options.httpPort, "http-port", 0, "Port to listen on for HTTP (0 for a port chosen by the OS)")
 	flag.StringVar(&options.httpUnixSocket, "http-unix", "", "Unix socket to listen on for HTTP")
+	flag.BoolVar(&options.http2, "http2", true, "Advertise HTTP/2 (h2) with ALPN on the HTTPS listener so that clients can negotiate it (-http2=false to only allow HTTP/1.1)")
 
 	flag.BoolVar(&options.https, "https", false, "Run with HTTPS (which also allows HTTP/2 to be activated)")
-	flag.IntVar(&options.httpsPort, "https-port", 0, "Port to listen on for HTTPS")
+	flag.StringVar(&options.httpsCertPath, "https-cert", "", "Path to a PEM certificate to use for HTTPS instead of the bundled self-signed one (requires -https-key)")
+	flag.StringVar(&options.httpsCAPath, "https-ca", "", "Path to a PEM bundle of CA certificates used to verify client certificates on HTTPS (enables mutual TLS)")
+	flag.StringVar(&options.httpsClientAuth, "https-client-auth", "", "How to treat client certificates with -https-ca: request (verify one if given) or require (reject connections without a valid one; the default)")
+	flag.StringVar(&options.httpsKeyPath, "https-key", "", "Path to the PEM private key of the certificate given with -https-cert")
+	flag.IntVar(&options.httpsPort, "https-port", 0, "Port to listen on for HTTPS (0 for a port chosen by the OS)")
 	flag.StringVar(&options.httpsUnixSocket, "https-unix", "", "Unix socket to listen on for HTTPS")
 
-	flag.IntVar(&options.port, "port", 0, "Port to listen on (also respects PORT from environment)")
-	flag.StringVar(&options.fixturesPath, "fixtures", "", "Path to fixtures to use instead of bundled version (should be JSON)")
-	flag.StringVar(&options.specPath, "spec", "", "Path to OpenAPI spec to use instead of bundled version (should be JSON)")
+	flag.IntVar(&options.port, "port", 0, "Port to listen on (also respects PORT from environment; 0 for a port chosen by the OS)")
+	flag.StringVar(&options.apiVersions, "api-versions", "", "Comma-separated list of bundled API versions to load so that they can be selected with a Stripe-Version header (defaults to all of them)")
+	flag.BoolVar(&options.compression, "compression", false, "Decompress request bodies sent with Content-Encoding: gzip, and gzip responses to requests sent with Accept-Encoding: gzip")
+	flag.StringVar(&options.contentType, "content-type", "", "Content-Type header to send with responses that have a body, like \"application/json; charset=utf-8\" (none by default)")
+	flag.BoolVar(&options.cors, "cors", false, "Allow browsers to make cross-origin requests (CORS) by responding to preflight requests and setting Access-Control-Allow-* headers")
+	flag.StringVar(&options.corsOriginRegex, "cors-origin-regex", "", "Regular expression matching whole origins allowed to make cross-origin requests with -cors on top of -cors-origins, like https://[a-z0-9-]+\\.preview\\.example\\.com")
+	flag.StringVar(&options.corsOrigins, "cors-origins", "", "Comma-separated list of origins allowed to make cross-origin requests with -cors (defaults to any origin)")
+	flag.StringVar(&options.defaultAPIVersion, "default-api-version", "", "API version to use for requests without a Stripe-Version header (defaults to the version of the primary spec)")
+	flag.Float64Var(&options.failRate, "fail-rate", 0, "Fraction of requests (between 0 and 1) that fail with a 500 and Stripe-Should-Retry: true to simulate transient failures (reproducible with -seed)")
+	flag.StringVar(&options.fixturesPath, "fixtures", "", "Path or HTTP(S) URL of fixtures to use instead of bundled version (should be JSON)")
+	flag.StringVar(&options.fixturesDir, "fixtures-dir", "", "Path to a directory of JSON files that are each the fixture of the resource named by the file (like charge.json), deep merged into the fixtures of every API version")
+	flag.StringVar(&options.fixturesOverridePath, "fixtures-override", "", "Path or HTTP(S) URL of fixtures for some resources that are deep merged into the fixtures of every API version (should be JSON)")
+	flag.BoolVar(&options.fixturesOverrideReplace, "fixtures-override-replace", false, "Replace the fixtures of resources in -fixtures-override entirely instead of merging into them")
+	flag.DurationVar(&options.latency, "latency", 0, "Artificial latency to add before responding to each request (e.g. 500ms)")
+	flag.StringVar(&options.latencyConfigPath, "latency-config", "", "Path to a JSON file with latencies for particular paths, overriding -latency")
+	flag.DurationVar(&options.latencyJitter, "latency-jitter", 0, "Upper bound of random jitter added to the latency of each request")
+	flag.StringVar(&options.logFormat, "log-format", "text", "Format of logs: text (written to stdout) or json (written to stderr)")
+	flag.StringVar(&options.logLevel, "log-level", "info", "Minimum severity of messages to log: error, info, or debug")
+	flag.DurationVar(&options.generateTimeout, "generate-timeout", server.DefaultGenerateTimeout, "How long a response may take to generate before responding with 503 Service Unavailable, which guards against pathological schemas in custom specs (0 for no limit)")
+	flag.StringVar(&options.idMode, "id-mode", server.IDModeRandom, "How the IDs of created objects are generated: random (random with -stateful, or the fixture's otherwise) or content-hash (derived from the request's path and parameters, so identical requests get identical IDs; can't be used with -stateful)")
+	flag.DurationVar(&options.idempotencyTTL, "idempotency-ttl", server.DefaultIdempotencyTTL, "How long responses are replayed for requests retried with the same Idempotency-Key (0 to never replay)")
+	flag.BoolVar(&options.maintenance, "maintenance", false, "Start down for simulated maintenance, responding to API requests with 503 Service Unavailable and a Retry-After header until it's turned off with a POST to /v1/_stripe_mock/maintenance")
+	flag.DurationVar(&options.maintenanceRetryAfter, "maintenance-retry-after", server.DefaultMaintenanceRetryAfter, "How long clients are told to wait with Retry-After before retrying requests made during maintenance")
+	flag.Int64Var(&options.maxBodySize, "max-body-size", server.DefaultMaxBodySize, "Maximum size in bytes of a request body before responding with 413 Request Entity Too Large (0 for no limit)")
+	flag.IntVar(&options.maxExpansionDepth, "max-expansion-depth", server.DefaultMaxExpansionDepth, "Maximum number of levels a single expand[] path may descend (0 for no limit)")
+	flag.IntVar(&options.maxListSize, "max-list-size", 0, "Maximum number of objects in a generated list, each of which gets a random number of objects up to it (reproducible with -seed; 0 for lists of 100)")
+	flag.BoolVar(&options.metrics, "metrics", false, "Serve Prometheus metrics at /metrics on -metrics-addr")
+	flag.StringVar(&options.metricsAddress, "metrics-addr", defaultMetricsAddress, "Address to serve metrics on when -metrics is given")
+	flag.BoolVar(&options.noExpand, "no-expand", false, "Ignore expand[] parameters and always respond with unexpanded objects, which makes responses cheaper to generate for load tests")
+	flag.BoolVar(&options.noFixtures, "no-fixtures", false, "Ignore fixtures and generate every object from its schema alone, which brings out properties that the fixtures leave out")
+	flag.StringVar(&options.nullableMode, "nullable-mode", server.NullableModeFixture, "How nullable fields are generated: fixture (values from fixtures), random (null about half of the time), or always (always null)")
+	flag.BoolVar(&options.pretty, "pretty", false, "Indent JSON responses (responses to curl are always indented)")
+	flag.BoolVar(&options.quiet, "quiet", false, "Only log errors and leave out the startup banner (the same as -log-level error); a port chosen by the OS is still printed")
+	flag.IntVar(&options.rateLimit, "rate-limit", 0, "Maximum number of requests per second before responding with 429 Too Many Requests (0 for no limit)")
+	flag.BoolVar(&options.rateLimitPerKey, "rate-limit-per-key", false, "Apply -rate-limit to each API key separately instead of to all requests")
+	flag.StringVar(&options.recordPath, "record", "", "Path to a file that requests and the responses generated for them are appended to as JSON lines (see -replay)")
+	flag.StringVar(&options.replayPath, "replay", "", "Path to a file of requests recorded with -record, whose responses are sent back to requests with the same method, path, and parameters instead of generated ones")
+	flag.BoolVar(&options.restrictedKeysReadOnly, "restricted-keys-read-only", false, "Only allow GET requests made with restricted keys (rk_test_...), responding to others with 403 Forbidden")
+	flag.StringVar(&options.selfValidateMode, "self-validate", server.SelfValidateModeOff, "Check each generated response against its schema to catch generator bugs: off, log (log an error for an invalid response), or strict (respond with 500 instead)")
+	flag.Int64Var(&options.seed, "seed", 0, "Seed for randomly generated values like IDs, so that the same request always gets the same response")
+	flag.DurationVar(&options.shutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "How long requests in flight are given to finish on SIGINT or SIGTERM before connections are closed (0 to wait for them indefinitely)")
+	flag.StringVar(&options.specPath, "spec", "", "Path or HTTP(S) URL of OpenAPI spec to use instead of bundled version (should be JSON)")
+	flag.DurationVar(&options.specPollInterval, "spec-poll-interval", 0, "How often to fetch a -spec, -fixtures, or -fixtures-override given as a URL again, reloading them when their content changes (0 to only fetch them at startup)")
+	flag.StringVar(&options.stringCharset, "string-charset", server.DefaultStringCharset, "Characters that strings of random characters in generated objects are made of")
+	flag.IntVar(&options.stringLength, "string-length", server.DefaultStringLength, "Length of strings of random characters in generated objects, within the minLength and maxLength of their schemas (0 for strings as short as their schemas allow)")
+	flag.BoolVar(&options.strictAuth, "strict-auth", false, "Respond to missing or malformed API keys with errors like the Stripe API's")
+	flag.BoolVar(&options.strictIDs, "strict-ids", false, "Respond with 404 Not Found to requests for objects whose IDs don't have the prefix of their resource (like ch_ for charges)")
+	flag.BoolVar(&options.stateful, "stateful", false, "Store objects created with POST so that they can be retrieved, updated, listed, and deleted")
 	flag.StringVar(&options.unixSocket, "unix", "", "Unix socket to listen on")
-	flag.BoolVar(&verbose, "verbose", false, "Enable verbose mode")
+	flag.StringVar(&options.unixSocketMode, "unix-socket-mode", "", "Permissions in octal to give Unix sockets listened on with -unix, -http-unix, or -https-unix (like 0666 to let every local user connect)")
+	flag.BoolVar(&options.validateSpec, "validate-spec", false, "Check the -spec and -fixtures (or the bundled ones) for problems like unresolved references, print them, and exit without starting the server")
+	flag.BoolVar(&options.verbose, "verbose", false, "Enable verbose mode (the same as -log-level debug)")
+	flag.StringVar(&options.webhookSecret, "webhook-secret", "", "Secret used to sign webhooks sent to -webhook-url")
+	flag.StringVar(&options.webhookURL, "webhook-url", "", "URL to send events created with the trigger endpoint to")
 	flag.BoolVar(&options.showVersion, "version", false, "Show version and exit")
+	flag.BoolVar(&options.watch, "watch", false, "Reload the -spec, -fixtures, -fixtures-dir, and -fixtures-override files when they change")
 
 	flag.Parse()
 
-	fmt.Printf("stripe-mock %s\n", version)
+	// A seed of 0 is as valid as any other, so check whether one was given.
+	// Similarly, a port of 0 asks for one chosen by the OS, which is
+	// different from not giving a port at all.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "http-port":
+			options.httpPort = ephemeralPortOr(options.httpPort)
+		case "https-port":
+			options.httpsPort = ephemeralPortOr(options.httpsPort)
+		case "port":
+			options.port = ephemeralPortOr(options.port)
+		case "seed":
+			options.seeded = true
+		}
+	})
+
 	if options.showVersion || len(flag.Args()) == 1 && flag.Arg(0) == "version" {
+		fmt.Printf("stripe-mock %s\n", server.Version)
 		return
 	}
 
@@ -61,87 +140,87 @@ func main() {
 		abort(fmt.Sprintf("Invalid options: %v", err))
 	}
 
-	// For both spec and fixtures stripe-mock will by default load data from
-	// internal assets compiled into the binary, but either one can be
-	// overridden with a -spec or -fixtures argument and a path to a file.
-	stripeSpec, err := getSpec(options.specPath)
+	err = logging.Configure(options.getLogLevel(), options.logFormat)
 	if err != nil {
-		abort(err.Error())
+		flag.Usage()
+		abort(fmt.Sprintf("Invalid options: %v", err))
 	}
 
-	fixtures, err := getFixtures(options.fixturesPath)
-	if err != nil {
-		abort(err.Error())
-	}
+	// The banner is logged so that it's left out along with other
+	// informational messages by -quiet
+	logging.Info("stripe-mock " + server.Version)
 
-	stub := StubServer{fixtures: fixtures, spec: stripeSpec}
-	err = stub.initializeRouter()
-	if err != nil {
-		abort(fmt.Sprintf("Error initializing router: %v\n", err))
+	// Checking the spec doesn't need the server to be started
+	if options.validateSpec {
+		err = validateSpec(options.getServerConfig())
+		if err != nil {
+			abort(err.Error())
+		}
+		fmt.Println("No problems found in the spec")
+		return
 	}
 
-	http.HandleFunc("/", stub.HandleRequest)
-
-	httpListener, err := options.getHTTPListener()
+	stub, err := server.NewServer(options.getServerConfig())
 	if err != nil {
 		abort(err.Error())
 	}
 
-	// Only start HTTP if requested (it's the default, but it won't start if
-	// HTTPS is explicitly requested instead)
-	if httpListener != nil {
-		server := http.Server{}
-
-		// Listen in a new Goroutine that so we can start a simultaneous HTTPS
-		// listener if necessary.
-		go func() {
-			err := server.Serve(httpListener)
-			if err != nil {
-				abort(err.Error())
-			}
-		}()
-	}
-
-	httpsListener, err := options.getNonSecureHTTPSListener()
+	httpListener, httpsListener, err := options.getListeners()
 	if err != nil {
 		abort(err.Error())
 	}
 
-	// Only start HTTPS if requested
+	var tlsConfig *tls.Config
 	if httpsListener != nil {
-		// Our self-signed certificate is bundled up using go-bindata so that
-		// it stays easy to distribute stripe-mock as a standalone binary with
-		// no other dependencies.
-		certificate, err := getTLSCertificate()
+		tlsConfig, err = options.getTLSConfig()
 		if err != nil {
 			abort(err.Error())
 		}
+	}
 
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{certificate},
+	// Servers are tracked so that they can all be shut down together. HTTP
+	// and HTTPS share the same stub, so they also share its stored objects.
+	servers := serveAPI(stub, httpListener, httpsListener, tlsConfig)
 
-			// h2 is HTTP/2. A server with a default config normally doesn't
-			// need this hint, but Go is somewhat inflexible, and we need this
-			// here because we're using `Serve` and reading a TLS certificate
-			// from memory instead of using `ServeTLS` which would've read a
-			// certificate from file.
-			NextProtos: []string{"h2"},
+	// Metrics are served separately so that they don't interfere with the
+	// API
+	if metricsHandler := stub.MetricsHandler(); metricsHandler != nil {
+		metricsListener, err := net.Listen("tcp", options.metricsAddress)
+		if err != nil {
+			abort(fmt.Sprintf("Error listening for metrics: %v", err))
 		}
+		logging.Info("Serving metrics", "address", metricsListener.Addr())
 
-		server := http.Server{TLSConfig: tlsConfig}
-		tlsListener := tls.NewListener(httpsListener, tlsConfig)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler)
+		metricsServer := &http.Server{Handler: mux}
+		servers = append(servers, metricsServer)
 
-		go func() {
-			err := server.Serve(tlsListener)
-			if err != nil {
-				abort(err.Error())
-			}
-		}()
+		go serve(metricsServer, metricsListener)
 	}
 
-	// Block forever. The serve Goroutines above will abort the program if
-	// either of them fails.
-	select {}
+	// Block until asked to stop. The serve Goroutines above will abort the
+	// program if any of them fails.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	received := <-signals
+
+	// Requests that are in flight are given a chance to finish so that
+	// clients don't see their connections reset
+	logging.Info("Shutting down", "signal", received,
+		"timeout", options.shutdownTimeout)
+	err = shutdownServers(servers, options.shutdownTimeout)
+
+	// The stub is only stopped once its servers are so that the requests
+	// that were allowed to finish still make it into a recording. It's
+	// stopped even if they timed out so that the recording is flushed.
+	stopErr := stub.Stop()
+	if err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		abort(fmt.Sprintf("Error shutting down: %v", err))
+	}
 }
 
 //
@@ -150,20 +229,80 @@ func main() {
 
 // options is a container for the command line options passed to stripe-mock.
 type options struct {
-	fixturesPath string
+	apiVersions       string
+	compression       bool
+	contentType       string
+	cors              bool
+	corsOriginRegex   string
+	corsOrigins       string
+	defaultAPIVersion string
+	failRate          float64
+
+	fixturesDir             string
+	fixturesOverridePath    string
+	fixturesOverrideReplace bool
+	fixturesPath            string
 
 	http           bool
 	httpPort       int
 	httpUnixSocket string
+	http2          bool
 
 	https           bool
+	httpsCAPath     string
+	httpsCertPath   string
+	httpsClientAuth string
+	httpsKeyPath    string
 	httpsPort       int
 	httpsUnixSocket string
 
-	port        int
-	showVersion bool
-	specPath    string
-	unixSocket  string
+	generateTimeout   time.Duration
+	idMode            string
+	idempotencyTTL    time.Duration
+	latency           time.Duration
+	latencyConfigPath string
+	latencyJitter     time.Duration
+	logFormat         string
+	logLevel          string
+	maintenance       bool
+	maxBodySize       int64
+	maxExpansionDepth int
+	maxListSize       int
+	metrics           bool
+	metricsAddress    string
+	noExpand          bool
+	noFixtures        bool
+	nullableMode      string
+	port              int
+	pretty            bool
+	quiet             bool
+	rateLimit         int
+	rateLimitPerKey   bool
+	recordPath        string
+	replayPath        string
+	seed              int64
+	seeded            bool
+	selfValidateMode  string
+	showVersion       bool
+	shutdownTimeout   time.Duration
+	specPath          string
+	specPollInterval  time.Duration
+	stateful          bool
+	strictAuth        bool
+	strictIDs         bool
+	stringCharset     string
+	stringLength      int
+	unixSocket        string
+	unixSocketMode    string
+	validateSpec      bool
+	verbose           bool
+	watch             bool
+
+	maintenanceRetryAfter  time.Duration
+	restrictedKeysReadOnly bool
+
+	webhookSecret string
+	webhookURL    string
 }
 
 func (o *options) checkConflictingOptions() error {
@@ -203,6 +342,69 @@ func (o *options) checkConflictingOptions() error {
 		return fmt.Errorf("Please specify only one of -https-port or -https-unix")
 	}
 
+	if o.httpsCAPath != "" && !o.https && o.httpsPort == 0 && o.httpsUnixSocket == "" {
+		return fmt.Errorf("Please specify -https, -https-port, or -https-unix when using -https-ca")
+	}
+
+	if (o.httpsCertPath != "" || o.httpsKeyPath != "") && !o.https && o.httpsPort == 0 && o.httpsUnixSocket == "" {
+		return fmt.Errorf("Please specify -https, -https-port, or -https-unix when using -https-cert or -https-key")
+	}
+
+	if (o.httpsCertPath == "") != (o.httpsKeyPath == "") {
+		return fmt.Errorf("Please specify both -https-cert and -https-key")
+	}
+
+	if o.httpsClientAuth != "" && o.httpsCAPath == "" {
+		return fmt.Errorf("Please specify -https-ca when using -https-client-auth")
+	}
+
+	if _, err := parseClientAuth(o.httpsClientAuth); err != nil {
+		return err
+	}
+
+	if o.apiVersions != "" && o.specPath != "" {
+		return fmt.Errorf("Please don't specify -api-versions when using -spec")
+	}
+
+	if o.corsOrigins != "" && !o.cors {
+		return fmt.Errorf("Please specify -cors when using -cors-origins")
+	}
+
+	if o.corsOriginRegex != "" && !o.cors {
+		return fmt.Errorf("Please specify -cors when using -cors-origin-regex")
+	}
+
+	if o.fixturesOverrideReplace && o.fixturesOverridePath == "" {
+		return fmt.Errorf("Please specify -fixtures-override when using -fixtures-override-replace")
+	}
+
+	if o.unixSocketMode != "" && o.unixSocket == "" && o.httpUnixSocket == "" &&
+		o.httpsUnixSocket == "" {
+		return fmt.Errorf("Please specify -unix, -http-unix, or -https-unix when using -unix-socket-mode")
+	}
+
+	if _, err := parseUnixSocketMode(o.unixSocketMode); err != nil {
+		return err
+	}
+
+	if o.stringLength < 0 {
+		return fmt.Errorf("Please specify a -string-length of 0 or more")
+	}
+
+	if o.quiet && o.verbose {
+		return fmt.Errorf("Please specify only one of -quiet or -verbose")
+	}
+
+	if o.specPollInterval != 0 && !isURL(o.specPath) && !isURL(o.fixturesPath) &&
+		!isURL(o.fixturesOverridePath) {
+		return fmt.Errorf("Please specify a URL for -spec, -fixtures, or -fixtures-override when using -spec-poll-interval")
+	}
+
+	if o.watch && o.specPath == "" && o.fixturesPath == "" && o.fixturesDir == "" &&
+		o.fixturesOverridePath == "" {
+		return fmt.Errorf("Please specify -spec, -fixtures, -fixtures-dir, or -fixtures-override when using -watch")
+	}
+
 	return nil
 }
 
@@ -214,7 +416,7 @@ func (o *options) getHTTPListener() (net.Listener, error) {
 	}
 
 	if o.httpUnixSocket != "" {
-		return getUnixSocketListener(o.httpUnixSocket)
+		return getUnixSocketListener(o.httpUnixSocket, o.getUnixSocketMode())
 	}
 
 	// HTTP is active by default, but only if HTTPS is *not* active
@@ -227,12 +429,51 @@ func (o *options) getHTTPListener() (net.Listener, error) {
 	}
 
 	if o.unixSocket != "" {
-		return getUnixSocketListener(o.unixSocket)
+		return getUnixSocketListener(o.unixSocket, o.getUnixSocketMode())
 	}
 
 	return getPortListenerDefault(defaultPortHTTP)
 }
 
+// getListeners gets the listeners for HTTP and HTTPS depending on the options
+// provided, either of which is nil if it should not be enabled. Both are
+// attempted even if the first fails so that every port or socket that
+// couldn't be bound is reported at once, and a listener that was bound is
+// closed again if the other wasn't.
+func (o *options) getListeners() (net.Listener, net.Listener, error) {
+	httpListener, httpErr := o.getHTTPListener()
+	httpsListener, httpsErr := o.getNonSecureHTTPSListener()
+	if httpErr == nil && httpsErr == nil {
+		return httpListener, httpsListener, nil
+	}
+
+	var messages []string
+	if httpErr != nil {
+		messages = append(messages, fmt.Sprintf("HTTP: %v", httpErr))
+	} else if httpListener != nil {
+		httpListener.Close()
+	}
+	if httpsErr != nil {
+		messages = append(messages, fmt.Sprintf("HTTPS: %v", httpsErr))
+	} else if httpsListener != nil {
+		httpsListener.Close()
+	}
+	return nil, nil, fmt.Errorf("error starting listeners: %s",
+		strings.Join(messages, "; "))
+}
+
+// getLogLevel gets the name of the minimum severity of messages to log, which
+// is lowered to `debug` by -verbose and raised to `error` by -quiet.
+func (o *options) getLogLevel() string {
+	switch {
+	case o.quiet:
+		return "error"
+	case o.verbose:
+		return "debug"
+	}
+	return o.logLevel
+}
+
 // getNonSecureHTTPSListener gets a basic listener on a port or unix socket
 // depending on the options provided. Its return listener must still be wrapped
 // in a TLSListener. If HTTPS should not be enabled, it returns nil.
@@ -242,7 +483,7 @@ func (o *options) getNonSecureHTTPSListener() (net.Listener, error) {
 	}
 
 	if o.httpsUnixSocket != "" {
-		return getUnixSocketListener(o.httpsUnixSocket)
+		return getUnixSocketListener(o.httpsUnixSocket, o.getUnixSocketMode())
 	}
 
 	// HTTPS is disabled by default
@@ -255,72 +496,196 @@ func (o *options) getNonSecureHTTPSListener() (net.Listener, error) {
 	}
 
 	if o.unixSocket != "" {
-		return getUnixSocketListener(o.unixSocket)
+		return getUnixSocketListener(o.unixSocket, o.getUnixSocketMode())
 	}
 
 	return getPortListenerDefault(defaultPortHTTPS)
 }
 
-//
-// Private functions
-//
+// getServerConfig builds the configuration of the API stub from the options.
+func (o *options) getServerConfig() *server.Config {
+	config := &server.Config{
+		APIVersions:       parseList(o.apiVersions),
+		Compression:       o.compression,
+		CORS:              o.cors,
+		CORSOriginRegex:   o.corsOriginRegex,
+		CORSOrigins:       parseList(o.corsOrigins),
+		DefaultAPIVersion: o.defaultAPIVersion,
+		FailRate:          o.failRate,
+		FixturesDir:       o.fixturesDir,
+		FixturesPath:      o.fixturesPath,
+		GenerateTimeout:   o.generateTimeout,
+		IDMode:            o.idMode,
+		IdempotencyTTL:    o.idempotencyTTL,
+		Latency:           o.latency,
+		LatencyConfigPath: o.latencyConfigPath,
+		LatencyJitter:     o.latencyJitter,
+		Maintenance:       o.maintenance,
+		MaxBodySize:       o.maxBodySize,
+		MaxExpansionDepth: o.maxExpansionDepth,
+		MaxListSize:       o.maxListSize,
+		Metrics:           o.metrics,
+		NoExpand:          o.noExpand,
+		NoFixtures:        o.noFixtures,
+		NullableMode:      o.nullableMode,
+		Pretty:            o.pretty,
+		RateLimit:         o.rateLimit,
+		RateLimitPerKey:   o.rateLimitPerKey,
+		RecordPath:        o.recordPath,
+		ReplayPath:        o.replayPath,
+		SelfValidateMode:  o.selfValidateMode,
+		SpecPath:          o.specPath,
+		SpecPollInterval:  o.specPollInterval,
+		Stateful:          o.stateful,
+		StrictAuth:        o.strictAuth,
+		StrictIDs:         o.strictIDs,
+		StringCharset:     o.stringCharset,
+		StringLength:      o.stringLength,
+		Watch:             o.watch,
+
+		FixturesOverridePath:    o.fixturesOverridePath,
+		FixturesOverrideReplace: o.fixturesOverrideReplace,
+
+		MaintenanceRetryAfter:  o.maintenanceRetryAfter,
+		ResponseContentType:    o.contentType,
+		RestrictedKeysReadOnly: o.restrictedKeysReadOnly,
+
+		WebhookSecret: o.webhookSecret,
+		WebhookURL:    o.webhookURL,
+	}
+
+	if o.seeded {
+		config.Seed = &o.seed
+	}
+
+	return config
+}
 
-func abort(message string) {
-	fmt.Fprintf(os.Stderr, message)
-	os.Exit(1)
+// getUnixSocketMode gets the permissions that Unix sockets should be given
+// once they're listened on. It's 0 if their permissions should be left as
+// they are.
+func (o *options) getUnixSocketMode() os.FileMode {
+	// The mode was already checked along with other options
+	mode, _ := parseUnixSocketMode(o.unixSocketMode)
+	return mode
 }
 
-// getTLSCertificate reads a certificate and key from the assets built by
-// go-bindata.
-func getTLSCertificate() (tls.Certificate, error) {
-	cert, err := Asset("cert/cert.pem")
+// getTLSConfig builds the configuration for the HTTPS listener. If a CA
+// bundle was given with -https-ca, clients are asked for certificates which
+// are verified against it.
+func (o *options) getTLSConfig() (*tls.Config, error) {
+	certificate, err := getTLSCertificate(o.httpsCertPath, o.httpsKeyPath)
 	if err != nil {
-		return tls.Certificate{}, err
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{"http/1.1"},
 	}
 
-	key, err := Asset("cert/key.pem")
+	// h2 is HTTP/2. A server with a default config normally doesn't need this
+	// hint, but Go is somewhat inflexible, and we need this here because we're
+	// using `Serve` and reading a TLS certificate from memory instead of using
+	// `ServeTLS` which would've read a certificate from file.
+	if o.http2 {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	if o.httpsCAPath == "" {
+		return tlsConfig, nil
+	}
+
+	data, err := ioutil.ReadFile(o.httpsCAPath)
 	if err != nil {
-		return tls.Certificate{}, err
+		return nil, fmt.Errorf("error loading CA bundle: %v", err)
 	}
 
-	return tls.X509KeyPair(cert, key)
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA bundle %s doesn't contain any PEM "+
+			"certificates", o.httpsCAPath)
+	}
+
+	clientAuth, err := parseClientAuth(o.httpsClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ClientAuth = clientAuth
+	tlsConfig.ClientCAs = clientCAs
+	logging.Info("Verifying client certificates", "ca", o.httpsCAPath,
+		"required", clientAuth == tls.RequireAndVerifyClientCert)
+
+	return tlsConfig, nil
 }
 
-func getFixtures(fixturesPath string) (*spec.Fixtures, error) {
-	var data []byte
-	var err error
+//
+// Private functions
+//
 
-	if fixturesPath == "" {
-		// And do the same for fixtures
-		data, err = Asset("openapi/openapi/fixtures3.json")
-	} else {
-		if !isJSONFile(fixturesPath) {
-			return nil, fmt.Errorf("Fixtures should come from a JSON file")
+func abort(message string) {
+	fmt.Fprint(os.Stderr, message)
+	os.Exit(1)
+}
+
+// ephemeralPortOr returns ephemeralPort for a port of 0 that was given as an
+// option, or otherwise the port itself.
+func ephemeralPortOr(port int) int {
+	if port == 0 {
+		return ephemeralPort
+	}
+	return port
+}
+
+// getTLSCertificate loads a certificate and key from the given files, or if
+// they're empty, reads our self-signed certificate and key from the assets
+// built by go-bindata. The certificate is bundled so that it stays easy to
+// distribute stripe-mock as a standalone binary with no other dependencies.
+func getTLSCertificate(certPath, keyPath string) (tls.Certificate, error) {
+	if certPath != "" || keyPath != "" {
+		certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("error loading HTTPS "+
+				"certificate from %s and key from %s: %v", certPath, keyPath, err)
 		}
 
-		data, err = ioutil.ReadFile(fixturesPath)
+		logging.Info("Using HTTPS certificate", "cert", certPath, "key", keyPath)
+		return certificate, nil
 	}
 
+	cert, err := server.Asset("cert/cert.pem")
 	if err != nil {
-		return nil, fmt.Errorf("error loading fixtures: %v", err)
+		return tls.Certificate{}, err
 	}
 
-	var fixtures spec.Fixtures
-	err = json.Unmarshal(data, &fixtures)
+	key, err := server.Asset("cert/key.pem")
 	if err != nil {
-		return nil, fmt.Errorf("error decoding spec: %v", err)
+		return tls.Certificate{}, err
 	}
 
-	return &fixtures, nil
+	return tls.X509KeyPair(cert, key)
 }
 
+// getPortListener gets a listener on the given port, or on a port chosen by
+// the OS if it's 0 or ephemeralPort. A chosen port is also printed in a stable
+// format like `Listening on 127.0.0.1:54321` so that whatever started
+// stripe-mock can find it.
 func getPortListener(port int) (net.Listener, error) {
+	if port == ephemeralPort {
+		port = 0
+	}
+
 	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		return nil, fmt.Errorf("error listening on port: %v", err)
 	}
 
-	fmt.Printf("Listening on port: %v\n", port)
+	boundPort := listenerPort(listener)
+	logging.Info("Listening on port", "port", boundPort)
+	if port == 0 {
+		fmt.Printf("Listening on 127.0.0.1:%d\n", boundPort)
+	}
 	return listener, nil
 }
 
@@ -339,46 +704,186 @@ func getPortListenerDefault(defaultPort int) (net.Listener, error) {
 	return getPortListener(defaultPort)
 }
 
-func getSpec(specPath string) (*spec.Spec, error) {
-	var data []byte
-	var err error
+// getUnixSocketListener gets a listener on a Unix socket, giving it the
+// permissions in mode unless that's 0. A socket file left behind by a process
+// that's no longer listening on it is removed first.
+func getUnixSocketListener(unixSocket string, mode os.FileMode) (net.Listener, error) {
+	err := removeStaleUnixSocket(unixSocket)
+	if err != nil {
+		return nil, fmt.Errorf("error removing stale socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", unixSocket)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on socket: %v", err)
+	}
 
-	if specPath == "" {
-		// Load the spec information from go-bindata
-		data, err = Asset("openapi/openapi/spec3.json")
-	} else {
-		if !isJSONFile(specPath) {
-			return nil, fmt.Errorf("spec should come from a JSON file")
+	if mode != 0 {
+		err = os.Chmod(unixSocket, mode)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("error setting socket permissions: %v", err)
 		}
+	}
+
+	logging.Info("Listening on Unix socket", "path", unixSocket)
+	return listener, nil
+}
 
-		data, err = ioutil.ReadFile(specPath)
+// isURL checks whether an option like -spec was given an HTTP(S) URL instead
+// of a path.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// listenerPort returns the port that a TCP listener is bound to, which is
+// useful when it was chosen by the OS. Returns 0 for other kinds of listeners.
+func listenerPort(listener net.Listener) int {
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return 0
 	}
-	if err != nil {
-		return nil, fmt.Errorf("error loading spec: %v", err)
+	return addr.Port
+}
+
+// parseClientAuth parses the value of -https-client-auth. Client certificates
+// are required unless they're explicitly only requested.
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "require":
+		return tls.RequireAndVerifyClientCert, nil
+	case "request":
+		return tls.VerifyClientCertIfGiven, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("Unknown client certificate mode: %s "+
+		"(expected request or require)", mode)
+}
+
+// parseList parses a comma-separated list given as an option like
+// -api-versions. Returns nil if the list is empty.
+func parseList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	var stripeSpec spec.Spec
-	err = json.Unmarshal(data, &stripeSpec)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding spec: %v", err)
+// parseUnixSocketMode parses the value of -unix-socket-mode, which is in octal
+// like the mode of chmod. Returns 0 if no mode was given.
+func parseUnixSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
 	}
 
-	return &stripeSpec, nil
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0777 {
+		return 0, fmt.Errorf("Invalid Unix socket mode: %s (expected permissions "+
+			"in octal, like 0666)", mode)
+	}
+	return os.FileMode(parsed), nil
 }
 
-func getUnixSocketListener(unixSocket string) (net.Listener, error) {
-	listener, err := net.Listen("unix", unixSocket)
-	if err != nil {
-		return nil, fmt.Errorf("error listening on socket: %v", err)
+// removeStaleUnixSocket removes a socket file at the given path that was left
+// behind by a process that's no longer listening on it, which would otherwise
+// make listening on the path fail. Other files, and sockets that are still
+// being listened on, are left alone.
+func removeStaleUnixSocket(unixSocket string) error {
+	info, err := os.Lstat(unixSocket)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
 	}
 
-	fmt.Printf("Listening on Unix socket: %v\n", unixSocket)
-	return listener, nil
+	conn, err := net.Dial("unix", unixSocket)
+	if err == nil {
+		conn.Close()
+		return nil
+	}
+
+	return os.Remove(unixSocket)
+}
+
+// serve serves a server on a listener, aborting the program if it fails. It
+// returns once the server is shut down, so it should be run in a goroutine.
+func serve(httpServer *http.Server, listener net.Listener) {
+	err := httpServer.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
+		abort(err.Error())
+	}
 }
 
-// isJSONFile judges based on a file's extension whether it's a JSON file. It's
-// used to return a better error message if the user points to an unsupported
-// file.
-func isJSONFile(path string) bool {
-	return strings.ToLower(filepath.Ext(path)) == ".json"
+// serveAPI serves handler on the HTTP and HTTPS listeners that were opened
+// (either may be nil), each in its own goroutine. It returns the servers that
+// were started so that they can be shut down later.
+func serveAPI(handler http.Handler, httpListener, httpsListener net.Listener,
+	tlsConfig *tls.Config) []*http.Server {
+
+	var servers []*http.Server
+
+	// Only start HTTP if requested (it's the default, but it won't start if
+	// HTTPS is explicitly requested instead)
+	if httpListener != nil {
+		httpServer := &http.Server{Handler: handler}
+		servers = append(servers, httpServer)
+		go serve(httpServer, httpListener)
+	}
+
+	// Only start HTTPS if requested
+	if httpsListener != nil {
+		httpsServer := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+		servers = append(servers, httpsServer)
+		go serve(httpsServer, tls.NewListener(httpsListener, tlsConfig))
+	}
+
+	return servers
+}
+
+// shutdownServers gracefully shuts down servers concurrently, waiting for the
+// requests that they're handling to finish. If they haven't finished after
+// timeout, their connections are closed and an error is returned. A timeout
+// of 0 waits indefinitely.
+func shutdownServers(servers []*http.Server, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	errs := make(chan error, len(servers))
+	for _, httpServer := range servers {
+		go func(httpServer *http.Server) {
+			err := httpServer.Shutdown(ctx)
+			if err != nil {
+				httpServer.Close()
+			}
+			errs <- err
+		}(httpServer)
+	}
+
+	var firstErr error
+	for range servers {
+		err := <-errs
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// validateSpec checks the spec and fixtures that the server would be built
+// with for problems. Returns an error listing them if there are any.
+func validateSpec(config *server.Config) error {
+	problems, err := server.CheckSpec(config)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Found %d problem(s) in the spec:\n%s\n", len(problems),
+			strings.Join(problems, "\n"))
+	}
+	return nil
 }
@@ -1,193 +1,30 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	assert "github.com/stretchr/testify/require"
-	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/server"
 )
 
-var applicationFeeRefundCreateMethod *spec.Operation
-var applicationFeeRefundGetMethod *spec.Operation
-var chargeAllMethod *spec.Operation
-var chargeCreateMethod *spec.Operation
-var chargeDeleteMethod *spec.Operation
-var chargeGetMethod *spec.Operation
-var invoicePayMethod *spec.Operation
-
-// Try to avoid using the real spec as much as possible because it's more
-// complicated and slower. A test spec is provided below. If you do use it,
-// don't mutate it.
-var realSpec spec.Spec
-var realFixtures spec.Fixtures
-var realComponentsForValidation *spec.ComponentsForValidation
-
-var testSpec spec.Spec
-var testFixtures spec.Fixtures
-
-func init() {
-	initRealSpec()
-	initTestSpec()
-}
-
-func initRealSpec() {
-	// Load the spec information from go-bindata
-	data, err := Asset("openapi/openapi/spec3.json")
-	if err != nil {
-		panic(err)
-	}
-
-	err = json.Unmarshal(data, &realSpec)
-	if err != nil {
-		panic(err)
-	}
-
-	realComponentsForValidation =
-		spec.GetComponentsForValidation(&realSpec.Components)
-
-	// And do the same for fixtures
-	data, err = Asset("openapi/openapi/fixtures3.json")
-	if err != nil {
-		panic(err)
-	}
-
-	err = json.Unmarshal(data, &realFixtures)
-	if err != nil {
-		panic(err)
-	}
-}
-
-func initTestSpec() {
-	// These are basically here to give us a URL to test against that has
-	// multiple parameters in it.
-	applicationFeeRefundCreateMethod = &spec.Operation{}
-	applicationFeeRefundGetMethod = &spec.Operation{}
-
-	chargeAllMethod = &spec.Operation{}
-	chargeCreateMethod = &spec.Operation{
-		RequestBody: &spec.RequestBody{
-			Content: map[string]spec.MediaType{
-				"application/x-www-form-urlencoded": {
-					Schema: &spec.Schema{
-						AdditionalProperties: false,
-						Properties: map[string]*spec.Schema{
-							"amount": {
-								Type: "integer",
-							},
-						},
-						Required: []string{"amount"},
-					},
-				},
-			},
-		},
-		Responses: map[spec.StatusCode]spec.Response{
-			"200": {
-				Content: map[string]spec.MediaType{
-					"application/json": {
-						Schema: &spec.Schema{
-							Ref: "#/components/schemas/charge",
-						},
-					},
-				},
-			},
-		},
-	}
-	chargeDeleteMethod = &spec.Operation{
-		Responses: map[spec.StatusCode]spec.Response{
-			"200": {
-				Content: map[string]spec.MediaType{
-					"application/json": {
-						Schema: &spec.Schema{
-							Ref: "#/components/schemas/charge",
-						},
-					},
-				},
-			},
-		},
-	}
-	chargeGetMethod = &spec.Operation{}
-
-	// Here so we can test the relatively rare "action" operations (e.g.,
-	// `POST` to `/pay` on an invoice).
-	invoicePayMethod = &spec.Operation{}
-
-	testFixtures =
-		spec.Fixtures{
-			Resources: map[spec.ResourceID]interface{}{
-				spec.ResourceID("charge"): map[string]interface{}{
-					"customer": "cus_123",
-					"id":       "ch_123",
-				},
-				spec.ResourceID("customer"): map[string]interface{}{
-					"id": "cus_123",
-				},
-				spec.ResourceID("deleted_customer"): map[string]interface{}{
-					"deleted": true,
-				},
-			},
-		}
-
-	testSpec = spec.Spec{
-		Components: spec.Components{
-			Schemas: map[string]*spec.Schema{
-				"charge": {
-					Type: "object",
-					Properties: map[string]*spec.Schema{
-						"id": {Type: "string"},
-						// Normally a customer ID, but expandable to a full
-						// customer resource
-						"customer": {
-							AnyOf: []*spec.Schema{
-								{Type: "string"},
-								{Ref: "#/components/schemas/customer"},
-							},
-							XExpansionResources: &spec.ExpansionResources{
-								OneOf: []*spec.Schema{
-									{Ref: "#/components/schemas/customer"},
-								},
-							},
-						},
-					},
-					XExpandableFields: &[]string{"customer"},
-					XResourceID:       "charge",
-				},
-				"customer": {
-					Type:        "object",
-					XResourceID: "customer",
-				},
-				"deleted_customer": {
-					Properties: map[string]*spec.Schema{
-						"deleted": {Type: "boolean"},
-					},
-					Type:        "object",
-					XResourceID: "deleted_customer",
-				},
-			},
-		},
-		Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
-			spec.Path("/v1/application_fees/{fee}/refunds"): {
-				"get": applicationFeeRefundCreateMethod,
-			},
-			spec.Path("/v1/application_fees/{fee}/refunds/{id}"): {
-				"get": applicationFeeRefundGetMethod,
-			},
-			spec.Path("/v1/charges"): {
-				"get":  chargeAllMethod,
-				"post": chargeCreateMethod,
-			},
-			spec.Path("/v1/charges/{id}"): {
-				"get":    chargeGetMethod,
-				"delete": chargeDeleteMethod,
-			},
-			spec.Path("/v1/invoices/{id}/pay"): {
-				"post": invoicePayMethod,
-			},
-		},
-	}
-}
-
 func TestCheckConflictingOptions(t *testing.T) {
 	//
 	// Valid sets of options (not exhaustive, but included quite a few standard invocations)
@@ -262,6 +99,16 @@ func TestCheckConflictingOptions(t *testing.T) {
 		assert.Equal(t, fmt.Errorf("Please don't specify -http when using -http-port or -http-unix"), err)
 	}
 
+	// A port chosen by the OS counts as a port
+	{
+		options := &options{
+			http:     true,
+			httpPort: ephemeralPort,
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please don't specify -http when using -http-port or -http-unix"), err)
+	}
+
 	{
 		options := &options{
 			http:           true,
@@ -346,4 +193,725 @@ func TestCheckConflictingOptions(t *testing.T) {
 		err := options.checkConflictingOptions()
 		assert.Equal(t, fmt.Errorf("Please specify only one of -https-port or -https-unix"), err)
 	}
+
+	{
+		options := &options{
+			httpsCAPath: "ca.pem",
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -https, -https-port, or -https-unix when using -https-ca"), err)
+	}
+
+	{
+		options := &options{
+			httpsCertPath: "cert.pem",
+			httpsKeyPath:  "key.pem",
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -https, -https-port, or -https-unix when using -https-cert or -https-key"), err)
+	}
+
+	{
+		options := &options{
+			https:         true,
+			httpsCertPath: "cert.pem",
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify both -https-cert and -https-key"), err)
+	}
+
+	{
+		options := &options{
+			https:         true,
+			httpsCertPath: "cert.pem",
+			httpsKeyPath:  "key.pem",
+		}
+		err := options.checkConflictingOptions()
+		assert.NoError(t, err)
+	}
+
+	{
+		options := &options{
+			https:           true,
+			httpsClientAuth: "request",
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -https-ca when using -https-client-auth"), err)
+	}
+
+	{
+		options := &options{
+			httpsCAPath:     "ca.pem",
+			httpsClientAuth: "optional",
+			httpsPort:       12112,
+		}
+		err := options.checkConflictingOptions()
+		assert.Error(t, err)
+	}
+
+	{
+		options := &options{
+			httpsCAPath:     "ca.pem",
+			httpsClientAuth: "request",
+			httpsPort:       12112,
+		}
+		err := options.checkConflictingOptions()
+		assert.NoError(t, err)
+	}
+
+	//
+	// Spec
+	//
+
+	{
+		options := &options{
+			apiVersions: "2018-07-27",
+			specPath:    "spec3.json",
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please don't specify -api-versions when using -spec"), err)
+	}
+
+	{
+		options := &options{
+			specPath:         "spec3.json",
+			specPollInterval: time.Minute,
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a URL for -spec, -fixtures, or -fixtures-override when using -spec-poll-interval"), err)
+	}
+
+	{
+		options := &options{
+			specPath:         "https://example.com/spec3.json",
+			specPollInterval: time.Minute,
+		}
+		err := options.checkConflictingOptions()
+		assert.NoError(t, err)
+	}
+
+	//
+	// CORS
+	//
+
+	{
+		options := &options{
+			corsOrigins: "http://localhost:3000",
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -cors when using -cors-origins"), err)
+	}
+
+	{
+		options := &options{
+			cors:        true,
+			corsOrigins: "http://localhost:3000",
+		}
+		err := options.checkConflictingOptions()
+		assert.NoError(t, err)
+	}
+
+	{
+		options := &options{
+			corsOriginRegex: `https://.*\.example\.com`,
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -cors when using -cors-origin-regex"), err)
+	}
+
+	//
+	// Fixtures override
+	//
+
+	{
+		options := &options{
+			fixturesOverrideReplace: true,
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -fixtures-override when using -fixtures-override-replace"), err)
+	}
+
+	{
+		options := &options{
+			fixturesOverridePath:    "override.json",
+			fixturesOverrideReplace: true,
+		}
+		err := options.checkConflictingOptions()
+		assert.NoError(t, err)
+	}
+
+	//
+	// Watch
+	//
+
+	{
+		options := &options{
+			watch: true,
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -spec, -fixtures, -fixtures-dir, or -fixtures-override when using -watch"), err)
+	}
+
+	{
+		options := &options{
+			specPath: "spec3.json",
+			watch:    true,
+		}
+		err := options.checkConflictingOptions()
+		assert.NoError(t, err)
+	}
+
+	//
+	// Generated strings
+	//
+
+	{
+		options := &options{
+			stringLength: -1,
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -string-length of 0 or more"), err)
+	}
+
+	//
+	// Logging
+	//
+
+	{
+		options := &options{
+			quiet:   true,
+			verbose: true,
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify only one of -quiet or -verbose"), err)
+	}
+
+	//
+	// Unix sockets
+	//
+
+	{
+		options := &options{
+			unixSocketMode: "0666",
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify -unix, -http-unix, or -https-unix when using -unix-socket-mode"), err)
+	}
+
+	{
+		options := &options{
+			unixSocket:     "/tmp/stripe-mock.sock",
+			unixSocketMode: "0999",
+		}
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Invalid Unix socket mode: 0999 (expected permissions in octal, like 0666)"), err)
+	}
+}
+
+func TestGetListeners(t *testing.T) {
+	// A port that's already taken
+	taken, err := net.Listen("tcp", ":0")
+	assert.NoError(t, err)
+	defer taken.Close()
+	takenPort := listenerPort(taken)
+
+	// Both listeners are opened
+	{
+		options := &options{httpPort: ephemeralPort, httpsPort: ephemeralPort}
+		httpListener, httpsListener, err := options.getListeners()
+		assert.NoError(t, err)
+		assert.NotNil(t, httpListener)
+		assert.NotNil(t, httpsListener)
+		httpListener.Close()
+		httpsListener.Close()
+	}
+
+	// Every listener that fails is reported
+	{
+		options := &options{httpPort: takenPort, httpsPort: takenPort}
+		_, _, err := options.getListeners()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "HTTP: error listening on port")
+		assert.Contains(t, err.Error(), "HTTPS: error listening on port")
+	}
+
+	// A listener that was opened is closed again if the other fails
+	{
+		free, err := net.Listen("tcp", ":0")
+		assert.NoError(t, err)
+		freePort := listenerPort(free)
+		free.Close()
+
+		options := &options{httpPort: freePort, httpsPort: takenPort}
+		_, _, err = options.getListeners()
+		assert.Error(t, err)
+		assert.NotContains(t, err.Error(), "HTTP: ")
+
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", freePort))
+		assert.NoError(t, err)
+		listener.Close()
+	}
+}
+
+func TestGetLogLevel(t *testing.T) {
+	assert.Equal(t, "info", (&options{logLevel: "info"}).getLogLevel())
+	assert.Equal(t, "debug", (&options{logLevel: "info", verbose: true}).getLogLevel())
+	assert.Equal(t, "error", (&options{logLevel: "info", quiet: true}).getLogLevel())
+	assert.Equal(t, "error", (&options{logLevel: "debug", quiet: true}).getLogLevel())
+}
+
+func TestGetPortListener(t *testing.T) {
+	// The OS chooses a port
+	listener, err := getPortListener(ephemeralPort)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	port := listenerPort(listener)
+	assert.NotEqual(t, 0, port)
+
+	// And it's the one that's listened on
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NoError(t, err)
+	conn.Close()
+}
+
+func TestGetUnixSocketListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripe-mock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	unixSocket := filepath.Join(dir, "stripe-mock.sock")
+
+	// The socket is given the requested permissions
+	listener, err := getUnixSocketListener(unixSocket, 0666)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(unixSocket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0666), info.Mode().Perm())
+
+	// A socket that's still listened on isn't removed
+	_, err = getUnixSocketListener(unixSocket, 0)
+	assert.Error(t, err)
+
+	// But one left behind is
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	listener, err = getUnixSocketListener(unixSocket, 0)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	conn, err := net.Dial("unix", unixSocket)
+	assert.NoError(t, err)
+	conn.Close()
+}
+
+func TestGetTLSCertificate(t *testing.T) {
+	// The bundled certificate
+	certificate, err := getTLSCertificate("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(certificate.Certificate))
+
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certPath, keyPath := writeServerCertificate(t, dir, "server")
+	certificate, err = getTLSCertificate(certPath, keyPath)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "server", leaf.Subject.CommonName)
+
+	// The custom certificate is used for HTTPS
+	tlsConfig, err := (&options{https: true, httpsCertPath: certPath,
+		httpsKeyPath: keyPath}).getTLSConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, certificate.Certificate, tlsConfig.Certificates[0].Certificate)
+
+	// A key that doesn't match the certificate
+	_, otherKeyPath := writeServerCertificate(t, dir, "other")
+	_, err = getTLSCertificate(certPath, otherKeyPath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), certPath)
+
+	// A certificate that can't be parsed
+	invalidPath := filepath.Join(dir, "invalid.pem")
+	err = ioutil.WriteFile(invalidPath, []byte("not a certificate"), 0644)
+	assert.NoError(t, err)
+	_, err = getTLSCertificate(invalidPath, keyPath)
+	assert.Error(t, err)
+
+	_, err = getTLSCertificate(filepath.Join(dir, "missing.pem"), keyPath)
+	assert.Error(t, err)
+}
+
+func TestGetTLSConfig(t *testing.T) {
+	tlsConfig, err := (&options{https: true}).getTLSConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+	assert.Equal(t, 1, len(tlsConfig.Certificates))
+	assert.Equal(t, []string{"http/1.1"}, tlsConfig.NextProtos)
+
+	tlsConfig, err = (&options{https: true, http2: true}).getTLSConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"h2", "http/1.1"}, tlsConfig.NextProtos)
+
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caPEM, clientCertificate := generateClientCertificate(t)
+	caPath := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caPath, caPEM, 0644)
+	assert.NoError(t, err)
+
+	// Client certificates are required by default
+	{
+		tlsConfig, err := (&options{https: true, httpsCAPath: caPath}).getTLSConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+		assert.Error(t, tlsHandshake(tlsConfig, nil))
+		assert.NoError(t, tlsHandshake(tlsConfig, &clientCertificate))
+	}
+
+	// Or only verified if they're given
+	{
+		tlsConfig, err := (&options{https: true, httpsCAPath: caPath,
+			httpsClientAuth: "request"}).getTLSConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+
+		assert.NoError(t, tlsHandshake(tlsConfig, nil))
+		assert.NoError(t, tlsHandshake(tlsConfig, &clientCertificate))
+	}
+
+	// A certificate that wasn't issued by the CA is rejected
+	{
+		tlsConfig, err := (&options{https: true, httpsCAPath: caPath,
+			httpsClientAuth: "request"}).getTLSConfig()
+		assert.NoError(t, err)
+
+		_, otherCertificate := generateClientCertificate(t)
+		assert.Error(t, tlsHandshake(tlsConfig, &otherCertificate))
+	}
+
+	// A file without any certificates
+	err = ioutil.WriteFile(caPath, []byte("not a certificate"), 0644)
+	assert.NoError(t, err)
+	_, err = (&options{https: true, httpsCAPath: caPath}).getTLSConfig()
+	assert.Error(t, err)
+
+	_, err = (&options{https: true,
+		httpsCAPath: filepath.Join(dir, "missing.pem")}).getTLSConfig()
+	assert.Error(t, err)
+}
+
+func TestParseList(t *testing.T) {
+	assert.Equal(t, []string(nil), parseList(""))
+	assert.Equal(t, []string{"2018-07-27"}, parseList("2018-07-27"))
+	assert.Equal(t, []string{"2018-07-27", "2019-02-19"},
+		parseList("2018-07-27, 2019-02-19,"))
+}
+
+func TestValidateSpec(t *testing.T) {
+	err := validateSpec(&server.Config{})
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "spec")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	specPath := filepath.Join(dir, "spec.json")
+	err = ioutil.WriteFile(specPath, []byte(`{
+		"paths": {"/v1/charges": {"get": {"responses": {"200": {"content": {
+			"application/json": {"schema": {"$ref": "#/components/schemas/charge"}}
+		}}}}}}
+	}`), 0644)
+	assert.NoError(t, err)
+
+	err = validateSpec(&server.Config{SpecPath: specPath})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Found ")
+	assert.Contains(t, err.Error(),
+		"GET /v1/charges 200 response (application/json): $ref "+
+			"#/components/schemas/charge doesn't resolve")
+}
+
+func TestServeAPI(t *testing.T) {
+	stub, err := server.NewServer(&server.Config{Stateful: true})
+	assert.NoError(t, err)
+	defer stub.Stop()
+
+	options := &options{httpPort: ephemeralPort, httpsPort: ephemeralPort}
+	httpListener, httpsListener, err := options.getListeners()
+	assert.NoError(t, err)
+	tlsConfig, err := options.getTLSConfig()
+	assert.NoError(t, err)
+
+	servers := serveAPI(stub, httpListener, httpsListener, tlsConfig)
+	defer shutdownServers(servers, time.Minute)
+	assert.Equal(t, 2, len(servers))
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	send := func(method, url, body string) map[string]interface{} {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer sk_test_123")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		assert.NoError(t, err)
+		return data
+	}
+
+	httpURL := fmt.Sprintf("http://127.0.0.1:%d", listenerPort(httpListener))
+	httpsURL := fmt.Sprintf("https://127.0.0.1:%d", listenerPort(httpsListener))
+
+	// An object created over HTTP can be retrieved over HTTPS, and the other
+	// way around
+	created := send("POST", httpURL+"/v1/customers", "email=http@example.com")
+	retrieved := send("GET", httpsURL+"/v1/customers/"+created["id"].(string), "")
+	assert.Equal(t, "http@example.com", retrieved["email"])
+
+	created = send("POST", httpsURL+"/v1/customers", "email=https@example.com")
+	retrieved = send("GET", httpURL+"/v1/customers/"+created["id"].(string), "")
+	assert.Equal(t, "https@example.com", retrieved["email"])
+}
+
+func TestServeAPI_HTTP2(t *testing.T) {
+	stub, err := server.NewServer(&server.Config{})
+	assert.NoError(t, err)
+	defer stub.Stop()
+
+	for _, http2 := range []bool{true, false} {
+		options := &options{http2: http2, httpsPort: ephemeralPort}
+		_, httpsListener, err := options.getListeners()
+		assert.NoError(t, err)
+		tlsConfig, err := options.getTLSConfig()
+		assert.NoError(t, err)
+
+		servers := serveAPI(stub, nil, httpsListener, tlsConfig)
+		defer shutdownServers(servers, time.Minute)
+
+		// A transport with its own TLS config only tries HTTP/2 when forced to
+		client := &http.Client{Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		}}
+		req, err := http.NewRequest("GET", fmt.Sprintf("https://127.0.0.1:%d/v1/charges",
+			listenerPort(httpsListener)), nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer sk_test_123")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		assert.NoError(t, err)
+		assert.Equal(t, "list", data["object"])
+
+		if http2 {
+			assert.Equal(t, "HTTP/2.0", resp.Proto)
+		} else {
+			assert.Equal(t, "HTTP/1.1", resp.Proto)
+		}
+	}
+}
+
+func TestShutdownServers(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	httpServer := &http.Server{Handler: handler}
+	go serve(httpServer, listener)
+
+	// A request is in flight when the server is shut down
+	respErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- shutdownServers([]*http.Server{httpServer}, time.Minute)
+	}()
+
+	// It gets to finish, and the server is shut down afterwards
+	close(release)
+	assert.NoError(t, <-respErr)
+	assert.NoError(t, <-shutdownErr)
+
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownServers_Timeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	httpServer := &http.Server{Handler: handler}
+	go serve(httpServer, listener)
+
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	// The request doesn't finish in time
+	err = shutdownServers([]*http.Server{httpServer}, 10*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+//
+// Private functions
+//
+
+// generateClientCertificate generates a CA and a client certificate issued by
+// it. The CA is returned PEM-encoded.
+func generateClientCertificate(t *testing.T) ([]byte, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotAfter:              time.Now().Add(time.Hour),
+		NotBefore:             time.Now().Add(-time.Hour),
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stripe-mock test CA"},
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate,
+		&caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	assert.NoError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	clientTemplate := &x509.Certificate{
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		NotAfter:     time.Now().Add(time.Hour),
+		NotBefore:    time.Now().Add(-time.Hour),
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca,
+		&clientKey.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return caPEM, tls.Certificate{
+		Certificate: [][]byte{clientDER},
+		PrivateKey:  clientKey,
+	}
+}
+
+// tlsHandshake performs a TLS handshake between a server with the given config
+// and a client presenting the given certificate (or none if it's nil).
+func tlsHandshake(serverConfig *tls.Config, clientCertificate *tls.Certificate) error {
+	// A real connection is used rather than net.Pipe because its writes are
+	// buffered, so neither side blocks sending messages that the other has
+	// stopped reading (like the alert for a rejected certificate)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- tls.Server(conn, serverConfig).Handshake()
+	}()
+
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+	if clientCertificate != nil {
+		clientConfig.Certificates = []tls.Certificate{*clientCertificate}
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The server's result is the one that matters. The client's handshake can
+	// succeed even if the server goes on to reject its certificate.
+	tls.Client(conn, clientConfig).Handshake()
+	return <-serverErr
+}
+
+// writeServerCertificate generates a self-signed server certificate with the
+// given common name and writes it and its key to PEM files in dir.
+func writeServerCertificate(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		NotAfter:     time.Now().Add(time.Hour),
+		NotBefore:    time.Now().Add(-time.Hour),
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPath := filepath.Join(dir, name+"-cert.pem")
+	err = ioutil.WriteFile(certPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	assert.NoError(t, err)
+
+	keyPath := filepath.Join(dir, name+"-key.pem")
+	err = ioutil.WriteFile(keyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	assert.NoError(t, err)
+
+	return certPath, keyPath
 }
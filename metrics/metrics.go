@@ -0,0 +1,120 @@
+// Package metrics collects metrics about the requests that stripe-mock
+// handles and exposes them in the Prometheus text exposition format, so that
+// they can be scraped during load tests.
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+//
+// Public values
+//
+
+// DefaultBuckets are the upper bounds in seconds of the buckets of the
+// response generation histogram. They're tuned for stripe-mock's responses,
+// which are usually generated in well under a millisecond.
+var DefaultBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1,
+}
+
+//
+// Public types
+//
+
+// Metrics is a concurrency-safe collection of request metrics. It implements
+// http.Handler to serve them in the Prometheus text format.
+//
+// Its metrics are kept in their own registry rather than Prometheus's default
+// one, so that only stripe-mock's own metrics are served.
+type Metrics struct {
+	generations *prometheus.HistogramVec
+	handler     http.Handler
+	registry    *prometheus.Registry
+	requests    *prometheus.CounterVec
+}
+
+// NewMetrics initializes a new Metrics. storedObjects is called when metrics
+// are scraped to get the current size of the stateful store, and may be nil
+// if stripe-mock isn't running in stateful mode.
+func NewMetrics(storedObjects func() int) *Metrics {
+	return newMetrics(storedObjects, DefaultBuckets)
+}
+
+// ObserveGeneration records how long it took to generate the response for a
+// request to the given path.
+func (m *Metrics) ObserveGeneration(path string, elapsed time.Duration) {
+	m.generations.WithLabelValues(path).Observe(elapsed.Seconds())
+}
+
+// ObserveRequest counts a handled request. path should be the path of the
+// matched route as it appears in the OpenAPI spec (like
+// `/v1/charges/{charge}`) rather than the requested one so that the number
+// of distinct paths stays small.
+func (m *Metrics) ObserveRequest(method, path string, status int) {
+	m.requests.WithLabelValues(method, path, strconv.Itoa(status)).Inc()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+// Write writes the metrics in the Prometheus text format. Series are sorted
+// so that the output is stable.
+func (m *Metrics) Write(w io.Writer) error {
+	families, err := m.registry.Gather()
+	if err != nil {
+		return err
+	}
+
+	encoder := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, family := range families {
+		err := encoder.Encode(family)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// Private functions
+//
+
+// newMetrics is NewMetrics with the bounds of the buckets of the response
+// generation histogram.
+func newMetrics(storedObjects func() int, buckets []float64) *Metrics {
+	m := &Metrics{
+		generations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stripe_mock_response_generation_seconds",
+			Help:    "Time taken to generate responses, by path.",
+			Buckets: buckets,
+		}, []string{"path"}),
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stripe_mock_requests_total",
+			Help: "Requests handled, by method, path, and status.",
+		}, []string{"method", "path", "status"}),
+	}
+	m.registry.MustRegister(m.generations, m.requests)
+
+	if storedObjects != nil {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "stripe_mock_stored_objects",
+			Help: "Objects currently in the stateful store.",
+		}, func() float64 {
+			return float64(storedObjects())
+		}))
+	}
+
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return m
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestMetrics_Write(t *testing.T) {
+	m := newMetrics(func() int { return 3 }, []float64{0.001, 0.01})
+
+	m.ObserveRequest("POST", "/v1/charges", 200)
+	m.ObserveRequest("GET", "/v1/charges/{charge}", 404)
+	m.ObserveRequest("POST", "/v1/charges", 200)
+	m.ObserveGeneration("/v1/charges", 500*time.Microsecond)
+	m.ObserveGeneration("/v1/charges", 5*time.Millisecond)
+	m.ObserveGeneration("/v1/charges", time.Second)
+
+	var out bytes.Buffer
+	err := m.Write(&out)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP stripe_mock_requests_total Requests handled, by method, path, and status.
+# TYPE stripe_mock_requests_total counter
+stripe_mock_requests_total{method="GET",path="/v1/charges/{charge}",status="404"} 1
+stripe_mock_requests_total{method="POST",path="/v1/charges",status="200"} 2
+# HELP stripe_mock_response_generation_seconds Time taken to generate responses, by path.
+# TYPE stripe_mock_response_generation_seconds histogram
+stripe_mock_response_generation_seconds_bucket{path="/v1/charges",le="0.001"} 1
+stripe_mock_response_generation_seconds_bucket{path="/v1/charges",le="0.01"} 2
+stripe_mock_response_generation_seconds_bucket{path="/v1/charges",le="+Inf"} 3
+stripe_mock_response_generation_seconds_sum{path="/v1/charges"} 1.0055
+stripe_mock_response_generation_seconds_count{path="/v1/charges"} 3
+# HELP stripe_mock_stored_objects Objects currently in the stateful store.
+# TYPE stripe_mock_stored_objects gauge
+stripe_mock_stored_objects 3
+`, out.String())
+}
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	m := NewMetrics(nil)
+	m.ObserveRequest("GET", "/v1/charges", 200)
+
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, recorder.Body.String(),
+		`stripe_mock_requests_total{method="GET",path="/v1/charges",status="200"} 1`)
+
+	// Without a store, there's no gauge for its size
+	assert.NotContains(t, recorder.Body.String(), "stripe_mock_stored_objects")
+
+	// Label values are escaped
+	m.ObserveRequest("GET", "/v1/\"quoted\"", 404)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, recorder.Body.String(),
+		`stripe_mock_requests_total{method="GET",path="/v1/\"quoted\"",status="404"} 1`)
+}
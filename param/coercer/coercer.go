@@ -2,9 +2,11 @@ package coercer
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 
+	"github.com/stripe/stripe-mock/param"
 	"github.com/stripe/stripe-mock/spec"
 )
 
@@ -13,16 +15,81 @@ import (
 // input format like form-encoding doesn't support anything but strings, and
 // we'd like to work with a slightly wider variety of types like booleans and
 // integers.
+//
+// Parameters decoded from JSON already have types, but their numbers are
+// always floats, so whole numbers are coerced to integers where required.
+//
+// If a parameter's schema requires a primitive type, but its value can't be
+// coerced to that type, an *InvalidValueError naming the parameter is
+// returned.
 func CoerceParams(schema *spec.Schema, data map[string]interface{}) error {
+	return coerceParams(schema, data, "")
+}
+
+// InvalidValueError is returned by CoerceParams when a parameter's value
+// can't be coerced to the primitive type that its schema requires.
+type InvalidValueError struct {
+	// Param is the name of the parameter in the form used by the Stripe API.
+	// Nested parameters look like `card[exp_month]` or `items[0][quantity]`.
+	Param string
+
+	// Type is the JSON schema type that the value couldn't be coerced to.
+	Type string
+
+	// Value is the value as it was received.
+	Value string
+}
+
+func (e *InvalidValueError) Error() string {
+	return fmt.Sprintf("Invalid %s: %s", typeDisplayNames[e.Type], e.Value)
+}
+
+//
+// ---
+//
+
+// Various identifiers for types in JSON schema.
+const (
+	arrayType   = "array"
+	booleanType = "boolean"
+	integerType = "integer"
+	numberType  = "number"
+	objectType  = "object"
+)
+
+// maxSliceSize defines a somewhat arbitrary maximum size on an incoming
+// integer-indexed map that we're willing to parse so that we don't run out of
+// memory trying to allocate a slice.
+const maxSliceSize = 1000
+
+// numberPattern simply checks to see if an input string looks like a number.
+var numberPattern = regexp.MustCompile(`\A\d+\z`)
+
+// typeDisplayNames maps JSON schema types to the names used for them in the
+// messages of InvalidValueError.
+var typeDisplayNames = map[string]string{
+	booleanType: "boolean",
+	integerType: "integer",
+	numberType:  "decimal",
+}
+
+// coerceParams is the implementation of CoerceParams. prefix is the name of
+// the parameter that data was found under, and is empty at the top level.
+func coerceParams(schema *spec.Schema, data map[string]interface{}, prefix string) error {
 	for key, subSchema := range schema.Properties {
 		val, ok := data[key]
 		if !ok {
 			continue
 		}
 
+		paramName := param.NestedParamName(prefix, key)
+
 		valMap, ok := val.(map[string]interface{})
 		if ok {
-			CoerceParams(subSchema, valMap)
+			err := coerceParams(subSchema, valMap, paramName)
+			if err != nil {
+				return err
+			}
 
 			if subSchema.Type == arrayType {
 				valSlice, err := parseIntegerIndexedMap(valMap)
@@ -44,13 +111,21 @@ func CoerceParams(schema *spec.Schema, data map[string]interface{}) error {
 		if ok {
 			if subSchema.Items != nil {
 				for i, itemVal := range valArr {
+					itemParam := param.NestedParamName(paramName, strconv.Itoa(i))
+
 					itemValMap, ok := itemVal.(map[string]interface{})
 					if ok {
 						// Handles the case of an array of generic objects
-						CoerceParams(subSchema.Items, itemValMap)
+						err := coerceParams(subSchema.Items, itemValMap, itemParam)
+						if err != nil {
+							return err
+						}
 					} else if subSchema.Items.Type != "" {
 						// Handles the case of an array of primitive types
-						itemValCoerced, ok := coerceSchema(itemVal, subSchema.Items)
+						itemValCoerced, ok, err := coerceSchema(itemVal, subSchema.Items, itemParam)
+						if err != nil {
+							return err
+						}
 						if ok {
 							valArr[i] = itemValCoerced
 						}
@@ -61,7 +136,10 @@ func CoerceParams(schema *spec.Schema, data map[string]interface{}) error {
 			continue
 		}
 
-		valCoerced, ok := coerceSchema(val, subSchema)
+		valCoerced, ok, err := coerceSchema(val, subSchema, paramName)
+		if err != nil {
+			return err
+		}
 		if ok {
 			data[key] = valCoerced
 		}
@@ -70,32 +148,18 @@ func CoerceParams(schema *spec.Schema, data map[string]interface{}) error {
 	return nil
 }
 
-//
-// ---
-//
-
-// Various identifiers for types in JSON schema.
-const (
-	arrayType   = "array"
-	booleanType = "boolean"
-	integerType = "integer"
-	numberType  = "number"
-	objectType  = "object"
-)
-
-// maxSliceSize defines a somewhat arbitrary maximum size on an incoming
-// integer-indexed map that we're willing to parse so that we don't run out of
-// memory trying to allocate a slice.
-const maxSliceSize = 1000
-
-// numberPattern simply checks to see if an input string looks like a number.
-var numberPattern = regexp.MustCompile(`\A\d+\z`)
-
 // coercePrimitiveType tries to coerce a primitive type (e.g. bool, int, etc.)
 // from the given generic interface{} value. On success it returns a coerced
 // value with a boolean true. On failure (say the value wasn't a type that
 // could be coerced) it returns nil and a boolean false.
 func coercePrimitiveType(val interface{}, primitiveType string) (interface{}, bool) {
+	if valFloat, ok := val.(float64); ok {
+		if primitiveType == integerType && valFloat == math.Trunc(valFloat) {
+			return int(valFloat), true
+		}
+		return nil, false
+	}
+
 	valStr, ok := val.(string)
 	if !ok {
 		return nil, false
@@ -103,11 +167,15 @@ func coercePrimitiveType(val interface{}, primitiveType string) (interface{}, bo
 
 	switch {
 	case primitiveType == booleanType:
-		valBool, err := strconv.ParseBool(valStr)
-		if err != nil {
-			return nil, false
+		// Only a few spellings are accepted, which is stricter than
+		// strconv.ParseBool.
+		switch valStr {
+		case "true", "1":
+			return true, true
+		case "false", "0":
+			return false, true
 		}
-		return valBool, true
+		return nil, false
 
 	case primitiveType == integerType:
 		valInt, err := strconv.Atoi(valStr)
@@ -133,29 +201,75 @@ func coercePrimitiveType(val interface{}, primitiveType string) (interface{}, bo
 // It's similar to coercePrimitiveType above (and indeed calls into it), but
 // also handles the case of an anyOf schema that supports a number of different
 // primitve types.
-func coerceSchema(val interface{}, schema *spec.Schema) (interface{}, bool) {
+//
+// An *InvalidValueError is returned for the parameter param if the schema
+// requires a single primitive type and the value is a scalar (like a string,
+// or a number or boolean from JSON) that can't be coerced to it.
+func coerceSchema(val interface{}, schema *spec.Schema, param string) (interface{}, bool, error) {
 	if isSchemaPrimitiveType(schema) {
-		return coercePrimitiveType(val, schema.Type)
+		valCoerced, ok := coercePrimitiveType(val, schema.Type)
+		if !ok && !hasPrimitiveType(val, schema.Type) {
+			if valStr, isScalar := formatScalar(val); isScalar {
+				return nil, false, &InvalidValueError{
+					Param: param,
+					Type:  schema.Type,
+					Value: valStr,
+				}
+			}
+		}
+		return valCoerced, ok, nil
 	}
 
 	if schema.AnyOf != nil {
 		for _, subSchema := range schema.AnyOf {
 			if isSchemaPrimitiveType(subSchema) {
-				val, ok := coerceSchema(val, subSchema)
+				val, ok := coercePrimitiveType(val, subSchema.Type)
 				if ok {
-					return val, ok
+					return val, ok, nil
 				}
 			} else {
 				valMap, ok := val.(map[string]interface{})
 				if ok {
-					CoerceParams(subSchema, valMap)
-					return valMap, ok
+					err := coerceParams(subSchema, valMap, param)
+					if err != nil {
+						return nil, false, err
+					}
+					return valMap, ok, nil
 				}
 			}
 		}
 	}
 
-	return nil, false
+	return nil, false, nil
+}
+
+// formatScalar formats a scalar value as it would've been received in a form
+// (e.g. `12.5` or `true`). Its second return value is false if the value isn't
+// a scalar.
+func formatScalar(val interface{}) (string, bool) {
+	switch val := val.(type) {
+	case bool:
+		return strconv.FormatBool(val), true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case string:
+		return val, true
+	}
+	return "", false
+}
+
+// hasPrimitiveType checks whether a value already has the given primitive
+// type, as values decoded from JSON may.
+func hasPrimitiveType(val interface{}, primitiveType string) bool {
+	switch val.(type) {
+	case bool:
+		return primitiveType == booleanType
+	case float64:
+		return primitiveType == numberType
+	case int:
+		return primitiveType == integerType
+	}
+	return false
 }
 
 // isSchemaPrimitiveType checks whether the given schema is a coercable
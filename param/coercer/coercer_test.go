@@ -148,13 +148,22 @@ func TestCoerceParams_BooleanCoercion(t *testing.T) {
 	schema := &spec.Schema{Properties: map[string]*spec.Schema{
 		"boolkey": {Type: booleanType},
 	}}
-	data := map[string]interface{}{
-		"boolkey": "true",
+
+	testCases := map[string]bool{
+		"true":  true,
+		"false": false,
+		"1":     true,
+		"0":     false,
 	}
+	for valStr, expected := range testCases {
+		data := map[string]interface{}{
+			"boolkey": valStr,
+		}
 
-	err := CoerceParams(schema, data)
-	assert.NoError(t, err)
-	assert.Equal(t, true, data["boolkey"])
+		err := CoerceParams(schema, data)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, data["boolkey"])
+	}
 }
 
 func TestCoerceParams_IntegerCoercion(t *testing.T) {
@@ -170,6 +179,25 @@ func TestCoerceParams_IntegerCoercion(t *testing.T) {
 	assert.Equal(t, 123, data["intkey"])
 }
 
+func TestCoerceParams_JSONValues(t *testing.T) {
+	schema := &spec.Schema{Properties: map[string]*spec.Schema{
+		"boolkey":   {Type: booleanType},
+		"intkey":    {Type: integerType},
+		"numberkey": {Type: numberType},
+	}}
+	data := map[string]interface{}{
+		"boolkey":   true,
+		"intkey":    123.0,
+		"numberkey": 12.5,
+	}
+
+	err := CoerceParams(schema, data)
+	assert.NoError(t, err)
+	assert.Equal(t, true, data["boolkey"])
+	assert.Equal(t, 123, data["intkey"])
+	assert.Equal(t, 12.5, data["numberkey"])
+}
+
 func TestCoerceParams_IntegerIndexedMapCoercion(t *testing.T) {
 	{
 		schema := &spec.Schema{Properties: map[string]*spec.Schema{
@@ -269,3 +297,72 @@ func TestCoerceParams_Recursion(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 123, data["mapkey"].(map[string]interface{})["intkey"])
 }
+
+func TestCoerceParams_InvalidValue(t *testing.T) {
+	schema := &spec.Schema{Properties: map[string]*spec.Schema{
+		"arraykey": {
+			Items: &spec.Schema{
+				Properties: map[string]*spec.Schema{
+					"intkey": {Type: integerType},
+				},
+			},
+			Type: arrayType,
+		},
+		"boolkey":   {Type: booleanType},
+		"intkey":    {Type: integerType},
+		"numberkey": {Type: numberType},
+	}}
+
+	testCases := []struct {
+		data    map[string]interface{}
+		param   string
+		message string
+	}{
+		{
+			map[string]interface{}{"intkey": "abc"},
+			"intkey", "Invalid integer: abc",
+		},
+		{
+			map[string]interface{}{"intkey": "12.5"},
+			"intkey", "Invalid integer: 12.5",
+		},
+		{
+			map[string]interface{}{"intkey": 12.5},
+			"intkey", "Invalid integer: 12.5",
+		},
+		{
+			map[string]interface{}{"boolkey": "yes"},
+			"boolkey", "Invalid boolean: yes",
+		},
+		{
+			map[string]interface{}{"boolkey": 1.0},
+			"boolkey", "Invalid boolean: 1",
+		},
+		{
+			map[string]interface{}{"numberkey": false},
+			"numberkey", "Invalid decimal: false",
+		},
+		{
+			map[string]interface{}{"numberkey": "abc"},
+			"numberkey", "Invalid decimal: abc",
+		},
+		{
+			map[string]interface{}{"arraykey": map[string]interface{}{
+				"0": map[string]interface{}{"intkey": "123"},
+				"1": map[string]interface{}{"intkey": "abc"},
+			}},
+			"arraykey[1][intkey]", "Invalid integer: abc",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.param, func(t *testing.T) {
+			err := CoerceParams(schema, testCase.data)
+			assert.Error(t, err)
+
+			invalidValue, ok := err.(*InvalidValueError)
+			assert.True(t, ok)
+			assert.Equal(t, testCase.param, invalidValue.Param)
+			assert.Equal(t, testCase.message, invalidValue.Error())
+		})
+	}
+}
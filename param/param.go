@@ -1,6 +1,9 @@
 package param
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -10,14 +13,27 @@ import (
 	"github.com/stripe/stripe-mock/param/parser"
 )
 
+// NestedParamName produces the name of a parameter nested under another one
+// in the form used by the Stripe API. For example, `number` under `card`
+// becomes `card[number]`. parent may be empty at the top level.
+func NestedParamName(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return fmt.Sprintf("%s[%s]", parent, name)
+}
+
 // ParseParams extracts parameters from a request that an application can
 // consume.
 //
 // Depending on the type of request, parameters may be extracted from either
-// the query string, a form-encoded body, or a multipart form-encoded body (the
-// latter being specific to only a very small number of endpoints).
+// the query string, a form-encoded body, a JSON body, or a multipart
+// form-encoded body (the latter being specific to only a very small number of
+// endpoints).
 //
-// Regardless of origin, parameters are assumed to follow "Rack-style"
+// A JSON body must contain an object, which is decoded into the same
+// structure that form-encoded parameters are assembled into. Otherwise,
+// parameters are assumed to follow "Rack-style"
 // conventions for encoding complex types like arrays and maps, which is how
 // the Stripe API decodes data. These complex types are what makes the param
 // package's implementation non-trivial. We rely on the nestedtypeassembler
@@ -42,6 +58,8 @@ func ParseParams(r *http.Request) (map[string]interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
+	} else if contentType == JSONMediaType {
+		return parseJSONBody(r)
 	} else if contentType == multipartMediaType {
 		err := r.ParseMultipartForm(maxMemory)
 		if err != nil {
@@ -88,6 +106,13 @@ func ParseParams(r *http.Request) (map[string]interface{}, error) {
 	return nestedtypeassembler.AssembleParams(values)
 }
 
+//
+// Public constants
+//
+
+// JSONMediaType is the `Content-Type` for a request with a JSON body.
+const JSONMediaType = "application/json"
+
 //
 // Private constants
 //
@@ -100,3 +125,33 @@ const maxMemory = 1 * 1024 * 1024
 
 // multipartMediaType is the `Content-Type` for a multipart request.
 const multipartMediaType = "multipart/form-data"
+
+//
+// Private functions
+//
+
+// parseJSONBody decodes a JSON body containing an object into parameters. An
+// empty body has no parameters.
+func parseJSONBody(r *http.Request) (map[string]interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return make(map[string]interface{}), nil
+	}
+
+	var value interface{}
+	err = json.Unmarshal(body, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	params, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("JSON body must be an object")
+	}
+	return params, nil
+}
@@ -10,6 +10,12 @@ import (
 	assert "github.com/stretchr/testify/require"
 )
 
+func TestNestedParamName(t *testing.T) {
+	assert.Equal(t, "card", NestedParamName("", "card"))
+	assert.Equal(t, "card[number]", NestedParamName("card", "number"))
+	assert.Equal(t, "items[0][price]", NestedParamName("items[0]", "price"))
+}
+
 func TestParseParams_Get(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/?foo=bar", nil)
 	params, err := ParseParams(req)
@@ -66,3 +72,34 @@ func TestParseParams_MultipartFormFromFile(t *testing.T) {
 		"foo": "bar",
 	}, params)
 }
+
+func TestParseParams_JSON(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/",
+		bytes.NewBufferString(`{"foo": "bar", "baz": {"qux": [1, true]}}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	params, err := ParseParams(req)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"baz": map[string]interface{}{
+			"qux": []interface{}{1.0, true},
+		},
+		"foo": "bar",
+	}, params)
+}
+
+func TestParseParams_JSONEmpty(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(" "))
+	req.Header.Set("Content-Type", "application/json")
+	params, err := ParseParams(req)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, params)
+}
+
+func TestParseParams_JSONInvalid(t *testing.T) {
+	for _, body := range []string{`{"foo": `, `["foo"]`} {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		_, err := ParseParams(req)
+		assert.Error(t, err)
+	}
+}
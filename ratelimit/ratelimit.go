@@ -0,0 +1,84 @@
+// Package ratelimit provides a token bucket rate limiter. It allows
+// stripe-mock to respond to requests made too quickly with `429 Too Many
+// Requests` like the Stripe API does, so that an integration's backoff logic
+// can be exercised.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// Limiter is a concurrency-safe rate limiter that keeps a token bucket for
+// every key it's asked about.
+//
+// Each bucket holds up to one second's worth of requests and starts out full,
+// so short bursts up to the rate are allowed.
+type Limiter struct {
+	buckets map[string]*bucket
+	mu      sync.Mutex
+	rate    float64
+
+	// now returns the current time. It's a field so that it can be replaced
+	// in tests.
+	now func() time.Time
+}
+
+// NewLimiter initializes a new Limiter that allows rate requests per second
+// for each key.
+func NewLimiter(rate int) *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+		rate:    float64(rate),
+	}
+}
+
+// Allow takes a token from the bucket for the given key. If the bucket is
+// empty, the request should be rejected, and Allow returns false along with
+// how long it'll be before a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.rate, updatedAt: now}
+		l.buckets[key] = b
+	}
+
+	// Refill the bucket for the time that's passed since it was last used
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens += elapsed * l.rate
+	if b.tokens > l.rate {
+		b.tokens = l.rate
+	}
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+//
+// Private types
+//
+
+// bucket is the token bucket for a single key.
+type bucket struct {
+	// tokens is the number of requests that can currently be made. It's
+	// fractional because the bucket is refilled continuously.
+	tokens float64
+
+	updatedAt time.Time
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	l := NewLimiter(2)
+	l.now = func() time.Time { return now }
+
+	// The bucket starts out full
+	allowed, _ := l.Allow("key")
+	assert.True(t, allowed)
+	allowed, _ = l.Allow("key")
+	assert.True(t, allowed)
+
+	allowed, retryAfter := l.Allow("key")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// Other keys have their own buckets
+	allowed, _ = l.Allow("other_key")
+	assert.True(t, allowed)
+
+	// The bucket refills over time
+	now = now.Add(500 * time.Millisecond)
+	allowed, _ = l.Allow("key")
+	assert.True(t, allowed)
+	allowed, _ = l.Allow("key")
+	assert.False(t, allowed)
+
+	// But never beyond its capacity
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		allowed, _ = l.Allow("key")
+		assert.True(t, allowed)
+	}
+	allowed, _ = l.Allow("key")
+	assert.False(t, allowed)
+}
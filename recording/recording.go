@@ -0,0 +1,207 @@
+// Package recording provides a log of requests and the responses generated
+// for them, written as JSON lines. A log can be replayed later so that
+// matching requests get exactly the responses that were recorded, which is
+// useful for golden file tests.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+//
+// Public functions
+//
+
+// EncodeParams encodes a request's parameters for a Recording. It should be
+// called before the parameters are coerced or otherwise modified. Maps are
+// encoded with sorted keys, so equal parameters are always encoded the same
+// way.
+//
+// Returns nil if there are no parameters.
+func EncodeParams(params map[string]interface{}) json.RawMessage {
+	if len(params) == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return nil
+	}
+	return encoded
+}
+
+// LoadReplayer loads the recordings in the file at path into a new Replayer.
+// Later recordings of the same request take precedence over earlier ones.
+func LoadReplayer(path string) (*Replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recordings: %v", err)
+	}
+	defer file.Close()
+
+	replayer := &Replayer{recordings: make(map[recordingKey]*Recording)}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxRecordingSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var recording Recording
+		err := json.Unmarshal(scanner.Bytes(), &recording)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding recording on line %v of %s: %v",
+				line, path, err)
+		}
+
+		// Parameters are re-encoded in case the file was edited by hand
+		key, err := newRecordingKey(recording.Method, recording.Path,
+			recording.Params)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding recording on line %v of %s: %v",
+				line, path, err)
+		}
+		replayer.recordings[key] = &recording
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading recordings: %v", err)
+	}
+
+	return replayer, nil
+}
+
+// NewRecorder initializes a Recorder that appends to the file at path,
+// creating it if it doesn't exist.
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recordings: %v", err)
+	}
+	return &Recorder{file: file}, nil
+}
+
+//
+// Public types
+//
+
+// Recorder writes recordings to a file. It's safe for concurrent use.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Close closes the Recorder's file. Recordings made after it's closed fail,
+// and closing it again does nothing.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Record appends a recording to the Recorder's file as a single line.
+func (r *Recorder) Record(recording *Recording) error {
+	data, err := json.Marshal(recording)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return fmt.Errorf("recorder is closed")
+	}
+	_, err = r.file.Write(append(data, '\n'))
+	return err
+}
+
+// Recording is a request and the response that was generated for it.
+type Recording struct {
+	// Method is the request's HTTP method.
+	Method string `json:"method"`
+
+	// Params are the request's parameters from either its query string or
+	// its body (see EncodeParams).
+	Params json.RawMessage `json:"params,omitempty"`
+
+	// Path is the request's path, without its query string.
+	Path string `json:"path"`
+
+	// Response is the response's body before it's encoded.
+	Response interface{} `json:"response"`
+
+	// Status is the response's HTTP status code.
+	Status int `json:"status"`
+}
+
+// Replayer holds recordings loaded from a file so that they can be looked up
+// for incoming requests. It's read-only once loaded, and so safe for
+// concurrent use.
+type Replayer struct {
+	recordings map[recordingKey]*Recording
+}
+
+// Lookup finds the recording of a request with the given method, path, and
+// parameters (see EncodeParams). Headers aren't part of a request's identity,
+// so ones that vary between runs, like `Idempotency-Key`, don't get in the way
+// of a match.
+//
+// Returns nil if no matching request was recorded.
+func (r *Replayer) Lookup(method, path string, params json.RawMessage) *Recording {
+	key, err := newRecordingKey(method, path, params)
+	if err != nil {
+		return nil
+	}
+	return r.recordings[key]
+}
+
+//
+// Private values
+//
+
+// maxRecordingSize is the maximum size in bytes of a single line of a
+// recordings file.
+const maxRecordingSize = 16 * 1024 * 1024
+
+//
+// Private functions
+//
+
+// newRecordingKey produces the key of a request. Params are decoded and
+// encoded again so that formatting doesn't matter.
+func newRecordingKey(method, path string, params json.RawMessage) (recordingKey, error) {
+	key := recordingKey{method: method, path: path}
+	if len(params) == 0 {
+		return key, nil
+	}
+
+	var decoded map[string]interface{}
+	err := json.Unmarshal(params, &decoded)
+	if err != nil {
+		return key, err
+	}
+	key.params = string(EncodeParams(decoded))
+	return key, nil
+}
+
+//
+// Private types
+//
+
+// recordingKey identifies a request for the purposes of replaying it.
+type recordingKey struct {
+	method string
+	params string
+	path   string
+}
@@ -0,0 +1,111 @@
+package recording
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestEncodeParams(t *testing.T) {
+	assert.Nil(t, EncodeParams(nil))
+	assert.Nil(t, EncodeParams(map[string]interface{}{}))
+
+	// Keys are sorted
+	assert.Equal(t, `{"amount":"123","currency":"usd"}`,
+		string(EncodeParams(map[string]interface{}{
+			"currency": "usd",
+			"amount":   "123",
+		})))
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recordings.jsonl")
+
+	recorder, err := NewRecorder(path)
+	assert.NoError(t, err)
+
+	params := EncodeParams(map[string]interface{}{"amount": "123"})
+	assert.NoError(t, recorder.Record(&Recording{
+		Method:   "POST",
+		Params:   params,
+		Path:     "/v1/charges",
+		Response: map[string]interface{}{"id": "ch_123"},
+		Status:   200,
+	}))
+	assert.NoError(t, recorder.Record(&Recording{
+		Method:   "GET",
+		Path:     "/v1/charges/ch_123",
+		Response: map[string]interface{}{"id": "ch_123"},
+		Status:   200,
+	}))
+
+	// A later recording of the same request wins
+	assert.NoError(t, recorder.Record(&Recording{
+		Method:   "POST",
+		Params:   params,
+		Path:     "/v1/charges",
+		Response: map[string]interface{}{"id": "ch_456"},
+		Status:   200,
+	}))
+	assert.NoError(t, recorder.Close())
+
+	replayer, err := LoadReplayer(path)
+	assert.NoError(t, err)
+
+	recording := replayer.Lookup("POST", "/v1/charges", params)
+	assert.NotNil(t, recording)
+	assert.Equal(t, 200, recording.Status)
+	assert.Equal(t, map[string]interface{}{"id": "ch_456"}, recording.Response)
+
+	recording = replayer.Lookup("GET", "/v1/charges/ch_123", nil)
+	assert.NotNil(t, recording)
+
+	// Every part of the request has to match
+	assert.Nil(t, replayer.Lookup("GET", "/v1/charges", params))
+	assert.Nil(t, replayer.Lookup("POST", "/v1/customers", params))
+	assert.Nil(t, replayer.Lookup("POST", "/v1/charges", nil))
+	assert.Nil(t, replayer.Lookup("POST", "/v1/charges",
+		EncodeParams(map[string]interface{}{"amount": "456"})))
+}
+
+func TestLoadReplayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Files edited by hand may be formatted differently and have blank lines
+	path := filepath.Join(dir, "edited.jsonl")
+	err = ioutil.WriteFile(path, []byte(`
+{"method": "POST", "path": "/v1/charges", "params": {"currency": "usd", "amount": "123"}, "response": {"id": "ch_123"}, "status": 200}
+
+`), 0644)
+	assert.NoError(t, err)
+
+	replayer, err := LoadReplayer(path)
+	assert.NoError(t, err)
+	recording := replayer.Lookup("POST", "/v1/charges",
+		json.RawMessage(`{"amount":"123","currency":"usd"}`))
+	assert.NotNil(t, recording)
+
+	path = filepath.Join(dir, "malformed.jsonl")
+	err = ioutil.WriteFile(path, []byte("{\"method\": \"GET\"}\nnot json\n"), 0644)
+	assert.NoError(t, err)
+
+	_, err = LoadReplayer(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+
+	_, err = LoadReplayer(filepath.Join(dir, "missing.jsonl"))
+	assert.Error(t, err)
+}
@@ -6,7 +6,7 @@
 // openapi/openapi/spec3.json
 // DO NOT EDIT!
 
-package main
+package server
 
 import (
 	"bytes"
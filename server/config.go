@@ -0,0 +1,1108 @@
+//go:generate go-bindata -pkg server -prefix ../ ../cert/cert.pem ../cert/key.pem ../openapi/openapi/fixtures3.json ../openapi/openapi/spec3.json
+
+// Package server implements stripe-mock's API stub. It can be embedded in
+// another Go program (like a test binary) by building a Server with NewServer,
+// which can either be served on its own listener with Start or mounted as an
+// http.Handler.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-mock/idempotency"
+	"github.com/stripe/stripe-mock/logging"
+	"github.com/stripe/stripe-mock/metrics"
+	"github.com/stripe/stripe-mock/ratelimit"
+	"github.com/stripe/stripe-mock/recording"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+// DefaultGenerateTimeout is the default length of time that a response may
+// take to generate. No response generated from a valid spec should come close
+// to it.
+const DefaultGenerateTimeout = 10 * time.Second
+
+// DefaultIdempotencyTTL is the default length of time for which a response is
+// replayed for requests with the same idempotency key. It matches how long
+// the Stripe API keeps keys.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// DefaultMaintenanceRetryAfter is the default length of time that clients are
+// told to wait before retrying requests made during simulated maintenance.
+const DefaultMaintenanceRetryAfter = 30 * time.Second
+
+// DefaultMaxBodySize is the default maximum size of a request body. It's
+// generous enough for any request that the Stripe API would accept.
+const DefaultMaxBodySize = 2 * 1024 * 1024
+
+// DefaultMaxExpansionDepth is the default maximum depth of a requested
+// expansion. It matches the limit enforced by the Stripe API.
+const DefaultMaxExpansionDepth = 4
+
+// DefaultStringCharset is the default set of characters that strings in
+// synthetic objects are made of.
+const DefaultStringCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// DefaultStringLength is the default length of strings in synthetic objects.
+// It's short enough to fit in most database columns.
+const DefaultStringLength = 8
+
+// Modes for generating the IDs of created objects (see Config.IDMode).
+const (
+	// IDModeContentHash gives an object created without -stateful an ID
+	// derived from the request that created it, so that the same request
+	// always gets the same ID.
+	IDModeContentHash = "content-hash"
+
+	// IDModeRandom gives objects created with -stateful random IDs, and keeps
+	// the IDs of fixtures otherwise. It's the default.
+	IDModeRandom = "random"
+)
+
+// Modes for generating nullable fields (see Config.NullableMode).
+const (
+	// NullableModeAlways generates every nullable field as null.
+	NullableModeAlways = "always"
+
+	// NullableModeFixture generates nullable fields with the values in their
+	// fixtures, which may or may not be null. It's the default.
+	NullableModeFixture = "fixture"
+
+	// NullableModeRandom generates each nullable field as null about half of
+	// the time.
+	NullableModeRandom = "random"
+)
+
+// Modes for checking generated responses against their schemas (see
+// Config.SelfValidateMode).
+const (
+	// SelfValidateModeLog logs an error for each generated response that
+	// doesn't match its schema, which is still sent.
+	SelfValidateModeLog = "log"
+
+	// SelfValidateModeOff doesn't check generated responses. It's the
+	// default.
+	SelfValidateModeOff = "off"
+
+	// SelfValidateModeStrict responds with a 500 instead of a generated
+	// response that doesn't match its schema.
+	SelfValidateModeStrict = "strict"
+)
+
+// Version is the version of stripe-mock, which is sent back in the
+// `Stripe-Mock-Version` header of every response. It's set to the actual
+// version by GoReleaser (using `-ldflags "-X ..."`) as it's run. Versions
+// built from source will always show master.
+var Version = "master"
+
+// Config configures a Server. Its fields correspond to stripe-mock's command
+// line options. The zero value serves the bundled spec and fixtures
+// statelessly with none of the optional behavior enabled, and note that unlike
+// the command line options, limits like MaxBodySize are off unless they're
+// set (see DefaultMaxBodySize and friends).
+type Config struct {
+	// Address is the address that Start listens on, like `127.0.0.1:12111`.
+	// Defaults to a port on localhost chosen by the OS, which can be found
+	// with URL.
+	Address string
+
+	// APIVersions are the bundled API versions to load so that they can be
+	// selected with a `Stripe-Version` header. Defaults to all of them. Can't
+	// be used with SpecPath.
+	APIVersions []string
+
+	// Compression decompresses request bodies sent with `Content-Encoding:
+	// gzip`, and compresses the responses of requests sent with
+	// `Accept-Encoding: gzip`.
+	Compression bool
+
+	// CORS allows browsers to make cross-origin requests by responding to
+	// preflight requests and setting `Access-Control-Allow-*` headers.
+	CORS bool
+
+	// CORSOrigins are the origins that are allowed to make cross-origin
+	// requests with CORS, like `http://localhost:3000`. Defaults to any
+	// origin.
+	CORSOrigins []string
+
+	// CORSOriginRegex is a regular expression matching origins that are
+	// allowed to make cross-origin requests with CORS on top of CORSOrigins,
+	// like `https://[a-z0-9-]+\.preview\.example\.com`. It must match an
+	// origin as a whole.
+	CORSOriginRegex string
+
+	// DefaultAPIVersion is the API version used for requests without a
+	// `Stripe-Version` header. Defaults to the version of the primary spec.
+	DefaultAPIVersion string
+
+	// FailRate is the fraction of requests, between 0 and 1, that fail with
+	// a `500` and a `Stripe-Should-Retry: true` header to simulate transient
+	// failures of the Stripe API. Requests that fail are picked with Seed if
+	// it's set.
+	FailRate float64
+
+	// FixturesDir is the path to a directory of JSON files that are each the
+	// fixture of the resource named by the file, like `charge.json`. They're
+	// deep merged into the fixtures of every API version before those at
+	// FixturesOverridePath.
+	FixturesDir string
+
+	// FixturesOverridePath is the path to a JSON file of fixtures for some
+	// resources that are deep merged into the fixtures of every API version,
+	// pinning the values of their fields.
+	FixturesOverridePath string
+
+	// FixturesOverrideReplace replaces the fixtures of the resources in the
+	// file at FixturesOverridePath entirely instead of merging into them.
+	FixturesOverrideReplace bool
+
+	// FixturesPath is the path to a JSON file of fixtures to use instead of
+	// the bundled ones.
+	FixturesPath string
+
+	// GenerateTimeout is how long a response may take to generate before
+	// generation is abandoned and the request gets a 503, like it might for a
+	// pathological schema in a custom spec. Generation isn't limited if it's
+	// 0.
+	GenerateTimeout time.Duration
+
+	// IDMode is how the IDs of created objects are generated: IDModeRandom
+	// (the default) or IDModeContentHash, which can't be used with Stateful.
+	IDMode string
+
+	// IdempotencyTTL is how long responses are replayed for requests retried
+	// with the same `Idempotency-Key` header. Responses are never replayed if
+	// it's 0.
+	IdempotencyTTL time.Duration
+
+	// Latency is artificial latency added before responding to each request.
+	Latency time.Duration
+
+	// LatencyConfigPath is the path to a JSON file with latencies for
+	// particular paths, overriding Latency.
+	LatencyConfigPath string
+
+	// LatencyJitter is the upper bound of random jitter added to the latency
+	// of each request.
+	LatencyJitter time.Duration
+
+	// Maintenance starts the server down for simulated maintenance, during
+	// which API requests get a `503` with a `Retry-After` header. It can be
+	// toggled through the internal maintenance endpoint either way.
+	Maintenance bool
+
+	// MaintenanceRetryAfter is how long clients are told to wait before
+	// retrying requests made during maintenance. Defaults to
+	// DefaultMaintenanceRetryAfter if it's 0.
+	MaintenanceRetryAfter time.Duration
+
+	// MaxBodySize is the maximum size in bytes of a request body before
+	// responding with a 413. Body size isn't limited if it's 0.
+	MaxBodySize int64
+
+	// MaxExpansionDepth is the maximum number of levels that a single
+	// requested expansion may descend. Expansion depth isn't limited if it's
+	// 0.
+	MaxExpansionDepth int
+
+	// MaxListSize is the maximum number of objects in a synthetic list. Each
+	// list gets a random number of objects up to it (the same number for the
+	// same request with Seed). Lists always have 100 objects if it's 0.
+	MaxListSize int
+
+	// Metrics enables the collection of metrics about handled requests, which
+	// are served by the handler returned from MetricsHandler.
+	Metrics bool
+
+	// NoExpand makes the server ignore requested expansions so that objects
+	// are always returned unexpanded, which saves generating the expanded
+	// ones. Requests with expansions aren't rejected.
+	NoExpand bool
+
+	// NoFixtures ignores fixtures so that every object is synthetic,
+	// generated from its schema alone. That brings out properties that the
+	// fixtures happen to leave out.
+	NoFixtures bool
+
+	// NullableMode is how fields that the spec marks as nullable are
+	// generated: NullableModeFixture (the default), NullableModeRandom, or
+	// NullableModeAlways. Fields that are expanded are never null.
+	NullableMode string
+
+	// Pretty indents JSON responses to make them easier to read. Responses
+	// to curl are always indented.
+	Pretty bool
+
+	// RateLimit is the maximum number of requests per second before
+	// responding with a 429. Requests aren't rate limited if it's 0.
+	RateLimit int
+
+	// RateLimitPerKey applies RateLimit to each API key separately instead of
+	// to all requests.
+	RateLimitPerKey bool
+
+	// RecordPath is the path to a file that every request and the response
+	// generated for it are appended to as JSON lines (see ReplayPath).
+	// Nothing is recorded if it's empty.
+	RecordPath string
+
+	// ReplayPath is the path to a file of requests recorded with RecordPath.
+	// Requests matching a recorded one by method, path, and parameters get
+	// the recorded response instead of a generated one.
+	ReplayPath string
+
+	// RestrictedKeysReadOnly only allows `GET` requests made with restricted
+	// keys (`rk_test_...`), responding to others with a 403.
+	RestrictedKeysReadOnly bool
+
+	// ResponseContentType is the `Content-Type` header sent with responses
+	// that have a body, like `application/json; charset=utf-8`. No header is
+	// set if it's empty.
+	ResponseContentType string
+
+	// Seed seeds randomly generated values like IDs so that the same request
+	// always gets the same response. Values are random if it's nil.
+	Seed *int64
+
+	// SelfValidateMode is whether generated responses are checked against
+	// their schemas to catch problems with the generator: SelfValidateModeOff
+	// (the default), SelfValidateModeLog, or SelfValidateModeStrict.
+	SelfValidateMode string
+
+	// SpecPath is the path to a JSON OpenAPI spec to use instead of the
+	// bundled one. Like FixturesPath and FixturesOverridePath, it may be an
+	// HTTP(S) URL to fetch it from instead.
+	SpecPath string
+
+	// SpecPollInterval is how often SpecPath, FixturesPath, and
+	// FixturesOverridePath are fetched again if they're URLs, reloading the
+	// spec and fixtures when their content changes. URLs are only fetched
+	// once if it's 0.
+	SpecPollInterval time.Duration
+
+	// Stateful stores objects created with `POST` so that they can be
+	// retrieved, updated, listed, and deleted.
+	Stateful bool
+
+	// StringCharset is the characters that strings in synthetic objects are
+	// made of (see StringLength). Defaults to DefaultStringCharset.
+	StringCharset string
+
+	// StringLength is the length of the strings of random characters in
+	// synthetic objects, within the `minLength` and `maxLength` of their
+	// schemas (see DefaultStringLength). Free text like a `description` is
+	// given words instead. Strings are as short as their schemas allow if
+	// it's 0.
+	StringLength int
+
+	// StrictAuth responds to missing or malformed API keys with errors like
+	// the Stripe API's.
+	StrictAuth bool
+
+	// StrictIDs responds with a `404` to requests for objects whose IDs don't
+	// have the prefix of their resource, like a charge ID not starting with
+	// `ch_`.
+	StrictIDs bool
+
+	// Watch reloads the files at SpecPath, FixturesPath, FixturesDir, and
+	// FixturesOverridePath when they change until the server is stopped.
+	Watch bool
+
+	// WebhookSecret is the secret used to sign webhooks sent to WebhookURL.
+	WebhookSecret string
+
+	// WebhookURL is the URL to send events created with the trigger endpoint
+	// to.
+	WebhookURL string
+}
+
+// Server is a configured instance of stripe-mock. It's an http.Handler, so it
+// can be mounted on any HTTP server, or it can be served on its own listener
+// with Start.
+type Server struct {
+	address string
+
+	// httpServer serves the server once it's been started.
+	//
+	// nil if the server hasn't been started.
+	httpServer *http.Server
+
+	listener net.Listener
+
+	// metrics collects metrics about handled requests.
+	//
+	// nil if metrics aren't being collected.
+	metrics *metrics.Metrics
+
+	// mu guards httpServer, listener, and recorder.
+	mu sync.Mutex
+
+	// mutators are the functions registered with RegisterResponseMutator,
+	// which are shared with the servers for every API version.
+	mutators *responseMutators
+
+	// recorder records requests and the responses generated for them. It's
+	// closed when the server is stopped.
+	//
+	// nil if requests aren't recorded.
+	recorder *recording.Recorder
+
+	// stopWatching is closed to stop watching the spec and fixtures files
+	// and polling their URLs.
+	//
+	// nil if they aren't being watched.
+	stopWatching chan struct{}
+
+	// stub is the server for the default API version, which dispatches
+	// requests for other versions itself.
+	stub *StubServer
+}
+
+// NewServer builds a Server from the given configuration, loading the specs
+// and fixtures that it serves. It returns an error if any of them couldn't be
+// loaded or the configuration is invalid.
+func NewServer(config *Config) (*Server, error) {
+	if len(config.APIVersions) > 0 && config.SpecPath != "" {
+		return nil, fmt.Errorf("API versions can't be selected when using a custom spec")
+	}
+
+	// For both spec and fixtures stripe-mock will by default load data from
+	// internal assets compiled into the binary, but either one can be
+	// overridden with a path to a file.
+	stripeSpec, err := getSpec(config.SpecPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures, err := getFixtures(config.FixturesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Overridden resources are applied to the fixtures of every version
+	dirFixtures, err := getFixturesDir(config.FixturesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	override, err := getFixturesOverride(config.FixturesOverridePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var idempotencyCache *idempotency.Cache
+	if config.IdempotencyTTL > 0 {
+		idempotencyCache = idempotency.NewCache(config.IdempotencyTTL)
+	}
+
+	mutators := &responseMutators{}
+
+	var recorder *recording.Recorder
+	if config.RecordPath != "" {
+		recorder, err = recording.NewRecorder(config.RecordPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var replayer *recording.Replayer
+	if config.ReplayPath != "" {
+		replayer, err = recording.LoadReplayer(config.ReplayPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	latency, err := newLatencyConfig(config.Latency, config.LatencyJitter,
+		config.LatencyConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	nullableMode := config.NullableMode
+	if nullableMode == "" {
+		nullableMode = NullableModeFixture
+	}
+	if nullableMode != NullableModeAlways && nullableMode != NullableModeFixture &&
+		nullableMode != NullableModeRandom {
+
+		return nil, fmt.Errorf("Unknown nullable mode: %s", nullableMode)
+	}
+
+	idMode := config.IDMode
+	if idMode == "" {
+		idMode = IDModeRandom
+	}
+	if idMode != IDModeContentHash && idMode != IDModeRandom {
+		return nil, fmt.Errorf("Unknown ID mode: %s", idMode)
+	}
+	if idMode == IDModeContentHash && config.Stateful {
+		return nil, fmt.Errorf("The %s ID mode can't be used in stateful mode, "+
+			"where every created object needs a unique ID", idMode)
+	}
+
+	selfValidateMode := config.SelfValidateMode
+	if selfValidateMode == "" {
+		selfValidateMode = SelfValidateModeOff
+	}
+	if selfValidateMode != SelfValidateModeLog && selfValidateMode != SelfValidateModeOff &&
+		selfValidateMode != SelfValidateModeStrict {
+
+		return nil, fmt.Errorf("Unknown self-validate mode: %s", selfValidateMode)
+	}
+
+	var strs *syntheticStrings
+	if config.StringLength > 0 {
+		charset := config.StringCharset
+		if charset == "" {
+			charset = DefaultStringCharset
+		}
+		strs = &syntheticStrings{charset: charset, length: config.StringLength}
+	}
+
+	var cors *corsConfig
+	if config.CORS {
+		var err error
+		cors, err = newCORSConfig(config.CORSOrigins, config.CORSOriginRegex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Request IDs are generated by the default version's server, but the
+	// generator is shared so that it doesn't matter which one that is
+	requestIDs := newRequestIDGenerator(config.Seed)
+
+	// Likewise for picking requests to fail
+	failures, err := newFailureInjector(config.FailRate, config.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	// And for maintenance, so that it's toggled for every version at once
+	maintenance := newMaintenanceMode(config.Maintenance,
+		config.MaintenanceRetryAfter)
+
+	var rateLimiter *ratelimit.Limiter
+	if config.RateLimit > 0 {
+		rateLimiter = ratelimit.NewLimiter(config.RateLimit)
+	}
+
+	var resourceStore *store.ResourceStore
+	if config.Stateful {
+		resourceStore = store.NewResourceStore()
+	}
+
+	var serverMetrics *metrics.Metrics
+	if config.Metrics {
+		var storedObjects func() int
+		if resourceStore != nil {
+			storedObjects = resourceStore.Len
+		}
+		serverMetrics = metrics.NewMetrics(storedObjects)
+	}
+
+	// Any versioned specs bundled with stripe-mock are made available so that
+	// they can be selected with a `Stripe-Version` header. The primary spec is
+	// available under its own version, taking precedence over a bundled spec
+	// for the same version.
+	//
+	// A custom spec replaces the bundled ones entirely, so none of them are
+	// loaded in that case. Otherwise they can be limited to the ones in
+	// APIVersions to save memory.
+	specs := make(map[string]*spec.Spec)
+	if config.SpecPath == "" {
+		specs, err = getVersionedSpecs(AssetNames(), Asset, config.APIVersions)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, apiVersion := range config.APIVersions {
+			_, ok := specs[apiVersion]
+			if !ok && apiVersion != stripeSpec.Info.Version {
+				return nil, fmt.Errorf("No spec bundled for API version: %s",
+					apiVersion)
+			}
+		}
+	}
+	specs[stripeSpec.Info.Version] = stripeSpec
+
+	versions := make(map[string]*StubServer)
+	for apiVersion, versionSpec := range specs {
+		// The primary spec always uses the primary fixtures (bundled or from
+		// FixturesPath) so that custom fixtures are never overridden by
+		// bundled ones.
+		versionFixtures := fixtures
+		if versionSpec != stripeSpec {
+			versionFixtures, err = getVersionedFixtures(apiVersion, fixtures)
+			if err != nil {
+				return nil, err
+			}
+		}
+		versionFixtures = overrideFixtures(versionFixtures, dirFixtures, false)
+		versionFixtures = overrideFixtures(versionFixtures, override,
+			config.FixturesOverrideReplace)
+
+		stub := &StubServer{
+			apiVersion:        apiVersion,
+			cors:              cors,
+			failures:          failures,
+			fixtures:          versionFixtures,
+			generateTimeout:   config.GenerateTimeout,
+			idMode:            idMode,
+			idempotencyCache:  idempotencyCache,
+			latency:           latency,
+			maintenance:       maintenance,
+			maxBodySize:       config.MaxBodySize,
+			maxExpansionDepth: config.MaxExpansionDepth,
+			maxListSize:       config.MaxListSize,
+			metrics:           serverMetrics,
+			mutators:          mutators,
+			noExpand:          config.NoExpand,
+			noFixtures:        config.NoFixtures,
+			nullableMode:      nullableMode,
+			rateLimiter:       rateLimiter,
+			rateLimitPerKey:   config.RateLimitPerKey,
+			recorder:          recorder,
+			replayer:          replayer,
+			requestIDs:        requestIDs,
+			responseFormat:    newResponseFormat(config),
+			seed:              config.Seed,
+			selfValidateMode:  selfValidateMode,
+			spec:              versionSpec,
+			store:             resourceStore,
+			strictAuth:        config.StrictAuth,
+			strictIDs:         config.StrictIDs,
+			syntheticStrings:  strs,
+
+			restrictedKeysReadOnly: config.RestrictedKeysReadOnly,
+
+			webhookSecret: config.WebhookSecret,
+			webhookURL:    config.WebhookURL,
+		}
+		err = stub.initializeRouter()
+		if err != nil {
+			return nil, fmt.Errorf("error initializing router: %v", err)
+		}
+		versions[apiVersion] = stub
+	}
+
+	defaultAPIVersion := config.DefaultAPIVersion
+	if defaultAPIVersion == "" {
+		defaultAPIVersion = stripeSpec.Info.Version
+	}
+
+	stub, ok := versions[defaultAPIVersion]
+	if !ok {
+		return nil, fmt.Errorf("No spec available for default API version: %s",
+			defaultAPIVersion)
+	}
+	stub.versions = versions
+
+	logging.Info("Default API version", "api_version", stringOrEmpty(defaultAPIVersion))
+
+	server := &Server{
+		address:  config.Address,
+		metrics:  serverMetrics,
+		mutators: mutators,
+		recorder: recorder,
+		stub:     stub,
+	}
+
+	// Only the primary spec and fixtures can come from files, so they're the
+	// only ones that need to be watched
+	if config.Watch || config.SpecPollInterval > 0 {
+		server.stopWatching = make(chan struct{})
+	}
+	if config.Watch {
+		watcher, err := newFileWatcher(config.SpecPath, config.FixturesPath,
+			config.FixturesDir, config.FixturesOverridePath)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't watch spec files: %v", err)
+		}
+		go watchSpecFiles(versions[stripeSpec.Info.Version], config, watcher,
+			server.stopWatching)
+	}
+	if config.SpecPollInterval > 0 {
+		go watchSpecURLs(versions[stripeSpec.Info.Version], config,
+			server.stopWatching)
+	}
+
+	return server, nil
+}
+
+// CheckSpec loads the spec and fixtures that a Server would be built with
+// from config and checks them for problems (see spec.Check) without serving
+// them. Only the primary spec is checked, since the bundled versioned specs
+// can't be changed.
+//
+// It returns an error if either couldn't be loaded at all.
+func CheckSpec(config *Config) ([]string, error) {
+	stripeSpec, err := getSpec(config.SpecPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures, err := getFixtures(config.FixturesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dirFixtures, err := getFixturesDir(config.FixturesDir)
+	if err != nil {
+		return nil, err
+	}
+	fixtures = overrideFixtures(fixtures, dirFixtures, false)
+
+	override, err := getFixturesOverride(config.FixturesOverridePath)
+	if err != nil {
+		return nil, err
+	}
+	fixtures = overrideFixtures(fixtures, override, config.FixturesOverrideReplace)
+
+	return spec.Check(stripeSpec, fixtures), nil
+}
+
+// MetricsHandler returns a handler that serves metrics about the requests
+// that the server has handled in the Prometheus text exposition format.
+// Returns nil if the server wasn't configured to collect metrics.
+func (s *Server) MetricsHandler() http.Handler {
+	if s.metrics == nil {
+		return nil
+	}
+	return s.metrics
+}
+
+// ServeHTTP handles a request to the API stub. Health and readiness checks
+// at `/healthz` and `/readyz` are answered before the request reaches it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handleHealthCheck(w, r) {
+		return
+	}
+	s.stub.HandleRequest(w, r)
+}
+
+// Start starts serving the server on the configured address in the
+// background. It returns once the server is listening, so requests can be
+// sent to URL immediately.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.httpServer != nil {
+		return fmt.Errorf("server is already started")
+	}
+
+	address := s.address
+	if address == "" {
+		address = "127.0.0.1:0"
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", address, err)
+	}
+	logging.Info("Listening on address", "address", listener.Addr())
+
+	httpServer := &http.Server{Handler: s}
+	go func() {
+		err := httpServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logging.Error("Error serving", "error", err)
+		}
+	}()
+
+	s.httpServer = httpServer
+	s.listener = listener
+	return nil
+}
+
+// Stop stops a server started with Start, closing its listener and any open
+// connections, stops watching the spec and fixtures files and URLs, and
+// closes the file that requests are recorded to.
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopWatching != nil {
+		close(s.stopWatching)
+		s.stopWatching = nil
+	}
+
+	var err error
+	if s.httpServer != nil {
+		err = s.httpServer.Close()
+		s.httpServer = nil
+		s.listener = nil
+	}
+
+	// Recordings are closed once the listeners are, so that no more
+	// requests come in to be recorded
+	if s.recorder != nil {
+		closeErr := s.recorder.Close()
+		if err == nil {
+			err = closeErr
+		}
+		s.recorder = nil
+	}
+	return err
+}
+
+// URL returns the base URL of a server started with Start, like
+// `http://127.0.0.1:54321`. Returns an empty string if the server isn't
+// started.
+func (s *Server) URL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return ""
+	}
+	return "http://" + s.listener.Addr().String()
+}
+
+//
+// Private values
+//
+
+// specClient is the client used to fetch specs and fixtures from URLs.
+var specClient = &http.Client{Timeout: 30 * time.Second}
+
+// versionedSpecAssetPattern matches the name of a bundled spec for a specific
+// API version and captures the version.
+var versionedSpecAssetPattern = regexp.MustCompile(
+	`\Aopenapi/openapi/spec3-(\d{4}-\d{2}-\d{2})\.json\z`)
+
+//
+// Private functions
+//
+
+func getFixtures(fixturesPath string) (*spec.Fixtures, error) {
+	var data []byte
+	var err error
+
+	if fixturesPath == "" {
+		// And do the same for fixtures
+		data, err = Asset("openapi/openapi/fixtures3.json")
+	} else {
+		if !isURL(fixturesPath) && !isJSONFile(fixturesPath) {
+			return nil, fmt.Errorf("Fixtures should come from a JSON file")
+		}
+
+		data, err = readSource(fixturesPath)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error loading fixtures: %v", err)
+	}
+
+	var fixtures spec.Fixtures
+	err = json.Unmarshal(data, &fixtures)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding fixtures from %s: %v",
+			sourceName(fixturesPath), err)
+	}
+
+	// Decoding succeeds for any JSON object, so make sure that the fixtures
+	// actually contain what we expect
+	if fixtures.Resources == nil {
+		return nil, fmt.Errorf("fixtures from %s don't contain a `resources` "+
+			"object", sourceName(fixturesPath))
+	}
+
+	return &fixtures, nil
+}
+
+// getFixturesDir gets the fixtures from the JSON files in dir, each of which is
+// the fixture of the resource named by the file (like `charge.json`), to
+// override the fixtures of those resources (see overrideFixtures). Other files
+// are ignored. Returns nil if no directory is given.
+func getFixturesDir(dir string) (*spec.Fixtures, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error loading fixtures directory: %v", err)
+	}
+
+	fixtures := &spec.Fixtures{Resources: make(map[spec.ResourceID]interface{})}
+	for _, file := range files {
+		if file.IsDir() || !isJSONFile(file.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading fixture: %v", err)
+		}
+
+		var fixture map[string]interface{}
+		err = json.Unmarshal(data, &fixture)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding fixture from %s: %v", path, err)
+		}
+		if fixture == nil {
+			return nil, fmt.Errorf("fixture from %s isn't a JSON object", path)
+		}
+
+		resourceID := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		fixtures.Resources[spec.ResourceID(resourceID)] = fixture
+	}
+	return fixtures, nil
+}
+
+// getFixturesOverride gets the fixtures from the file at overridePath that
+// override the fixtures of some resources (see overrideFixtures). Returns nil
+// if no path is given.
+func getFixturesOverride(overridePath string) (*spec.Fixtures, error) {
+	if overridePath == "" {
+		return nil, nil
+	}
+
+	override, err := getFixtures(overridePath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading fixtures override: %v", err)
+	}
+	return override, nil
+}
+
+// getVersionedFixtures gets fixtures for a specific API version from the
+// assets built by go-bindata. If there are no fixtures bundled for the version,
+// the given default fixtures are returned instead.
+func getVersionedFixtures(apiVersion string, defaultFixtures *spec.Fixtures) (*spec.Fixtures, error) {
+	data, err := Asset("openapi/openapi/fixtures3-" + apiVersion + ".json")
+	if err != nil {
+		return defaultFixtures, nil
+	}
+
+	var fixtures spec.Fixtures
+	err = json.Unmarshal(data, &fixtures)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding fixtures for API version %s: %v",
+			apiVersion, err)
+	}
+
+	return &fixtures, nil
+}
+
+// getVersionedSpecs loads the specs for specific API versions from assets
+// like those built by go-bindata (i.e., any named like
+// `spec3-2018-07-27.json`). names are the names of all the assets, and asset
+// loads the one with the given name.
+//
+// Only the versions in apiVersions are loaded unless it's empty, in which case
+// all of them are. Specs are big, so they're decoded concurrently to keep
+// startup fast. They're returned keyed by API version.
+func getVersionedSpecs(names []string, asset func(string) ([]byte, error),
+	apiVersions []string) (map[string]*spec.Spec, error) {
+
+	wanted := make(map[string]bool)
+	for _, apiVersion := range apiVersions {
+		wanted[apiVersion] = true
+	}
+
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	specs := make(map[string]*spec.Spec)
+
+	for _, name := range names {
+		apiVersion := versionFromSpecAssetName(name)
+		if apiVersion == "" || (len(wanted) > 0 && !wanted[apiVersion]) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name, apiVersion string) {
+			defer wg.Done()
+
+			stripeSpec, err := loadVersionedSpec(name, asset)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error loading spec for API version %s: %v",
+					apiVersion, err))
+				return
+			}
+			specs[apiVersion] = stripeSpec
+		}(name, apiVersion)
+	}
+	wg.Wait()
+
+	// Report the same error every time if there are several
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].Error() < errs[j].Error()
+		})
+		return nil, errs[0]
+	}
+
+	return specs, nil
+}
+
+func getSpec(specPath string) (*spec.Spec, error) {
+	var data []byte
+	var err error
+
+	if specPath == "" {
+		// Load the spec information from go-bindata
+		data, err = Asset("openapi/openapi/spec3.json")
+	} else {
+		if !isURL(specPath) && !isJSONFile(specPath) {
+			return nil, fmt.Errorf("spec should come from a JSON file")
+		}
+
+		data, err = readSource(specPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading spec: %v", err)
+	}
+
+	var stripeSpec spec.Spec
+	err = json.Unmarshal(data, &stripeSpec)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding spec from %s: %v",
+			sourceName(specPath), err)
+	}
+
+	// Decoding succeeds for any JSON object, so make sure that what we got
+	// actually looks like an OpenAPI spec
+	if len(stripeSpec.Paths) == 0 {
+		return nil, fmt.Errorf("spec from %s doesn't contain any paths (is it "+
+			"an OpenAPI 3 spec?)", sourceName(specPath))
+	}
+
+	return &stripeSpec, nil
+}
+
+// isJSONFile judges based on a file's extension whether it's a JSON file. It's
+// used to return a better error message if the user points to an unsupported
+// file.
+func isJSONFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".json"
+}
+
+// isURL checks whether the path to a spec or fixtures is actually an HTTP(S)
+// URL to fetch them from.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// loadVersionedSpec loads and decodes the spec in the asset with the given
+// name.
+func loadVersionedSpec(name string, asset func(string) ([]byte, error)) (*spec.Spec, error) {
+	data, err := asset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var stripeSpec spec.Spec
+	err = json.Unmarshal(data, &stripeSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stripeSpec, nil
+}
+
+// mergeFixture deep merges an overriding value into a value from a fixture.
+// Objects are merged key by key, and any other value (including an array)
+// replaces the one in the fixture. Neither value is modified.
+func mergeFixture(value interface{}, override interface{}) interface{} {
+	valueMap, ok := value.(map[string]interface{})
+	if !ok {
+		return store.CopyValue(override)
+	}
+	overrideMap, ok := override.(map[string]interface{})
+	if !ok {
+		return store.CopyValue(override)
+	}
+
+	merged := store.CopyValue(valueMap).(map[string]interface{})
+	for key, overrideValue := range overrideMap {
+		merged[key] = mergeFixture(merged[key], overrideValue)
+	}
+	return merged
+}
+
+// overrideFixtures produces fixtures with the resources in override deep
+// merged into them (see mergeFixture), or with them replaced entirely if
+// replace is set. Resources that don't have fixtures are added. The given
+// fixtures are returned as they are if override is nil.
+func overrideFixtures(fixtures *spec.Fixtures, override *spec.Fixtures,
+	replace bool) *spec.Fixtures {
+
+	if override == nil {
+		return fixtures
+	}
+
+	overridden := &spec.Fixtures{
+		Resources: make(map[spec.ResourceID]interface{}, len(fixtures.Resources)),
+	}
+	for resourceID, fixture := range fixtures.Resources {
+		overridden.Resources[resourceID] = fixture
+	}
+	for resourceID, overrideFixture := range override.Resources {
+		if replace {
+			overridden.Resources[resourceID] = overrideFixture
+		} else {
+			overridden.Resources[resourceID] = mergeFixture(
+				fixtures.Resources[resourceID], overrideFixture)
+		}
+	}
+	return overridden
+}
+
+// readSource reads a spec or fixtures from a file, or fetches them if path is
+// a URL.
+func readSource(path string) ([]byte, error) {
+	if !isURL(path) {
+		return ioutil.ReadFile(path)
+	}
+
+	resp, err := specClient.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with status %d", path, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// sourceName describes where a spec or fixtures were loaded from for use in
+// error messages: either a file given as an option or the bundled assets.
+func sourceName(path string) string {
+	if path == "" {
+		return "bundled assets"
+	}
+	return path
+}
+
+// versionFromSpecAssetName extracts an API version from the name of a bundled
+// versioned spec like `openapi/openapi/spec3-2018-07-27.json`. An empty
+// string is returned if the name isn't one of a versioned spec.
+func versionFromSpecAssetName(name string) string {
+	matches := versionedSpecAssetPattern.FindStringSubmatch(name)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
@@ -0,0 +1,706 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/recording"
+	"github.com/stripe/stripe-mock/spec"
+)
+
+var applicationFeeRefundCreateMethod *spec.Operation
+var applicationFeeRefundGetMethod *spec.Operation
+var chargeAllMethod *spec.Operation
+var chargeCreateMethod *spec.Operation
+var chargeDeleteMethod *spec.Operation
+var chargeGetMethod *spec.Operation
+var invoicePayMethod *spec.Operation
+
+// Try to avoid using the real spec as much as possible because it's more
+// complicated and slower. A test spec is provided below. If you do use it,
+// don't mutate it.
+var realSpec spec.Spec
+var realFixtures spec.Fixtures
+var realComponentsForValidation *spec.ComponentsForValidation
+
+var testSpec spec.Spec
+var testFixtures spec.Fixtures
+
+func init() {
+	initRealSpec()
+	initTestSpec()
+}
+
+func initRealSpec() {
+	// Load the spec information from go-bindata
+	data, err := Asset("openapi/openapi/spec3.json")
+	if err != nil {
+		panic(err)
+	}
+
+	err = json.Unmarshal(data, &realSpec)
+	if err != nil {
+		panic(err)
+	}
+
+	realComponentsForValidation =
+		spec.GetComponentsForValidation(&realSpec.Components)
+
+	// And do the same for fixtures
+	data, err = Asset("openapi/openapi/fixtures3.json")
+	if err != nil {
+		panic(err)
+	}
+
+	err = json.Unmarshal(data, &realFixtures)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func initTestSpec() {
+	// These are basically here to give us a URL to test against that has
+	// multiple parameters in it.
+	applicationFeeRefundCreateMethod = &spec.Operation{}
+	applicationFeeRefundGetMethod = &spec.Operation{}
+
+	chargeAllMethod = &spec.Operation{
+		Responses: map[spec.StatusCode]spec.Response{
+			"200": {
+				Content: map[string]spec.MediaType{
+					"application/json": {
+						Schema: &spec.Schema{
+							Type: "object",
+							Properties: map[string]*spec.Schema{
+								"data": {
+									Items: &spec.Schema{
+										Ref: "#/components/schemas/charge",
+									},
+								},
+								"has_more": {Type: "boolean"},
+								"object":   {Enum: []interface{}{"list"}},
+								"url":      {Type: "string"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	chargeCreateMethod = &spec.Operation{
+		RequestBody: &spec.RequestBody{
+			Content: map[string]spec.MediaType{
+				"application/x-www-form-urlencoded": {
+					Schema: &spec.Schema{
+						AdditionalProperties: false,
+						Properties: map[string]*spec.Schema{
+							"amount": {
+								Type: "integer",
+							},
+							"metadata": {
+								Type: "object",
+							},
+							"source": {
+								Type: "string",
+							},
+						},
+						Required: []string{"amount"},
+					},
+				},
+			},
+		},
+		Responses: map[spec.StatusCode]spec.Response{
+			"200": {
+				Content: map[string]spec.MediaType{
+					"application/json": {
+						Schema: &spec.Schema{
+							Ref: "#/components/schemas/charge",
+						},
+					},
+				},
+			},
+		},
+	}
+	chargeDeleteMethod = &spec.Operation{
+		Responses: map[spec.StatusCode]spec.Response{
+			"200": {
+				Content: map[string]spec.MediaType{
+					"application/json": {
+						Schema: &spec.Schema{
+							Ref: "#/components/schemas/charge",
+						},
+					},
+				},
+			},
+		},
+	}
+	chargeGetMethod = &spec.Operation{
+		Responses: map[spec.StatusCode]spec.Response{
+			"200": {
+				Content: map[string]spec.MediaType{
+					"application/json": {
+						Schema: &spec.Schema{
+							Ref: "#/components/schemas/charge",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// Here so we can test the relatively rare "action" operations (e.g.,
+	// `POST` to `/pay` on an invoice).
+	invoicePayMethod = &spec.Operation{
+		Responses: map[spec.StatusCode]spec.Response{
+			"200": {
+				Content: map[string]spec.MediaType{
+					"application/json": {
+						Schema: &spec.Schema{
+							Ref: "#/components/schemas/invoice",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	testFixtures =
+		spec.Fixtures{
+			Resources: map[spec.ResourceID]interface{}{
+				spec.ResourceID("charge"): map[string]interface{}{
+					"amount":   100,
+					"customer": "cus_123",
+					"id":       "ch_123",
+					"metadata": map[string]interface{}{},
+					"object":   "charge",
+				},
+				spec.ResourceID("customer"): map[string]interface{}{
+					"id": "cus_123",
+				},
+				spec.ResourceID("deleted_customer"): map[string]interface{}{
+					"deleted": true,
+				},
+				spec.ResourceID("event"): map[string]interface{}{
+					"data": map[string]interface{}{
+						"object": map[string]interface{}{},
+					},
+					"id":     "evt_123",
+					"object": "event",
+					"type":   "customer.created",
+				},
+				spec.ResourceID("invoice"): map[string]interface{}{
+					"id":     "in_123",
+					"object": "invoice",
+					"paid":   false,
+					"status": "draft",
+				},
+			},
+		}
+
+	testSpec = spec.Spec{
+		Components: spec.Components{
+			Schemas: map[string]*spec.Schema{
+				"charge": {
+					Type: "object",
+					Properties: map[string]*spec.Schema{
+						"amount": {Type: "integer"},
+						"id":     {Type: "string"},
+						"metadata": {
+							Type: "object",
+						},
+						"object": {
+							Type: "string",
+							Enum: []interface{}{"charge"},
+						},
+						// Normally a customer ID, but expandable to a full
+						// customer resource
+						"customer": {
+							AnyOf: []*spec.Schema{
+								{Type: "string"},
+								{Ref: "#/components/schemas/customer"},
+							},
+							XExpansionResources: &spec.ExpansionResources{
+								OneOf: []*spec.Schema{
+									{Ref: "#/components/schemas/customer"},
+								},
+							},
+						},
+					},
+					XExpandableFields: &[]string{"customer"},
+					XResourceID:       "charge",
+				},
+				"customer": {
+					Type:              "object",
+					XExpandableFields: &[]string{},
+					XResourceID:       "customer",
+				},
+				"deleted_customer": {
+					Properties: map[string]*spec.Schema{
+						"deleted": {Type: "boolean"},
+					},
+					Type:        "object",
+					XResourceID: "deleted_customer",
+				},
+				"event": {
+					Properties: map[string]*spec.Schema{
+						"data": {Type: "object"},
+						"id":   {Type: "string"},
+						"object": {
+							Type: "string",
+							Enum: []interface{}{"event"},
+						},
+						"type": {Type: "string"},
+					},
+					Type:        "object",
+					XResourceID: "event",
+				},
+				"invoice": {
+					Properties: map[string]*spec.Schema{
+						"amount_due": {Type: "integer"},
+						"id":         {Type: "string"},
+						"object": {
+							Type: "string",
+							Enum: []interface{}{"invoice"},
+						},
+						"paid":   {Type: "boolean"},
+						"status": {Type: "string"},
+					},
+					Type:        "object",
+					XResourceID: "invoice",
+				},
+			},
+		},
+		Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+			spec.Path("/v1/application_fees/{fee}/refunds"): {
+				"get": applicationFeeRefundCreateMethod,
+			},
+			spec.Path("/v1/application_fees/{fee}/refunds/{id}"): {
+				"get": applicationFeeRefundGetMethod,
+			},
+			spec.Path("/v1/charges"): {
+				"get":  chargeAllMethod,
+				"post": chargeCreateMethod,
+			},
+			spec.Path("/v1/charges/{id}"): {
+				"get":    chargeGetMethod,
+				"delete": chargeDeleteMethod,
+			},
+			spec.Path("/v1/invoices/{id}/pay"): {
+				"post": invoicePayMethod,
+			},
+		},
+	}
+}
+
+//
+// Tests
+//
+
+func TestGetFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fixturesPath := filepath.Join(dir, "fixtures.json")
+	err = ioutil.WriteFile(fixturesPath,
+		[]byte(`{"resources": {"charge": {"id": "ch_123"}}}`), 0644)
+	assert.NoError(t, err)
+
+	fixtures, err := getFixtures(fixturesPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "ch_123"},
+		fixtures.Resources["charge"])
+
+	// JSON that isn't fixtures
+	err = ioutil.WriteFile(fixturesPath, []byte(`{"paths": {}}`), 0644)
+	assert.NoError(t, err)
+	_, err = getFixtures(fixturesPath)
+	assert.Error(t, err)
+
+	_, err = getFixtures(filepath.Join(dir, "fixtures.yaml"))
+	assert.Error(t, err)
+}
+
+func TestCheckSpec(t *testing.T) {
+	// The bundled spec and fixtures don't have any problems
+	problems, err := CheckSpec(&Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, problems)
+
+	dir, err := ioutil.TempDir("", "spec")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Overridden fixtures are checked too
+	overridePath := filepath.Join(dir, "override.json")
+	err = ioutil.WriteFile(overridePath,
+		[]byte(`{"resources": {"widget": {"id": "wid_123"}}}`), 0644)
+	assert.NoError(t, err)
+	problems, err = CheckSpec(&Config{FixturesOverridePath: overridePath})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Fixture widget has no schema with a matching x-resourceId",
+	}, problems)
+
+	_, err = CheckSpec(&Config{SpecPath: filepath.Join(dir, "missing.json")})
+	assert.Error(t, err)
+}
+
+func TestGetFixturesDir(t *testing.T) {
+	fixtures, err := getFixturesDir("")
+	assert.NoError(t, err)
+	assert.Nil(t, fixtures)
+
+	dir, err := ioutil.TempDir("", "fixtures")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "charge.json"),
+		[]byte(`{"id": "ch_123"}`), 0644)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("Fixtures"), 0644)
+	assert.NoError(t, err)
+
+	// Each file is the fixture of the resource that it's named for, and other
+	// files are ignored
+	fixtures, err = getFixturesDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[spec.ResourceID]interface{}{
+		"charge": map[string]interface{}{"id": "ch_123"},
+	}, fixtures.Resources)
+
+	// JSON that isn't an object
+	err = ioutil.WriteFile(filepath.Join(dir, "customer.json"), []byte(`null`), 0644)
+	assert.NoError(t, err)
+	_, err = getFixturesDir(dir)
+	assert.Error(t, err)
+
+	_, err = getFixturesDir(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestGetFixturesOverride(t *testing.T) {
+	override, err := getFixturesOverride("")
+	assert.NoError(t, err)
+	assert.Nil(t, override)
+
+	_, err = getFixturesOverride("override.yaml")
+	assert.Error(t, err)
+}
+
+func TestGetSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spec")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	specPath := filepath.Join(dir, "spec.json")
+	data, err := json.Marshal(&testSpec)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(specPath, data, 0644)
+	assert.NoError(t, err)
+
+	stripeSpec, err := getSpec(specPath)
+	assert.NoError(t, err)
+	assert.Equal(t, len(testSpec.Paths), len(stripeSpec.Paths))
+
+	// Invalid JSON
+	err = ioutil.WriteFile(specPath, []byte(`{"paths":`), 0644)
+	assert.NoError(t, err)
+	_, err = getSpec(specPath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), specPath)
+
+	// JSON that isn't a spec
+	err = ioutil.WriteFile(specPath, []byte(`{"resources": {}}`), 0644)
+	assert.NoError(t, err)
+	_, err = getSpec(specPath)
+	assert.Error(t, err)
+
+	_, err = getSpec(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestGetVersionedSpecs(t *testing.T) {
+	assets := map[string][]byte{
+		"openapi/openapi/fixtures3-2018-07-27.json": []byte(`{}`),
+		"openapi/openapi/spec3.json":                []byte(`{}`),
+		"openapi/openapi/spec3-2018-07-27.json": []byte(
+			`{"info": {"version": "2018-07-27"}}`),
+		"openapi/openapi/spec3-2019-02-19.json": []byte(
+			`{"info": {"version": "2019-02-19"}}`),
+		"openapi/openapi/spec3-2019-03-14.json": []byte(`not JSON`),
+	}
+	var names []string
+	for name := range assets {
+		names = append(names, name)
+	}
+	asset := func(name string) ([]byte, error) {
+		return assets[name], nil
+	}
+
+	specs, err := getVersionedSpecs(names, asset, []string{"2018-07-27", "2019-02-19"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(specs))
+	assert.Equal(t, "2018-07-27", specs["2018-07-27"].Info.Version)
+	assert.Equal(t, "2019-02-19", specs["2019-02-19"].Info.Version)
+
+	specs, err = getVersionedSpecs(names, asset, []string{"2018-07-27"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(specs))
+	assert.Equal(t, "2018-07-27", specs["2018-07-27"].Info.Version)
+
+	// Without a list of versions all of them are loaded, including the one
+	// that can't be decoded
+	_, err = getVersionedSpecs(names, asset, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "error loading spec for API version 2019-03-14")
+}
+
+func TestNewServer(t *testing.T) {
+	server, err := NewServer(&Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, server.MetricsHandler())
+
+	req := httptest.NewRequest("GET", "/v1/charges", nil)
+	req.Header.Set("Authorization", "Bearer sk_test_123")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	server, err = NewServer(&Config{Metrics: true})
+	assert.NoError(t, err)
+	assert.NotNil(t, server.MetricsHandler())
+}
+
+func TestNewServer_FixturesDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fixturesDir := filepath.Join(dir, "fixtures")
+	err = os.Mkdir(fixturesDir, 0755)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(fixturesDir, "account.json"), []byte(
+		`{"email": "dir@example.com", "payout_schedule": {"interval": "daily"}}`), 0644)
+	assert.NoError(t, err)
+
+	// An override file is applied after the directory
+	overridePath := filepath.Join(dir, "override.json")
+	err = ioutil.WriteFile(overridePath, []byte(`{"resources": {"account": `+
+		`{"payout_schedule": {"interval": "weekly"}}}}`), 0644)
+	assert.NoError(t, err)
+
+	server, err := NewServer(&Config{
+		FixturesDir:          fixturesDir,
+		FixturesOverridePath: overridePath,
+	})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/v1/accounts/acct_123", nil)
+	req.Header.Set("Authorization", "Bearer sk_test_123")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var data map[string]interface{}
+	err = json.Unmarshal(w.Body.Bytes(), &data)
+	assert.NoError(t, err)
+	assert.Equal(t, "dir@example.com", data["email"])
+
+	// Fields of the fixture that weren't overridden are kept
+	payoutSchedule := data["payout_schedule"].(map[string]interface{})
+	assert.Equal(t, "weekly", payoutSchedule["interval"])
+	assert.Equal(t, 2.0, payoutSchedule["delay_days"])
+}
+
+func TestNewServer_FixturesOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	overridePath := filepath.Join(dir, "override.json")
+	err = ioutil.WriteFile(overridePath, []byte(`{"resources": {"account": `+
+		`{"email": "pinned@example.com", "payout_schedule": {"interval": "weekly"}}}}`), 0644)
+	assert.NoError(t, err)
+
+	server, err := NewServer(&Config{FixturesOverridePath: overridePath})
+	assert.NoError(t, err)
+
+	// The override applies to every version's fixtures
+	for _, apiVersion := range []string{"", "2018-07-27"} {
+		req := httptest.NewRequest("GET", "/v1/accounts/acct_123", nil)
+		req.Header.Set("Authorization", "Bearer sk_test_123")
+		if apiVersion != "" {
+			req.Header.Set("Stripe-Version", apiVersion)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var data map[string]interface{}
+		err = json.Unmarshal(w.Body.Bytes(), &data)
+		assert.NoError(t, err)
+		assert.Equal(t, "pinned@example.com", data["email"])
+
+		// Fields of the fixture that weren't overridden are kept
+		payoutSchedule := data["payout_schedule"].(map[string]interface{})
+		assert.Equal(t, "weekly", payoutSchedule["interval"])
+		assert.Equal(t, 2.0, payoutSchedule["delay_days"])
+	}
+}
+
+func TestNewServer_Invalid(t *testing.T) {
+	_, err := NewServer(&Config{APIVersions: []string{"2018-07-27"},
+		SpecPath: "spec.json"})
+	assert.Error(t, err)
+
+	_, err = NewServer(&Config{DefaultAPIVersion: "1999-01-01"})
+	assert.Error(t, err)
+	assert.Equal(t, "No spec available for default API version: 1999-01-01",
+		err.Error())
+
+	_, err = NewServer(&Config{FixturesPath: "fixtures.yaml"})
+	assert.Error(t, err)
+
+	_, err = NewServer(&Config{FixturesOverridePath: "override.yaml"})
+	assert.Error(t, err)
+
+	_, err = NewServer(&Config{NullableMode: "sometimes"})
+	assert.Error(t, err)
+	assert.Equal(t, "Unknown nullable mode: sometimes", err.Error())
+
+	_, err = NewServer(&Config{FailRate: 1.5})
+	assert.Error(t, err)
+
+	_, err = NewServer(&Config{CORS: true, CORSOriginRegex: "https://("})
+	assert.Error(t, err)
+
+	_, err = NewServer(&Config{IDMode: "sequential"})
+	assert.Error(t, err)
+	assert.Equal(t, "Unknown ID mode: sequential", err.Error())
+
+	_, err = NewServer(&Config{IDMode: IDModeContentHash, Stateful: true})
+	assert.Error(t, err)
+
+	_, err = NewServer(&Config{SelfValidateMode: "sometimes"})
+	assert.Error(t, err)
+	assert.Equal(t, "Unknown self-validate mode: sometimes", err.Error())
+}
+
+func TestOverrideFixtures(t *testing.T) {
+	fixtures := &spec.Fixtures{Resources: map[spec.ResourceID]interface{}{
+		"charge": map[string]interface{}{
+			"amount":   100,
+			"id":       "ch_123",
+			"metadata": map[string]interface{}{"foo": "bar"},
+			"tags":     []interface{}{"a", "b"},
+		},
+		"customer": map[string]interface{}{"id": "cus_123"},
+	}}
+	override := &spec.Fixtures{Resources: map[spec.ResourceID]interface{}{
+		"charge": map[string]interface{}{
+			"amount":   200,
+			"metadata": map[string]interface{}{"baz": "qux"},
+			"tags":     []interface{}{"c"},
+		},
+		"refund": map[string]interface{}{"id": "re_123"},
+	}}
+
+	assert.Equal(t, fixtures, overrideFixtures(fixtures, nil, false))
+
+	overridden := overrideFixtures(fixtures, override, false)
+	assert.Equal(t, map[string]interface{}{
+		"amount": 200,
+		"id":     "ch_123",
+		"metadata": map[string]interface{}{
+			"baz": "qux",
+			"foo": "bar",
+		},
+		"tags": []interface{}{"c"},
+	}, overridden.Resources["charge"])
+	assert.Equal(t, fixtures.Resources["customer"], overridden.Resources["customer"])
+	assert.Equal(t, map[string]interface{}{"id": "re_123"}, overridden.Resources["refund"])
+
+	// The original fixtures are left alone
+	assert.Equal(t, 100, fixtures.Resources["charge"].(map[string]interface{})["amount"])
+
+	overridden = overrideFixtures(fixtures, override, true)
+	assert.Equal(t, override.Resources["charge"], overridden.Resources["charge"])
+}
+
+func TestServer_StartStop(t *testing.T) {
+	server, err := NewServer(&Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", server.URL())
+
+	err = server.Start()
+	assert.NoError(t, err)
+	url := server.URL()
+	assert.NotEqual(t, "", url)
+
+	// Starting twice isn't allowed
+	assert.Error(t, server.Start())
+
+	req, err := http.NewRequest("GET", url+"/v1/charges", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer sk_test_123")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Health checks don't need an API key
+	resp, err = http.Get(url + "/healthz")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	err = server.Stop()
+	assert.NoError(t, err)
+	assert.Equal(t, "", server.URL())
+
+	_, err = http.Get(url + "/v1/charges")
+	assert.Error(t, err)
+}
+
+func TestServer_StopClosesRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripe-mock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "recording.jsonl")
+	server, err := NewServer(&Config{RecordPath: path})
+	assert.NoError(t, err)
+	assert.NoError(t, server.Start())
+
+	req, err := http.NewRequest("GET", server.URL()+"/v1/charges/ch_123", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer sk_test_123")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.NoError(t, server.Stop())
+
+	// Stopping again is harmless now that the recording is closed
+	assert.NoError(t, server.Stop())
+
+	replayer, err := recording.LoadReplayer(path)
+	assert.NoError(t, err)
+	assert.NotNil(t, replayer.Lookup("GET", "/v1/charges/ch_123", nil))
+}
+
+func TestVersionFromSpecAssetName(t *testing.T) {
+	assert.Equal(t, "2018-07-27",
+		versionFromSpecAssetName("openapi/openapi/spec3-2018-07-27.json"))
+	assert.Equal(t, "", versionFromSpecAssetName("openapi/openapi/spec3.json"))
+	assert.Equal(t, "",
+		versionFromSpecAssetName("openapi/openapi/fixtures3-2018-07-27.json"))
+}
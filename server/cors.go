@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// corsConfig describes which browser origins may make cross-origin requests
+// to stripe-mock, so that a frontend can be tested against it directly.
+type corsConfig struct {
+	// originPattern matches origins allowed to make requests on top of
+	// origins, like the ever-changing domains of preview deploys.
+	//
+	// nil if no pattern was given.
+	originPattern *regexp.Regexp
+
+	// origins are the origins allowed to make requests, like
+	// `http://localhost:3000`.
+	//
+	// Any origin is allowed if it's empty and there's no originPattern.
+	origins map[string]bool
+}
+
+// newCORSConfig initializes a corsConfig that allows requests from the given
+// origins and from those matching originPattern, or from any origin if
+// neither are given. The pattern must match an origin as a whole.
+//
+// Returns an error if the pattern isn't a valid regular expression.
+func newCORSConfig(origins []string, originPattern string) (*corsConfig, error) {
+	c := &corsConfig{origins: make(map[string]bool)}
+	for _, origin := range origins {
+		c.origins[origin] = true
+	}
+
+	if originPattern != "" {
+		pattern, err := regexp.Compile(`\A(?:` + originPattern + `)\z`)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CORS origin regex: %v", err)
+		}
+		c.originPattern = pattern
+	}
+
+	return c, nil
+}
+
+// allowed checks whether requests from an origin are allowed.
+func (c *corsConfig) allowed(origin string) bool {
+	if len(c.origins) == 0 && c.originPattern == nil {
+		return true
+	}
+	return c.origins[origin] ||
+		c.originPattern != nil && c.originPattern.MatchString(origin)
+}
+
+// handle sets the CORS headers of the response to a request from an allowed
+// origin. It reports whether the request was a preflight request, in which
+// case it's been responded to and shouldn't be handled any further.
+func (c *corsConfig) handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+
+	// The response depends on the origin even when it's not allowed, so
+	// caches need to keep them apart
+	w.Header().Add("Vary", "Origin")
+
+	if origin != "" && c.allowed(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Headers",
+				strings.Join(corsAllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Allow-Methods",
+				strings.Join(corsAllowedMethods, ", "))
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		} else {
+			w.Header().Set("Access-Control-Expose-Headers",
+				strings.Join(corsExposedHeaders, ", "))
+		}
+	}
+
+	if preflight {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return preflight
+}
+
+//
+// Private values
+//
+
+// corsAllowedHeaders are the request headers that the Stripe API reads.
+var corsAllowedHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"Idempotency-Key",
+	"Stripe-Account",
+	"Stripe-Version",
+}
+
+// corsAllowedMethods are the methods used by the Stripe API.
+var corsAllowedMethods = []string{
+	http.MethodDelete,
+	http.MethodGet,
+	http.MethodPost,
+}
+
+// corsExposedHeaders are the response headers that a browser makes available
+// to a frontend beyond the basic ones.
+var corsExposedHeaders = []string{
+	"Idempotency-Key",
+	"Request-Id",
+	"Stripe-Mock-Version",
+}
+
+// corsMaxAge is how long in seconds that a browser may cache the response to
+// a preflight request.
+const corsMaxAge = "600"
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestCORSConfig_Preflight(t *testing.T) {
+	c, err := newCORSConfig(nil, "")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("OPTIONS", "/v1/charges", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	assert.True(t, c.handle(w, req))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Authorization, Content-Type, Idempotency-Key, Stripe-Account, Stripe-Version",
+		w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "DELETE, GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSConfig_Request(t *testing.T) {
+	c, err := newCORSConfig(nil, "")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/v1/charges", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	w := httptest.NewRecorder()
+	assert.False(t, c.handle(w, req))
+
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Idempotency-Key, Request-Id, Stripe-Mock-Version",
+		w.Header().Get("Access-Control-Expose-Headers"))
+
+	// Not a cross-origin request
+	req = httptest.NewRequest("GET", "/v1/charges", nil)
+	w = httptest.NewRecorder()
+	assert.False(t, c.handle(w, req))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSConfig_Origins(t *testing.T) {
+	c, err := newCORSConfig([]string{"http://localhost:3000"}, "")
+	assert.NoError(t, err)
+	assert.True(t, c.allowed("http://localhost:3000"))
+	assert.False(t, c.allowed("http://example.com"))
+
+	req := httptest.NewRequest("OPTIONS", "/v1/charges", nil)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	assert.True(t, c.handle(w, req))
+
+	// The preflight request is answered, but without allowing the origin
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSConfig_OriginPattern(t *testing.T) {
+	c, err := newCORSConfig([]string{"http://localhost:3000"},
+		`https://[a-z0-9-]+\.preview\.example\.com`)
+	assert.NoError(t, err)
+	assert.True(t, c.allowed("http://localhost:3000"))
+	assert.True(t, c.allowed("https://pr-123.preview.example.com"))
+	assert.False(t, c.allowed("http://example.com"))
+
+	// The pattern is anchored at both ends
+	assert.False(t, c.allowed("https://evil.com/https://pr-123.preview.example.com"))
+	assert.False(t, c.allowed("https://pr-123.preview.example.com.evil.com"))
+
+	// The origin is echoed back when it matches
+	req := httptest.NewRequest("GET", "/v1/charges", nil)
+	req.Header.Set("Origin", "https://pr-123.preview.example.com")
+	w := httptest.NewRecorder()
+	assert.False(t, c.handle(w, req))
+	assert.Equal(t, "https://pr-123.preview.example.com",
+		w.Header().Get("Access-Control-Allow-Origin"))
+
+	_, err = newCORSConfig(nil, "https://(")
+	assert.Error(t, err)
+}
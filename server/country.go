@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/stripe/stripe-mock/spec"
+)
+
+// accountCountry finds the country of the account that a request was made for,
+// which generated objects are localized to (see DataGenerator.country). It's
+// given by a `Stripe-Mock-Account-Country` header (or
+// `X-Stripe-Mock-Account-Country`), or otherwise in stateful mode by the stored
+// connected account that the request was made on behalf of.
+//
+// Returns an empty country if there's none to localize to, or an error if the
+// header isn't a known country.
+func (s *StubServer) accountCountry(r *http.Request, account string) (string, error) {
+	header := r.Header.Get("Stripe-Mock-Account-Country")
+	if header == "" {
+		header = r.Header.Get("X-Stripe-Mock-Account-Country")
+	}
+	if header != "" {
+		country := strings.ToUpper(header)
+		if _, ok := countryCurrencies[country]; !ok {
+			return "", fmt.Errorf(invalidAccountCountry, header,
+				strings.Join(knownCountries(), ", "))
+		}
+		return country, nil
+	}
+
+	if s.store == nil || account == "" {
+		return "", nil
+	}
+	stored, ok := s.store.Get("account", account)
+	if !ok {
+		return "", nil
+	}
+	country, _ := stored["country"].(string)
+	country = strings.ToUpper(country)
+	if _, ok := countryCurrencies[country]; !ok {
+		return "", nil
+	}
+	return country, nil
+}
+
+//
+// Private values
+//
+
+// countryCurrencies maps the countries that objects can be localized to
+// (with their ISO 3166-1 alpha-2 codes) to their default currencies.
+var countryCurrencies = map[string]string{
+	"AT": "eur",
+	"AU": "aud",
+	"BE": "eur",
+	"BR": "brl",
+	"CA": "cad",
+	"CH": "chf",
+	"DE": "eur",
+	"DK": "dkk",
+	"ES": "eur",
+	"FI": "eur",
+	"FR": "eur",
+	"GB": "gbp",
+	"HK": "hkd",
+	"IE": "eur",
+	"IN": "inr",
+	"IT": "eur",
+	"JP": "jpy",
+	"LU": "eur",
+	"MX": "mxn",
+	"NL": "eur",
+	"NO": "nok",
+	"NZ": "nzd",
+	"PL": "pln",
+	"PT": "eur",
+	"SE": "sek",
+	"SG": "sgd",
+	"US": "usd",
+}
+
+const invalidAccountCountry = "Invalid `Stripe-Mock-Account-Country` header: " +
+	"'%s'. It must be one of: %s."
+
+//
+// Private functions
+//
+
+// knownCountries returns a sorted list of the countries in countryCurrencies.
+func knownCountries() []string {
+	countries := make([]string, 0, len(countryCurrencies))
+	for country := range countryCurrencies {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+	return countries
+}
+
+// localizeValue gives the generated value of an object's property the country
+// or currency that suits the given country, like `jpy` for a `currency` in
+// `JP`. Values of other properties, ones limited to an enum, and ones that
+// aren't strings (like a null or an expanded object) are returned as they
+// were.
+func localizeValue(country string, name string, schema *spec.Schema,
+	value interface{}) interface{} {
+
+	if _, ok := value.(string); !ok || schema.Type != spec.TypeString ||
+		len(schema.Enum) > 0 {
+
+		return value
+	}
+
+	switch name {
+	case "country":
+		return country
+	case "currency", "default_currency":
+		return countryCurrencies[country]
+	}
+	return value
+}
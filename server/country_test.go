@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_AccountCountry(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	headers := getDefaultHeaders()
+	headers["Stripe-Mock-Account-Country"] = "jp"
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "jpy", decodeResponse(t, body)["currency"])
+
+	// A currency given with the request is still reflected
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&currency=usd", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "usd", decodeResponse(t, body)["currency"])
+
+	headers["Stripe-Mock-Account-Country"] = "XX"
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Contains(t, errorInfo["message"],
+		"Invalid `Stripe-Mock-Account-Country` header: 'XX'.")
+}
+
+func TestStubServer_AccountCountry_Stored(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+	server.store.Put("account", "acct_123", map[string]interface{}{
+		"country": "DE",
+		"id":      "acct_123",
+	})
+
+	headers := getDefaultHeaders()
+	headers["Stripe-Account"] = "acct_123"
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/refunds",
+		"charge=ch_123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "eur", decodeResponse(t, body)["currency"])
+
+	// An account that isn't stored gets objects as they'd otherwise be
+	headers["Stripe-Account"] = "acct_456"
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/refunds",
+		"charge=ch_123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t,
+		realFixtures.Resources["refund"].(map[string]interface{})["currency"],
+		decodeResponse(t, body)["currency"])
+}
+
+func TestLocalizeValue(t *testing.T) {
+	stringSchema := &spec.Schema{Type: spec.TypeString}
+
+	assert.Equal(t, "GB", localizeValue("GB", "country", stringSchema, "US"))
+	assert.Equal(t, "gbp", localizeValue("GB", "currency", stringSchema, "usd"))
+	assert.Equal(t, "gbp", localizeValue("GB", "default_currency", stringSchema, "usd"))
+
+	// Other properties and values that aren't strings are left alone
+	assert.Equal(t, "foo", localizeValue("GB", "description", stringSchema, "foo"))
+	assert.Nil(t, localizeValue("GB", "currency", stringSchema, nil))
+	assert.Equal(t, "US", localizeValue("GB", "country", &spec.Schema{
+		Enum: []interface{}{"US"},
+		Type: spec.TypeString,
+	}, "US"))
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// failureInjector picks requests to fail with a `500` as if the Stripe API
+// had a transient failure, so that a client's retry logic can be exercised.
+type failureInjector struct {
+	// mu guards rand, which is shared by requests handled concurrently.
+	mu   sync.Mutex
+	rand *rand.Rand
+
+	// rate is the fraction of requests that fail, unless a request gives its
+	// own with a `Stripe-Mock-Fail-Rate` header.
+	rate float64
+}
+
+// newFailureInjector initializes a failureInjector that fails the given
+// fraction of requests. With a seed, the same requests fail every time that
+// stripe-mock is run, as long as they're made in the same order.
+func newFailureInjector(rate float64, seed *int64) (*failureInjector, error) {
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("Fail rate must be between 0 and 1")
+	}
+
+	source := rand.NewSource(time.Now().UnixNano())
+	if seed != nil {
+		source = rand.NewSource(*seed)
+	}
+	return &failureInjector{rand: rand.New(source), rate: rate}, nil
+}
+
+// fail picks whether to fail a request. rawRate is the value of the request's
+// `Stripe-Mock-Fail-Rate` header, which overrides the configured rate unless
+// it's empty.
+//
+// Returns an error if rawRate isn't a valid rate.
+func (f *failureInjector) fail(rawRate string) (bool, error) {
+	rate := f.rate
+	if rawRate != "" {
+		var err error
+		rate, err = strconv.ParseFloat(rawRate, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return false, fmt.Errorf(invalidFailRate, rawRate)
+		}
+	}
+
+	if rate == 0 {
+		return false, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64() < rate, nil
+}
+
+//
+// Private values
+//
+
+const invalidFailRate = "Invalid `Stripe-Mock-Fail-Rate` header: '%s'. It " +
+	"must be a number between 0 and 1."
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestNewFailureInjector(t *testing.T) {
+	_, err := newFailureInjector(-0.1, nil)
+	assert.Error(t, err)
+
+	_, err = newFailureInjector(1.1, nil)
+	assert.Error(t, err)
+}
+
+func TestFailureInjector_Fail(t *testing.T) {
+	// With a seed, the same requests fail every time
+	seed := int64(42)
+	picks := func() []bool {
+		failures, err := newFailureInjector(0.5, &seed)
+		assert.NoError(t, err)
+
+		var picks []bool
+		for i := 0; i < 20; i++ {
+			fail, err := failures.fail("")
+			assert.NoError(t, err)
+			picks = append(picks, fail)
+		}
+		return picks
+	}
+	first := picks()
+	assert.Equal(t, first, picks())
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+
+	// A request's own rate overrides the configured one
+	failures, err := newFailureInjector(0, &seed)
+	assert.NoError(t, err)
+	fail, err := failures.fail("")
+	assert.NoError(t, err)
+	assert.False(t, fail)
+	fail, err = failures.fail("1")
+	assert.NoError(t, err)
+	assert.True(t, fail)
+
+	for _, rawRate := range []string{"foo", "-1", "1.5"} {
+		_, err = failures.fail(rawRate)
+		assert.Equal(t, "Invalid `Stripe-Mock-Fail-Rate` header: '"+rawRate+
+			"'. It must be a number between 0 and 1.", err.Error())
+	}
+}
+
+func TestStubServer_FailRate(t *testing.T) {
+	server := &StubServer{spec: &testSpec, fixtures: &testFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.failures, err = newFailureInjector(1, nil)
+	assert.NoError(t, err)
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Stripe-Should-Retry"))
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "api_error", errorInfo["type"])
+
+	// Requests that aren't picked behave normally
+	headers := getDefaultHeaders()
+	headers["Stripe-Mock-Fail-Rate"] = "0"
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Stripe-Should-Retry"))
+
+	headers["Stripe-Mock-Fail-Rate"] = "often"
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"strings"
+)
+
+// fieldSelection is a tree of the fields requested for a partial response.
+// Each key is a field to keep. Its value selects the fields to keep within
+// it, or is nil if the whole field should be kept.
+type fieldSelection map[string]fieldSelection
+
+// parseFieldSelection parses a comma-separated list of field paths like
+// `amount,customer.email` into a fieldSelection.
+//
+// Returns nil if no fields were given.
+func parseFieldSelection(raw string) fieldSelection {
+	var selection fieldSelection
+
+	for _, path := range strings.Split(raw, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		if selection == nil {
+			selection = make(fieldSelection)
+		}
+
+		level := selection
+		keys := strings.Split(path, ".")
+		for i, key := range keys {
+			subselection, ok := level[key]
+
+			// The whole field was already selected by a shorter path
+			if ok && subselection == nil {
+				break
+			}
+
+			// The path ends here, so keep the whole field
+			if i == len(keys)-1 {
+				level[key] = nil
+				break
+			}
+
+			if !ok {
+				subselection = make(fieldSelection)
+				level[key] = subselection
+			}
+			level = subselection
+		}
+	}
+
+	return selection
+}
+
+// filterFields prunes generated data down to the selected fields. Objects
+// always keep their `id` and `object` so that they can still be identified,
+// and the objects in arrays (like a list's `data`) are each pruned the same
+// way.
+//
+// Selected fields that don't exist are ignored, as are selections within
+// values that aren't objects (like an unexpanded ID).
+func filterFields(data interface{}, selection fieldSelection) interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		filtered := make(map[string]interface{})
+		for _, key := range []string{"id", "object"} {
+			if value, ok := v[key]; ok {
+				filtered[key] = value
+			}
+		}
+
+		for key, subselection := range selection {
+			value, ok := v[key]
+			if !ok {
+				continue
+			}
+
+			if subselection == nil {
+				filtered[key] = value
+			} else {
+				filtered[key] = filterFields(value, subselection)
+			}
+		}
+		return filtered
+
+	case []interface{}:
+		filtered := make([]interface{}, len(v))
+		for i, value := range v {
+			filtered[i] = filterFields(value, selection)
+		}
+		return filtered
+	}
+
+	return data
+}
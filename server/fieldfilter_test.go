@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestParseFieldSelection(t *testing.T) {
+	assert.Nil(t, parseFieldSelection(""))
+	assert.Nil(t, parseFieldSelection(" , "))
+
+	assert.Equal(t, fieldSelection{
+		"amount": nil,
+		"customer": fieldSelection{
+			"email": nil,
+			"name":  nil,
+		},
+	}, parseFieldSelection("amount, customer.email,customer.name"))
+
+	// A shorter path selects the whole field regardless of order
+	assert.Equal(t, fieldSelection{"customer": nil},
+		parseFieldSelection("customer,customer.email"))
+	assert.Equal(t, fieldSelection{"customer": nil},
+		parseFieldSelection("customer.email,customer"))
+}
+
+func TestFilterFields(t *testing.T) {
+	data := map[string]interface{}{
+		"amount":   100,
+		"currency": "usd",
+		"customer": map[string]interface{}{
+			"email":  "jenny.rosen@example.com",
+			"id":     "cus_123",
+			"name":   "Jenny Rosen",
+			"object": "customer",
+		},
+		"id":     "ch_123",
+		"object": "charge",
+		"source": "card_123",
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"amount": 100,
+		"customer": map[string]interface{}{
+			"email":  "jenny.rosen@example.com",
+			"id":     "cus_123",
+			"object": "customer",
+		},
+		"id":     "ch_123",
+		"object": "charge",
+		"source": "card_123",
+	}, filterFields(data, parseFieldSelection(
+		"amount,customer.email,source.last4,unknown")))
+
+	// The objects of a list are each filtered
+	list := map[string]interface{}{
+		"data":     []interface{}{data},
+		"has_more": false,
+		"object":   "list",
+	}
+	assert.Equal(t, map[string]interface{}{
+		"data": []interface{}{
+			map[string]interface{}{
+				"currency": "usd",
+				"id":       "ch_123",
+				"object":   "charge",
+			},
+		},
+		"object": "list",
+	}, filterFields(list, parseFieldSelection("data.currency")))
+}
@@ -0,0 +1,3386 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stripe/stripe-mock/generator/datareplacer"
+	"github.com/stripe/stripe-mock/logging"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+// GenerateParams is a parameters structure that's used to invoke Generate and
+// its associated methods.
+//
+// This structure exists to avoid runaway paramete inflation for the Generate
+// function, so that we can document individual parameters in a more organized
+// way, and because it can conveniently encapsulate some unexported fields that
+// Generate uses to track its progress.
+type GenerateParams struct {
+	// Account is the ID of the connected account that the request was made
+	// on behalf of with a `Stripe-Account` header. It's reflected into the
+	// `account` property of the generated object and into the ID of the
+	// account retrieved from `/v1/account`.
+	//
+	// Empty if the request was made by the platform itself.
+	Account string
+
+	// Expansions are the requested expansions for the current level of generation.
+	//
+	// nil if no expansions were requested, or we've recursed to a level where
+	// none of the original expansions applied.
+	Expansions *ExpansionLevel
+
+	// PathParams, if set, is a collection that contains values for parameters
+	// that were extracted from a request path. This is useful so that we can
+	// reflect those values into responses for a more realistic effect.
+	//
+	// nil if there were no values extracted from the path.
+	//
+	// The value of this field is considered in a post-processing step for the
+	// generator. It's not used in the generator at all.
+	PathParams *PathParamsMap
+
+	// RequestData is a collection of decoded data that was included as part of
+	// the request's payload.
+	//
+	// It's used to find opportunities to reflect information included with a
+	// request into the response to make responses look more accurate than
+	// they'd otherwise be if they'd been generated from fixtures alone..
+	RequestData map[string]interface{}
+
+	// RequestMethod is the HTTP method of the URL being requested which we're
+	// generating data for. It's used to decide between returning a deleted and
+	// non-deleted schema in some cases.
+	//
+	// The value of this field is expected to stay stable across all levels of
+	// recursion.
+	RequestMethod string
+
+	// RequestPath is the path of the URL being requested which we're
+	// generating data for. It's used to populate the url property of any
+	// nested lists that we generate.
+	//
+	// The value of this field is expected to stay stable across all levels of
+	// recursion.
+	RequestPath string
+
+	//
+	// Private fields
+	//
+
+	// Schema representing the object that we're trying to generate.
+	//
+	// The value of this field will change as Generate recurses to the target
+	// schema at that level of recursion.
+	//
+	// This field is required.
+	Schema *spec.Schema
+
+	// context is a breadcrumb trail that's added to as Generate recurses. It's
+	// not important for the final result, but is very useful for debugging.
+	context string
+
+	// example is a valid data sample for the target schema at this level of
+	// recursion.
+	//
+	// nil means that were was no sample available. A valueWrapper instance
+	// with an embedded nil means that there is a sample, and it's nil/null.
+	example *valueWrapper
+}
+
+// DataGenerator generates fixture response data based off a response schema, a
+// set of definitions, and a fixture store.
+type DataGenerator struct {
+	// creatableResources are the resources whose objects are created with a
+	// `POST` and stored in stateful mode. An object of one that isn't in the
+	// store was never created, so requests for it get a notFoundError.
+	creatableResources map[string]bool
+
+	// ctx is the context of the request being responded to. Generation stops
+	// with its error once it's done, like when its deadline passes.
+	//
+	// Generation is never stopped if nil.
+	ctx context.Context
+
+	// country is the country of the account that the request was made for.
+	// Generated objects get its code as their `country` and its currency
+	// (see countryCurrencies) as their `currency`.
+	//
+	// Empty if objects aren't localized.
+	country string
+
+	definitions map[string]*spec.Schema
+	fixtures    *spec.Fixtures
+
+	// maxListSize is the maximum number of objects in a synthetic list. Each
+	// list gets a random number of objects up to it.
+	//
+	// 0 if lists always have syntheticListSize objects.
+	maxListSize int
+
+	// now is the time at which the request being responded to was received.
+	// It's used as the `created` timestamp of generated objects so that all
+	// the objects in a response agree on it.
+	//
+	// The current time is used if zero.
+	now time.Time
+
+	// nestedLists maps resources to their properties that are lists of
+	// sub-resources with a path of their own (like a charge's `refunds`), and
+	// those to the path, like `/v1/charges/{charge}/refunds`. A nested list's
+	// `url` points at its path, and in stateful mode, its `data` is made up of
+	// the stored sub-resources that belong to the object.
+	nestedLists map[string]map[string]string
+
+	// noFixtures is whether fixtures are ignored so that every object is
+	// synthetic (see Config.NoFixtures).
+	noFixtures bool
+
+	// idMode is how the IDs of created objects are generated (see
+	// Config.IDMode).
+	//
+	// Empty to use random IDs.
+	idMode string
+
+	// nullableMode is how fields with nullable schemas are generated (see
+	// Config.NullableMode).
+	//
+	// Empty to use the values in fixtures.
+	nullableMode string
+
+	// rand is the source of randomness for generated values like object IDs.
+	// Responses to requests generated with sources seeded the same way are
+	// identical.
+	//
+	// The global source is used if nil.
+	rand *rand.Rand
+
+	// store holds objects created in previous requests when running in
+	// stateful mode. When set, it's consulted before generating a response
+	// from fixtures, and objects created by the current request are added to
+	// it.
+	//
+	// nil if stateful mode is disabled.
+	store *store.ResourceStore
+
+	// strings is how strings in synthetic objects are generated.
+	//
+	// nil if they're as short as their schemas allow.
+	strings *syntheticStrings
+}
+
+// Generate generates a fixture response.
+func (g *DataGenerator) Generate(params *GenerateParams) (interface{}, error) {
+	// A search's query is checked even if there aren't stored objects to
+	// search through
+	schema, err := g.resolveResourceSchema(params.Schema, false)
+	if err != nil {
+		return nil, err
+	}
+	if isSearchResultResource(schema) {
+		_, err := parseSearchRequest(params.RequestData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if g.store != nil {
+		data, ok, err := g.generateFromStore(params)
+		if unexpandable, ok := err.(*unexpandableError); ok {
+			return nil, unexpandable.invalidRequest()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return data, nil
+		}
+	}
+
+	// This just makes our context message readable in case there was no
+	// request path specified.
+	requestPathDisplay := params.RequestPath
+	if requestPathDisplay == "" {
+		requestPathDisplay = "(empty request path)"
+	}
+
+	// A `DELETE` responds with the deleted form of a resource (like
+	// `deleted_customer`) even if the operation describes its response with
+	// the full form.
+	// A response that can be one of several resources (like a customer's
+	// source) takes the deleted form of the one that the ID in the path
+	// belongs to.
+	responseSchema := params.Schema
+	if params.RequestMethod == http.MethodDelete {
+		deletedSchema, err := g.findDeletedSchema(params.Schema)
+		if err != nil {
+			return nil, err
+		}
+		if deletedSchema == nil && params.PathParams != nil &&
+			params.PathParams.PrimaryID != nil {
+
+			deletedSchema, err = g.findAnyOfBranchForID(params.Schema,
+				*params.PathParams.PrimaryID, true)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if deletedSchema != nil {
+			responseSchema = deletedSchema
+		}
+	}
+
+	// A list nested under a parent resource whose objects can be one of
+	// several resources is generated with one whose objects refer back to
+	// the parent (see setParentIDs), like the cards of
+	// `/v1/customers/cus_123/sources`.
+	if params.RequestMethod == http.MethodGet && params.PathParams != nil {
+		listSchema, err := g.findParentListSchema(params.Schema,
+			params.PathParams, params.RequestPath)
+		if err != nil {
+			return nil, err
+		}
+		if listSchema != nil {
+			responseSchema = listSchema
+		}
+	}
+
+	data, err := g.generateInternal(&GenerateParams{
+		Expansions:    params.Expansions,
+		PathParams:    nil,
+		RequestData:   params.RequestData,
+		RequestMethod: params.RequestMethod,
+		RequestPath:   params.RequestPath,
+		Schema:        responseSchema,
+
+		context: fmt.Sprintf("Responding to %s %s:\n",
+			params.RequestMethod, requestPathDisplay),
+		example: nil,
+	})
+	if unexpandable, ok := err.(*unexpandableError); ok {
+		return nil, unexpandable.invalidRequest()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A list at the top level of a response is filled out with a page of
+	// synthetic objects according to the request's pagination parameters.
+	schema, err = g.resolveResourceSchema(responseSchema,
+		params.RequestMethod == http.MethodDelete)
+	if err != nil {
+		return nil, err
+	}
+	if isListResource(schema) {
+		err = paginateSyntheticList(params, data, g.syntheticListLength())
+		if err != nil {
+			return nil, err
+		}
+		setListURL(data, params.RequestPath)
+
+		// Objects in a list nested under a parent resource (like
+		// `/v1/customers/cus_123/sources`) refer back to that parent
+		if params.PathParams != nil {
+			setParentIDs(params.PathParams, params.RequestPath, data)
+		}
+	}
+
+	// Synthetic search results are a single page containing the generated
+	// object, which matches any query
+	if isSearchResultResource(schema) {
+		setListURL(data, params.RequestPath)
+	}
+
+	if params.PathParams != nil {
+		// Passses through the generated data and replaces IDs that existed in
+		// the fixtures with IDs that were extracted from the request path, if
+		// and where appropriate.
+		//
+		// Note that the path params are mutated by the function, but we return
+		// them anyway to make the control flow here more clear.
+		pathParams := recordAndReplaceIDs(params.PathParams, data)
+
+		// Passes through the generated data again to replace the values of any old
+		// IDs that we replaced. This is a separate step because IDs could have
+		// been found and replace at any point in the generation process.
+		distributeReplacedIDs(pathParams, data)
+	}
+
+	// In `POST` requests we reflect input parameters into responses to try and
+	// simulate a more realistic create or update operation. Free-form maps
+	// like `metadata` are reflected in full because the generated object
+	// won't have the same keys.
+	if params.RequestMethod == http.MethodPost {
+		if mapData, ok := data.(map[string]interface{}); ok {
+			referencedIDs := referencedObjectIDs(mapData)
+			mapData = datareplacer.ReplaceData(params.RequestData, mapData, schema)
+			replaceReferencedObjectIDs(params.RequestData, referencedIDs, mapData)
+			mergeFreeformMaps(schema, params.RequestData, mapData)
+			applyAction(params, schema, mapData)
+		}
+	}
+
+	if params.Account != "" {
+		if mapData, ok := data.(map[string]interface{}); ok {
+			setAccountContext(schema, params, mapData)
+		}
+	}
+
+	// Without a store, an object that was just created may get an ID derived
+	// from its request so that the same request always gets the same ID
+	if g.idMode == IDModeContentHash && g.store == nil &&
+		params.RequestMethod == http.MethodPost &&
+		(params.PathParams == nil || params.PathParams.PrimaryID == nil) {
+
+		if mapData, ok := data.(map[string]interface{}); ok {
+			if oldID, ok := mapData["id"].(string); ok {
+				newID := contentHashID(oldID, params)
+				distributeReplacedIDs(&PathParamsMap{
+					PrimaryID:         &newID,
+					replacedPrimaryID: &oldID,
+				}, mapData)
+			}
+		}
+	}
+
+	// In stateful mode, objects that were just created get a unique ID and
+	// are stored so that they can be retrieved by subsequent requests.
+	if g.store != nil && params.RequestMethod == http.MethodPost &&
+		(params.PathParams == nil || params.PathParams.PrimaryID == nil) {
+
+		// An object created under a parent resource (like a source created
+		// with `/v1/customers/cus_123/sources`) belongs to it, so that it's
+		// included in the parent's list
+		if mapData, ok := data.(map[string]interface{}); ok && params.PathParams != nil {
+			setObjectParentIDs(params.PathParams, params.RequestPath, mapData)
+		}
+
+		// A PaymentIntent starts out needing a payment method or confirmation
+		// (or is confirmed right away) rather than in its fixture's status
+		if mapData, ok := data.(map[string]interface{}); ok &&
+			mapData["object"] == paymentIntentResource {
+
+			err := g.initializePaymentIntent(params, mapData)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		g.storeCreatedObject(params, data)
+
+		// The lists of sub-resources nested in the new object only contain
+		// ones that were created for it, which there aren't any of yet
+		if mapData, ok := data.(map[string]interface{}); ok {
+			_, err := g.populateStoredNestedLists(schema, mapData, params.Expansions)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// generateFromStore tries to produce a response using objects that were
+// stored by previous requests. Its second return value is false if the store
+// couldn't provide a response, in which case one should be generated from
+// fixtures instead.
+//
+// Lists are always produced from the store so that they only contain objects
+// that were actually created. A request for an object that was previously
+// deleted, or for one of a creatable resource that was never created,
+// produces a notFoundError.
+//
+// Note that a `DELETE` removes the targeted object from the store, but still
+// returns false so that the deleted form of the object is generated normally.
+func (g *DataGenerator) generateFromStore(params *GenerateParams) (interface{}, bool, error) {
+	schema, err := g.resolveResourceSchema(params.Schema,
+		params.RequestMethod == http.MethodDelete)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if isSearchResultResource(schema) {
+		return g.searchStore(params, schema)
+	}
+
+	if isListResource(schema) {
+		itemSchemas, err := g.findListItemSchemas(schema.Properties["data"].Items)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(itemSchemas) == 0 {
+			return nil, false, nil
+		}
+
+		pagination, err := parseListPagination(params.RequestData)
+		if err != nil {
+			return nil, false, err
+		}
+		created, err := parseCreatedFilter(params.RequestData)
+		if err != nil {
+			return nil, false, err
+		}
+
+		objects, resourceIDs := g.listStoredObjects(params, itemSchemas)
+		objects = created.filter(objects)
+
+		ids := make([]string, len(objects))
+		for i, object := range objects {
+			ids[i], _ = object["id"].(string)
+		}
+
+		start, end, hasMore, cursor := pagination.page(ids)
+		if cursor != "" {
+			objectName := "object"
+			if len(resourceIDs) == 1 {
+				objectName = resourceIDs[0]
+			}
+			return nil, false, &invalidRequestError{
+				message: fmt.Sprintf("No such %s: %s", objectName, cursor),
+			}
+		}
+
+		itemData, err := g.expandStoredItems(params, itemSchemas, objects[start:end])
+		if err != nil {
+			return nil, false, err
+		}
+
+		listData := buildListResource(&GenerateParams{
+			RequestPath: params.RequestPath,
+			Schema:      schema,
+		}, itemData)
+		setListPageInfo(listData, hasMore, len(objects))
+		setListURL(listData, params.RequestPath)
+		return listData, true, nil
+	}
+
+	if params.PathParams == nil || params.PathParams.PrimaryID == nil {
+		return nil, false, nil
+	}
+
+	id := *params.PathParams.PrimaryID
+
+	// The object is looked for among the objects of the resource that its ID
+	// belongs to, which may not be the first of several
+	branch, err := g.findAnyOfBranchForID(params.Schema, id,
+		params.RequestMethod == http.MethodDelete)
+	if err != nil {
+		return nil, false, err
+	}
+	if branch != nil {
+		schema = branch
+	}
+
+	resourceID := resourceObjectName(schema)
+	if resourceID == "" {
+		return nil, false, nil
+	}
+
+	if g.store.Deleted(resourceID, id) {
+		return nil, false, &notFoundError{object: resourceID, id: id}
+	}
+
+	object, ok := g.store.Get(resourceID, id)
+	if !ok {
+		// The object of a response that can be one of several resources may
+		// be of a different one than the one that was looked for
+		names, err := g.resourceObjectNames(params.Schema)
+		if err != nil {
+			return nil, false, err
+		}
+		if g.creatableResources[resourceID] && len(names) == 1 {
+			return nil, false, &notFoundError{object: resourceID, id: id}
+		}
+		return nil, false, nil
+	}
+
+	switch params.RequestMethod {
+	case http.MethodDelete:
+		g.store.Delete(resourceID, id)
+		return nil, false, nil
+
+	case http.MethodPost:
+		// PaymentIntents move between statuses as actions are taken on them,
+		// and an action that isn't allowed in the current status fails
+		action := actionName(params)
+		if resourceID == paymentIntentResource {
+			err := checkPaymentIntentAction(action, object)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+
+		object = datareplacer.ReplaceData(params.RequestData, object, schema)
+		mergeFreeformMaps(schema, params.RequestData, object)
+		if resourceID == paymentIntentResource {
+			err := g.transitionPaymentIntent(params, action, object)
+			if err != nil {
+				return nil, false, err
+			}
+		} else {
+			applyAction(params, schema, object)
+		}
+		g.store.Put(resourceID, id, object)
+	}
+
+	object, err = g.expandStoredObject(params, schema, object, params.Expansions)
+	if err != nil {
+		return nil, false, err
+	}
+	return object, true, nil
+}
+
+// generateArrayItems generates the items of an array from its schema's
+// `items`, so that items that are references or expandable are generated like
+// any other value.
+//
+// The array has as many items as example, within the bounds of the schema's
+// `minItems` and `maxItems`. Extra items are based on the example's last one.
+// An array that's generated from scratch gets a single item by default.
+func (g *DataGenerator) generateArrayItems(params *GenerateParams,
+	schema *spec.Schema, example *valueWrapper, context string) (interface{}, error) {
+
+	exampleItems, ok := example.value.([]interface{})
+	if !ok || schema.Items == nil {
+		return example.value, nil
+	}
+
+	count := len(exampleItems)
+	if count == 0 && example.synthetic {
+		count = 1
+	}
+	count = maxInt(count, schema.MinItems)
+	if schema.MaxItems > 0 {
+		count = minInt(count, schema.MaxItems)
+	}
+
+	items := make([]interface{}, count)
+	for i := range items {
+		var itemExample *valueWrapper
+		if len(exampleItems) > 0 {
+			itemExample = &valueWrapper{
+				value:     exampleItems[minInt(i, len(exampleItems)-1)],
+				synthetic: example.synthetic,
+			}
+		}
+
+		item, err := g.generateInternal(&GenerateParams{
+			Expansions:    params.Expansions,
+			PathParams:    nil,
+			RequestMethod: params.RequestMethod,
+			RequestPath:   params.RequestPath,
+			Schema:        schema.Items,
+
+			context: fmt.Sprintf("%sIn item %v of array:\n", context, i),
+			example: itemExample,
+		})
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// generateInternal encompasses all the generation logic. It's separate from
+// Generate only so that Generate can seed it with a little bit of information.
+func (g *DataGenerator) generateInternal(params *GenerateParams) (interface{}, error) {
+	// Checked at every level so that a pathological schema can't keep
+	// generation going forever
+	if g.ctx != nil {
+		if err := g.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// This is a bit of a mess. We don't have an elegant fully-general approach to
+	// generating examples, just a bunch of specific cases that we know how to
+	// handle. If we find ourselves in a situation that doesn't match any of the
+	// cases, then we fall through to the end of the function and panic().
+	// Obviously this is fragile, so we have a unit test that makes sure it works
+	// correctly on every resource; hopefully this will at least allow us to catch
+	// any errors in advance.
+
+	schema, context, err := g.maybeDereference(params.Schema, params.context)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine if the requested expansions are possible
+	if params.Expansions != nil && schema.XExpandableFields != nil {
+		for key := range params.Expansions.expansions {
+			if indexOfString(*schema.XExpandableFields, key) == -1 {
+				return nil, &unexpandableError{path: key}
+			}
+		}
+	}
+
+	example := params.example
+	if (example == nil || example.value == nil) && schema.XResourceID != "" &&
+		!g.noFixtures {
+
+		// Use the fixture as our example. (Note that if the caller gave us a
+		// non-trivial example, we prefer it instead, because it's probably more
+		// relevant in context.)
+		//
+		// A resource that's newer than the fixtures won't have one, in which
+		// case a synthetic fixture is generated for it below.
+		fixture, ok := g.fixtures.Resources[spec.ResourceID(schema.XResourceID)]
+		if ok {
+			example = &valueWrapper{value: fixture}
+			context = fmt.Sprintf("%sUsing fixture '%s':\n", context, schema.XResourceID)
+		}
+	}
+
+	if schema.XExpansionResources != nil {
+		if params.Expansions != nil {
+			// We're expanding this specific object. Its unexpanded form is its
+			// ID, which the expanded object keeps, and in stateful mode, the
+			// object is the stored one if there is one.
+			var id string
+			if example != nil {
+				id, _ = example.value.(string)
+			}
+
+			stored, storedSchema, err := g.findStoredObject(
+				schema.XExpansionResources.OneOf[0], id)
+			if err != nil {
+				return nil, err
+			}
+			if stored != nil {
+				expanded, err := g.expandStoredObject(params, storedSchema, stored,
+					params.Expansions)
+				if err != nil {
+					return nil, err
+				}
+				return expanded, nil
+			}
+
+			data, err := g.generateInternal(&GenerateParams{
+				Expansions:    params.Expansions,
+				PathParams:    nil,
+				RequestMethod: params.RequestMethod,
+				RequestPath:   params.RequestPath,
+				Schema:        schema.XExpansionResources.OneOf[0],
+
+				context: fmt.Sprintf("%sExpanding optional expandable field:\n", context),
+				example: nil,
+			})
+			if dataMap, ok := data.(map[string]interface{}); ok && id != "" {
+				// Generated objects can share structure with fixtures, so
+				// the ID is set on a copy
+				dataMap = store.CopyValue(dataMap).(map[string]interface{})
+				dataMap["id"] = id
+				return dataMap, err
+			}
+			return data, err
+		}
+
+		// We're not expanding this specific object. Our example should be of
+		// the unexpanded form, which is the first branch of the AnyOf
+		return g.generateInternal(&GenerateParams{
+			Expansions:    params.Expansions,
+			PathParams:    nil,
+			RequestMethod: params.RequestMethod,
+			RequestPath:   params.RequestPath,
+			Schema:        schema.AnyOf[0],
+
+			context: fmt.Sprintf("%sNot expanding optional expandable field:\n", context),
+			example: example,
+		})
+	}
+
+	if len(schema.AnyOf) == 1 && schema.Nullable {
+		if example != nil && example.value == nil {
+			if params.Expansions == nil {
+				return nil, nil
+			}
+		} else {
+			// Since there's only one subschema, we can confidently recurse into it
+			return g.generateInternal(&GenerateParams{
+				Expansions:    params.Expansions,
+				PathParams:    nil,
+				RequestMethod: params.RequestMethod,
+				RequestPath:   params.RequestPath,
+				Schema:        schema.AnyOf[0],
+
+				context: fmt.Sprintf("%sChoosing only branch of anyOf:\n", context),
+				example: example,
+			})
+		}
+	}
+
+	if len(schema.AnyOf) != 0 {
+		// A request may pick a branch by including the discriminator property
+		// (like a `type`) that identifies it.
+		requestValue, _ := params.RequestData[schema.DiscriminatorPropertyName()].(string)
+
+		anyOfSchema, discriminatorValue, err := g.findAnyOfBranch(schema,
+			params.RequestMethod == http.MethodDelete, requestValue)
+		if err != nil {
+			return nil, err
+		}
+
+		var context string
+		if anyOfSchema != nil {
+			context = fmt.Sprintf("%sChoosing branch '%s' of anyOf based on request:\n",
+				context, discriminatorValue)
+			logging.Debug("Chose branch of anyOf", "branch", discriminatorValue,
+				"requested", requestValue)
+		} else {
+			context = fmt.Sprintf("%sChoosing first branch of anyOf:\n", context)
+			anyOfSchema = schema.AnyOf[0]
+		}
+
+		// Just generate an example of the chosen subschema. Note that we don't
+		// pass in any example, even if we have an example available, because
+		// we don't know which branch of the AnyOf the example corresponds to.
+		data, err := g.generateInternal(&GenerateParams{
+			Expansions:    params.Expansions,
+			PathParams:    nil,
+			RequestMethod: params.RequestMethod,
+			RequestPath:   params.RequestPath,
+			Schema:        anyOfSchema,
+
+			context: context,
+			example: nil,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Make sure that the object identifies the branch it was generated
+		// from, even if its fixture is for some other type.
+		propertyName := schema.DiscriminatorPropertyName()
+		_, hasProperty := anyOfSchema.Properties[propertyName]
+		if dataMap, ok := data.(map[string]interface{}); ok && hasProperty &&
+			discriminatorValue != "" {
+
+			dataMap[propertyName] = discriminatorValue
+		}
+
+		return data, nil
+	}
+
+	if isListResource(schema) || isSearchResultResource(schema) {
+		// We special-case list resources (and search results, which are like
+		// them) and always fill in the list with at least one item of data,
+		// regardless of what was present in the example
+		listData, err := g.generateListResource(&GenerateParams{
+			Expansions:    params.Expansions,
+			PathParams:    nil,
+			RequestMethod: params.RequestMethod,
+			RequestPath:   params.RequestPath,
+			Schema:        schema,
+
+			context: context,
+			example: example,
+		})
+		return listData, err
+	}
+
+	// Generate a synthethic schema as a last ditch effort
+	if example == nil {
+		example = &valueWrapper{
+			value:     generateSyntheticFixture(g.rand, g.strings, schema, context),
+			synthetic: true,
+		}
+
+		context = fmt.Sprintf("%sGenerated synthetic fixture: %+v\n", context, schema)
+
+		// We list properties here because the schema might not have a better
+		// name to identify it with.
+		logging.Debug("Generated synthetic fixture",
+			"properties", stringOrEmpty(propertyNames(schema)))
+	}
+
+	if example == nil {
+		// If none of the above conditions met, we've run out of ways of generating
+		// examples from scratch, so we can only raise an error.
+		panic(fmt.Sprintf("%sCannot find or generate example for: %s", context, schema))
+	}
+
+	if example.value == nil {
+		if params.Expansions != nil {
+			panic(fmt.Sprintf("%sWe were asked to expand a key, but our example "+
+				"has null for that key.", context))
+		}
+		return nil, nil
+	}
+
+	// A fixture may have a string that's longer than the schema allows
+	if value, ok := example.value.(string); ok && schema.Type == "string" {
+		return truncateString(value, schema.MaxLength), nil
+	}
+
+	// A synthetic timestamp is the time of the request, like `created` is,
+	// so that it's the same every time with a seed
+	if example.synthetic && schema.Type == "integer" && schema.Format == formatUnixTime {
+		return g.requestTime(), nil
+	}
+
+	if schema.Type == "boolean" || schema.Type == "integer" ||
+		schema.Type == "number" || schema.Type == "string" {
+		return example.value, nil
+	}
+
+	if schema.Type == "object" && schema.Properties == nil {
+		// For a generic object type with no particular properties specified, we
+		// assume it must not contain any expandable fields or list resources
+		return example.value, nil
+	}
+
+	if schema.Type == "array" {
+		return g.generateArrayItems(params, schema, example, context)
+	}
+
+	if schema.Type == "object" && schema.Properties != nil {
+		exampleMap, ok := example.value.(map[string]interface{})
+		if !ok {
+			panic(fmt.Sprintf(
+				"%sSchema is an object:\n%s\n\nBut example is (type: %v):\n%s",
+				context, schema, reflect.TypeOf(example.value), example.value))
+		}
+
+		resultMap := make(map[string]interface{})
+
+		// Properties are generated in a stable order so that a seeded source
+		// of randomness produces the same object every time
+		keys := make([]string, 0, len(schema.Properties))
+		for key := range schema.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			subSchema := schema.Properties[key]
+
+			// Properties that can only be sent with requests are never
+			// included in a response, even if a fixture has them
+			if subSchema.WriteOnly {
+				continue
+			}
+
+			var subExpansions *ExpansionLevel
+			if params.Expansions != nil {
+				subExpansions = params.Expansions.expansions[key]
+				if subExpansions == nil && params.Expansions.wildcard {
+					// No expansion was provided for this key but the wildcard bit is set,
+					// so make a fake expansion
+					subExpansions = &ExpansionLevel{
+						expansions: make(map[string]*ExpansionLevel),
+						wildcard:   false,
+					}
+				}
+			}
+
+			var subvalueWrapper *valueWrapper
+			subvalueWrapperValue, exampleHasKey := exampleMap[key]
+			if exampleHasKey {
+				subvalueWrapper = &valueWrapper{
+					value:     subvalueWrapperValue,
+					synthetic: example.synthetic,
+				}
+			}
+
+			if !exampleHasKey && subExpansions == nil &&
+				!(example.synthetic && isRequiredProperty(schema, key)) {
+
+				// If the example omitted this key, then so do we; unless we were asked
+				// to expand the key, or a synthetic example left out a required
+				// property that it couldn't generate, in which case we'll have to
+				// generate an example from scratch.
+				continue
+			}
+
+			if subExpansions == nil && g.generatesNull(subSchema) {
+				resultMap[key] = nil
+				continue
+			}
+
+			if subExpansions != nil && subSchema.XExpansionResources != nil {
+				logging.Debug("Expanding property", "property", key,
+					"resource", schemaName(subSchema.XExpansionResources.OneOf[0]))
+			}
+
+			subValue, err := g.generateInternal(&GenerateParams{
+				Expansions:    subExpansions,
+				PathParams:    nil,
+				RequestMethod: params.RequestMethod,
+				RequestPath:   params.RequestPath,
+				Schema:        subSchema,
+
+				context: fmt.Sprintf("%sIn property '%s' of object:\n", context, key),
+				example: subvalueWrapper,
+			})
+			if unexpandable, ok := err.(*unexpandableError); ok {
+				return nil, unexpandable.under(key)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			// Objects are created when they're requested rather than when
+			// their fixture was
+			if subValue != nil && isCreatedTimestamp(key, subSchema) {
+				subValue = g.requestTime()
+			}
+
+			if g.country != "" {
+				subValue = localizeValue(g.country, key, subSchema, subValue)
+			}
+
+			resultMap[key] = subValue
+		}
+
+		// Clients often decide how to decode an object by its `object` field,
+		// so a resource always gets the right one, even if its fixture left it
+		// out
+		if name := resourceObjectValue(schema); name != "" {
+			resultMap["object"] = name
+		}
+
+		setNestedListURLs(g.nestedLists[resourceObjectName(schema)], resultMap)
+
+		return resultMap, nil
+	}
+
+	// If the schema is of the format we expect, this shouldn't ever happen.
+	panic(fmt.Sprintf(
+		"%sEncountered unusual scenario:\nschema=%s\nexample=%+v",
+		context, schema, example))
+}
+
+// expandStoredObject expands the requested properties of an object from the
+// store. Other properties are left as they were stored except for any that
+// aren't in the schema (see shapeStoredValue), and the stored object itself
+// isn't modified.
+func (g *DataGenerator) expandStoredObject(params *GenerateParams, schema *spec.Schema,
+	object map[string]interface{}, expansions *ExpansionLevel) (map[string]interface{}, error) {
+
+	shaped, err := g.shapeStoredValue(schema, object)
+	if err != nil {
+		return nil, err
+	}
+	object, _ = shaped.(map[string]interface{})
+
+	populated, err := g.populateStoredNestedLists(schema, object, expansions)
+	if err != nil {
+		return nil, err
+	}
+
+	if expansions == nil {
+		return object, nil
+	}
+
+	keys := make([]string, 0, len(expansions.expansions))
+	for key := range expansions.expansions {
+		if schema.XExpandableFields == nil ||
+			indexOfString(*schema.XExpandableFields, key) == -1 {
+			return nil, &unexpandableError{path: key}
+		}
+		keys = append(keys, key)
+	}
+	if expansions.wildcard && schema.XExpandableFields != nil {
+		for _, key := range *schema.XExpandableFields {
+			if _, ok := expansions.expansions[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	expanded := store.CopyValue(object).(map[string]interface{})
+	for _, key := range keys {
+		value, ok := object[key]
+		if !ok || value == nil || populated[key] {
+			continue
+		}
+
+		subExpansions := expansions.expansions[key]
+		if subExpansions == nil {
+			subExpansions = &ExpansionLevel{expansions: make(map[string]*ExpansionLevel)}
+		}
+
+		subValue, err := g.generateInternal(&GenerateParams{
+			Expansions:    subExpansions,
+			PathParams:    nil,
+			RequestMethod: params.RequestMethod,
+			RequestPath:   params.RequestPath,
+			Schema:        schema.Properties[key],
+
+			context: fmt.Sprintf("Expanding property '%s' of stored object:\n", key),
+			example: &valueWrapper{value: value},
+		})
+		if unexpandable, ok := err.(*unexpandableError); ok {
+			return nil, unexpandable.under(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		expanded[key] = subValue
+	}
+	return expanded, nil
+}
+
+// expandStoredItems expands the stored objects on a page of a list as
+// requested, producing the list's `data`. Only the objects in a list's `data`
+// can be expanded.
+func (g *DataGenerator) expandStoredItems(params *GenerateParams,
+	itemSchemas map[string]*spec.Schema, objects []map[string]interface{}) ([]interface{}, error) {
+
+	var itemExpansions *ExpansionLevel
+	if params.Expansions != nil {
+		for key, subExpansions := range params.Expansions.expansions {
+			if key != "data" {
+				return nil, &unexpandableError{path: key}
+			}
+			itemExpansions = subExpansions
+		}
+	}
+
+	itemData := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		resourceID, _ := object["object"].(string)
+		item, err := g.expandStoredObject(params, itemSchemas[resourceID], object,
+			itemExpansions)
+		if unexpandable, ok := err.(*unexpandableError); ok {
+			return nil, unexpandable.under("data")
+		}
+		if err != nil {
+			return nil, err
+		}
+		itemData = append(itemData, item)
+	}
+	return itemData, nil
+}
+
+// findAnyOfBranch finds a branch of a schema containing `anyOf` that's either
+// a deleted resource or not based off of the value of the deleted argument.
+// If discriminatorValue isn't empty, the branch that it identifies is
+// preferred (see spec.Schema.DiscriminatorValue).
+//
+// The branch's own discriminator value is returned along with it so that it
+// can be set on the generated object.
+func (g *DataGenerator) findAnyOfBranch(schema *spec.Schema, deleted bool,
+	discriminatorValue string) (*spec.Schema, string, error) {
+
+	var firstBranch *spec.Schema
+	var firstBranchValue string
+
+	for _, anyOfSchema := range schema.AnyOf {
+		ref := anyOfSchema.Ref
+		anyOfSchema, _, err := g.maybeDereference(anyOfSchema, "")
+		if err != nil {
+			return nil, "", err
+		}
+
+		deletedResource := isDeletedResource(anyOfSchema)
+		if deleted != deletedResource {
+			continue
+		}
+
+		branchValue := schema.DiscriminatorValue(ref, anyOfSchema)
+		if discriminatorValue == "" || branchValue == discriminatorValue {
+			return anyOfSchema, branchValue, nil
+		}
+
+		if firstBranch == nil {
+			firstBranch = anyOfSchema
+			firstBranchValue = branchValue
+		}
+	}
+	return firstBranch, firstBranchValue, nil
+}
+
+// findAnyOfBranchForID finds the branch of a schema containing `anyOf` for the
+// resource that the given object ID belongs to going by its prefix, like a
+// card for `card_123`. Like findAnyOfBranch, the branch is the resource's
+// deleted form or not based off of the value of the deleted argument.
+//
+// Returns nil if schema doesn't have an `anyOf` or none of its branches
+// match.
+func (g *DataGenerator) findAnyOfBranchForID(schema *spec.Schema, id string,
+	deleted bool) (*spec.Schema, error) {
+
+	schema, _, err := g.maybeDereference(schema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, anyOfSchema := range schema.AnyOf {
+		anyOfSchema, _, err := g.maybeDereference(anyOfSchema, "")
+		if err != nil {
+			return nil, err
+		}
+		if deleted != isDeletedResource(anyOfSchema) {
+			continue
+		}
+
+		name := resourceObjectName(anyOfSchema)
+		if name != "" && strings.HasPrefix(id, objectIDPrefix(name)) {
+			return anyOfSchema, nil
+		}
+	}
+	return nil, nil
+}
+
+// findDeletedSchema finds the schema for the deleted form of the resource
+// described by schema, which is named after the resource like
+// `deleted_customer`. Returns nil if schema isn't for a single resource (e.g.
+// it's a list or an `anyOf`), is already for a deleted resource, or if the
+// resource has no deleted form.
+func (g *DataGenerator) findDeletedSchema(schema *spec.Schema) (*spec.Schema, error) {
+	schema, _, err := g.maybeDereference(schema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if schema.XResourceID == "" || len(schema.AnyOf) > 0 || isDeletedResource(schema) {
+		return nil, nil
+	}
+
+	deletedSchema, ok := g.definitions["deleted_"+schema.XResourceID]
+	if !ok || !isDeletedResource(deletedSchema) {
+		return nil, nil
+	}
+	return deletedSchema, nil
+}
+
+// findListItemSchemas finds the schemas of the resources whose objects can
+// be in a list with items described by itemSchema. There's one unless the
+// items are polymorphic, in which case there's one for each branch of their
+// `anyOf`. They're returned keyed by resource (e.g. `card`).
+//
+// The returned map is empty if the items aren't resources that can be stored.
+func (g *DataGenerator) findListItemSchemas(itemSchema *spec.Schema) (map[string]*spec.Schema, error) {
+	itemSchemas := make(map[string]*spec.Schema)
+
+	itemSchema, _, err := g.maybeDereference(itemSchema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if resourceID := resourceObjectName(itemSchema); resourceID != "" {
+		itemSchemas[resourceID] = itemSchema
+		return itemSchemas, nil
+	}
+
+	for _, subSchema := range itemSchema.AnyOf {
+		subSchema, _, err := g.maybeDereference(subSchema, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if resourceID := resourceObjectName(subSchema); resourceID != "" {
+			itemSchemas[resourceID] = subSchema
+		}
+	}
+	return itemSchemas, nil
+}
+
+// findParentListSchema finds the schema to generate a list nested under a
+// parent resource with when its objects can be one of several resources (like
+// a customer's sources). It's a copy of the list's schema with items of the
+// first of those resources whose objects can refer back to the parent, like a
+// card rather than an account, so that they can be given the parent's ID.
+//
+// Returns nil if schema isn't for such a list, or if none of the resources
+// can refer to the parent.
+func (g *DataGenerator) findParentListSchema(schema *spec.Schema,
+	pathParams *PathParamsMap, requestPath string) (*spec.Schema, error) {
+
+	schema, _, err := g.maybeDereference(schema, "")
+	if err != nil {
+		return nil, err
+	}
+	if !isListResource(schema) || len(pathParams.SecondaryIDs) == 0 {
+		return nil, nil
+	}
+
+	itemSchema, _, err := g.maybeDereference(schema.Properties["data"].Items, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, anyOfSchema := range itemSchema.AnyOf {
+		anyOfSchema, _, err := g.maybeDereference(anyOfSchema, "")
+		if err != nil {
+			return nil, err
+		}
+		if isDeletedResource(anyOfSchema) ||
+			!refersToParents(pathParams, requestPath, anyOfSchema) {
+
+			continue
+		}
+
+		dataSchema := *schema.Properties["data"]
+		dataSchema.Items = anyOfSchema
+
+		listSchema := *schema
+		listSchema.Properties = make(map[string]*spec.Schema, len(schema.Properties))
+		for name, subSchema := range schema.Properties {
+			listSchema.Properties[name] = subSchema
+		}
+		listSchema.Properties["data"] = &dataSchema
+		return &listSchema, nil
+	}
+	return nil, nil
+}
+
+// findStoredObject looks up the object with the given ID of the resource
+// described by schema in the store. It also returns the dereferenced schema.
+// The object is nil if not running in stateful mode or if there's no such
+// object.
+func (g *DataGenerator) findStoredObject(schema *spec.Schema, id string) (map[string]interface{}, *spec.Schema, error) {
+	if g.store == nil || id == "" {
+		return nil, nil, nil
+	}
+
+	schema, _, err := g.maybeDereference(schema, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resourceID := resourceObjectName(schema)
+	if resourceID == "" {
+		return nil, nil, nil
+	}
+
+	object, _ := g.store.Get(resourceID, id)
+	return object, schema, nil
+}
+
+// generatesNull decides whether a field is generated as null instead of with
+// the value in its fixture, which depends on the nullable mode. Only fields
+// with nullable schemas are ever null.
+func (g *DataGenerator) generatesNull(schema *spec.Schema) bool {
+	if !schema.Nullable {
+		return false
+	}
+
+	switch g.nullableMode {
+	case NullableModeAlways:
+		return true
+	case NullableModeRandom:
+		return randIntn(g.rand, 2) == 0
+	}
+	return false
+}
+
+// listStoredObjects gets the stored objects of the resources in itemSchemas
+// that belong in a list. It also returns the names of the resources, sorted.
+//
+// A list of polymorphic objects (like a customer's sources) includes those of
+// every resource it can contain. A list nested under a parent resource only
+// includes the objects that belong to it.
+func (g *DataGenerator) listStoredObjects(params *GenerateParams,
+	itemSchemas map[string]*spec.Schema) ([]map[string]interface{}, []string) {
+
+	// A list of polymorphic objects nested under a parent resource only
+	// includes the resources whose objects can refer back to it, like cards
+	// but not accounts among a customer's sources. Objects of the others
+	// would otherwise be assumed to belong to every parent.
+	if params.PathParams != nil && len(params.PathParams.SecondaryIDs) > 0 &&
+		len(itemSchemas) > 1 {
+
+		children := make(map[string]*spec.Schema)
+		for resourceID, itemSchema := range itemSchemas {
+			if refersToParents(params.PathParams, params.RequestPath, itemSchema) {
+				children[resourceID] = itemSchema
+			}
+		}
+		if len(children) > 0 {
+			itemSchemas = children
+		}
+	}
+
+	var objects []map[string]interface{}
+	var resourceIDs []string
+	for resourceID := range itemSchemas {
+		resourceIDs = append(resourceIDs, resourceID)
+	}
+	sort.Strings(resourceIDs)
+	for _, resourceID := range resourceIDs {
+		objects = append(objects, g.store.List(resourceID)...)
+	}
+
+	// Like the Stripe API, lists are ordered with the most recently created
+	// objects first. The store already returns objects in that order as long
+	// as their `created` timestamps weren't changed, so the sort is stable to
+	// keep it for objects created in the same second.
+	sort.SliceStable(objects, func(i, j int) bool {
+		return createdTime(objects[i]) > createdTime(objects[j])
+	})
+
+	if params.PathParams != nil && len(params.PathParams.SecondaryIDs) > 0 {
+		var children []map[string]interface{}
+		for _, object := range objects {
+			if belongsToParents(params.PathParams, params.RequestPath, object) {
+				children = append(children, object)
+			}
+		}
+		objects = children
+	}
+
+	return objects, resourceIDs
+}
+
+// maybeDereference resolves a schema that's a reference to the definition
+// that it refers to, following references to references to any depth, and
+// merges the schemas of an `allOf` into one so that the result can be
+// generated like any other schema.
+//
+// Returns an error if references refer back to themselves.
+func (g *DataGenerator) maybeDereference(schema *spec.Schema, context string) (*spec.Schema, string, error) {
+	return g.maybeDereferenceInternal(schema, context, make(map[string]bool))
+}
+
+// maybeDereferenceInternal is maybeDereference with the references that have
+// already been followed, which are used to detect cycles.
+func (g *DataGenerator) maybeDereferenceInternal(schema *spec.Schema, context string,
+	seenRefs map[string]bool) (*spec.Schema, string, error) {
+
+	for schema.Ref != "" {
+		if seenRefs[schema.Ref] {
+			return nil, context, fmt.Errorf("%sCycle in references at '%s'",
+				context, schema.Ref)
+		}
+		seenRefs[schema.Ref] = true
+
+		definition := definitionFromJSONPointer(schema.Ref)
+
+		newSchema, ok := g.definitions[definition]
+		if !ok {
+			panic(fmt.Sprintf("Couldn't dereference: %v", schema.Ref))
+		}
+		context = fmt.Sprintf("%sDereferencing '%s':\n", context, schema.Ref)
+		schema = newSchema
+	}
+
+	if len(schema.AllOf) == 0 {
+		return schema, context, nil
+	}
+
+	// Copy the schema so that the merge doesn't modify its definition
+	merged := *schema
+	merged.AllOf = nil
+	for _, subSchema := range schema.AllOf {
+		// Each branch gets its own copy of the references followed so far so
+		// that two branches referring to the same schema isn't a cycle
+		branchRefs := make(map[string]bool, len(seenRefs))
+		for ref := range seenRefs {
+			branchRefs[ref] = true
+		}
+
+		subSchema, _, err := g.maybeDereferenceInternal(subSchema, context, branchRefs)
+		if err != nil {
+			return nil, context, err
+		}
+		mergeSchema(&merged, subSchema)
+	}
+	return &merged, context, nil
+}
+
+// populateStoredNestedLists fills the lists of sub-resources nested in an
+// object (see nestedLists) with the stored objects that belong to it,
+// expanding them as requested. A list is only filled if the object has it or
+// it was expanded, and it's left alone if its sub-resources aren't ones that
+// are stored. The object is modified in place.
+//
+// Returns the properties of the lists that were filled.
+func (g *DataGenerator) populateStoredNestedLists(schema *spec.Schema,
+	object map[string]interface{}, expansions *ExpansionLevel) (map[string]bool, error) {
+
+	id, ok := object["id"].(string)
+	if !ok {
+		return nil, nil
+	}
+
+	populated := make(map[string]bool)
+	for key, path := range g.nestedLists[resourceObjectName(schema)] {
+		var listExpansions *ExpansionLevel
+		if expansions != nil {
+			listExpansions = expansions.expansions[key]
+			if listExpansions == nil && expansions.wildcard {
+				listExpansions = &ExpansionLevel{expansions: make(map[string]*ExpansionLevel)}
+			}
+		}
+		if _, ok := object[key]; !ok && listExpansions == nil {
+			continue
+		}
+
+		listSchema, _, err := g.maybeDereference(schema.Properties[key], "")
+		if err != nil {
+			return nil, err
+		}
+		itemSchemas, err := g.findListItemSchemas(listSchema.Properties["data"].Items)
+		if err != nil {
+			return nil, err
+		}
+		var stored bool
+		for resourceID := range itemSchemas {
+			stored = stored || g.creatableResources[resourceID]
+		}
+		if !stored {
+			continue
+		}
+
+		// The list contains what would be listed at its own path, like
+		// `/v1/charges/ch_123/refunds`
+		listURL := nestedListURL(path, id)
+		listParams := &GenerateParams{
+			Expansions: listExpansions,
+			PathParams: &PathParamsMap{SecondaryIDs: []*PathParamsSecondaryID{
+				{ID: id, Name: pathParameterPattern.FindStringSubmatch(path)[1]},
+			}},
+			RequestPath: listURL,
+			Schema:      listSchema,
+		}
+		objects, _ := g.listStoredObjects(listParams, itemSchemas)
+
+		ids := make([]string, len(objects))
+		for i, object := range objects {
+			ids[i], _ = object["id"].(string)
+		}
+		pagination := &listPagination{limit: listLimitDefault}
+		start, end, hasMore, _ := pagination.page(ids)
+
+		itemData, err := g.expandStoredItems(listParams, itemSchemas, objects[start:end])
+		if unexpandable, ok := err.(*unexpandableError); ok {
+			return nil, unexpandable.under(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		listData := buildListResource(listParams, itemData)
+		setListPageInfo(listData, hasMore, len(objects))
+		setListURL(listData, listURL)
+		object[key] = listData
+		populated[key] = true
+	}
+	return populated, nil
+}
+
+// resolveResourceSchema dereferences the given schema, and if it's an anyOf,
+// picks the branch that's a deleted resource or not based off of the value of
+// the deleted argument.
+func (g *DataGenerator) resolveResourceSchema(schema *spec.Schema, deleted bool) (*spec.Schema, error) {
+	schema, _, err := g.maybeDereference(schema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(schema.AnyOf) == 0 {
+		return schema, nil
+	}
+
+	anyOfSchema, _, err := g.findAnyOfBranch(schema, deleted, "")
+	if err != nil {
+		return nil, err
+	}
+	if anyOfSchema != nil {
+		return anyOfSchema, nil
+	}
+
+	schema, _, err = g.maybeDereference(schema.AnyOf[0], "")
+	return schema, err
+}
+
+// resourceObjectNames gets the names of the resources that objects described
+// by a schema (or any of the branches of its `anyOf`) can be, without
+// duplicates. A resource and its deleted form have the same name.
+func (g *DataGenerator) resourceObjectNames(schema *spec.Schema) ([]string, error) {
+	schema, _, err := g.maybeDereference(schema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := []*spec.Schema{schema}
+	if len(schema.AnyOf) > 0 {
+		schemas = schema.AnyOf
+	}
+
+	var names []string
+	for _, subSchema := range schemas {
+		subSchema, _, err := g.maybeDereference(subSchema, "")
+		if err != nil {
+			return nil, err
+		}
+		name := resourceObjectName(subSchema)
+		if name != "" && indexOfString(names, name) == -1 {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (g *DataGenerator) generateListResource(params *GenerateParams) (interface{}, error) {
+	// Only the objects in a list's `data` can be expanded
+	var itemExpansions *ExpansionLevel
+	if params.Expansions != nil {
+		for key, subExpansions := range params.Expansions.expansions {
+			if key != "data" {
+				return nil, &unexpandableError{path: key}
+			}
+			itemExpansions = subExpansions
+		}
+	}
+
+	itemData, err := g.generateInternal(&GenerateParams{
+		Expansions:    itemExpansions,
+		PathParams:    nil,
+		RequestMethod: params.RequestMethod,
+		RequestPath:   params.RequestPath,
+		Schema:        params.Schema.Properties["data"].Items,
+
+		context: fmt.Sprintf("%sPopulating list resource:\n", params.context),
+		example: nil,
+	})
+	if unexpandable, ok := err.(*unexpandableError); ok {
+		return nil, unexpandable.under("data")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buildListResource(params, []interface{}{itemData}), nil
+}
+
+// requestTime returns the Unix timestamp of the time at which the request
+// being responded to was received.
+func (g *DataGenerator) requestTime() int64 {
+	if g.now.IsZero() {
+		return time.Now().Unix()
+	}
+	return g.now.Unix()
+}
+
+// shapeStoredValue returns a copy of a value from the store without any of the
+// properties of its objects that aren't in the given schema. An object may
+// have been stored while handling a request for another API version, and the
+// response has to match the requested version's schema exactly, so a property
+// that was removed in that version mustn't appear.
+//
+// Values under an `anyOf` are left alone because it isn't clear which branch
+// they belong to.
+func (g *DataGenerator) shapeStoredValue(schema *spec.Schema, value interface{}) (interface{}, error) {
+	schema, _, err := g.maybeDereference(schema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if schema.Properties == nil {
+			return v, nil
+		}
+
+		shaped := make(map[string]interface{}, len(v))
+		for key, subValue := range v {
+			subSchema, ok := schema.Properties[key]
+			if !ok {
+				continue
+			}
+
+			shaped[key], err = g.shapeStoredValue(subSchema, subValue)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return shaped, nil
+
+	case []interface{}:
+		if schema.Items == nil {
+			return v, nil
+		}
+
+		shaped := make([]interface{}, len(v))
+		for i, item := range v {
+			shaped[i], err = g.shapeStoredValue(schema.Items, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return shaped, nil
+	}
+
+	return value, nil
+}
+
+// storeCreatedObject assigns a newly created object a unique ID and puts it
+// in the store. The object is modified in place.
+//
+// Objects that don't look like an API resource (i.e., they don't have an
+// `id` and `object`) are left alone.
+func (g *DataGenerator) storeCreatedObject(params *GenerateParams, data interface{}) {
+	object, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	oldID, ok := object["id"].(string)
+	if !ok {
+		return
+	}
+
+	resourceID, ok := object["object"].(string)
+	if !ok {
+		return
+	}
+
+	// Every object generated from the same fixture would otherwise have the
+	// same ID, so give it a new one and replace any references to its old ID
+	// (e.g. in the URL of an embedded list) along with it.
+	//
+	// IDs are random, but may be generated from a seeded source that's in the
+	// same state for every request, so make sure that the ID isn't taken.
+	newID := generateObjectID(g.rand, oldID)
+	for {
+		_, exists := g.store.Get(resourceID, newID)
+		if !exists && !g.store.Deleted(resourceID, newID) {
+			break
+		}
+		newID = generateObjectID(g.rand, oldID)
+	}
+	distributeReplacedIDs(&PathParamsMap{
+		PrimaryID:         &newID,
+		replacedPrimaryID: &oldID,
+	}, object)
+
+	g.store.Put(resourceID, newID, object)
+}
+
+// syntheticListLength picks the number of objects in a synthetic list. It's
+// between 1 and maxListSize if that's set, so that clients can be tested
+// against lists of varying (and larger) sizes.
+func (g *DataGenerator) syntheticListLength() int {
+	if g.maxListSize <= 0 {
+		return syntheticListSize
+	}
+	return randIntn(g.rand, g.maxListSize) + 1
+}
+
+//
+// Private values
+//
+
+// Bounds and default for the number of objects that can be requested in a
+// single page of a list with the `limit` parameter.
+const (
+	listLimitDefault = 10
+	listLimitMax     = 100
+	listLimitMin     = 1
+)
+
+// actionStates maps resources to the actions that can be taken on their
+// objects with `POST` requests (like `/v1/invoices/in_123/pay`), and those to
+// the fields that change when they are, like an invoice's `status`.
+var actionStates = map[string]map[string]map[string]interface{}{
+	"bank_account": {
+		"verify": {"status": "verified"},
+	},
+	"charge": {
+		"capture": {"captured": true},
+		"refund":  {"refunded": true},
+	},
+	"dispute": {
+		"close": {"status": "lost"},
+	},
+	"invoice": {
+		"pay": {"paid": true, "status": "paid"},
+	},
+	"issuing.authorization": {
+		"approve": {"approved": true},
+		"decline": {"approved": false, "status": "closed"},
+	},
+	"order": {
+		"pay": {"status": "paid"},
+	},
+	"payment_intent": {
+		"cancel":  {"status": "canceled"},
+		"capture": {"status": "succeeded"},
+		"confirm": {"status": "succeeded"},
+	},
+	"payout": {
+		"cancel": {"status": "canceled"},
+	},
+	"source": {
+		"verify": {"status": "chargeable"},
+	},
+	"topup": {
+		"cancel": {"status": "canceled"},
+	},
+}
+
+// syntheticListSize is the number of objects in a list that's generated
+// without the benefit of a store (unless a maximum list size is configured).
+// Pages of a list like that are made up of copies of a single generated
+// object, each given a different (but stable) ID.
+const syntheticListSize = 100
+
+// objectIDChars are the characters used in the random part of generated
+// object IDs.
+const objectIDChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// objectIDLength is the length of the random part of generated object IDs.
+const objectIDLength = 24
+
+// syntheticCurrencies are the currencies that synthetic objects may be given,
+// along with the rules for amounts in their minor units.
+var syntheticCurrencies = []syntheticCurrency{
+	{code: "eur", decimals: 2},
+	{code: "gbp", decimals: 2},
+	{code: "jpy", decimals: 0},
+	{code: "krw", decimals: 0},
+	{code: "kwd", decimals: 3},
+	{code: "usd", decimals: 2},
+}
+
+// syntheticLoremIpsum is the free text given to synthetic objects, repeated or
+// cut short to fit its schema.
+const syntheticLoremIpsum = "Lorem ipsum dolor sit amet"
+
+// syntheticObjectIDSuffix follows the prefix of the IDs of synthetic objects.
+// Like the IDs in fixtures, it's always the same.
+const syntheticObjectIDSuffix = "123456789"
+
+// objectIDPrefixes maps resources to the prefixes of their objects' IDs. It's
+// used to give IDs to synthetic objects.
+var objectIDPrefixes = map[string]string{
+	"account":               "acct",
+	"alipay_account":        "aliacc",
+	"apple_pay_domain":      "apwc",
+	"application_fee":       "fee",
+	"balance_transaction":   "txn",
+	"bank_account":          "ba",
+	"bitcoin_receiver":      "btcrcv",
+	"bitcoin_transaction":   "btctxn",
+	"card":                  "card",
+	"charge":                "ch",
+	"customer":              "cus",
+	"dispute":               "dp",
+	"ephemeral_key":         "ephkey",
+	"event":                 "evt",
+	"fee_refund":            "fr",
+	"file":                  "file",
+	"file_link":             "link",
+	"file_upload":           "file",
+	"invoice":               "in",
+	"invoiceitem":           "ii",
+	"issuing.authorization": "iauth",
+	"issuing.card":          "ic",
+	"issuing.cardholder":    "ich",
+	"issuing.dispute":       "idp",
+	"issuing.transaction":   "ipi",
+	"order":                 "or",
+	"order_return":          "orret",
+	"payment_intent":        "pi",
+	"payment_method":        "pm",
+	"payout":                "po",
+	"product":               "prod",
+	"recipient":             "rp",
+	"refund":                "re",
+	"reporting.report_run":  "frr",
+	"scheduled_query_run":   "sqr",
+	"setup_intent":          "seti",
+	"sku":                   "sku",
+	"source":                "src",
+	"subscription":          "sub",
+	"subscription_item":     "si",
+	"token":                 "tok",
+	"topup":                 "tu",
+	"transfer":              "tr",
+	"transfer_reversal":     "trr",
+	"usage_record":          "mbur",
+}
+
+// formatUnixTime is the schema format of integers that are Unix timestamps.
+const formatUnixTime = "unix-time"
+
+// invalidRangeOperator is the message of the error produced when a list is
+// filtered with a range operator that doesn't exist, like `created[gteq]`.
+const invalidRangeOperator = "Invalid range operator: %s. Ranges of " +
+	"timestamps are given with `gt`, `gte`, `lt`, and `lte`."
+
+// unexpandableProperty is the message of the error produced when a request
+// asks for a property to be expanded that can't be.
+const unexpandableProperty = "This property cannot be expanded (%s)."
+
+//
+// Private types
+//
+
+// createdFilter restricts a list to the objects created in a range of times,
+// as requested with a `created` parameter like `created[gte]=1500000000`.
+type createdFilter struct {
+	// Bounds of the range of `created` timestamps. A bound is nil if the
+	// range isn't bounded by it.
+	gt, gte, lt, lte *int64
+}
+
+// filter returns the objects whose `created` timestamps are in the range.
+// Objects are returned unfiltered if the filter is nil.
+func (f *createdFilter) filter(objects []map[string]interface{}) []map[string]interface{} {
+	if f == nil {
+		return objects
+	}
+
+	var filtered []map[string]interface{}
+	for _, object := range objects {
+		if f.matches(createdTime(object)) {
+			filtered = append(filtered, object)
+		}
+	}
+	return filtered
+}
+
+// matches checks whether a `created` timestamp is in the range.
+func (f *createdFilter) matches(created int64) bool {
+	return (f.gt == nil || created > *f.gt) &&
+		(f.gte == nil || created >= *f.gte) &&
+		(f.lt == nil || created < *f.lt) &&
+		(f.lte == nil || created <= *f.lte)
+}
+
+// invalidRequestError is produced when a request's parameters are found to be
+// invalid while generating a response for it.
+type invalidRequestError struct {
+	// code is a short string identifying the error, like
+	// `payment_intent_unexpected_state`. Empty for most errors.
+	code string
+
+	message string
+
+	// param is the name of the parameter that was invalid, if the error is
+	// about a single one.
+	param string
+}
+
+func (e *invalidRequestError) Error() string {
+	return e.message
+}
+
+// listPagination contains the pagination parameters of a list request.
+type listPagination struct {
+	// endingBefore is an object ID from which to return the page of objects
+	// that come before it. Empty if not requested.
+	endingBefore string
+
+	// limit is the maximum number of objects to return.
+	limit int
+
+	// startingAfter is an object ID from which to return the page of objects
+	// that come after it. Empty if not requested.
+	startingAfter string
+}
+
+// page finds the bounds of the requested page in a list made up of objects
+// with the given IDs (in list order). It also returns whether there are more
+// objects in the list beyond the page in the direction of pagination.
+//
+// If a requested cursor ID couldn't be found in the list, it's returned as
+// the last value, and the other values should be ignored.
+func (p *listPagination) page(ids []string) (int, int, bool, string) {
+	if p.startingAfter != "" {
+		index := indexOfString(ids, p.startingAfter)
+		if index == -1 {
+			return 0, 0, false, p.startingAfter
+		}
+
+		start := index + 1
+		end := minInt(start+p.limit, len(ids))
+		return start, end, end < len(ids), ""
+	}
+
+	if p.endingBefore != "" {
+		end := indexOfString(ids, p.endingBefore)
+		if end == -1 {
+			return 0, 0, false, p.endingBefore
+		}
+
+		start := maxInt(end-p.limit, 0)
+		return start, end, start > 0, ""
+	}
+
+	end := minInt(p.limit, len(ids))
+	return 0, end, end < len(ids), ""
+}
+
+// notFoundError is produced when a request targets an object that's known to
+// have been deleted from the store, or that was never created.
+type notFoundError struct {
+	object string
+	id     string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("No such %s: %s", e.object, e.id)
+}
+
+// syntheticStrings is how strings in synthetic objects are generated (see
+// Config.StringLength and Config.StringCharset).
+type syntheticStrings struct {
+	charset string
+	length  int
+}
+
+// generate generates a string of random characters from the charset for a
+// schema. It has the configured length, but no shorter than the schema's
+// `minLength` or longer than its `maxLength`.
+//
+// A nil syntheticStrings generates strings that are as short as the schema
+// allows.
+func (s *syntheticStrings) generate(r *rand.Rand, schema *spec.Schema) string {
+	length := schema.MinLength
+	if s == nil {
+		return strings.Repeat("a", length)
+	}
+
+	length = maxInt(s.length, length)
+	if schema.MaxLength != 0 {
+		length = minInt(length, schema.MaxLength)
+	}
+
+	charset := []rune(s.charset)
+	b := make([]rune, length)
+	for i := range b {
+		b[i] = charset[randIntn(r, len(charset))]
+	}
+	return string(b)
+}
+
+// syntheticCurrency is a currency that synthetic objects may be given.
+type syntheticCurrency struct {
+	code string
+
+	// decimals is the number of decimal places in the currency's minor unit,
+	// which amounts are given in. It's 0 for zero-decimal currencies like
+	// `jpy`, which have no sub-unit, and 2 for ones like `usd`, whose amounts
+	// are in cents.
+	decimals int
+}
+
+// unexpandableError is produced when a request asks for a property to be
+// expanded that can't be.
+type unexpandableError struct {
+	// path is the dotted path to the property relative to the level of
+	// generation that produced the error. It's built up as the error is
+	// returned through each level.
+	path string
+}
+
+func (e *unexpandableError) Error() string {
+	return fmt.Sprintf(unexpandableProperty, e.path)
+}
+
+// invalidRequest converts the error to the one that's returned to the client
+// once it reaches the top level.
+func (e *unexpandableError) invalidRequest() *invalidRequestError {
+	return &invalidRequestError{message: e.Error()}
+}
+
+// under produces a new error for the same property that's relative to the
+// level above, where the current level is found under key.
+func (e *unexpandableError) under(key string) *unexpandableError {
+	return &unexpandableError{path: key + "." + e.path}
+}
+
+// valueWrapper wraps an example value that we're generating.
+//
+// It exists so that we can make a distinction between an example that we don't
+// have (where `valueWrapper` itself is `nil`) from one where we have an
+// example, but it has a `null` value (where we'd have `valueWrapper{value:
+// nil}`).
+type valueWrapper struct {
+	value interface{}
+
+	// synthetic is whether the value was generated from scratch (see
+	// generateSyntheticFixture) rather than taken from a fixture. An empty
+	// array in a synthetic value is just a placeholder for its items.
+	synthetic bool
+}
+
+//
+// Private functions
+//
+
+// actionName gets the name of the action that a request takes on an object,
+// like `pay` for `POST /v1/invoices/in_123/pay`. Returns an empty string for
+// requests that aren't for actions.
+func actionName(params *GenerateParams) string {
+	if params.RequestMethod != http.MethodPost || params.PathParams == nil ||
+		params.PathParams.PrimaryID == nil {
+		return ""
+	}
+
+	action := params.RequestPath[strings.LastIndex(params.RequestPath, "/")+1:]
+	if action == *params.PathParams.PrimaryID {
+		return ""
+	}
+	return action
+}
+
+// applyAction sets the fields of an object that change when the request is
+// for an action taken on it, like `POST /v1/invoices/in_123/pay` (see
+// actionStates). Only fields that the object already has are set, and nothing
+// is done for requests that aren't for actions.
+func applyAction(params *GenerateParams, schema *spec.Schema, object map[string]interface{}) {
+	action := actionName(params)
+	if action == "" {
+		return
+	}
+
+	for name, value := range actionStates[resourceObjectName(schema)][action] {
+		if _, ok := object[name]; ok {
+			object[name] = value
+		}
+	}
+}
+
+// belongsToParents checks whether an object refers to the parent resources
+// whose IDs were extracted from the request path (see parentFieldNames). An
+// object without any field referring to a parent is assumed to belong to it.
+func belongsToParents(pathParams *PathParamsMap, requestPath string,
+	object map[string]interface{}) bool {
+
+	for _, secondaryID := range pathParams.SecondaryIDs {
+		for _, name := range parentFieldNames(requestPath, secondaryID) {
+			value, ok := object[name]
+			if !ok {
+				continue
+			}
+			if expanded, ok := value.(map[string]interface{}); ok {
+				value = expanded["id"]
+			}
+			if value != secondaryID.ID {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// buildListResource builds a list resource for the list schema in params
+// containing the given items.
+func buildListResource(params *GenerateParams, itemData []interface{}) map[string]interface{} {
+	if itemData == nil {
+		itemData = []interface{}{}
+	}
+
+	// This is written to hopefully be a little more forward compatible in that
+	// it respects the list properties dictated by the included schema rather
+	// than assuming its own.
+	listData := make(map[string]interface{})
+	for key, subSchema := range params.Schema.Properties {
+		var val interface{}
+		switch key {
+		case "data":
+			val = itemData
+		case "has_more":
+			val = false
+		case "object":
+			val = subSchema.Enum[0]
+		case "total_count":
+			val = len(itemData)
+		case "url":
+			if strings.HasPrefix(subSchema.Pattern, "^") {
+				// Many list resources have a URL pattern of the form "^/v1/whatevers";
+				// we cut off the "^" to leave the URL.
+				val = subSchema.Pattern[1:]
+			} else if params.example != nil {
+				// If an example was provided, we can assume it has the correct format
+				example := params.example.value.(map[string]interface{})
+				val = example["url"].(string)
+			} else {
+				val = params.RequestPath
+			}
+		default:
+			val = nil
+		}
+		listData[key] = val
+	}
+	return listData
+}
+
+// clampToRange brings a number within the minimum and maximum of a schema, if
+// it has them.
+func clampToRange(schema *spec.Schema, number float64) float64 {
+	if schema.Minimum != nil && number < *schema.Minimum {
+		number = *schema.Minimum
+	}
+	if schema.Maximum != nil && number > *schema.Maximum {
+		number = *schema.Maximum
+	}
+	return number
+}
+
+// contentHashID generates an object ID that's derived from the request that
+// created the object: its method, path, and parameters. It has the same prefix
+// as the given example ID, followed by the base62-encoded hash of the
+// request.
+func contentHashID(exampleID string, params *GenerateParams) string {
+	var prefix string
+	if i := strings.LastIndex(exampleID, "_"); i != -1 {
+		prefix = exampleID[:i+1]
+	}
+
+	// Maps are encoded with their keys sorted, so the same parameters are
+	// always hashed the same way
+	requestData, err := json.Marshal(params.RequestData)
+	if err != nil {
+		panic(err)
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", params.RequestMethod, params.RequestPath)
+	hash.Write(requestData)
+
+	n := new(big.Int).SetBytes(hash.Sum(nil))
+	base := big.NewInt(int64(len(objectIDChars)))
+	remainder := new(big.Int)
+
+	b := make([]byte, objectIDLength)
+	for i := range b {
+		n.DivMod(n, base, remainder)
+		b[i] = objectIDChars[remainder.Int64()]
+	}
+	return prefix + string(b)
+}
+
+// createdTime returns the `created` timestamp of an object, or 0 if it doesn't
+// have one. Stored timestamps may be integers or, if they came from decoded
+// JSON, floats.
+func createdTime(object map[string]interface{}) int64 {
+	switch created := object["created"].(type) {
+	case float64:
+		return int64(created)
+	case int:
+		return int64(created)
+	case int64:
+		return created
+	}
+	return 0
+}
+
+// definitionFromJSONPointer extracts the name of a JSON schema definition from
+// a JSON pointer, so "#/components/schemas/charge" would become just "charge".
+// This is a simplified workaround to avoid bringing in JSON schema
+// infrastructure because we can guarantee that the spec we're producing will
+// take a certain shape. If this gets too hacky, it will be better to put a more
+// legitimate JSON schema parser in place.
+func definitionFromJSONPointer(pointer string) string {
+	parts := strings.Split(pointer, "/")
+
+	if len(parts) != 4 ||
+		parts[0] != "#" ||
+		parts[1] != "components" ||
+		parts[2] != "schemas" {
+		panic(fmt.Sprintf("Expected '#/components/schemas/...' but got '%v'", pointer))
+	}
+	return parts[3]
+}
+
+// distributeReplacedIDs descends through a generated data structure
+// recursively looking for IDs that were generated during data generation and
+// replaces them with their appropriate replacement value.
+func distributeReplacedIDs(pathParams *PathParamsMap, data interface{}) {
+	dataSlice, ok := data.([]interface{})
+	if ok {
+		for _, val := range dataSlice {
+			distributeReplacedIDs(pathParams, val)
+		}
+		return
+	}
+
+	dataMap, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for key, value := range dataMap {
+		newValue, ok := distributeReplacedIDsInValue(pathParams, value)
+		if ok {
+			dataMap[key] = newValue
+			continue
+		}
+
+		if key == "url" {
+			newValue, ok := distributeReplacedIDsInURL(pathParams, value)
+			if ok {
+				dataMap[key] = newValue
+				continue
+			}
+		}
+
+		distributeReplacedIDs(pathParams, value)
+	}
+}
+
+// distributeReplacedIDsInValue returns a new value for the `url` field of a
+// list object if it's detected that its value contained an ID that we replaced
+// with an injected one.
+//
+// For example, in the URL `/v1/charges/ch_123/refunds`, `ch_123` may have been
+// a replaced ID.
+func distributeReplacedIDsInURL(pathParams *PathParamsMap, value interface{}) (string, bool) {
+	valStr, ok := value.(string)
+	if !ok {
+		return "", false
+	}
+
+	if pathParams.replacedPrimaryID != nil {
+		search := "/" + *pathParams.replacedPrimaryID + "/"
+		if strings.Index(valStr, search) != -1 {
+			return strings.Replace(valStr, search, "/"+*pathParams.PrimaryID+"/", 1), true
+		}
+	}
+
+	for _, secondaryID := range pathParams.SecondaryIDs {
+		for _, replacedID := range secondaryID.replacedIDs {
+			search := "/" + replacedID + "/"
+			if strings.Index(valStr, search) != -1 {
+				return strings.Replace(valStr, search, "/"+secondaryID.ID+"/", 1), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// distributeReplacedIDsInValue returns a new value for an existing one if it's
+// detected that its value was an ID that we replaced with an injected one.
+//
+// It works by comparing the value against any replacement ID values that were
+// found in pathParams. Replacement IDs were added to pathParams when the
+// generator was doing another pass earlier on in the process.
+func distributeReplacedIDsInValue(pathParams *PathParamsMap, value interface{}) (string, bool) {
+	valStr, ok := value.(string)
+	if !ok {
+		return "", false
+	}
+
+	if pathParams.replacedPrimaryID != nil && valStr == *pathParams.replacedPrimaryID {
+		return *pathParams.PrimaryID, true
+	}
+
+	for _, secondaryID := range pathParams.SecondaryIDs {
+		for _, replacedID := range secondaryID.replacedIDs {
+			if valStr == replacedID {
+				return secondaryID.ID, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// generateObjectID generates a new random object ID that has the same prefix
+// as the given example ID. For example, given `ch_123`, it might return
+// `ch_Zu2k4G0fW8bIEiDZ3WK7pSPE`.
+//
+// Randomness comes from r, or from the global source if r is nil.
+func generateObjectID(r *rand.Rand, exampleID string) string {
+	var prefix string
+	if i := strings.LastIndex(exampleID, "_"); i != -1 {
+		prefix = exampleID[:i+1]
+	}
+
+	b := make([]byte, objectIDLength)
+	for i := range b {
+		b[i] = objectIDChars[randIntn(r, len(objectIDChars))]
+	}
+	return prefix + string(b)
+}
+
+// generateSyntheticAmount generates an amount in the given currency for a
+// synthetic fixture. It's a whole number of the currency's major units from 1
+// to 100, given in its minor units, so it never has a sub-unit that the
+// currency doesn't (and is a multiple of 10 for three-decimal currencies, as
+// Stripe requires).
+func generateSyntheticAmount(r *rand.Rand, schema *spec.Schema,
+	currency *syntheticCurrency) int {
+
+	amount := randIntn(r, 100) + 1
+	for i := 0; i < currency.decimals; i++ {
+		amount *= 10
+	}
+	return int(clampToRange(schema, float64(amount)))
+}
+
+// generateSyntheticFixture generates a synthetic fixture for the given schema
+// by examining its properties and returning default values for each.
+//
+// This is useful in cases where we don't have a valid fixture for some object.
+// That could happen for a prerelease object or in cases where an expansion has
+// been requested for an embedded object that doesn't occur at the top level of
+// the API.
+//
+// This function calls itself recursively by initially iterating through every
+// property in an object schema, then recursing and returning values for
+// embedded objects and scalars.
+func generateSyntheticFixture(r *rand.Rand, strs *syntheticStrings, schema *spec.Schema,
+	context string) interface{} {
+
+	context = fmt.Sprintf("%sGenerating synthetic fixture: %+v\n", context, schema)
+
+	// A documented default is what the property would most likely be
+	if schema.Default != nil {
+		return store.CopyValue(schema.Default)
+	}
+
+	// Return the minimum viable object by returning nil/null for a nullable
+	// property.
+	if schema.Nullable {
+		return nil
+	}
+
+	// Return a random member of an enum if one is available because it's
+	// probably going to be a more realistic value.
+	if len(schema.Enum) > 0 {
+		return schema.Enum[randIntn(r, len(schema.Enum))]
+	}
+
+	if len(schema.AnyOf) > 0 {
+		for _, subSchema := range schema.AnyOf {
+			// We don't handle dereferencing here right now, but it's plausible
+			if subSchema.Ref != "" {
+				continue
+			}
+			return generateSyntheticFixture(r, strs, subSchema, context)
+		}
+
+		// Every branch is a reference, like the possible types of a charge's
+		// `source`. The caller picks a branch and generates it from its schema
+		// without an example, so any placeholder will do.
+		return map[string]interface{}{}
+	}
+
+	switch schema.Type {
+	case spec.TypeArray:
+		// Items are generated from the schema's `items` by the caller, which
+		// can dereference it
+		return []interface{}{}
+
+	case spec.TypeBoolean:
+		return true
+
+	case spec.TypeInteger:
+		if schema.Format == formatUnixTime {
+			return time.Now().Unix()
+		}
+		return int(math.Ceil(clampToRange(schema, 0)))
+
+	case spec.TypeNumber:
+		return clampToRange(schema, 0.0)
+
+	case spec.TypeObject:
+		// The currency is picked first so that amounts can suit it
+		var currency *syntheticCurrency
+		if hasSyntheticCurrency(schema) {
+			currency = &syntheticCurrencies[randIntn(r, len(syntheticCurrencies))]
+		}
+
+		// Properties are generated in a stable order so that a seeded source
+		// of randomness produces the same fixture every time
+		properties := make([]string, 0, len(schema.Properties))
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+
+		fixture := make(map[string]interface{})
+		for _, property := range properties {
+			subSchema := schema.Properties[property]
+
+			// Return the minimum viable object by not including properties
+			// that are not necessary for a valid object.
+			if !isRequiredProperty(schema, property) {
+				continue
+			}
+
+			// A reference can't be followed here, so the caller generates
+			// the property from scratch instead
+			if subSchema.Ref != "" {
+				continue
+			}
+
+			var value interface{}
+			var ok bool
+			if subSchema.Default == nil {
+				value, ok = generateSyntheticPropertyValue(r, strs, schema, property,
+					subSchema, currency)
+			}
+			if !ok {
+				value = generateSyntheticFixture(r, strs, subSchema, context)
+			}
+			fixture[property] = value
+		}
+		return fixture
+
+	case spec.TypeString:
+		// There's no general way to produce a string matching a pattern, but
+		// at least make sure the string is long enough.
+		if schema.Pattern != "" {
+			return strings.Repeat("a", schema.MinLength)
+		}
+		return strs.generate(r, schema)
+	}
+
+	panic(fmt.Sprintf("%sUnhandled type: %s", context, stringOrEmpty(schema.Type)))
+}
+
+// generateSyntheticPropertyValue generates a plausible value for a property
+// of a synthetic fixture based on the property's name, like a currency for a
+// `currency` or an ID with the right prefix for an `id`, or words for free
+// text like a `description` if strs is set. Properties that every
+// Stripe object has are given the values they always have in test mode:
+// `livemode` is false, and `object` is the resource's name. objectSchema is the
+// schema of the object that the property belongs to, and currency is the one
+// picked for it, if it has one, which its amounts are generated to suit.
+//
+// The second return value is false if there's no particular value that the
+// property should have, in which case a default for its type should be used.
+func generateSyntheticPropertyValue(r *rand.Rand, strs *syntheticStrings,
+	objectSchema *spec.Schema, name string, schema *spec.Schema,
+	currency *syntheticCurrency) (interface{}, bool) {
+
+	// An `object` may allow the names of several resources, but only one of
+	// them is right for this one
+	if name == "object" {
+		if value := resourceObjectValue(objectSchema); value != "" {
+			return value, true
+		}
+	}
+
+	// Nullable properties and enums are handled well enough by
+	// generateSyntheticFixture.
+	if schema.Nullable || len(schema.Enum) > 0 {
+		return nil, false
+	}
+
+	switch schema.Type {
+	case spec.TypeBoolean:
+		// stripe-mock only ever acts like test mode
+		if name == "livemode" {
+			return false, true
+		}
+
+	case spec.TypeInteger:
+		if name == "created" {
+			return time.Now().Unix(), true
+		}
+		if currency != nil && isAmountProperty(name) {
+			return generateSyntheticAmount(r, schema, currency), true
+		}
+
+	case spec.TypeString:
+		switch {
+		case name == "country":
+			return "US", true
+
+		case name == "currency" && currency != nil:
+			return currency.code, true
+
+		case name == "email" || strings.HasSuffix(name, "_email"):
+			return "jenny.rosen@example.com", true
+
+		case strs != nil && schema.Pattern == "" &&
+			(name == "description" || strings.HasSuffix(name, "_description")):
+			return syntheticText(schema), true
+
+		case name == "id" && objectSchema.XResourceID != "":
+			return objectIDPrefix(objectSchema.XResourceID) + syntheticObjectIDSuffix, true
+		}
+	}
+
+	return nil, false
+}
+
+// indexOfString returns the index of the first instance of s in slice, or -1
+// if it's not present.
+func indexOfString(slice []string, s string) int {
+	for i, val := range slice {
+		if val == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasSyntheticCurrency checks whether a synthetic fixture for the given object
+// schema will be given a currency picked from syntheticCurrencies, which is the
+// case if the object requires a plain `currency` string.
+func hasSyntheticCurrency(schema *spec.Schema) bool {
+	currency, ok := schema.Properties["currency"]
+	return ok && isRequiredProperty(schema, "currency") &&
+		currency.Type == spec.TypeString && !currency.Nullable && len(currency.Enum) == 0
+}
+
+// hasListShape checks whether a schema describes an object with the given
+// name that contains other objects in its `data`, like a list.
+func hasListShape(schema *spec.Schema, objectName string) bool {
+	if schema.Type != "object" || schema.Properties == nil {
+		return false
+	}
+
+	object, ok := schema.Properties["object"]
+	if !ok || object.Enum == nil || object.Enum[0] != objectName {
+		return false
+	}
+
+	data, ok := schema.Properties["data"]
+	if !ok || data.Items == nil {
+		return false
+	}
+
+	return true
+}
+
+// isAmountProperty checks whether a property is an amount of money, like
+// `amount`, `amount_refunded`, or `application_fee_amount`.
+func isAmountProperty(name string) bool {
+	return name == "amount" || strings.HasPrefix(name, "amount_") ||
+		strings.HasSuffix(name, "_amount")
+}
+
+// isCreatedTimestamp checks whether a property is the timestamp of when its
+// object was created, which is an integer (or Unix time) named `created`.
+func isCreatedTimestamp(name string, schema *spec.Schema) bool {
+	return name == "created" &&
+		(schema.Type == spec.TypeInteger || schema.Format == formatUnixTime)
+}
+
+func isDeletedResource(schema *spec.Schema) bool {
+	_, ok := schema.Properties["deleted"]
+	return ok
+}
+
+func isListResource(schema *spec.Schema) bool {
+	return hasListShape(schema, "list")
+}
+
+// isSearchResultResource checks whether a schema describes the results of a
+// search, which are shaped like a list but paged through with `next_page`.
+func isSearchResultResource(schema *spec.Schema) bool {
+	return hasListShape(schema, "search_result")
+}
+
+// isObjectID checks whether a string is the ID of an object of one of the
+// resources in objectIDPrefixes, like `cus_123`.
+func isObjectID(s string) bool {
+	prefix := objectIDPrefixOf(s)
+	if prefix == "" {
+		return false
+	}
+	for _, resourcePrefix := range objectIDPrefixes {
+		if prefix == resourcePrefix+"_" {
+			return true
+		}
+	}
+	return false
+}
+
+// isRequiredProperty checks whether the given property name is required for
+// the given schema. Note that this assumes that the schema is of type object
+// because that would be semantic nonsense for any other type.
+func isRequiredProperty(schema *spec.Schema, name string) bool {
+	for _, property := range schema.Required {
+		if name == property {
+			return true
+		}
+	}
+	return false
+}
+
+// logReplacedID is just a logging shortcut for replaceIDsInternal so that we
+// can keep its function body more succinct.
+func logReplacedID(prevID, newID string) {
+	logging.Debug("Found ID to replace", "previous", prevID, "new", newID)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// mergeFreeformMaps merges maps included with a request into the free-form
+// maps of an object, like its `metadata`. Like in the Stripe API, a key set
+// to an empty string is removed.
+//
+// A free-form map is a property that the object's schema describes as an
+// object with no particular properties. Other properties are left alone.
+func mergeFreeformMaps(schema *spec.Schema, requestData map[string]interface{},
+	object map[string]interface{}) {
+
+	for name, requestValue := range requestData {
+		requestMap, ok := requestValue.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		propertySchema, ok := schema.Properties[name]
+		if !ok || propertySchema.Type != spec.TypeObject ||
+			propertySchema.Properties != nil {
+			continue
+		}
+
+		// Copy the existing map rather than modifying it because it may be
+		// shared with a fixture.
+		objectMap := make(map[string]interface{})
+		if existing, ok := object[name].(map[string]interface{}); ok {
+			for key, value := range existing {
+				objectMap[key] = value
+			}
+		}
+
+		for key, value := range requestMap {
+			if value == "" {
+				delete(objectMap, key)
+			} else {
+				objectMap[key] = value
+			}
+		}
+		object[name] = objectMap
+	}
+}
+
+// mergeSchema merges one of the schemas of an `allOf` into the schema that's
+// composed of them. Properties and required properties are combined, and
+// other fields are taken from the subschema when the schema doesn't already
+// have them.
+//
+// The schema's maps and slices are copied rather than modified because they
+// may be shared with the definition that it was copied from.
+func mergeSchema(schema *spec.Schema, subSchema *spec.Schema) {
+	if len(subSchema.Properties) > 0 {
+		properties := make(map[string]*spec.Schema,
+			len(schema.Properties)+len(subSchema.Properties))
+		for name, propertySchema := range subSchema.Properties {
+			properties[name] = propertySchema
+		}
+		for name, propertySchema := range schema.Properties {
+			properties[name] = propertySchema
+		}
+		schema.Properties = properties
+	}
+
+	if len(subSchema.Required) > 0 {
+		required := append([]string(nil), schema.Required...)
+		for _, name := range subSchema.Required {
+			if indexOfString(required, name) == -1 {
+				required = append(required, name)
+			}
+		}
+		schema.Required = required
+	}
+
+	if subSchema.XExpandableFields != nil {
+		var expandableFields []string
+		if schema.XExpandableFields != nil {
+			expandableFields = append(expandableFields, *schema.XExpandableFields...)
+		}
+		for _, name := range *subSchema.XExpandableFields {
+			if indexOfString(expandableFields, name) == -1 {
+				expandableFields = append(expandableFields, name)
+			}
+		}
+		schema.XExpandableFields = &expandableFields
+	}
+
+	if schema.AdditionalProperties == nil {
+		schema.AdditionalProperties = subSchema.AdditionalProperties
+	}
+	if len(schema.AnyOf) == 0 {
+		schema.AnyOf = subSchema.AnyOf
+	}
+	if schema.Discriminator == nil {
+		schema.Discriminator = subSchema.Discriminator
+	}
+	if len(schema.Enum) == 0 {
+		schema.Enum = subSchema.Enum
+	}
+	if schema.Format == "" {
+		schema.Format = subSchema.Format
+	}
+	if schema.Items == nil {
+		schema.Items = subSchema.Items
+	}
+	if schema.MaxLength == 0 {
+		schema.MaxLength = subSchema.MaxLength
+	}
+	if schema.Maximum == nil {
+		schema.Maximum = subSchema.Maximum
+	}
+	if schema.MinLength == 0 {
+		schema.MinLength = subSchema.MinLength
+	}
+	if schema.Minimum == nil {
+		schema.Minimum = subSchema.Minimum
+	}
+	if schema.Pattern == "" {
+		schema.Pattern = subSchema.Pattern
+	}
+	if schema.Type == "" {
+		schema.Type = subSchema.Type
+	}
+	if schema.XExpansionResources == nil {
+		schema.XExpansionResources = subSchema.XExpansionResources
+	}
+	if schema.XResourceID == "" {
+		schema.XResourceID = subSchema.XResourceID
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// nestedListURL gets the URL of a list of sub-resources nested in the object
+// with the given ID from the path that lists them, like
+// `/v1/charges/{charge}/refunds`.
+func nestedListURL(path string, id string) string {
+	return pathParameterPattern.ReplaceAllLiteralString(path, id)
+}
+
+// objectIDPrefix gets the prefix used for the IDs of objects of the given
+// resource, including its trailing underscore (e.g. `cus_` for `customer`).
+// Resources that aren't in objectIDPrefixes get a prefix derived from their
+// name.
+func objectIDPrefix(resourceID string) string {
+	prefix, ok := objectIDPrefixes[resourceID]
+	if !ok {
+		prefix = strings.Replace(resourceID, ".", "_", -1)
+	}
+	return prefix + "_"
+}
+
+// objectIDPrefixOf gets the prefix of an object ID, including its trailing
+// underscore (e.g. `cus_` for `cus_123`). None of the prefixes contain an
+// underscore themselves. Returns an empty string if the ID doesn't have one.
+func objectIDPrefixOf(id string) string {
+	i := strings.Index(id, "_")
+	if i == -1 {
+		return ""
+	}
+	return id[:i+1]
+}
+
+// paginateSyntheticList replaces the single object in a generated list with a
+// page of a larger synthetic list as requested by the pagination parameters
+// in the request. The list is modified in place.
+//
+// Objects in the synthetic list are copies of the generated object, each
+// with a stable ID derived from its position in the list (see
+// syntheticListID). That allows a client to page through the list
+// consistently.
+func paginateSyntheticList(params *GenerateParams, data interface{}, length int) error {
+	pagination, err := parseListPagination(params.RequestData)
+	if err != nil {
+		return err
+	}
+
+	// Synthetic objects are all created at the time of the request, so a
+	// filter on `created` is only checked
+	_, err = parseCreatedFilter(params.RequestData)
+	if err != nil {
+		return err
+	}
+
+	listData, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	itemData, ok := listData["data"].([]interface{})
+	if !ok || len(itemData) < 1 {
+		return nil
+	}
+
+	template, ok := itemData[0].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	templateID, ok := template["id"].(string)
+	if !ok {
+		return nil
+	}
+
+	ids := make([]string, length)
+	for i := range ids {
+		ids[i] = syntheticListID(templateID, i)
+	}
+
+	start, end, hasMore, cursor := pagination.page(ids)
+
+	// Clients may have gotten their cursor from anywhere, so be lenient and
+	// return the beginning of the list if it's not one of ours.
+	if cursor != "" {
+		start, end, hasMore, _ = (&listPagination{limit: pagination.limit}).page(ids)
+	}
+
+	page := make([]interface{}, 0, end-start)
+	for i := start; i < end; i++ {
+		item := store.CopyValue(template)
+		distributeReplacedIDs(&PathParamsMap{
+			PrimaryID:         &ids[i],
+			replacedPrimaryID: &templateID,
+		}, item)
+		page = append(page, item)
+	}
+
+	listData["data"] = page
+	setListPageInfo(listData, hasMore, len(ids))
+	return nil
+}
+
+// parentFieldNames returns the names of the fields that an object nested under
+// a parent resource might use to refer to it, given one of the IDs extracted
+// from the request path.
+//
+// That's the name of the path parameter, and the singular form of the path
+// segment that comes before the ID. Parameters named `id` aren't useful, so
+// the last word of the segment is used too. For example, in
+// `/v1/application_fees/fee_123/refunds` a refund refers to its parent with
+// `fee`.
+func parentFieldNames(requestPath string, secondaryID *PathParamsSecondaryID) []string {
+	var names []string
+	if secondaryID.Name != "id" {
+		names = append(names, secondaryID.Name)
+	}
+
+	segments := strings.Split(requestPath, "/")
+	index := indexOfString(segments, secondaryID.ID)
+	if index < 1 {
+		return names
+	}
+
+	// Resource names in paths are plural with a trailing "s" (like
+	// `customers` or `application_fees`)
+	segment := strings.TrimSuffix(segments[index-1], "s")
+	words := strings.Split(segment, "_")
+	for _, name := range []string{segment, words[len(words)-1]} {
+		if indexOfString(names, name) == -1 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseCreatedFilter extracts the range of times that a list is restricted to
+// from a `created` parameter in a request's data. That's either a timestamp,
+// which objects must've been created at exactly, or bounds of a range like
+// `created[gte]=1500000000&created[lt]=1600000000`.
+//
+// Returns nil if there's no `created` parameter, or an invalidRequestError if
+// it isn't well-formed.
+func parseCreatedFilter(requestData map[string]interface{}) (*createdFilter, error) {
+	value, ok := requestData["created"]
+	if !ok {
+		return nil, nil
+	}
+
+	bounds, ok := value.(map[string]interface{})
+	if !ok {
+		created, err := parseTimestampParam("created", value)
+		if err != nil {
+			return nil, err
+		}
+		return &createdFilter{gte: &created, lte: &created}, nil
+	}
+
+	// Operators are checked in a stable order so that the same problem is
+	// reported for the same request every time
+	operators := make([]string, 0, len(bounds))
+	for operator := range bounds {
+		operators = append(operators, operator)
+	}
+	sort.Strings(operators)
+
+	filter := &createdFilter{}
+	for _, operator := range operators {
+		param := fmt.Sprintf("created[%s]", operator)
+		bound, err := parseTimestampParam(param, bounds[operator])
+		if err != nil {
+			return nil, err
+		}
+
+		switch operator {
+		case "gt":
+			filter.gt = &bound
+		case "gte":
+			filter.gte = &bound
+		case "lt":
+			filter.lt = &bound
+		case "lte":
+			filter.lte = &bound
+		default:
+			return nil, &invalidRequestError{
+				message: fmt.Sprintf(invalidRangeOperator, param),
+				param:   param,
+			}
+		}
+	}
+	return filter, nil
+}
+
+// parseListPagination extracts pagination parameters for a list from a
+// request's data. `limit` defaults to listLimitDefault and is clamped between
+// listLimitMin and listLimitMax.
+func parseListPagination(requestData map[string]interface{}) (*listPagination, error) {
+	pagination := &listPagination{limit: listLimitDefault}
+
+	switch limit := requestData["limit"].(type) {
+	case int:
+		pagination.limit = limit
+	case float64:
+		pagination.limit = int(limit)
+	case string:
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, &invalidRequestError{
+				message: fmt.Sprintf("Invalid integer: %s", limit),
+			}
+		}
+		pagination.limit = limitInt
+	}
+	pagination.limit = maxInt(minInt(pagination.limit, listLimitMax), listLimitMin)
+
+	pagination.endingBefore, _ = requestData["ending_before"].(string)
+	pagination.startingAfter, _ = requestData["starting_after"].(string)
+
+	if pagination.endingBefore != "" && pagination.startingAfter != "" {
+		return nil, &invalidRequestError{
+			message: "You may only specify one of these parameters: " +
+				"ending_before, starting_after.",
+		}
+	}
+
+	return pagination, nil
+}
+
+// parseTimestampParam parses the value of a parameter that's a Unix timestamp,
+// which may have been given as a string or a number.
+//
+// Returns an invalidRequestError naming the parameter if it isn't an integer.
+func parseTimestampParam(param string, value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v), nil
+		}
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case string:
+		timestamp, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			return timestamp, nil
+		}
+	}
+
+	return 0, &invalidRequestError{
+		message: fmt.Sprintf("Invalid integer: %v", value),
+		param:   param,
+	}
+}
+
+// propertyNames returns the names of all properties of a schema joined
+// together and comma-separated.
+//
+// This is useful for printing debugging information.
+func propertyNames(schema *spec.Schema) string {
+	var names []string
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+
+	// Sort just so we can have stable output to test against (the order at
+	// which keys will be iterated in the map is undefined).
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// randIntn returns a random number in [0,n) from r, or from the global source
+// if r is nil.
+func randIntn(r *rand.Rand, n int) int {
+	if r != nil {
+		return r.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// recordAndReplaceIDs descends through a generated data structure recursively
+// looking for object IDs and replaces them with values from the request's URL
+// (i.e., what's in pathParams) where appropriate.
+//
+// Returns the same PathParamsMap given to it as a parameter, after some
+// mutation. It's returned to add clarity as to what's happening to its
+// invocation sites.
+func recordAndReplaceIDs(pathParams *PathParamsMap, data interface{}) *PathParamsMap {
+	recordAndReplaceIDsInternal(pathParams, data, nil, 0)
+	return pathParams
+}
+
+// recordAndReplaceIDsInternal is identical to recordAndReplaceIDs, but is an
+// internal interface that tracks a parent key and recursion level. Use
+// recordAndReplaceIDs instead.
+func recordAndReplaceIDsInternal(pathParams *PathParamsMap, data interface{},
+	parentKey *string, recurseLevel int) {
+
+	dataSlice, ok := data.([]interface{})
+	if ok {
+		for _, val := range dataSlice {
+			recordAndReplaceIDsInternal(pathParams, val, nil, recurseLevel+1)
+		}
+		return
+	}
+
+	dataMap, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for key, val := range dataMap {
+		strVal, ok := val.(string)
+		if key == "id" && ok {
+			if recurseLevel == 0 {
+				// We'll only use a primary ID at the top level of the object
+				// (which is why we track recursion level).
+				if pathParams.PrimaryID != nil {
+					pathParams.replacedPrimaryID = &strVal
+					dataMap["id"] = *pathParams.PrimaryID
+					logReplacedID(strVal, *pathParams.PrimaryID)
+				}
+			} else {
+				// After the object's top level, we'll replace an object's ID
+				// if either of these two values are the same s the secondary
+				// ID's name (i.e., the "name" for the parameter that was
+				// extracted from the path in OpenAPI):
+				//
+				// (1) The value in the object's `object` field.
+				// (2) The value of the object's parent key (e.g., say it's a
+				//     "charge" object that was nested under a refund's
+				//     `charge` key).
+				objectVal, ok := dataMap["object"].(string)
+				if ok {
+					for _, secondaryID := range pathParams.SecondaryIDs {
+						if objectVal == secondaryID.Name {
+							secondaryID.appendReplacedID(strVal)
+							dataMap["id"] = secondaryID.ID
+							logReplacedID(strVal, secondaryID.ID)
+							break
+						}
+					}
+				}
+
+				for _, secondaryID := range pathParams.SecondaryIDs {
+					if parentKey != nil && *parentKey == secondaryID.Name {
+						secondaryID.appendReplacedID(strVal)
+						dataMap["id"] = secondaryID.ID
+						logReplacedID(strVal, secondaryID.ID)
+						break
+					}
+				}
+			}
+		} else {
+			if ok {
+				// This path replaces a string value with a secondary ID if the
+				// name of the field matches the secondary ID's target name.
+				//
+				// For example, an application fee refund might have an
+				// embedded `fee` field which is the ID of its parent
+				// application fee (unless it's expanded, at which point it
+				// will be handled by the case above).
+				for _, secondaryID := range pathParams.SecondaryIDs {
+					if key == secondaryID.Name {
+						secondaryID.appendReplacedID(strVal)
+						dataMap[key] = secondaryID.ID
+						logReplacedID(strVal, secondaryID.ID)
+						break
+					}
+				}
+			} else {
+				recordAndReplaceIDsInternal(pathParams, val, &key, recurseLevel+1)
+			}
+		}
+	}
+}
+
+// referencedObjectIDs collects the IDs of the objects that an object refers
+// to from its top level, either as IDs or as expanded objects, keyed by the
+// properties that refer to them (like `customer`).
+func referencedObjectIDs(object map[string]interface{}) map[string]string {
+	ids := make(map[string]string)
+	for key, value := range object {
+		if expanded, ok := value.(map[string]interface{}); ok {
+			value = expanded["id"]
+		}
+		if id, ok := value.(string); ok && isObjectID(id) {
+			ids[key] = id
+		}
+	}
+	return ids
+}
+
+// refersToParents checks whether objects described by schema have a field
+// that could refer to one of the parent resources whose IDs were extracted
+// from the request path (see parentFieldNames).
+func refersToParents(pathParams *PathParamsMap, requestPath string,
+	schema *spec.Schema) bool {
+
+	for _, secondaryID := range pathParams.SecondaryIDs {
+		for _, name := range parentFieldNames(requestPath, secondaryID) {
+			if _, ok := schema.Properties[name]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replaceReferencedObjectIDs keeps the object graph of a response consistent
+// after the IDs of objects that it refers to were reflected from the request,
+// like the `customer` of a charge created with `customer=cus_123`. Every
+// other reference in the response to the object that the fixture referred to,
+// like the `customer` of the charge's card or the `id` of an expanded
+// customer, is replaced with the request's ID too. referencedIDs are the IDs
+// that the object referred to before the request was reflected into it (see
+// referencedObjectIDs).
+//
+// Only IDs of the same resource are replaced, so that a token like `tok_visa`
+// given as a `source` doesn't replace the card that it stands in for.
+func replaceReferencedObjectIDs(requestData map[string]interface{},
+	referencedIDs map[string]string, object map[string]interface{}) {
+
+	// Replacements are distributed the same way as IDs extracted from a
+	// request path
+	replaced := &PathParamsMap{}
+	for key, oldID := range referencedIDs {
+		newID, ok := requestData[key].(string)
+		if !ok || newID == oldID || !isObjectID(newID) ||
+			objectIDPrefixOf(newID) != objectIDPrefixOf(oldID) {
+			continue
+		}
+
+		replaced.SecondaryIDs = append(replaced.SecondaryIDs, &PathParamsSecondaryID{
+			ID:          newID,
+			Name:        key,
+			replacedIDs: []string{oldID},
+		})
+	}
+
+	if len(replaced.SecondaryIDs) > 0 {
+		distributeReplacedIDs(replaced, object)
+	}
+}
+
+// resourceObjectName returns the name of a resource's type as it'll appear in
+// the `object` field of its objects (e.g. "charge"), or an empty string if
+// the schema doesn't describe a resource with a fixed `object` value.
+func resourceObjectName(schema *spec.Schema) string {
+	object, ok := schema.Properties["object"]
+	if !ok || object == nil || len(object.Enum) != 1 {
+		return ""
+	}
+
+	name, _ := object.Enum[0].(string)
+	return name
+}
+
+// resourceObjectValue returns the value of the `object` field of a resource's
+// objects. That's the one fixed by its schema if there is one (which may not
+// be its resource ID, like `customer` for a `deleted_customer`), or otherwise
+// its resource ID.
+//
+// Returns an empty string if the schema isn't a resource's or doesn't have an
+// `object` property.
+func resourceObjectValue(schema *spec.Schema) string {
+	object, ok := schema.Properties["object"]
+	if schema.XResourceID == "" || !ok || object == nil {
+		return ""
+	}
+
+	if name := resourceObjectName(schema); name != "" {
+		return name
+	}
+	if len(object.Enum) > 0 {
+		for _, value := range object.Enum {
+			if value == schema.XResourceID {
+				return schema.XResourceID
+			}
+		}
+		return ""
+	}
+	return schema.XResourceID
+}
+
+// setAccountContext reflects the connected account that a request was made on
+// behalf of into a generated object, as the Stripe API would.
+//
+// An `account` property that isn't expanded is set to the account's ID, and
+// so is the ID of the account retrieved from `/v1/account`.
+func setAccountContext(schema *spec.Schema, params *GenerateParams,
+	data map[string]interface{}) {
+
+	if _, ok := schema.Properties["account"]; ok {
+		if _, expanded := data["account"].(map[string]interface{}); !expanded {
+			data["account"] = params.Account
+		}
+	}
+
+	if schema.XResourceID == "account" && params.RequestPath == "/v1/account" {
+		data["id"] = params.Account
+	}
+}
+
+// setParentIDs sets the fields of the objects in a list that refer to the
+// parent resources whose IDs were extracted from the request path (see
+// parentFieldNames). Only fields that the objects already have are set.
+func setParentIDs(pathParams *PathParamsMap, requestPath string, data interface{}) {
+	listData, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	itemData, ok := listData["data"].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, item := range itemData {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			setObjectParentIDs(pathParams, requestPath, itemMap)
+		}
+	}
+}
+
+// setObjectParentIDs is like setParentIDs, but sets the fields of a single
+// object.
+func setObjectParentIDs(pathParams *PathParamsMap, requestPath string,
+	object map[string]interface{}) {
+
+	for _, secondaryID := range pathParams.SecondaryIDs {
+		for _, name := range parentFieldNames(requestPath, secondaryID) {
+			value, ok := object[name]
+			if !ok {
+				continue
+			}
+
+			if expanded, ok := value.(map[string]interface{}); ok {
+				expanded["id"] = secondaryID.ID
+			} else {
+				object[name] = secondaryID.ID
+			}
+		}
+	}
+}
+
+// setListPageInfo sets the fields of a list resource that describe the page
+// that it contains, where those fields are present in the list.
+func setListPageInfo(listData map[string]interface{}, hasMore bool, totalCount int) {
+	if _, ok := listData["has_more"]; ok {
+		listData["has_more"] = hasMore
+	}
+	if _, ok := listData["total_count"]; ok {
+		listData["total_count"] = totalCount
+	}
+}
+
+// setListURL sets the url of a list resource at the top level of a response to
+// the path that was requested, which includes the IDs of any parent resource
+// (like `/v1/customers/cus_123/sources`). The spec and fixtures only describe
+// it generically.
+//
+// Like in the Stripe API, the query string isn't included. Clients fetching
+// further pages send their filters along with a cursor themselves.
+func setListURL(data interface{}, requestPath string) {
+	listData, ok := data.(map[string]interface{})
+	if !ok || requestPath == "" {
+		return
+	}
+	if _, ok := listData["url"]; ok {
+		listData["url"] = requestPath
+	}
+}
+
+// setNestedListURLs points the url of each list of sub-resources nested in a
+// generated object at the path that lists them for the object. paths maps the
+// lists' properties to those paths (see DataGenerator.nestedLists).
+//
+// The object's ID may still be one from its fixture, which is replaced in the
+// URLs along with the ID itself (see distributeReplacedIDsInURL).
+func setNestedListURLs(paths map[string]string, object map[string]interface{}) {
+	id, ok := object["id"].(string)
+	if !ok {
+		return
+	}
+	for key, path := range paths {
+		setListURL(object[key], nestedListURL(path, id))
+	}
+}
+
+// stringOrEmpty returns the string given as parameter, or the string "(empty)"
+// if the string was empty.
+//
+// This is useful in cases like logging to make sure that something is always
+// printed on screen (instead of a strangely truncated sentence for an empty
+// value).
+func stringOrEmpty(s string) string {
+	if s == "" {
+		return "(empty)"
+	}
+	return s
+}
+
+// syntheticListID produces the ID of the object at the given index of a
+// synthetic list. The first object keeps the ID of the generated object that
+// the list's objects are copied from.
+func syntheticListID(templateID string, index int) string {
+	if index == 0 {
+		return templateID
+	}
+	return fmt.Sprintf("%s_%d", templateID, index)
+}
+
+// syntheticText produces free text for a synthetic object, like its
+// `description`, that fits within its schema's `minLength` and `maxLength`.
+func syntheticText(schema *spec.Schema) string {
+	text := syntheticLoremIpsum
+	for len(text) < schema.MinLength {
+		text += " " + syntheticLoremIpsum
+	}
+	return truncateString(text, schema.MaxLength)
+}
+
+// truncateString cuts a string down to at most maxLength characters. It's
+// returned as it is if maxLength is 0.
+func truncateString(s string, maxLength int) string {
+	if maxLength == 0 || utf8.RuneCountInString(s) <= maxLength {
+		return s
+	}
+	return string([]rune(s)[:maxLength])
+}
@@ -0,0 +1,2375 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+var listSchema *spec.Schema
+
+func init() {
+	listSchema = &spec.Schema{
+		Type: "object",
+		Properties: map[string]*spec.Schema{
+			"data": {
+				Items: &spec.Schema{
+					Ref: "#/components/schemas/charge",
+				},
+			},
+			"has_more": {
+				Type: "boolean",
+			},
+			"object": {
+				Enum: []interface{}{"list"},
+			},
+			"total_count": {
+				Type: "integer",
+			},
+			"url": {
+				Type:    "string",
+				Pattern: "^/v1/charges",
+			},
+		},
+	}
+}
+
+//
+// Tests
+//
+
+func TestConcurrentAccess(t *testing.T) {
+	var generator DataGenerator
+
+	// We use the real spec here because when there was a concurrency problem,
+	// it wasn't revealed due to the test spec being oversimplistic.
+	generator = DataGenerator{definitions: realSpec.Components.Schemas, fixtures: &realFixtures}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := generator.Generate(&GenerateParams{
+				Schema: &spec.Schema{Ref: "#/components/schemas/subscription"},
+			})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestGenerateResponseData(t *testing.T) {
+	// basic reference
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t,
+			testFixtures.Resources["charge"].(map[string]interface{})["id"],
+			data.(map[string]interface{})["id"])
+
+		// Makes sure that customer is *not* expanded
+		assert.Equal(t,
+			testFixtures.Resources["customer"].(map[string]interface{})["id"],
+			data.(map[string]interface{})["customer"])
+	}
+
+	// polymorphic response picked by the request's `object`
+	{
+		generator := DataGenerator{definitions: realSpec.Components.Schemas, fixtures: &realFixtures}
+		schema := realSpec.Paths["/v1/customers/{customer}/sources/{id}"]["post"].
+			Responses["200"].Content["application/json"].Schema
+
+		data, err := generator.Generate(&GenerateParams{
+			RequestMethod: http.MethodPost,
+			Schema:        schema,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "card", data.(map[string]interface{})["object"])
+
+		data, err = generator.Generate(&GenerateParams{
+			RequestData:   map[string]interface{}{"object": "bank_account"},
+			RequestMethod: http.MethodPost,
+			Schema:        schema,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "bank_account", data.(map[string]interface{})["object"])
+		assert.Equal(t,
+			realFixtures.Resources["bank_account"].(map[string]interface{})["id"],
+			data.(map[string]interface{})["id"])
+	}
+
+	// expansion
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			Expansions: &ExpansionLevel{
+				expansions: map[string]*ExpansionLevel{"customer": {
+					expansions: map[string]*ExpansionLevel{}},
+				},
+			},
+			Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t,
+			testFixtures.Resources["customer"].(map[string]interface{})["id"],
+			data.(map[string]interface{})["customer"].(map[string]interface{})["id"])
+	}
+
+	// expansion keeping the ID of the unexpanded form
+	{
+		generator := DataGenerator{
+			definitions: testSpec.Components.Schemas,
+			fixtures: &spec.Fixtures{
+				Resources: map[spec.ResourceID]interface{}{
+					spec.ResourceID("charge"): map[string]interface{}{
+						"customer": "cus_456",
+						"id":       "ch_123",
+						"object":   "charge",
+					},
+					spec.ResourceID("customer"): testFixtures.Resources["customer"],
+				},
+			},
+		}
+		data, err := generator.Generate(&GenerateParams{
+			Expansions: parseExpansionLevel([]string{"customer"}),
+			Schema:     &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "cus_456",
+			data.(map[string]interface{})["customer"].(map[string]interface{})["id"])
+
+		// The fixture that the expanded object was generated from is unchanged
+		assert.Equal(t, "cus_123",
+			testFixtures.Resources["customer"].(map[string]interface{})["id"])
+	}
+
+	// bad expansion
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		_, err := generator.Generate(&GenerateParams{
+			Expansions: &ExpansionLevel{
+				expansions: map[string]*ExpansionLevel{"id": {
+					expansions: map[string]*ExpansionLevel{}},
+				},
+			},
+			Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+
+		assert.Equal(t, &invalidRequestError{
+			message: "This property cannot be expanded (id).",
+		}, err)
+	}
+
+	// bad nested expansion
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		_, err := generator.Generate(&GenerateParams{
+			Expansions: parseExpansionLevel([]string{"customer.id"}),
+			Schema:     &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.Equal(t, &invalidRequestError{
+			message: "This property cannot be expanded (customer.id).",
+		}, err)
+	}
+
+	// expansion in a list
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			Expansions:  parseExpansionLevel([]string{"data.customer"}),
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t,
+			testFixtures.Resources["customer"].(map[string]interface{})["id"],
+			data.(map[string]interface{})["data"].([]interface{})[0].(map[string]interface{})["customer"].(map[string]interface{})["id"])
+
+		_, err = generator.Generate(&GenerateParams{
+			Expansions:  parseExpansionLevel([]string{"data.customer.id"}),
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Equal(t, &invalidRequestError{
+			message: "This property cannot be expanded (data.customer.id).",
+		}, err)
+
+		_, err = generator.Generate(&GenerateParams{
+			Expansions:  parseExpansionLevel([]string{"customer"}),
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Equal(t, &invalidRequestError{
+			message: "This property cannot be expanded (customer).",
+		}, err)
+	}
+
+	// wildcard expansion
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			Expansions: &ExpansionLevel{wildcard: true},
+			Schema:     &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t,
+			testFixtures.Resources["customer"].(map[string]interface{})["id"],
+			data.(map[string]interface{})["customer"].(map[string]interface{})["id"])
+	}
+
+	// resource without a fixture
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			Schema: &spec.Schema{
+				Properties: map[string]*spec.Schema{
+					"id": {Type: spec.TypeString},
+				},
+				Required:    []string{"id"},
+				Type:        spec.TypeObject,
+				XResourceID: "payment_intent",
+			},
+		})
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(data.(map[string]interface{})["id"].(string), "pi_"))
+	}
+
+	// list
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "list", data.(map[string]interface{})["object"])
+		assert.Equal(t, "/v1/charges", data.(map[string]interface{})["url"])
+		assert.Equal(t,
+			testFixtures.Resources["charge"].(map[string]interface{})["id"],
+			data.(map[string]interface{})["data"].([]interface{})[0].(map[string]interface{})["id"])
+	}
+
+	// list url reflecting the requested path rather than the spec's pattern
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			RequestData: map[string]interface{}{"limit": "3"},
+			RequestPath: "/v1/customers/cus_123/charges",
+			Schema:      listSchema,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "/v1/customers/cus_123/charges", data.(map[string]interface{})["url"])
+	}
+
+	// list pagination
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		chargeID := testFixtures.Resources["charge"].(map[string]interface{})["id"].(string)
+
+		// The default limit
+		data, err := generator.Generate(&GenerateParams{
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Nil(t, err)
+		listData := data.(map[string]interface{})
+		assert.Equal(t, listLimitDefault, len(listData["data"].([]interface{})))
+		assert.Equal(t, true, listData["has_more"])
+		assert.Equal(t, syntheticListSize, listData["total_count"])
+
+		// Paging forward
+		data, err = generator.Generate(&GenerateParams{
+			RequestData: map[string]interface{}{
+				"limit":          "3",
+				"starting_after": syntheticListID(chargeID, 4),
+			},
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Nil(t, err)
+		listData = data.(map[string]interface{})
+		assert.Equal(t, 3, len(listData["data"].([]interface{})))
+		assert.Equal(t, syntheticListID(chargeID, 5),
+			listData["data"].([]interface{})[0].(map[string]interface{})["id"])
+		assert.Equal(t, syntheticListID(chargeID, 7),
+			listData["data"].([]interface{})[2].(map[string]interface{})["id"])
+		assert.Equal(t, true, listData["has_more"])
+
+		// Paging backward
+		data, err = generator.Generate(&GenerateParams{
+			RequestData: map[string]interface{}{
+				"ending_before": syntheticListID(chargeID, 2),
+			},
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Nil(t, err)
+		listData = data.(map[string]interface{})
+		assert.Equal(t, 2, len(listData["data"].([]interface{})))
+		assert.Equal(t, chargeID,
+			listData["data"].([]interface{})[0].(map[string]interface{})["id"])
+		assert.Equal(t, false, listData["has_more"])
+
+		// Reaching the end of the list
+		data, err = generator.Generate(&GenerateParams{
+			RequestData: map[string]interface{}{
+				"limit":          "1000",
+				"starting_after": syntheticListID(chargeID, 49),
+			},
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Nil(t, err)
+		listData = data.(map[string]interface{})
+		assert.Equal(t, syntheticListSize-50, len(listData["data"].([]interface{})))
+		assert.Equal(t, false, listData["has_more"])
+
+		// Both cursors at once
+		_, err = generator.Generate(&GenerateParams{
+			RequestData: map[string]interface{}{
+				"ending_before":  chargeID,
+				"starting_after": chargeID,
+			},
+			RequestPath: "/v1/charges",
+			Schema:      listSchema,
+		})
+		assert.Equal(t, &invalidRequestError{
+			message: "You may only specify one of these parameters: ending_before, starting_after.",
+		}, err)
+	}
+
+	// nested list
+	{
+		generator := DataGenerator{
+			definitions: testSpec.Components.Schemas,
+			fixtures: &spec.Fixtures{
+				Resources: map[spec.ResourceID]interface{}{
+					spec.ResourceID("charge"): map[string]interface{}{"id": "ch_123"},
+					spec.ResourceID("with_charges_list"): map[string]interface{}{
+						"charges_list": map[string]interface{}{
+							"url": "/v1/charges",
+						},
+					},
+				},
+			},
+		}
+		data, err := generator.Generate(&GenerateParams{
+			Schema: &spec.Schema{
+				Type: "object",
+				Properties: map[string]*spec.Schema{
+					"charges_list": listSchema,
+				},
+				XResourceID: "with_charges_list",
+			},
+		})
+		assert.Nil(t, err)
+		chargesList := data.(map[string]interface{})["charges_list"]
+		assert.Equal(t, "list", chargesList.(map[string]interface{})["object"])
+		assert.Equal(t, "/v1/charges", chargesList.(map[string]interface{})["url"])
+		assert.Equal(t,
+			testFixtures.Resources["charge"].(map[string]interface{})["id"],
+			chargesList.(map[string]interface{})["data"].([]interface{})[0].(map[string]interface{})["id"])
+	}
+
+	// injected ID
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &spec.Fixtures{
+			Resources: map[spec.ResourceID]interface{}{
+				spec.ResourceID("charge"): map[string]interface{}{
+					// This is contrived, but we inject the value we expect to be
+					// replaced into `customer` as well so that we can verify the
+					// secondary behavior that replaces all values that look like a
+					// replaced ID (as well as the ID).
+					"customer": "ch_123",
+
+					"id": "ch_123",
+				},
+			},
+		}}
+		newID := "ch_123_InjectedFromURL"
+		data, err := generator.Generate(&GenerateParams{
+			PathParams: &PathParamsMap{PrimaryID: &newID},
+			Schema:     &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t,
+			newID,
+			data.(map[string]interface{})["id"])
+		assert.Equal(t,
+			newID,
+			data.(map[string]interface{})["customer"])
+	}
+
+	// injected secondary ID
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &spec.Fixtures{
+			Resources: map[spec.ResourceID]interface{}{
+				spec.ResourceID("charge"): map[string]interface{}{
+					"id": "ch_123",
+				},
+				spec.ResourceID("customer"): map[string]interface{}{
+					"id":     "cus_123",
+					"object": "customer",
+				},
+			},
+		}}
+		newCustomerID := "cus_123_InjectedFromURL"
+		data, err := generator.Generate(&GenerateParams{
+			Expansions: &ExpansionLevel{
+				expansions: map[string]*ExpansionLevel{"customer": {
+					expansions: map[string]*ExpansionLevel{}},
+				},
+			},
+			PathParams: &PathParamsMap{
+				SecondaryIDs: []*PathParamsSecondaryID{
+					{ID: newCustomerID, Name: "customer"},
+				},
+			},
+			Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.Nil(t, err)
+
+		// The top level ID. This should have stayed the same.
+		assert.Equal(t,
+			"ch_123",
+			data.(map[string]interface{})["id"])
+
+		// The nested customer ID. This should have changed.
+		assert.Equal(t,
+			map[string]interface{}{
+				"id":     newCustomerID,
+				"object": "customer",
+			},
+			data.(map[string]interface{})["customer"])
+	}
+
+	// data replacement on `POST`
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			RequestData: map[string]interface{}{
+				"customer": "cus_9999",
+			},
+			RequestMethod: http.MethodPost,
+			Schema:        &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t,
+			"cus_9999",
+			data.(map[string]interface{})["customer"])
+	}
+
+	// *no* data replacement on non-`POST`
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			RequestData: map[string]interface{}{
+				"customer": "cus_9999",
+			},
+			RequestMethod: http.MethodGet,
+			Schema:        &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.Nil(t, err)
+		assert.NotEqual(t,
+			"cus_9999",
+			data.(map[string]interface{})["customer"])
+	}
+
+	// synthetic schema
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			Schema: &spec.Schema{
+				Properties: map[string]*spec.Schema{
+					"string_property": {
+						Type: spec.TypeString,
+					},
+				},
+				Required:    []string{"string_property"},
+				Type:        spec.TypeObject,
+				XResourceID: "",
+			},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t,
+			map[string]interface{}{
+				"string_property": "",
+			},
+			data,
+		)
+	}
+
+	// pick non-deleted anyOf branch
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			// Just needs to be any HTTP method that's not DELETE
+			RequestMethod: http.MethodPost,
+			Schema: &spec.Schema{AnyOf: []*spec.Schema{
+				// put the deleted version first so we know it's not just
+				// returning the first result
+				{Ref: "#/components/schemas/deleted_customer"},
+				{Ref: "#/components/schemas/customer"},
+			}},
+		})
+		assert.Nil(t, err)
+
+		// There should be no deleted field
+		_, ok := data.(map[string]interface{})["deleted"]
+		assert.False(t, ok)
+	}
+
+	// pick deleted anyOf branch
+	{
+		generator := DataGenerator{definitions: testSpec.Components.Schemas, fixtures: &testFixtures}
+		data, err := generator.Generate(&GenerateParams{
+			RequestMethod: http.MethodDelete,
+			Schema: &spec.Schema{AnyOf: []*spec.Schema{
+				// put the non-deleted version first so we know it's not just
+				// returning the first result
+				{Ref: "#/components/schemas/customer"},
+				{Ref: "#/components/schemas/deleted_customer"},
+			}},
+		})
+		assert.Nil(t, err)
+
+		// There should be a deleted field
+		_, ok := data.(map[string]interface{})["deleted"]
+		assert.True(t, ok)
+	}
+
+	// substitute the deleted form of a resource on delete
+	{
+		generator := DataGenerator{definitions: realSpec.Components.Schemas, fixtures: &realFixtures}
+		id := "cus_123"
+		data, err := generator.Generate(&GenerateParams{
+			PathParams:    &PathParamsMap{PrimaryID: &id},
+			RequestMethod: http.MethodDelete,
+			Schema:        &spec.Schema{Ref: "#/components/schemas/customer"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"deleted": true,
+			"id":      "cus_123",
+			"object":  "customer",
+		}, data)
+
+		// But not for resources without a deleted form
+		data, err = generator.Generate(&GenerateParams{
+			RequestMethod: http.MethodDelete,
+			Schema:        &spec.Schema{Ref: "#/components/schemas/subscription"},
+		})
+		assert.Nil(t, err)
+		_, ok := data.(map[string]interface{})["deleted"]
+		assert.False(t, ok)
+	}
+}
+
+func TestGenerateResponseData_AllOf(t *testing.T) {
+	generator := DataGenerator{
+		definitions: map[string]*spec.Schema{
+			"base": {
+				Properties: map[string]*spec.Schema{
+					"id":     {Type: "string"},
+					"object": {Enum: []interface{}{"widget"}, Type: "string"},
+				},
+				Required: []string{"id", "object"},
+				Type:     "object",
+			},
+			// A reference to a reference, which is resolved all the way
+			"named": {Ref: "#/components/schemas/named_properties"},
+			"named_properties": {
+				Properties: map[string]*spec.Schema{
+					"name": {Type: "string"},
+				},
+				Required: []string{"name"},
+				Type:     "object",
+			},
+			"widget": {
+				AllOf: []*spec.Schema{
+					{Ref: "#/components/schemas/base"},
+					{Ref: "#/components/schemas/named"},
+					{
+						Properties: map[string]*spec.Schema{
+							"size": {Type: "integer"},
+						},
+						Required: []string{"size"},
+					},
+				},
+				XResourceID: "widget",
+			},
+		},
+		fixtures: &spec.Fixtures{},
+		rand:     rand.New(rand.NewSource(0)),
+	}
+
+	data, err := generator.Generate(&GenerateParams{
+		Schema: &spec.Schema{Ref: "#/components/schemas/widget"},
+	})
+	assert.NoError(t, err)
+
+	widget := data.(map[string]interface{})
+	assert.Equal(t, "widget", widget["object"])
+	assert.IsType(t, "", widget["id"])
+	assert.IsType(t, "", widget["name"])
+	assert.NotNil(t, widget["size"])
+
+	// The definition that was composed isn't modified
+	assert.Nil(t, generator.definitions["widget"].Properties)
+}
+
+func TestGenerateResponseData_WriteOnly(t *testing.T) {
+	generator := DataGenerator{
+		definitions: map[string]*spec.Schema{
+			"card": {
+				Properties: map[string]*spec.Schema{
+					"id":     {Type: "string"},
+					"last4":  {Type: "string"},
+					"number": {Type: "string", WriteOnly: true},
+					"object": {Enum: []interface{}{"card"}, Type: "string"},
+				},
+				Type:        "object",
+				XResourceID: "card",
+			},
+		},
+		fixtures: &spec.Fixtures{Resources: map[spec.ResourceID]interface{}{
+			"card": map[string]interface{}{
+				"id":     "card_123",
+				"last4":  "4242",
+				"number": "4242424242424242",
+				"object": "card",
+			},
+		}},
+	}
+
+	// Write-only properties are left out even though the fixture has one and
+	// the request sent one
+	data, err := generator.Generate(&GenerateParams{
+		RequestData: map[string]interface{}{"number": "4012888888881881"},
+		Schema:      &spec.Schema{Ref: "#/components/schemas/card"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id":     "card_123",
+		"last4":  "4242",
+		"object": "card",
+	}, data)
+}
+
+func TestGenerateResponseData_Context(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	generator := DataGenerator{
+		ctx:         ctx,
+		definitions: testSpec.Components.Schemas,
+		fixtures:    &testFixtures,
+	}
+
+	_, err := generator.Generate(&GenerateParams{
+		Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+	})
+	assert.NoError(t, err)
+
+	// Generation stops with the context's error once it's done
+	cancel()
+	_, err = generator.Generate(&GenerateParams{
+		Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestGenerateResponseData_ObjectField(t *testing.T) {
+	// Fixtures that leave out `object` or have the wrong one
+	fixtures := &spec.Fixtures{Resources: map[spec.ResourceID]interface{}{}}
+	for resource, fixture := range realFixtures.Resources {
+		fixtures.Resources[resource] = fixture
+	}
+	charge := store.CopyValue(realFixtures.Resources["charge"]).(map[string]interface{})
+	delete(charge, "object")
+	fixtures.Resources["charge"] = charge
+	customer := store.CopyValue(realFixtures.Resources["customer"]).(map[string]interface{})
+	customer["object"] = "account"
+	fixtures.Resources["customer"] = customer
+
+	generator := DataGenerator{
+		definitions: realSpec.Components.Schemas,
+		fixtures:    fixtures,
+	}
+
+	// The root resource and ones generated during expansion get the right one
+	data, err := generator.Generate(&GenerateParams{
+		Expansions: &ExpansionLevel{expansions: map[string]*ExpansionLevel{
+			"customer": {expansions: map[string]*ExpansionLevel{}},
+		}},
+		RequestMethod: http.MethodGet,
+		Schema:        &spec.Schema{Ref: "#/components/schemas/charge"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "charge", data.(map[string]interface{})["object"])
+	assert.Equal(t, "customer",
+		data.(map[string]interface{})["customer"].(map[string]interface{})["object"])
+
+	// The fixtures weren't modified
+	_, ok := charge["object"]
+	assert.False(t, ok)
+	assert.Equal(t, "account", customer["object"])
+}
+
+func TestGenerateResponseData_ArrayItems(t *testing.T) {
+	definitions := make(map[string]*spec.Schema)
+	for name, schema := range realSpec.Components.Schemas {
+		definitions[name] = schema
+	}
+	definitions["bundle"] = &spec.Schema{
+		Type:        spec.TypeObject,
+		XResourceID: "bundle",
+		Properties: map[string]*spec.Schema{
+			"charges": {
+				Type:     spec.TypeArray,
+				MinItems: 2,
+				MaxItems: 3,
+				Items:    &spec.Schema{Ref: "#/components/schemas/charge"},
+			},
+			"customers": {
+				Type: spec.TypeArray,
+				Items: &spec.Schema{
+					AnyOf: []*spec.Schema{
+						{Type: spec.TypeString},
+						{Ref: "#/components/schemas/customer"},
+					},
+					XExpansionResources: &spec.ExpansionResources{
+						OneOf: []*spec.Schema{{Ref: "#/components/schemas/customer"}},
+					},
+				},
+			},
+			"tags": {
+				Type:     spec.TypeArray,
+				MaxItems: 2,
+				Items:    &spec.Schema{Type: spec.TypeString},
+			},
+		},
+		Required:          []string{"charges", "customers", "tags"},
+		XExpandableFields: &[]string{"customers"},
+	}
+
+	generate := func(fixtures *spec.Fixtures, expansions *ExpansionLevel) map[string]interface{} {
+		generator := DataGenerator{definitions: definitions, fixtures: fixtures}
+		data, err := generator.Generate(&GenerateParams{
+			Expansions:    expansions,
+			RequestMethod: http.MethodGet,
+			Schema:        &spec.Schema{Ref: "#/components/schemas/bundle"},
+		})
+		assert.NoError(t, err)
+		return data.(map[string]interface{})
+	}
+
+	// Without a fixture, arrays get a single item unless they need more, and
+	// items that are references are generated from their own fixtures
+	data := generate(&realFixtures, &ExpansionLevel{
+		expansions: map[string]*ExpansionLevel{
+			"customers": {expansions: map[string]*ExpansionLevel{}},
+		},
+	})
+	charges := data["charges"].([]interface{})
+	assert.Equal(t, 2, len(charges))
+	for _, charge := range charges {
+		assert.Equal(t, "charge", charge.(map[string]interface{})["object"])
+	}
+	customers := data["customers"].([]interface{})
+	assert.Equal(t, 1, len(customers))
+	assert.Equal(t, "customer", customers[0].(map[string]interface{})["object"])
+	assert.Equal(t, 1, len(data["tags"].([]interface{})))
+
+	// With a fixture, arrays have as many items as it does, within bounds
+	fixtures := &spec.Fixtures{Resources: map[spec.ResourceID]interface{}{}}
+	for resource, fixture := range realFixtures.Resources {
+		fixtures.Resources[resource] = fixture
+	}
+	fixtures.Resources["bundle"] = map[string]interface{}{
+		"charges":   []interface{}{},
+		"customers": []interface{}{"cus_123", "cus_456"},
+		"tags":      []interface{}{"a", "b", "c"},
+	}
+	data = generate(fixtures, nil)
+	charges = data["charges"].([]interface{})
+	assert.Equal(t, 2, len(charges))
+	assert.Equal(t, "charge", charges[0].(map[string]interface{})["object"])
+	assert.Equal(t, []interface{}{"cus_123", "cus_456"}, data["customers"])
+	assert.Equal(t, []interface{}{"a", "b"}, data["tags"])
+
+	// Items are expanded like any other field
+	data = generate(fixtures, &ExpansionLevel{
+		expansions: map[string]*ExpansionLevel{
+			"customers": {expansions: map[string]*ExpansionLevel{}},
+		},
+	})
+	customers = data["customers"].([]interface{})
+	assert.Equal(t, 2, len(customers))
+	assert.Equal(t, "cus_123", customers[0].(map[string]interface{})["id"])
+	assert.Equal(t, "cus_456", customers[1].(map[string]interface{})["id"])
+}
+
+func TestGenerateResponseData_Created(t *testing.T) {
+	generator := DataGenerator{
+		definitions: realSpec.Components.Schemas,
+		fixtures:    &realFixtures,
+		now:         time.Unix(1600000000, 0),
+	}
+	data, err := generator.Generate(&GenerateParams{
+		Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+	})
+	assert.Nil(t, err)
+
+	// Objects are created at the time of the request rather than whenever
+	// their fixtures say, including those nested in the response
+	charge := data.(map[string]interface{})
+	assert.Equal(t, int64(1600000000), charge["created"])
+	refund := charge["refunds"].(map[string]interface{})["data"].([]interface{})[0]
+	assert.Equal(t, int64(1600000000), refund.(map[string]interface{})["created"])
+
+	// The fixture itself isn't changed
+	assert.NotEqual(t, float64(1600000000),
+		realFixtures.Resources["charge"].(map[string]interface{})["created"])
+}
+
+func TestGenerateResponseData_NullableMode(t *testing.T) {
+	generate := func(mode string, seed int64) map[string]interface{} {
+		generator := DataGenerator{
+			definitions:  realSpec.Components.Schemas,
+			fixtures:     &realFixtures,
+			now:          time.Unix(1500000000, 0),
+			nullableMode: mode,
+			rand:         rand.New(rand.NewSource(seed)),
+		}
+		data, err := generator.Generate(&GenerateParams{
+			Expansions: &ExpansionLevel{
+				expansions: map[string]*ExpansionLevel{
+					"customer": {expansions: map[string]*ExpansionLevel{}},
+				},
+			},
+			Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+		})
+		assert.NoError(t, err)
+		return data.(map[string]interface{})
+	}
+
+	// Fixture values are kept by default
+	data := generate(NullableModeFixture, 0)
+	assert.Equal(t, realFixtures.Resources["charge"].(map[string]interface{})["receipt_email"],
+		data["receipt_email"])
+	assert.NotNil(t, data["balance_transaction"])
+
+	// Every nullable field is null, but fields that aren't nullable and
+	// expanded fields keep their values
+	data = generate(NullableModeAlways, 0)
+	assert.Nil(t, data["balance_transaction"])
+	assert.Nil(t, data["description"])
+	assert.NotNil(t, data["amount"])
+	assert.NotNil(t, data["id"])
+	assert.NotNil(t, data["customer"])
+
+	// Some nullable fields are null, and the same seed picks the same ones
+	data = generate(NullableModeRandom, 1)
+	assert.Equal(t, data, generate(NullableModeRandom, 1))
+	var nulls int
+	for key, value := range data {
+		if value == nil && realSpec.Components.Schemas["charge"].Properties[key].Nullable {
+			nulls++
+		}
+	}
+	assert.True(t, nulls > 0)
+}
+
+func TestGenerateResponseData_NoFixtures(t *testing.T) {
+	generator := DataGenerator{
+		definitions: realSpec.Components.Schemas,
+		fixtures:    &realFixtures,
+		noFixtures:  true,
+		rand:        rand.New(rand.NewSource(0)),
+	}
+	data, err := generator.Generate(&GenerateParams{
+		Schema: &spec.Schema{Ref: "#/components/schemas/charge"},
+	})
+	assert.NoError(t, err)
+
+	// The object is synthetic rather than the fixture, but still has every
+	// required property
+	charge := data.(map[string]interface{})
+	schema := realSpec.Components.Schemas["charge"]
+	assert.NotEqual(t,
+		realFixtures.Resources["charge"].(map[string]interface{})["id"],
+		charge["id"])
+	assert.Equal(t, "charge", charge["object"])
+	for _, property := range schema.Required {
+		_, ok := charge[property]
+		assert.True(t, ok, property)
+	}
+}
+
+func TestGenerateResponseData_NestedListURL(t *testing.T) {
+	// A fixture without the list, so that it's generated when it's expanded
+	charge := store.CopyValue(realFixtures.Resources["charge"]).(map[string]interface{})
+	delete(charge, "refunds")
+	fixtures := &spec.Fixtures{Resources: map[spec.ResourceID]interface{}{}}
+	for id, fixture := range realFixtures.Resources {
+		fixtures.Resources[id] = fixture
+	}
+	fixtures.Resources["charge"] = charge
+
+	generator := DataGenerator{
+		definitions: realSpec.Components.Schemas,
+		fixtures:    fixtures,
+		nestedLists: map[string]map[string]string{
+			"charge": {"refunds": "/v1/charges/{charge}/refunds"},
+		},
+	}
+	id := "ch_123"
+	data, err := generator.Generate(&GenerateParams{
+		Expansions: &ExpansionLevel{
+			expansions: map[string]*ExpansionLevel{
+				"refunds": {expansions: map[string]*ExpansionLevel{}},
+			},
+		},
+		PathParams:    &PathParamsMap{PrimaryID: &id},
+		RequestMethod: http.MethodGet,
+		RequestPath:   "/v1/charges/ch_123",
+		Schema:        &spec.Schema{Ref: "#/components/schemas/charge"},
+	})
+	assert.NoError(t, err)
+
+	refunds := data.(map[string]interface{})["refunds"].(map[string]interface{})
+	assert.Equal(t, "list", refunds["object"])
+	assert.Equal(t, "/v1/charges/ch_123/refunds", refunds["url"])
+	assert.Equal(t, 1, len(refunds["data"].([]interface{})))
+}
+
+func TestGenerateResponseData_ReferencedObjectIDs(t *testing.T) {
+	generator := DataGenerator{definitions: realSpec.Components.Schemas, fixtures: &realFixtures}
+	data, err := generator.Generate(&GenerateParams{
+		Expansions: &ExpansionLevel{
+			expansions: map[string]*ExpansionLevel{"customer": {
+				expansions: map[string]*ExpansionLevel{}},
+			},
+		},
+		RequestData:   map[string]interface{}{"amount": 123, "customer": "cus_123"},
+		RequestMethod: http.MethodPost,
+		Schema:        &spec.Schema{Ref: "#/components/schemas/charge"},
+	})
+	assert.Nil(t, err)
+	charge := data.(map[string]interface{})
+
+	// The expanded customer is the one that the charge was created for, and
+	// so is the list of its sources
+	customer := charge["customer"].(map[string]interface{})
+	assert.Equal(t, "cus_123", customer["id"])
+	sources := customer["sources"].(map[string]interface{})
+	assert.Equal(t, "/v1/customers/cus_123/sources", sources["url"])
+}
+
+func TestGenerateResponseData_StoredListOrder(t *testing.T) {
+	resourceStore := store.NewResourceStore()
+	resourceStore.Put("charge", "ch_1", map[string]interface{}{
+		"created": float64(1500000000), "id": "ch_1", "object": "charge"})
+	resourceStore.Put("charge", "ch_2", map[string]interface{}{
+		"created": int64(1600000000), "id": "ch_2", "object": "charge"})
+	resourceStore.Put("charge", "ch_3", map[string]interface{}{
+		"created": int64(1550000000), "id": "ch_3", "object": "charge"})
+	resourceStore.Put("charge", "ch_4", map[string]interface{}{
+		"created": int64(1600000000), "id": "ch_4", "object": "charge"})
+
+	generator := DataGenerator{
+		definitions: realSpec.Components.Schemas,
+		fixtures:    &realFixtures,
+		store:       resourceStore,
+	}
+	data, err := generator.Generate(&GenerateParams{
+		RequestMethod: http.MethodGet,
+		RequestPath:   "/v1/charges",
+		Schema: realSpec.Paths["/v1/charges"]["get"].
+			Responses["200"].Content["application/json"].Schema,
+	})
+	assert.Nil(t, err)
+
+	// Most recently created first, and most recently stored first among
+	// those created at the same time
+	var ids []string
+	for _, item := range data.(map[string]interface{})["data"].([]interface{}) {
+		ids = append(ids, item.(map[string]interface{})["id"].(string))
+	}
+	assert.Equal(t, []string{"ch_4", "ch_2", "ch_3", "ch_1"}, ids)
+}
+
+func TestValidFixtures(t *testing.T) {
+	// Every fixture should validate according to the schema it's a fixture for
+	for name, schema := range realSpec.Components.Schemas {
+		if schema.XResourceID == "" {
+			continue
+		}
+		t.Run(name, func(t2 *testing.T) {
+			fixture, ok := realFixtures.Resources[spec.ResourceID(schema.XResourceID)]
+			assert.True(t2, ok)
+			validator, err := spec.GetValidatorForOpenAPI3Schema(schema, realComponentsForValidation)
+			assert.NoError(t2, err)
+			err = validator.Validate(fixture)
+			assert.NoError(t2, err)
+		})
+	}
+}
+
+func TestResourcesCanBeGenerated(t *testing.T) {
+	for url, operations := range realSpec.Paths {
+		for method, operation := range operations {
+			schema := operation.Responses[spec.StatusCode("200")].Content["application/json"].Schema
+			t.Run(
+				fmt.Sprintf("%s %s (without expansions)", method, url),
+				func(t2 *testing.T) { testCanGenerate(t2, url, schema, false, false) },
+			)
+		}
+	}
+}
+
+func TestResourcesCanBeGenerated_NoFixtures(t *testing.T) {
+	for url, operations := range realSpec.Paths {
+		for method, operation := range operations {
+			schema := operation.Responses[spec.StatusCode("200")].Content["application/json"].Schema
+			t.Run(
+				fmt.Sprintf("%s %s (without fixtures)", method, url),
+				func(t2 *testing.T) { testCanGenerate(t2, url, schema, false, true) },
+			)
+		}
+	}
+}
+
+func TestResourcesCanBeGeneratedAndExpanded(t *testing.T) {
+	t.Skip("This test is known to fail because fixtures are missing for some " +
+		"expandable subresources.")
+	for url, operations := range realSpec.Paths {
+		for method, operation := range operations {
+			schema := operation.Responses[spec.StatusCode("200")].Content["application/json"].Schema
+			t.Run(
+				fmt.Sprintf("%s %s (with expansions)", method, url),
+				func(t2 *testing.T) { testCanGenerate(t2, url, schema, true, false) },
+			)
+		}
+	}
+}
+
+//
+// Tests for private functions
+//
+
+func TestApplyAction(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"object": {Enum: []interface{}{"payment_intent"}, Type: "string"},
+		},
+	}
+	id := "pi_123"
+
+	testCases := []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{http.MethodPost, "/v1/payment_intents/pi_123/cancel", "canceled"},
+		{http.MethodPost, "/v1/payment_intents/pi_123/confirm", "succeeded"},
+
+		// Not actions
+		{http.MethodGet, "/v1/payment_intents/pi_123/cancel", "requires_source"},
+		{http.MethodPost, "/v1/payment_intents/pi_123", "requires_source"},
+		{http.MethodPost, "/v1/payment_intents/pi_123/unknown", "requires_source"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.method+" "+testCase.path, func(t *testing.T) {
+			object := map[string]interface{}{"status": "requires_source"}
+			applyAction(&GenerateParams{
+				PathParams:    &PathParamsMap{PrimaryID: &id},
+				RequestMethod: testCase.method,
+				RequestPath:   testCase.path,
+			}, schema, object)
+			assert.Equal(t, map[string]interface{}{"status": testCase.expected}, object)
+		})
+	}
+}
+
+func TestBuildListResource(t *testing.T) {
+	// An empty list is still well-formed
+	assert.Equal(t, map[string]interface{}{
+		"data":        []interface{}{},
+		"has_more":    false,
+		"object":      "list",
+		"total_count": 0,
+		"url":         "/v1/charges",
+	}, buildListResource(&GenerateParams{
+		RequestPath: "/v1/charges",
+		Schema:      listSchema,
+	}, nil))
+}
+
+func TestDefinitionFromJSONPointer(t *testing.T) {
+	definition := definitionFromJSONPointer("#/components/schemas/charge")
+	assert.Equal(t, "charge", definition)
+}
+
+// This is meant as quite a blunt test. See TestMaybeReplaceID for something
+// that's probably easier to introspect/debug.
+func TestDistributeReplacedIDs(t *testing.T) {
+	newID := "new-id"
+	oldID := "old-id"
+
+	newSecondaryID := "new-secondary-id"
+	oldSecondaryID := "old-secondary-id"
+
+	pathParams := &PathParamsMap{
+		PrimaryID:         &newID,
+		replacedPrimaryID: &oldID,
+
+		SecondaryIDs: []*PathParamsSecondaryID{
+			{
+				ID:          newSecondaryID,
+				replacedIDs: []string{oldSecondaryID},
+			},
+		},
+	}
+
+	data := map[string]interface{}{
+		"key_with_id":   oldID,
+		"unrelated_key": "foo",
+		"nested": map[string]interface{}{
+			"key_with_secondary_id": oldSecondaryID,
+			"slice": []interface{}{
+				map[string]interface{}{
+					"key_with_id":   oldID,
+					"unrelated_key": "foo",
+					"url":           "/v1/charges/" + oldID + "/refunds",
+				},
+			},
+		},
+	}
+
+	// This function modifies structure in place.
+	distributeReplacedIDs(pathParams, data)
+
+	assert.Equal(t,
+		map[string]interface{}{
+			"key_with_id":   newID,
+			"unrelated_key": "foo",
+			"nested": map[string]interface{}{
+				"key_with_secondary_id": newSecondaryID,
+				"slice": []interface{}{
+					map[string]interface{}{
+						"key_with_id":   newID,
+						"unrelated_key": "foo",
+						"url":           "/v1/charges/" + newID + "/refunds",
+					},
+				},
+			},
+		},
+		data,
+	)
+}
+
+func TestDistributeReplacedIDsInURL(t *testing.T) {
+	newID := "new-id"
+	oldID := "old-id"
+
+	newSecondaryID := "new-secondary-id"
+	oldSecondaryID := "old-secondary-id"
+
+	pathParams := &PathParamsMap{
+		PrimaryID:         &newID,
+		replacedPrimaryID: &oldID,
+
+		SecondaryIDs: []*PathParamsSecondaryID{
+			{
+				ID:          newSecondaryID,
+				replacedIDs: []string{oldSecondaryID},
+			},
+		},
+	}
+
+	// Replaces primary ID
+	{
+		data, ok := distributeReplacedIDsInURL(pathParams, "/v1/charges/"+oldID+"/refunds")
+		assert.True(t, ok)
+		assert.Equal(t, "/v1/charges/"+newID+"/refunds", data)
+	}
+
+	// Replaces secondary ID
+	{
+		data, ok := distributeReplacedIDsInURL(pathParams, "/v1/charges/"+oldSecondaryID+"/refunds")
+		assert.True(t, ok)
+		assert.Equal(t, "/v1/charges/"+newSecondaryID+"/refunds", data)
+	}
+
+	// Doesn't replace a value that's not present
+	{
+		_, ok := distributeReplacedIDsInURL(pathParams, "/v1/charges/other/refunds")
+		assert.False(t, ok)
+	}
+
+	// Works fine on a non-string data type (by ignoring it)
+	{
+		_, ok := distributeReplacedIDsInURL(pathParams, 123)
+		assert.False(t, ok)
+	}
+}
+
+func TestDistributeReplacedIDsInValue(t *testing.T) {
+	newID := "new-id"
+	oldID := "old-id"
+
+	newSecondaryID := "new-secondary-id"
+	oldSecondaryID := "old-secondary-id"
+
+	pathParams := &PathParamsMap{
+		PrimaryID:         &newID,
+		replacedPrimaryID: &oldID,
+
+		SecondaryIDs: []*PathParamsSecondaryID{
+			{
+				ID:          newSecondaryID,
+				replacedIDs: []string{oldSecondaryID},
+			},
+		},
+	}
+
+	// Replaces primary ID
+	{
+		data, ok := distributeReplacedIDsInValue(pathParams, oldID)
+		assert.True(t, ok)
+		assert.Equal(t, newID, data)
+	}
+
+	// Replaces secondary ID
+	{
+		data, ok := distributeReplacedIDsInValue(pathParams, oldSecondaryID)
+		assert.True(t, ok)
+		assert.Equal(t, newSecondaryID, data)
+	}
+
+	// Doesn't replace a value that's not present
+	{
+		_, ok := distributeReplacedIDsInValue(pathParams, "other")
+		assert.False(t, ok)
+	}
+
+	// Works fine on a non-string data type (by ignoring it)
+	{
+		_, ok := distributeReplacedIDsInValue(pathParams, 123)
+		assert.False(t, ok)
+	}
+}
+
+func TestFindAnyOfBranch(t *testing.T) {
+	deletedSchema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"deleted": nil,
+		},
+	}
+
+	nonDeletedSchema := &spec.Schema{}
+
+	schema := &spec.Schema{
+		AnyOf: []*spec.Schema{
+			deletedSchema,
+			nonDeletedSchema,
+		},
+	}
+
+	generator := DataGenerator{}
+
+	// Finds a deleted schema branch
+	{
+		anyOfSchema, _, err := generator.findAnyOfBranch(schema, true, "")
+		assert.NoError(t, err)
+		assert.Equal(t, deletedSchema, anyOfSchema)
+	}
+
+	// Finds a non-deleted schema branch
+	{
+		anyOfSchema, _, err := generator.findAnyOfBranch(schema, false, "")
+		assert.NoError(t, err)
+		assert.Equal(t, nonDeletedSchema, anyOfSchema)
+	}
+
+	// Safe to use on an empty schema
+	{
+		anyOfSchema, _, err := generator.findAnyOfBranch(&spec.Schema{}, false, "")
+		assert.NoError(t, err)
+		assert.Equal(t, (*spec.Schema)(nil), anyOfSchema)
+	}
+}
+
+func TestFindAnyOfBranch_Discriminator(t *testing.T) {
+	cardSchema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"object": {Enum: []interface{}{"card"}, Type: "string"},
+		},
+	}
+	bankAccountSchema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"object": {Enum: []interface{}{"bank_account"}, Type: "string"},
+		},
+	}
+
+	generator := DataGenerator{definitions: map[string]*spec.Schema{
+		"bank_account": bankAccountSchema,
+		"card":         cardSchema,
+	}}
+
+	// Branches are identified by `object` by default
+	{
+		schema := &spec.Schema{
+			AnyOf: []*spec.Schema{cardSchema, bankAccountSchema},
+		}
+
+		anyOfSchema, value, err := generator.findAnyOfBranch(schema, false, "bank_account")
+		assert.NoError(t, err)
+		assert.Equal(t, bankAccountSchema, anyOfSchema)
+		assert.Equal(t, "bank_account", value)
+
+		// Without a value, or with an unknown one, the first branch is used
+		anyOfSchema, value, err = generator.findAnyOfBranch(schema, false, "")
+		assert.NoError(t, err)
+		assert.Equal(t, cardSchema, anyOfSchema)
+		assert.Equal(t, "card", value)
+
+		anyOfSchema, _, err = generator.findAnyOfBranch(schema, false, "other")
+		assert.NoError(t, err)
+		assert.Equal(t, cardSchema, anyOfSchema)
+	}
+
+	// Or by the schema's discriminator and its mapping
+	{
+		schema := &spec.Schema{
+			AnyOf: []*spec.Schema{
+				{Ref: "#/components/schemas/card"},
+				{Ref: "#/components/schemas/bank_account"},
+			},
+			Discriminator: &spec.Discriminator{
+				Mapping: map[string]string{
+					"us_bank_account": "#/components/schemas/bank_account",
+				},
+				PropertyName: "type",
+			},
+		}
+
+		anyOfSchema, value, err := generator.findAnyOfBranch(schema, false, "us_bank_account")
+		assert.NoError(t, err)
+		assert.Equal(t, bankAccountSchema, anyOfSchema)
+		assert.Equal(t, "us_bank_account", value)
+
+		// Branches that aren't mapped are identified by their schema's name
+		anyOfSchema, value, err = generator.findAnyOfBranch(schema, false, "card")
+		assert.NoError(t, err)
+		assert.Equal(t, cardSchema, anyOfSchema)
+		assert.Equal(t, "card", value)
+	}
+}
+
+func TestFindDeletedSchema(t *testing.T) {
+	generator := DataGenerator{definitions: testSpec.Components.Schemas}
+
+	deletedSchema, err := generator.findDeletedSchema(
+		&spec.Schema{Ref: "#/components/schemas/customer"})
+	assert.NoError(t, err)
+	assert.Equal(t, testSpec.Components.Schemas["deleted_customer"], deletedSchema)
+
+	// Already deleted
+	deletedSchema, err = generator.findDeletedSchema(
+		&spec.Schema{Ref: "#/components/schemas/deleted_customer"})
+	assert.NoError(t, err)
+	assert.Nil(t, deletedSchema)
+
+	// No deleted form
+	deletedSchema, err = generator.findDeletedSchema(
+		&spec.Schema{Ref: "#/components/schemas/charge"})
+	assert.NoError(t, err)
+	assert.Nil(t, deletedSchema)
+}
+
+func TestContentHashID(t *testing.T) {
+	params := &GenerateParams{
+		RequestData: map[string]interface{}{
+			"amount":   123,
+			"metadata": map[string]interface{}{"a": "1", "b": "2"},
+		},
+		RequestMethod: http.MethodPost,
+		RequestPath:   "/v1/charges",
+	}
+	id := contentHashID("ch_123", params)
+	assert.True(t, strings.HasPrefix(id, "ch_"))
+	assert.Equal(t, len("ch_")+objectIDLength, len(id))
+
+	// The same request always gets the same ID
+	assert.Equal(t, id, contentHashID("ch_123", params))
+
+	// But a different one gets a different ID
+	assert.NotEqual(t, id, contentHashID("ch_123", &GenerateParams{
+		RequestData:   map[string]interface{}{"amount": 124},
+		RequestMethod: http.MethodPost,
+		RequestPath:   "/v1/charges",
+	}))
+	assert.NotEqual(t, id, contentHashID("ch_123", &GenerateParams{
+		RequestData:   params.RequestData,
+		RequestMethod: http.MethodPost,
+		RequestPath:   "/v1/customers/cus_123/sources",
+	}))
+}
+
+func TestGenerateObjectID(t *testing.T) {
+	id := generateObjectID(nil, "ch_123")
+	assert.True(t, strings.HasPrefix(id, "ch_"))
+	assert.Equal(t, len("ch_")+objectIDLength, len(id))
+	assert.NotEqual(t, id, generateObjectID(nil, "ch_123"))
+
+	// Uses the last underscore so that multi-part prefixes are kept
+	assert.True(t, strings.HasPrefix(generateObjectID(nil, "sub_item_123"), "sub_item_"))
+
+	// No prefix at all
+	assert.Equal(t, objectIDLength, len(generateObjectID(nil, "123")))
+
+	// Sources seeded the same way produce the same IDs
+	assert.Equal(t,
+		generateObjectID(rand.New(rand.NewSource(123)), "ch_123"),
+		generateObjectID(rand.New(rand.NewSource(123)), "ch_123"))
+}
+
+func TestGenerateSyntheticFixture(t *testing.T) {
+	// Scalars (and an array, which is easy)
+	assert.Equal(t, []interface{}{}, generateSyntheticFixture(nil, nil, &spec.Schema{Type: spec.TypeArray}, ""))
+	assert.Equal(t, true, generateSyntheticFixture(nil, nil, &spec.Schema{Type: spec.TypeBoolean}, ""))
+	assert.Equal(t, 0, generateSyntheticFixture(nil, nil, &spec.Schema{Type: spec.TypeInteger}, ""))
+	assert.Equal(t, 0.0, generateSyntheticFixture(nil, nil, &spec.Schema{Type: spec.TypeNumber}, ""))
+	assert.Equal(t, "", generateSyntheticFixture(nil, nil, &spec.Schema{Type: spec.TypeString}, ""))
+
+	// Nullable property
+	assert.Equal(t, nil, generateSyntheticFixture(nil, nil, &spec.Schema{
+		Nullable: true,
+		Type:     spec.TypeString,
+	}, ""))
+
+	// Property with enum
+	assert.Equal(t, "list", generateSyntheticFixture(nil, nil, &spec.Schema{
+		Enum: []interface{}{"list"},
+		Type: spec.TypeString,
+	}, ""))
+
+	// Takes the first non-reference branch of an anyOf
+	assert.Equal(t, "", generateSyntheticFixture(nil, nil, &spec.Schema{
+		AnyOf: []*spec.Schema{
+			{Ref: "#/components/schemas/radar_rule"},
+			{Type: spec.TypeString},
+		},
+	}, ""))
+
+	// A placeholder for an anyOf that only has references, whose branch is
+	// generated by the caller
+	assert.Equal(t, map[string]interface{}{}, generateSyntheticFixture(nil, nil, &spec.Schema{
+		AnyOf: []*spec.Schema{
+			{Ref: "#/components/schemas/bank_account"},
+			{Ref: "#/components/schemas/card"},
+		},
+	}, ""))
+
+	// Object
+	assert.Equal(t,
+		map[string]interface{}{
+			"has_more": true,
+			"object":   "list",
+			"url":      "",
+		},
+		generateSyntheticFixture(nil, nil, &spec.Schema{
+			Type: "object",
+			Properties: map[string]*spec.Schema{
+				"has_more": {
+					Type: "boolean",
+				},
+				"object": {
+					Enum: []interface{}{"list"},
+				},
+				"total_count": {
+					Type: "integer",
+				},
+				"url": {
+					Type: "string",
+				},
+			},
+			Required: []string{
+				"has_more",
+				"object",
+				"url",
+			},
+		}, ""),
+	)
+}
+
+func TestGenerateSyntheticFixture_Enum(t *testing.T) {
+	schema := &spec.Schema{
+		Enum: []interface{}{"active", "canceled", "past_due"},
+		Type: spec.TypeString,
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.Contains(t, schema.Enum, generateSyntheticFixture(nil, nil, schema, ""))
+	}
+
+	// The same seed picks the same member
+	assert.Equal(t,
+		generateSyntheticFixture(rand.New(rand.NewSource(1)), nil, schema, ""),
+		generateSyntheticFixture(rand.New(rand.NewSource(1)), nil, schema, ""))
+}
+
+func TestGenerateSyntheticFixture_Constraints(t *testing.T) {
+	minimum := 2.5
+	maximum := -1.0
+
+	assert.Equal(t, 3, generateSyntheticFixture(nil, nil, &spec.Schema{
+		Minimum: &minimum,
+		Type:    spec.TypeInteger,
+	}, ""))
+	assert.Equal(t, 2.5, generateSyntheticFixture(nil, nil, &spec.Schema{
+		Minimum: &minimum,
+		Type:    spec.TypeNumber,
+	}, ""))
+	assert.Equal(t, -1.0, generateSyntheticFixture(nil, nil, &spec.Schema{
+		Maximum: &maximum,
+		Type:    spec.TypeNumber,
+	}, ""))
+	assert.Equal(t, "aaa", generateSyntheticFixture(nil, nil, &spec.Schema{
+		MinLength: 3,
+		Type:      spec.TypeString,
+	}, ""))
+}
+
+func TestGenerateSyntheticFixture_Default(t *testing.T) {
+	assert.Equal(t, "automatic", generateSyntheticFixture(nil, nil, &spec.Schema{
+		Default: "automatic",
+		Enum:    []interface{}{"automatic", "manual"},
+		Type:    spec.TypeString,
+	}, ""))
+
+	// Preferred over values given to properties based on their names
+	assert.Equal(t, map[string]interface{}{"country": "CA"},
+		generateSyntheticFixture(nil, nil, &spec.Schema{
+			Properties: map[string]*spec.Schema{
+				"country": {Default: "CA", Type: spec.TypeString},
+			},
+			Required: []string{"country"},
+			Type:     spec.TypeObject,
+		}, ""))
+}
+
+func TestGenerateSyntheticFixture_PropertyValues(t *testing.T) {
+	before := time.Now().Unix()
+
+	fixture := generateSyntheticFixture(nil, nil, &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"country":        {Type: spec.TypeString},
+			"created":        {Type: spec.TypeInteger},
+			"currency":       {Type: spec.TypeString},
+			"email":          {Type: spec.TypeString},
+			"id":             {Type: spec.TypeString},
+			"livemode":       {Type: spec.TypeBoolean},
+			"object":         {Enum: []interface{}{"payment_intent", "setup_intent"}, Type: spec.TypeString},
+			"receipt_email":  {Type: spec.TypeString},
+			"status_changed": {Format: "unix-time", Type: spec.TypeInteger},
+
+			// Nullable properties are still null
+			"billing_email": {Nullable: true, Type: spec.TypeString},
+		},
+		Required: []string{
+			"billing_email",
+			"country",
+			"created",
+			"currency",
+			"email",
+			"id",
+			"livemode",
+			"object",
+			"receipt_email",
+			"status_changed",
+		},
+		Type:        spec.TypeObject,
+		XResourceID: "payment_intent",
+	}, "").(map[string]interface{})
+
+	assert.Equal(t, nil, fixture["billing_email"])
+	assert.Equal(t, "US", fixture["country"])
+	assert.Contains(t, []string{"eur", "gbp", "jpy", "krw", "kwd", "usd"},
+		fixture["currency"])
+	assert.Equal(t, "jenny.rosen@example.com", fixture["email"])
+	assert.Equal(t, "jenny.rosen@example.com", fixture["receipt_email"])
+	assert.True(t, strings.HasPrefix(fixture["id"].(string), "pi_"))
+	assert.Equal(t, false, fixture["livemode"])
+	assert.Equal(t, "payment_intent", fixture["object"])
+	assert.True(t, fixture["created"].(int64) >= before)
+	assert.True(t, fixture["status_changed"].(int64) >= before)
+}
+
+func TestGenerateSyntheticFixture_Amounts(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"amount":                 {Type: spec.TypeInteger},
+			"amount_refunded":        {Type: spec.TypeInteger},
+			"application_fee_amount": {Type: spec.TypeInteger},
+			"currency":               {Type: spec.TypeString},
+		},
+		Required: []string{
+			"amount",
+			"amount_refunded",
+			"application_fee_amount",
+			"currency",
+		},
+		Type: spec.TypeObject,
+	}
+
+	currencies := make(map[string]bool)
+	for seed := int64(0); seed < 50; seed++ {
+		fixture := generateSyntheticFixture(rand.New(rand.NewSource(seed)), nil,
+			schema, "").(map[string]interface{})
+
+		var currency *syntheticCurrency
+		for i := range syntheticCurrencies {
+			if syntheticCurrencies[i].code == fixture["currency"] {
+				currency = &syntheticCurrencies[i]
+			}
+		}
+		assert.NotNil(t, currency)
+		currencies[currency.code] = true
+
+		// Amounts are whole numbers of major units, so they never have a
+		// sub-unit that the currency doesn't
+		unit := 1
+		for i := 0; i < currency.decimals; i++ {
+			unit *= 10
+		}
+		for _, name := range []string{"amount", "amount_refunded", "application_fee_amount"} {
+			amount := fixture[name].(int)
+			assert.True(t, amount >= unit && amount <= 100*unit, "%s: %d", name, amount)
+			assert.Equal(t, 0, amount%unit, "%s: %d %s", name, amount, currency.code)
+		}
+	}
+
+	// Both zero-decimal and other currencies are picked
+	assert.True(t, currencies["jpy"] || currencies["krw"])
+	assert.True(t, currencies["usd"] || currencies["eur"] || currencies["gbp"])
+
+	// Amounts without a currency are unaffected
+	delete(schema.Properties, "currency")
+	schema.Required = []string{"amount"}
+	assert.Equal(t, map[string]interface{}{"amount": 0},
+		generateSyntheticFixture(nil, nil, schema, ""))
+}
+
+func TestGenerateSyntheticFixture_Strings(t *testing.T) {
+	strs := &syntheticStrings{charset: "xyz", length: 8}
+	r := rand.New(rand.NewSource(1))
+
+	value := generateSyntheticFixture(r, strs, &spec.Schema{Type: spec.TypeString}, "").(string)
+	assert.Equal(t, 8, len(value))
+	assert.Equal(t, "", strings.Trim(value, "xyz"))
+
+	// Within the schema's minLength and maxLength
+	assert.Equal(t, 5, len(generateSyntheticFixture(r, strs, &spec.Schema{
+		MaxLength: 5,
+		Type:      spec.TypeString,
+	}, "").(string)))
+	assert.Equal(t, 12, len(generateSyntheticFixture(r, strs, &spec.Schema{
+		MinLength: 12,
+		Type:      spec.TypeString,
+	}, "").(string)))
+
+	// Free text is given words instead
+	fixture := generateSyntheticFixture(r, strs, &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"description":           {Type: spec.TypeString},
+			"statement_description": {MaxLength: 11, Type: spec.TypeString},
+		},
+		Required: []string{"description", "statement_description"},
+		Type:     spec.TypeObject,
+	}, "").(map[string]interface{})
+	assert.Equal(t, "Lorem ipsum dolor sit amet", fixture["description"])
+	assert.Equal(t, "Lorem ipsum", fixture["statement_description"])
+}
+
+func TestGenerateSyntheticFixture_Seeded(t *testing.T) {
+	strs := &syntheticStrings{charset: "abcdefghijklmnopqrstuvwxyz", length: 12}
+	status := &spec.Schema{
+		Enum: []interface{}{"active", "canceled", "past_due", "trialing"},
+		Type: spec.TypeString,
+	}
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"amount":          {Type: spec.TypeInteger},
+			"amount_refunded": {Type: spec.TypeInteger},
+			"currency":        {Type: spec.TypeString},
+			"name":            {Type: spec.TypeString},
+			"nickname":        {Type: spec.TypeString},
+			"reference":       {Type: spec.TypeString},
+			"status":          status,
+			"status_previous": status,
+		},
+		Required: []string{
+			"amount",
+			"amount_refunded",
+			"currency",
+			"name",
+			"nickname",
+			"reference",
+			"status",
+			"status_previous",
+		},
+		Type: spec.TypeObject,
+	}
+
+	// Every property draws from the same source, so they have to do it in
+	// the same order each time for the output to be the same
+	var expected []byte
+	for i := 0; i < 50; i++ {
+		fixture := generateSyntheticFixture(rand.New(rand.NewSource(1)), strs,
+			schema, "")
+		actual, err := json.Marshal(fixture)
+		assert.NoError(t, err)
+
+		if expected == nil {
+			expected = actual
+			continue
+		}
+		assert.Equal(t, string(expected), string(actual))
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "abc", truncateString("abc", 0))
+	assert.Equal(t, "abc", truncateString("abc", 3))
+	assert.Equal(t, "ab", truncateString("abc", 2))
+	assert.Equal(t, "hé", truncateString("héllo", 2))
+}
+
+func TestIsAmountProperty(t *testing.T) {
+	assert.True(t, isAmountProperty("amount"))
+	assert.True(t, isAmountProperty("amount_captured"))
+	assert.True(t, isAmountProperty("application_fee_amount"))
+	assert.False(t, isAmountProperty("amounts"))
+	assert.False(t, isAmountProperty("created"))
+}
+
+func TestIsObjectID(t *testing.T) {
+	assert.True(t, isObjectID("cus_123"))
+	assert.True(t, isObjectID("ch_123_1"))
+	assert.False(t, isObjectID("not_an_id"))
+	assert.False(t, isObjectID("A sentence_with an underscore"))
+	assert.False(t, isObjectID("usd"))
+}
+
+func TestObjectIDPrefix(t *testing.T) {
+	assert.Equal(t, "cus_", objectIDPrefix("customer"))
+	assert.Equal(t, "ic_", objectIDPrefix("issuing.card"))
+
+	// Resources we don't know about get a prefix derived from their name
+	assert.Equal(t, "new_thing_", objectIDPrefix("new_thing"))
+	assert.Equal(t, "new_namespace_thing_", objectIDPrefix("new_namespace.thing"))
+}
+
+func TestMaybeDereference(t *testing.T) {
+	chargeSchema := &spec.Schema{Type: "object"}
+	generator := DataGenerator{definitions: map[string]*spec.Schema{
+		"a":      {Ref: "#/components/schemas/b"},
+		"b":      {Ref: "#/components/schemas/a"},
+		"alias":  {Ref: "#/components/schemas/charge"},
+		"charge": chargeSchema,
+		"self":   {AllOf: []*spec.Schema{{Ref: "#/components/schemas/self"}}},
+	}}
+
+	// References are followed to any depth
+	schema, _, err := generator.maybeDereference(
+		&spec.Schema{Ref: "#/components/schemas/alias"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, chargeSchema, schema)
+
+	// But not forever
+	_, _, err = generator.maybeDereference(
+		&spec.Schema{Ref: "#/components/schemas/a"}, "")
+	assert.Error(t, err)
+
+	_, _, err = generator.maybeDereference(
+		&spec.Schema{Ref: "#/components/schemas/self"}, "")
+	assert.Error(t, err)
+
+	// Schemas that aren't references are returned as they are
+	schema, _, err = generator.maybeDereference(chargeSchema, "")
+	assert.NoError(t, err)
+	assert.Equal(t, chargeSchema, schema)
+}
+
+func TestMergeFreeformMaps(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"metadata": {Type: spec.TypeObject},
+			"shipping": {
+				Properties: map[string]*spec.Schema{
+					"name": {Type: spec.TypeString},
+				},
+				Type: spec.TypeObject,
+			},
+		},
+		Type: spec.TypeObject,
+	}
+
+	// Merges keys and removes ones set to an empty string
+	{
+		object := map[string]interface{}{
+			"metadata": map[string]interface{}{"foo": "bar", "baz": "qux"},
+		}
+		mergeFreeformMaps(schema, map[string]interface{}{
+			"metadata": map[string]interface{}{"foo": "new", "baz": "", "a": "b"},
+		}, object)
+		assert.Equal(t, map[string]interface{}{
+			"metadata": map[string]interface{}{"foo": "new", "a": "b"},
+		}, object)
+	}
+
+	// Fills in a map that was null or missing
+	{
+		object := map[string]interface{}{"metadata": nil}
+		mergeFreeformMaps(schema, map[string]interface{}{
+			"metadata": map[string]interface{}{"foo": "bar"},
+		}, object)
+		assert.Equal(t, map[string]interface{}{
+			"metadata": map[string]interface{}{"foo": "bar"},
+		}, object)
+	}
+
+	// Leaves properties that aren't free-form maps alone
+	{
+		object := map[string]interface{}{
+			"shipping": map[string]interface{}{"name": "Jenny Rosen"},
+		}
+		mergeFreeformMaps(schema, map[string]interface{}{
+			"other":    map[string]interface{}{"foo": "bar"},
+			"shipping": map[string]interface{}{"foo": "bar"},
+		}, object)
+		assert.Equal(t, map[string]interface{}{
+			"shipping": map[string]interface{}{"name": "Jenny Rosen"},
+		}, object)
+	}
+}
+
+func TestReplaceReferencedObjectIDs(t *testing.T) {
+	object := map[string]interface{}{
+		"customer": "cus_123",
+		"invoice":  "in_123",
+		"source": map[string]interface{}{
+			"customer": "cus_123",
+			"id":       "card_123",
+		},
+		"refunds": map[string]interface{}{
+			"url": "/v1/customers/cus_123/refunds",
+		},
+	}
+	referencedIDs := referencedObjectIDs(object)
+	assert.Equal(t, map[string]string{
+		"customer": "cus_123",
+		"invoice":  "in_123",
+		"source":   "card_123",
+	}, referencedIDs)
+
+	// As if the request had been reflected into the object
+	requestData := map[string]interface{}{"customer": "cus_456", "source": "tok_visa"}
+	object["customer"] = "cus_456"
+
+	replaceReferencedObjectIDs(requestData, referencedIDs, object)
+	assert.Equal(t, map[string]interface{}{
+		"customer": "cus_456",
+		"invoice":  "in_123",
+		"source": map[string]interface{}{
+			"customer": "cus_456",
+			"id":       "card_123",
+		},
+		"refunds": map[string]interface{}{
+			"url": "/v1/customers/cus_456/refunds",
+		},
+	}, object)
+}
+
+func TestResourceObjectValue(t *testing.T) {
+	objectSchema := func(resourceID string, enum ...interface{}) *spec.Schema {
+		return &spec.Schema{
+			Properties: map[string]*spec.Schema{
+				"object": {Enum: enum, Type: "string"},
+			},
+			XResourceID: resourceID,
+		}
+	}
+
+	assert.Equal(t, "charge", resourceObjectValue(objectSchema("charge", "charge")))
+	assert.Equal(t, "charge", resourceObjectValue(objectSchema("charge")))
+	assert.Equal(t, "customer",
+		resourceObjectValue(objectSchema("deleted_customer", "customer")))
+	assert.Equal(t, "card",
+		resourceObjectValue(objectSchema("card", "bank_account", "card")))
+	assert.Equal(t, "",
+		resourceObjectValue(objectSchema("source", "bank_account", "card")))
+
+	// Only resources with an `object` property have one
+	assert.Equal(t, "", resourceObjectValue(objectSchema("", "charge")))
+	assert.Equal(t, "", resourceObjectValue(&spec.Schema{XResourceID: "charge"}))
+}
+
+func TestSetAccountContext(t *testing.T) {
+	params := &GenerateParams{Account: "acct_123", RequestPath: "/v1/account"}
+
+	// An account property is set
+	{
+		schema := &spec.Schema{Properties: map[string]*spec.Schema{
+			"account": {Type: spec.TypeString},
+		}}
+		data := map[string]interface{}{"account": "acct_other"}
+		setAccountContext(schema, params, data)
+		assert.Equal(t, "acct_123", data["account"])
+	}
+
+	// Unless it's been expanded
+	{
+		schema := &spec.Schema{Properties: map[string]*spec.Schema{
+			"account": {Type: spec.TypeString},
+		}}
+		account := map[string]interface{}{"id": "acct_other"}
+		data := map[string]interface{}{"account": account}
+		setAccountContext(schema, params, data)
+		assert.Equal(t, account, data["account"])
+	}
+
+	// The account retrieved from /v1/account is the connected account
+	{
+		schema := &spec.Schema{XResourceID: "account"}
+		data := map[string]interface{}{"id": "acct_other"}
+		setAccountContext(schema, params, data)
+		assert.Equal(t, map[string]interface{}{"id": "acct_123"}, data)
+	}
+}
+
+func TestParentFieldNames(t *testing.T) {
+	assert.Equal(t, []string{"customer"}, parentFieldNames(
+		"/v1/customers/cus_123/sources",
+		&PathParamsSecondaryID{ID: "cus_123", Name: "customer"}))
+	assert.Equal(t, []string{"transfer"}, parentFieldNames(
+		"/v1/transfers/tr_123/reversals",
+		&PathParamsSecondaryID{ID: "tr_123", Name: "id"}))
+	assert.Equal(t, []string{"application_fee", "fee"}, parentFieldNames(
+		"/v1/application_fees/fee_123/refunds",
+		&PathParamsSecondaryID{ID: "fee_123", Name: "id"}))
+}
+
+func TestSetParentIDs(t *testing.T) {
+	pathParams := &PathParamsMap{
+		SecondaryIDs: []*PathParamsSecondaryID{{ID: "fee_123", Name: "id"}},
+	}
+	listData := map[string]interface{}{
+		"data": []interface{}{
+			map[string]interface{}{"id": "fr_1", "fee": "fee_other"},
+			map[string]interface{}{"id": "fr_2", "fee": map[string]interface{}{"id": "fee_other"}},
+			map[string]interface{}{"id": "fr_3"},
+		},
+	}
+	setParentIDs(pathParams, "/v1/application_fees/fee_123/refunds", listData)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"id": "fr_1", "fee": "fee_123"},
+		map[string]interface{}{"id": "fr_2", "fee": map[string]interface{}{"id": "fee_123"}},
+		map[string]interface{}{"id": "fr_3"},
+	}, listData["data"])
+
+	for _, item := range listData["data"].([]interface{}) {
+		assert.True(t, belongsToParents(pathParams,
+			"/v1/application_fees/fee_123/refunds", item.(map[string]interface{})))
+	}
+	assert.False(t, belongsToParents(pathParams, "/v1/application_fees/fee_123/refunds",
+		map[string]interface{}{"id": "fr_4", "fee": "fee_other"}))
+}
+
+func TestSetListURL(t *testing.T) {
+	listData := map[string]interface{}{"object": "list", "url": "/v1/charges"}
+	setListURL(listData, "/v1/customers/cus_123/charges")
+	assert.Equal(t, "/v1/customers/cus_123/charges", listData["url"])
+
+	// Nothing's added to a list without a url or set without a path
+	listData = map[string]interface{}{"object": "list"}
+	setListURL(listData, "/v1/charges")
+	assert.Equal(t, map[string]interface{}{"object": "list"}, listData)
+
+	listData = map[string]interface{}{"url": "/v1/charges"}
+	setListURL(listData, "")
+	assert.Equal(t, "/v1/charges", listData["url"])
+}
+
+func TestSetNestedListURLs(t *testing.T) {
+	object := map[string]interface{}{
+		"id":      "ch_123",
+		"refunds": map[string]interface{}{"object": "list", "url": "/v1/refunds"},
+	}
+	setNestedListURLs(map[string]string{
+		"disputes": "/v1/charges/{charge}/disputes",
+		"refunds":  "/v1/charges/{charge}/refunds",
+	}, object)
+	assert.Equal(t, map[string]interface{}{
+		"id": "ch_123",
+		"refunds": map[string]interface{}{
+			"object": "list",
+			"url":    "/v1/charges/ch_123/refunds",
+		},
+	}, object)
+}
+
+func TestListPaginationPage(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+
+	testCases := []struct {
+		pagination listPagination
+		start      int
+		end        int
+		hasMore    bool
+		cursor     string
+	}{
+		{listPagination{limit: 10}, 0, 5, false, ""},
+		{listPagination{limit: 2}, 0, 2, true, ""},
+		{listPagination{limit: 2, startingAfter: "b"}, 2, 4, true, ""},
+		{listPagination{limit: 2, startingAfter: "c"}, 3, 5, false, ""},
+		{listPagination{limit: 2, startingAfter: "e"}, 5, 5, false, ""},
+		{listPagination{limit: 2, endingBefore: "d"}, 1, 3, true, ""},
+		{listPagination{limit: 2, endingBefore: "c"}, 0, 2, false, ""},
+		{listPagination{limit: 2, endingBefore: "a"}, 0, 0, false, ""},
+		{listPagination{limit: 2, startingAfter: "z"}, 0, 0, false, "z"},
+		{listPagination{limit: 2, endingBefore: "z"}, 0, 0, false, "z"},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.pagination), func(t *testing.T) {
+			start, end, hasMore, cursor := tc.pagination.page(ids)
+			assert.Equal(t, tc.cursor, cursor)
+			if cursor != "" {
+				return
+			}
+			assert.Equal(t, tc.start, start)
+			assert.Equal(t, tc.end, end)
+			assert.Equal(t, tc.hasMore, hasMore)
+		})
+	}
+}
+
+func TestParseCreatedFilter(t *testing.T) {
+	timestamp := func(t int64) *int64 { return &t }
+
+	testCases := []struct {
+		requestData map[string]interface{}
+		want        *createdFilter
+	}{
+		{nil, nil},
+		{map[string]interface{}{"limit": "5"}, nil},
+		{
+			map[string]interface{}{"created": "100"},
+			&createdFilter{gte: timestamp(100), lte: timestamp(100)},
+		},
+		{
+			map[string]interface{}{"created": 100.0},
+			&createdFilter{gte: timestamp(100), lte: timestamp(100)},
+		},
+		{
+			map[string]interface{}{"created": map[string]interface{}{
+				"gt": "100", "gte": 200, "lt": int64(300), "lte": "400",
+			}},
+			&createdFilter{
+				gt: timestamp(100), gte: timestamp(200), lt: timestamp(300), lte: timestamp(400),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.requestData), func(t *testing.T) {
+			filter, err := parseCreatedFilter(tc.requestData)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, filter)
+		})
+	}
+
+	_, err := parseCreatedFilter(map[string]interface{}{"created": "foo"})
+	assert.Equal(t, &invalidRequestError{message: "Invalid integer: foo", param: "created"}, err)
+
+	_, err = parseCreatedFilter(map[string]interface{}{"created": 1.5})
+	assert.Equal(t, &invalidRequestError{message: "Invalid integer: 1.5", param: "created"}, err)
+
+	_, err = parseCreatedFilter(map[string]interface{}{
+		"created": map[string]interface{}{"gt": "100", "lte": "foo"},
+	})
+	assert.Equal(t, &invalidRequestError{
+		message: "Invalid integer: foo",
+		param:   "created[lte]",
+	}, err)
+
+	_, err = parseCreatedFilter(map[string]interface{}{
+		"created": map[string]interface{}{"gt": "100", "gteq": "200"},
+	})
+	assert.Equal(t, &invalidRequestError{
+		message: fmt.Sprintf(invalidRangeOperator, "created[gteq]"),
+		param:   "created[gteq]",
+	}, err)
+}
+
+func TestCreatedFilter(t *testing.T) {
+	timestamp := func(t int64) *int64 { return &t }
+	objects := []map[string]interface{}{
+		{"id": "a", "created": 300},
+		{"id": "b", "created": 200.0},
+		{"id": "c", "created": int64(100)},
+		{"id": "d"},
+	}
+
+	var filter *createdFilter
+	assert.Equal(t, objects, filter.filter(objects))
+
+	filter = &createdFilter{gt: timestamp(100), lte: timestamp(300)}
+	assert.Equal(t, objects[:2], filter.filter(objects))
+
+	filter = &createdFilter{lt: timestamp(200)}
+	assert.Equal(t, objects[2:], filter.filter(objects))
+
+	filter = &createdFilter{gte: timestamp(200), lt: timestamp(300)}
+	assert.Equal(t, objects[1:2], filter.filter(objects))
+	assert.True(t, filter.matches(200))
+	assert.False(t, filter.matches(300))
+}
+
+func TestParseListPagination(t *testing.T) {
+	testCases := []struct {
+		requestData map[string]interface{}
+		want        *listPagination
+	}{
+		{nil, &listPagination{limit: listLimitDefault}},
+		{map[string]interface{}{"limit": "5"}, &listPagination{limit: 5}},
+		{map[string]interface{}{"limit": 5}, &listPagination{limit: 5}},
+		{map[string]interface{}{"limit": "0"}, &listPagination{limit: listLimitMin}},
+		{map[string]interface{}{"limit": "1000"}, &listPagination{limit: listLimitMax}},
+		{
+			map[string]interface{}{"starting_after": "ch_123"},
+			&listPagination{limit: listLimitDefault, startingAfter: "ch_123"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.requestData), func(t *testing.T) {
+			pagination, err := parseListPagination(tc.requestData)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, pagination)
+		})
+	}
+
+	_, err := parseListPagination(map[string]interface{}{"limit": "foo"})
+	assert.Equal(t, &invalidRequestError{message: "Invalid integer: foo"}, err)
+}
+
+func TestPropertyNames(t *testing.T) {
+	assert.Equal(t, "bar, foo", propertyNames(&spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"foo": nil,
+			"bar": nil,
+		},
+	}))
+	assert.Equal(t, "", propertyNames(&spec.Schema{}))
+}
+
+func TestIsDeletedResource(t *testing.T) {
+	assert.True(t, isDeletedResource(&spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"deleted": nil,
+		},
+	}))
+
+	assert.False(t, isDeletedResource(&spec.Schema{}))
+}
+
+// This is meant as quite a blunt test. See TestMaybeReplaceID for something
+// that's probably easier to introspect/debug.
+func TestReplaceIDs(t *testing.T) {
+	newID := "new-id"
+	oldID := "old-id"
+
+	newApplicationID := "new-application-id"
+	oldApplicationID := "old-application-id"
+
+	newChargeID := "new-charge-id"
+	oldChargeID := "old-charge-id"
+
+	// The generator handles multiple values for the same type of entity. Here
+	// we're replacing two separate old charge IDs with a single new charge ID.
+	otherOldChargeID := "other-charge-id"
+
+	newRefundID := "new-refund-id"
+	oldRefundID := "old-refund-id"
+
+	newSourceID := "new-source-id"
+	oldSourceID := "old-source-id"
+
+	pathParams := &PathParamsMap{
+		PrimaryID:         &newID,
+		replacedPrimaryID: &oldID,
+
+		SecondaryIDs: []*PathParamsSecondaryID{
+			{
+				ID:          newApplicationID,
+				Name:        "application",
+				replacedIDs: nil,
+			},
+			{
+				ID:          newChargeID,
+				Name:        "charge",
+				replacedIDs: nil,
+			},
+			{
+				ID:          newRefundID,
+				Name:        "refund",
+				replacedIDs: nil,
+			},
+			{
+				ID:          newSourceID,
+				Name:        "source",
+				replacedIDs: nil,
+			},
+		},
+	}
+
+	data := map[string]interface{}{
+		"id": oldID,
+		"embedded_charge": map[string]interface{}{
+			"id":          oldChargeID,
+			"object":      "charge",
+			"application": oldApplicationID,
+			"embedded_refunds": []interface{}{
+				map[string]interface{}{
+					"id":     oldRefundID,
+					"object": "refund",
+				},
+			},
+			"source": map[string]interface{}{
+				"id":     oldSourceID,
+				"object": "not-source",
+			},
+		},
+		"charge": otherOldChargeID,
+		"not_replaced": map[string]interface{}{
+			"id":     "other-id",
+			"object": "other",
+		},
+	}
+
+	// This function modifies structure in place.
+	pathParams = recordAndReplaceIDs(pathParams, data)
+
+	assert.Equal(t, oldID, *pathParams.replacedPrimaryID)
+
+	assert.Equal(t, 1, len(pathParams.SecondaryIDs[0].replacedIDs))
+	assert.Contains(t, pathParams.SecondaryIDs[0].replacedIDs, oldApplicationID)
+
+	assert.Equal(t, 2, len(pathParams.SecondaryIDs[1].replacedIDs))
+	assert.Contains(t, pathParams.SecondaryIDs[1].replacedIDs, oldChargeID)
+	assert.Contains(t, pathParams.SecondaryIDs[1].replacedIDs, otherOldChargeID)
+
+	assert.Equal(t, 1, len(pathParams.SecondaryIDs[2].replacedIDs))
+	assert.Contains(t, pathParams.SecondaryIDs[2].replacedIDs, oldRefundID)
+
+	assert.Equal(t, 1, len(pathParams.SecondaryIDs[3].replacedIDs))
+	assert.Contains(t, pathParams.SecondaryIDs[3].replacedIDs, oldSourceID)
+
+	assert.Equal(t,
+		map[string]interface{}{
+			"id": newID,
+			"embedded_charge": map[string]interface{}{
+				"id":          newChargeID,
+				"object":      "charge",
+				"application": newApplicationID,
+				"embedded_refunds": []interface{}{
+					map[string]interface{}{
+						"id":     newRefundID,
+						"object": "refund",
+					},
+				},
+				"source": map[string]interface{}{
+					"id":     newSourceID,
+					"object": "not-source",
+				},
+			},
+			"charge": newChargeID,
+			"not_replaced": map[string]interface{}{
+				"id":     "other-id",
+				"object": "other",
+			},
+		},
+		data,
+	)
+}
+
+func TestStringOrEmpty(t *testing.T) {
+	assert.Equal(t, "foo", stringOrEmpty("foo"))
+	assert.Equal(t, "(empty)", stringOrEmpty(""))
+}
+
+//
+// Private functions
+//
+
+// Tests that DataGenerator can generate an example of the given schema, and
+// that the example validates against the schema correctly
+func testCanGenerate(t *testing.T, path spec.Path, schema *spec.Schema, expand bool,
+	noFixtures bool) {
+
+	assert.NotNil(t, schema)
+
+	generator := DataGenerator{
+		definitions: realSpec.Components.Schemas,
+		fixtures:    &realFixtures,
+		noFixtures:  noFixtures,
+	}
+
+	var expansions *ExpansionLevel
+	if expand {
+		expansions = &ExpansionLevel{
+			expansions: make(map[string]*ExpansionLevel),
+			wildcard:   true,
+		}
+	}
+
+	var example interface{}
+	var err error
+	assert.NotPanics(t, func() {
+		example, err = generator.Generate(&GenerateParams{
+			Expansions:  expansions,
+			RequestPath: string(path),
+			Schema:      schema,
+		})
+	})
+	assert.NoError(t, err)
+
+	validator, err := spec.GetValidatorForOpenAPI3Schema(schema, realComponentsForValidation)
+	assert.NoError(t, err)
+	err = validator.Validate(example)
+	if err != nil {
+		t.Logf("Schema is: %s", schema)
+		exampleJSON, err := json.MarshalIndent(example, "", "  ")
+		assert.NoError(t, err)
+		t.Logf("Example is: %s", exampleJSON)
+	}
+	assert.NoError(t, err)
+}
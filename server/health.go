@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/stripe/stripe-mock/logging"
+)
+
+// handleHealthCheck responds to a health or readiness check, like those of
+// Kubernetes probes, with a small JSON body. The checks are answered without
+// an API key and without touching the spec or generator, so they're cheap
+// enough to be made every few seconds.
+//
+// stripe-mock is ready as soon as it's listening, so both checks always
+// succeed. It reports whether the request was a check, in which case it's been
+// responded to and shouldn't be handled any further.
+func handleHealthCheck(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path != healthPath && r.URL.Path != readyPath {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// Probes are frequent, so they're only logged when debugging
+	logging.Debug("Health check", "path", r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write([]byte(healthCheckBody))
+	}
+	return true
+}
+
+//
+// Private values
+//
+
+// healthCheckBody is the body of the response to a health or readiness check.
+const healthCheckBody = `{"status":"ok"}` + "\n"
+
+// healthPath and readyPath are the paths of the health and readiness checks.
+// They're outside of `/v1` so that they can't be mistaken for part of the
+// Stripe API.
+const (
+	healthPath = "/healthz"
+	readyPath  = "/readyz"
+)
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestHandleHealthCheck(t *testing.T) {
+	for _, path := range []string{"/healthz", "/readyz"} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		assert.True(t, handleHealthCheck(w, req))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, map[string]interface{}{"status": "ok"},
+			decodeResponse(t, w.Body.Bytes()))
+	}
+
+	// A HEAD request gets no body
+	req := httptest.NewRequest("HEAD", "/healthz", nil)
+	w := httptest.NewRecorder()
+	assert.True(t, handleHealthCheck(w, req))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+
+	// Other methods and paths are left to the API
+	req = httptest.NewRequest("POST", "/healthz", nil)
+	assert.False(t, handleHealthCheck(httptest.NewRecorder(), req))
+
+	req = httptest.NewRequest("GET", "/v1/charges", nil)
+	assert.False(t, handleHealthCheck(httptest.NewRecorder(), req))
+}
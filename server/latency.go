@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"time"
+)
+
+// latencyConfig describes artificial latency that's added before responding
+// to requests. It's useful for exercising a client's timeout and retry
+// behavior.
+type latencyConfig struct {
+	// base is the latency added to requests whose path doesn't match any of
+	// paths.
+	base time.Duration
+
+	// jitter is the upper bound of a random duration added on top of the
+	// latency of every request.
+	jitter time.Duration
+
+	// paths contains latencies for requests to particular paths. The first
+	// one whose pattern matches a request's path is used instead of base.
+	paths []pathLatency
+}
+
+// newLatencyConfig initializes a latencyConfig from the -latency and
+// -latency-jitter options and from the per-path latencies in the file at
+// pathsFile (see loadPathLatencies).
+//
+// Returns nil if no latency was configured.
+func newLatencyConfig(base, jitter time.Duration, pathsFile string) (*latencyConfig, error) {
+	if base < 0 || jitter < 0 {
+		return nil, fmt.Errorf("Latency and latency jitter can't be negative")
+	}
+
+	var paths []pathLatency
+	if pathsFile != "" {
+		var err error
+		paths, err = loadPathLatencies(pathsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if base == 0 && jitter == 0 && len(paths) == 0 {
+		return nil, nil
+	}
+
+	return &latencyConfig{base: base, jitter: jitter, paths: paths}, nil
+}
+
+// latency picks the latency for a request to the given path, including any
+// jitter.
+func (c *latencyConfig) latency(requestPath string) time.Duration {
+	latency := c.base
+	for _, pathLatency := range c.paths {
+		if ok, _ := path.Match(pathLatency.Path, requestPath); ok {
+			latency = time.Duration(pathLatency.Latency)
+			break
+		}
+	}
+
+	if c.jitter > 0 {
+		latency += time.Duration(rand.Int63n(int64(c.jitter) + 1))
+	}
+
+	return latency
+}
+
+// wait sleeps for the latency of a request to the given path. It returns
+// early with false if ctx is done first (e.g. because the client
+// disconnected).
+func (c *latencyConfig) wait(ctx context.Context, requestPath string) bool {
+	return waitLatency(ctx, c.latency(requestPath))
+}
+
+// duration is a time.Duration that's decoded from a JSON string like "1.5s".
+type duration time.Duration
+
+// UnmarshalJSON decodes a duration from a JSON string.
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = duration(parsed)
+	return nil
+}
+
+// pathLatency is the latency for requests to paths matching a pattern.
+type pathLatency struct {
+	// Latency is the latency of matching requests (e.g. "500ms").
+	Latency duration `json:"latency"`
+
+	// Path is a pattern that matches request paths. It's matched with
+	// path.Match, so `*` matches a single path segment (e.g.
+	// `/v1/charges/*`).
+	Path string `json:"path"`
+}
+
+// parseLatencyHeader parses the latency that a request asked for with a
+// `Stripe-Mock-Latency` header, like `250ms`.
+//
+// The second return value is false if there's no header or its value isn't a
+// duration that's 0 or more, in which case the header is ignored.
+func parseLatencyHeader(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	latency, err := time.ParseDuration(value)
+	if err != nil || latency < 0 {
+		return 0, false
+	}
+	return latency, true
+}
+
+// waitLatency sleeps for the given latency. It returns early with false if
+// ctx is done first (e.g. because the client disconnected).
+func waitLatency(ctx context.Context, latency time.Duration) bool {
+	if latency <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// loadPathLatencies loads per-path latencies from a JSON file containing an
+// array of objects with `path` and `latency` keys, like
+// `[{"path": "/v1/charges/*", "latency": "2s"}]`.
+func loadPathLatencies(pathsFile string) ([]pathLatency, error) {
+	data, err := ioutil.ReadFile(pathsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading latency config: %v", err)
+	}
+
+	var paths []pathLatency
+	err = json.Unmarshal(data, &paths)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding latency config: %v", err)
+	}
+
+	for _, pathLatency := range paths {
+		if _, err := path.Match(pathLatency.Path, ""); err != nil {
+			return nil, fmt.Errorf("Invalid path pattern in latency config: %s",
+				pathLatency.Path)
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestNewLatencyConfig(t *testing.T) {
+	config, err := newLatencyConfig(0, 0, "")
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	_, err = newLatencyConfig(-time.Second, 0, "")
+	assert.Error(t, err)
+
+	_, err = newLatencyConfig(0, 0, "/does/not/exist.json")
+	assert.Error(t, err)
+}
+
+func TestLatencyConfig_Latency(t *testing.T) {
+	config := &latencyConfig{
+		base: time.Second,
+		paths: []pathLatency{
+			{Latency: duration(2 * time.Second), Path: "/v1/charges/*"},
+			{Latency: duration(3 * time.Second), Path: "/v1/*/*"},
+		},
+	}
+	assert.Equal(t, time.Second, config.latency("/v1/charges"))
+	assert.Equal(t, 2*time.Second, config.latency("/v1/charges/ch_123"))
+	assert.Equal(t, 3*time.Second, config.latency("/v1/customers/cus_123"))
+
+	// Jitter adds up to its bound
+	config = &latencyConfig{base: time.Second, jitter: time.Millisecond}
+	for i := 0; i < 10; i++ {
+		latency := config.latency("/v1/charges")
+		assert.True(t, latency >= time.Second)
+		assert.True(t, latency <= time.Second+time.Millisecond)
+	}
+}
+
+func TestLatencyConfig_WaitCanceled(t *testing.T) {
+	config := &latencyConfig{base: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, config.wait(ctx, "/v1/charges"))
+
+	config = &latencyConfig{base: time.Millisecond}
+	assert.True(t, config.wait(context.Background(), "/v1/charges"))
+}
+
+func TestLoadPathLatencies(t *testing.T) {
+	file, err := ioutil.TempFile("", "latency")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`[{"path": "/v1/charges/*", "latency": "1.5s"}]`)
+	assert.NoError(t, err)
+	file.Close()
+
+	paths, err := loadPathLatencies(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []pathLatency{
+		{Latency: duration(1500 * time.Millisecond), Path: "/v1/charges/*"},
+	}, paths)
+
+	// An invalid duration
+	err = ioutil.WriteFile(file.Name(),
+		[]byte(`[{"path": "/v1/charges", "latency": "soon"}]`), 0644)
+	assert.NoError(t, err)
+	_, err = loadPathLatencies(file.Name())
+	assert.Error(t, err)
+}
+
+func TestParseLatencyHeader(t *testing.T) {
+	latency, ok := parseLatencyHeader("250ms")
+	assert.True(t, ok)
+	assert.Equal(t, 250*time.Millisecond, latency)
+
+	latency, ok = parseLatencyHeader("0s")
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), latency)
+
+	// Anything else is ignored
+	for _, value := range []string{"", "250", "soon", "-1s"} {
+		_, ok := parseLatencyHeader(value)
+		assert.False(t, ok, value)
+	}
+}
+
+func TestStubServer_LatencyHeader(t *testing.T) {
+	server := getStubServer(t)
+
+	headers := getDefaultHeaders()
+	headers["X-Stripe-Mock-Latency"] = "50ms"
+	start := time.Now()
+	resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	// The header replaces the configured latency, and one that can't be
+	// parsed is ignored
+	server.latency = &latencyConfig{base: time.Hour}
+	testCases := []struct {
+		value     string
+		responded bool
+	}{
+		{"1ms", true},
+		{"soon", false},
+	}
+	for _, testCase := range testCases {
+		headers["Stripe-Mock-Latency"] = testCase.value
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		req := httptest.NewRequest("GET", "/v1/charges/ch_123", nil).WithContext(ctx)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.HandleRequest(w, req)
+		cancel()
+		assert.Equal(t, testCase.responded, w.Body.Len() > 0, testCase.value)
+	}
+}
+
+func TestWaitLatency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, waitLatency(ctx, time.Hour))
+
+	// No latency doesn't wait, even for a context that's done
+	assert.True(t, waitLatency(ctx, 0))
+	assert.True(t, waitLatency(context.Background(), time.Millisecond))
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-mock/logging"
+)
+
+// maintenanceMode is whether the server is down for simulated maintenance,
+// during which API requests get a `503` with a `Retry-After` header as if the
+// Stripe API were having an outage. It's toggled through the internal
+// maintenance endpoint while the server runs.
+type maintenanceMode struct {
+	// mu guards enabled, which is toggled while requests are being handled.
+	mu      sync.RWMutex
+	enabled bool
+
+	// retryAfter is how long clients are told to wait before retrying.
+	retryAfter time.Duration
+}
+
+// newMaintenanceMode initializes a maintenanceMode that starts out enabled or
+// not. Clients are told to retry after retryAfter, or after
+// DefaultMaintenanceRetryAfter if it's 0.
+func newMaintenanceMode(enabled bool, retryAfter time.Duration) *maintenanceMode {
+	if retryAfter == 0 {
+		retryAfter = DefaultMaintenanceRetryAfter
+	}
+	return &maintenanceMode{enabled: enabled, retryAfter: retryAfter}
+}
+
+// isEnabled reports whether the server is down for maintenance.
+func (m *maintenanceMode) isEnabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+// set puts the server into maintenance or takes it out.
+func (m *maintenanceMode) set(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = enabled
+}
+
+// writeUnavailable responds to an API request made during maintenance.
+func (m *maintenanceMode) writeUnavailable(w http.ResponseWriter, r *http.Request,
+	start time.Time) {
+
+	// Retry-After is in whole seconds, so round up
+	w.Header().Set("Retry-After",
+		strconv.Itoa(int(math.Ceil(m.retryAfter.Seconds()))))
+	writeResponse(w, r, start, http.StatusServiceUnavailable,
+		createStripeError(typeAPIError, underMaintenance))
+}
+
+// handleMaintenance handles a request to the internal maintenance endpoint,
+// which puts the server into maintenance or takes it out without a restart.
+// The body is a JSON object like `{"enabled": true}`.
+//
+// It responds with whether the server is now down for maintenance.
+func (s *StubServer) handleMaintenance(w http.ResponseWriter, r *http.Request, start time.Time) {
+	enabled, err := readMaintenanceRequest(r)
+	if isBodyTooLarge(err) {
+		writeResponse(w, r, start, http.StatusRequestEntityTooLarge,
+			createBodyTooLargeError(s.maxBodySize))
+		return
+	}
+	if err != nil {
+		message := fmt.Sprintf(invalidMaintenanceBody, err)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	s.maintenance.set(enabled)
+	logging.Info("Toggled maintenance", "enabled", enabled)
+
+	writeResponse(w, r, start, http.StatusOK, map[string]interface{}{
+		"maintenance": enabled,
+	})
+}
+
+//
+// Private values
+//
+
+const (
+	invalidMaintenanceBody = "Couldn't decode the maintenance request's body " +
+		"as a JSON object with a boolean `enabled`: %v"
+
+	underMaintenance = "The Stripe API is temporarily unavailable for " +
+		"maintenance. Please retry after the time given in the `Retry-After` " +
+		"header."
+)
+
+// maintenancePath is the path of stripe-mock's internal endpoint for putting
+// the server into maintenance or taking it out.
+const maintenancePath = "/v1/_stripe_mock/maintenance"
+
+//
+// Private functions
+//
+
+// readMaintenanceRequest decodes the body of a request to the internal
+// maintenance endpoint, returning whether maintenance should be enabled.
+func readMaintenanceRequest(r *http.Request) (bool, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return false, err
+	}
+	r.Body.Close()
+
+	var maintenance struct {
+		Enabled *bool `json:"enabled"`
+	}
+	err = json.Unmarshal(body, &maintenance)
+	if err != nil {
+		return false, err
+	}
+	if maintenance.Enabled == nil {
+		return false, fmt.Errorf("missing `enabled`")
+	}
+	return *maintenance.Enabled, nil
+}
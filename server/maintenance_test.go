@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestNewMaintenanceMode(t *testing.T) {
+	maintenance := newMaintenanceMode(true, 0)
+	assert.True(t, maintenance.isEnabled())
+	assert.Equal(t, DefaultMaintenanceRetryAfter, maintenance.retryAfter)
+
+	maintenance.set(false)
+	assert.False(t, maintenance.isEnabled())
+}
+
+func TestStubServer_Maintenance(t *testing.T) {
+	server := getStubServer(t)
+	server.maintenance = newMaintenanceMode(false, 2500*time.Millisecond)
+
+	resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/maintenance",
+		`{"enabled": true}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"maintenance": true}, decodeResponse(t, body))
+
+	// API requests are unavailable, with Retry-After rounded up to whole
+	// seconds
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("Retry-After"))
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "api_error", errorInfo["type"])
+	assert.Equal(t, underMaintenance, errorInfo["message"])
+
+	// Turning maintenance off resumes normal operation
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/maintenance",
+		`{"enabled": false}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"maintenance": false}, decodeResponse(t, body))
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Retry-After"))
+}
+
+func TestStubServer_Maintenance_InvalidBody(t *testing.T) {
+	server := getStubServer(t)
+	server.maintenance = newMaintenanceMode(false, 0)
+
+	for _, body := range []string{"", `{}`, `{"enabled": "yes"}`} {
+		resp, _ := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/maintenance",
+			body, getDefaultHeaders())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	assert.False(t, server.maintenance.isEnabled())
+}
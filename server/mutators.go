@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/stripe/stripe-mock/store"
+)
+
+// RegisterResponseMutator registers a function that modifies generated
+// responses to requests whose paths match pathPattern before they're sent.
+// It allows programs embedding stripe-mock to maintain invariants of their
+// own, like a field that should always have a particular value.
+//
+// The pattern is matched with path.Match, so `*` matches a single path
+// segment (e.g. `/v1/charges/*`). It panics if the pattern is malformed.
+//
+// Mutators run after a response has been generated and expanded, in the order
+// that they were registered, and are passed the response's top-level object.
+// The object is a copy, so it can be modified freely. Responses that aren't
+// objects and errors aren't passed to mutators.
+func (s *Server) RegisterResponseMutator(pathPattern string,
+	fn func(obj map[string]interface{})) {
+
+	if _, err := path.Match(pathPattern, ""); err != nil {
+		panic(fmt.Sprintf("Invalid path pattern for response mutator: %s",
+			pathPattern))
+	}
+
+	s.mutators.register(pathPattern, fn)
+}
+
+//
+// Private types
+//
+
+// responseMutator is a function registered with
+// Server.RegisterResponseMutator along with the pattern of paths that it
+// applies to.
+type responseMutator struct {
+	fn      func(obj map[string]interface{})
+	pattern string
+}
+
+// responseMutators holds the functions registered with
+// Server.RegisterResponseMutator. It's shared by the servers for every API
+// version, and is safe for concurrent use.
+type responseMutators struct {
+	mu       sync.RWMutex
+	mutators []responseMutator
+}
+
+// apply runs the mutators matching a request's path on a copy of its
+// response, which is returned. The response is returned as is if no mutators
+// match or it isn't an object.
+func (m *responseMutators) apply(requestPath string, data interface{}) interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var object map[string]interface{}
+	for _, mutator := range m.mutators {
+		if matched, _ := path.Match(mutator.pattern, requestPath); !matched {
+			continue
+		}
+
+		// Generated responses may be stored or share structure with
+		// fixtures, so they're only copied once a mutator applies
+		if object == nil {
+			var ok bool
+			object, ok = store.CopyValue(data).(map[string]interface{})
+			if !ok {
+				return data
+			}
+		}
+		mutator.fn(object)
+	}
+
+	if object == nil {
+		return data
+	}
+	return object
+}
+
+// register adds a mutator for paths matching pattern.
+func (m *responseMutators) register(pattern string, fn func(obj map[string]interface{})) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mutators = append(m.mutators, responseMutator{fn: fn, pattern: pattern})
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestServer_RegisterResponseMutator(t *testing.T) {
+	server, err := NewServer(&Config{Stateful: true})
+	assert.NoError(t, err)
+
+	server.RegisterResponseMutator("/v1/customers", func(obj map[string]interface{}) {
+		obj["name"] = "created"
+	})
+	server.RegisterResponseMutator("/v1/customers/*", func(obj map[string]interface{}) {
+		obj["description"] = "retrieved"
+	})
+	server.RegisterResponseMutator("/v1/customers/*", func(obj map[string]interface{}) {
+		obj["description"] = obj["description"].(string) + " twice"
+	})
+
+	send := func(method, path, body string) map[string]interface{} {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sk_test_123")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var data map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		return data
+	}
+
+	created := send(http.MethodPost, "/v1/customers", "email=jenny@example.com")
+	assert.Equal(t, "created", created["name"])
+	assert.Equal(t, "jenny@example.com", created["email"])
+
+	// Mutators run in the order they were registered, and the stored object
+	// isn't changed by the one that ran when it was created
+	retrieved := send(http.MethodGet, "/v1/customers/"+created["id"].(string), "")
+	assert.Equal(t, "retrieved twice", retrieved["description"])
+	assert.NotEqual(t, "created", retrieved["name"])
+
+	// Other paths aren't affected
+	charge := send(http.MethodPost, "/v1/charges", "amount=100")
+	assert.Nil(t, charge["name"])
+
+	assert.Panics(t, func() {
+		server.RegisterResponseMutator("/v1/[", func(obj map[string]interface{}) {})
+	})
+}
+
+func TestResponseMutators_Apply(t *testing.T) {
+	mutators := &responseMutators{}
+	mutators.register("/v1/charges/*", func(obj map[string]interface{}) {
+		obj["amount"] = 200
+	})
+
+	// The response is copied before it's modified
+	data := map[string]interface{}{"amount": 100}
+	mutated := mutators.apply("/v1/charges/ch_123", data)
+	assert.Equal(t, map[string]interface{}{"amount": 200}, mutated)
+	assert.Equal(t, map[string]interface{}{"amount": 100}, data)
+
+	// Responses that don't match or aren't objects are returned as they are
+	assert.Equal(t, data, mutators.apply("/v1/charges", data))
+	assert.Equal(t, "Bad Request", mutators.apply("/v1/charges/ch_123", "Bad Request"))
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stripe/stripe-mock/errors"
+	"github.com/stripe/stripe-mock/store"
+)
+
+// paymentIntentTransition describes an action that moves a PaymentIntent
+// from one status to another.
+type paymentIntentTransition struct {
+	// from are the statuses that a PaymentIntent may have for the action to be
+	// taken on it.
+	from []string
+
+	// participle describes a PaymentIntent that the action was taken on, like
+	// `captured`. It's used in error messages.
+	participle string
+}
+
+// allows checks whether the action can be taken on a PaymentIntent with the
+// given status.
+func (t *paymentIntentTransition) allows(status string) bool {
+	return indexOfString(t.from, status) != -1
+}
+
+// checkPaymentIntentAction checks whether an action can be taken on a stored
+// PaymentIntent given its current status, returning an error like the Stripe
+// API's if it can't. Actions other than those in paymentIntentTransitions are
+// always allowed.
+func checkPaymentIntentAction(action string, object map[string]interface{}) error {
+	transition, ok := paymentIntentTransitions[action]
+	if !ok {
+		return nil
+	}
+
+	status := paymentIntentStatus(object)
+	if transition.allows(status) {
+		return nil
+	}
+
+	from := make([]string, len(transition.from))
+	for i, fromStatus := range transition.from {
+		from[i] = paymentIntentStatusName(object, fromStatus)
+	}
+	return &invalidRequestError{
+		code: paymentIntentUnexpectedStateCode,
+		message: fmt.Sprintf(paymentIntentUnexpectedState, action,
+			paymentIntentStatusName(object, status), transition.participle,
+			strings.Join(from, ", ")),
+	}
+}
+
+// confirmPaymentIntent confirms a PaymentIntent, which needs a payment method
+// to be confirmed. One with manual capture is left to be captured.
+func confirmPaymentIntent(object map[string]interface{}) error {
+	if !hasPaymentMethod(object) {
+		return &invalidRequestError{
+			code:    paymentIntentUnexpectedStateCode,
+			message: paymentIntentMissingPaymentMethod,
+		}
+	}
+
+	if object["capture_method"] == "manual" {
+		setPaymentIntentStatus(object, "requires_capture")
+		setExistingField(object, "amount_capturable", object["amount"])
+	} else {
+		setPaymentIntentStatus(object, "succeeded")
+		setExistingField(object, "amount_capturable", 0)
+		setExistingField(object, "amount_received", object["amount"])
+	}
+	setExistingField(object, "last_payment_error", nil)
+	return nil
+}
+
+// declinePaymentIntent records that a stored PaymentIntent was declined as it
+// was being confirmed with one of the test cards, leaving it to need a new
+// payment method. Nothing is done if the request wasn't to confirm a stored
+// PaymentIntent.
+//
+// Returns an error if the PaymentIntent couldn't have been confirmed in the
+// first place.
+func declinePaymentIntent(resourceStore *store.ResourceStore, pathParams *PathParamsMap,
+	requestPath string, e *errors.Error) error {
+
+	if pathParams == nil || pathParams.PrimaryID == nil ||
+		!strings.HasPrefix(requestPath, "/v1/payment_intents/") ||
+		!strings.HasSuffix(requestPath, "/confirm") {
+		return nil
+	}
+
+	id := *pathParams.PrimaryID
+	stored, ok := resourceStore.Get(paymentIntentResource, id)
+	if !ok {
+		return nil
+	}
+
+	err := checkPaymentIntentAction("confirm", stored)
+	if err != nil {
+		return err
+	}
+
+	object := store.CopyValue(stored).(map[string]interface{})
+	setPaymentIntentDeclined(object, e)
+	resourceStore.Put(paymentIntentResource, id, object)
+	return nil
+}
+
+// initializePaymentIntent sets the status of a
+// PaymentIntent that was just created, which depends on whether it was given
+// a payment method and whether it was confirmed right away.
+func (g *DataGenerator) initializePaymentIntent(params *GenerateParams,
+	object map[string]interface{}) error {
+
+	// The fixture's payment method would otherwise stand in for one that
+	// wasn't given
+	for _, key := range paymentMethodKeys {
+		if value, ok := params.RequestData[key]; ok {
+			setExistingField(object, key, value)
+		} else {
+			setExistingField(object, key, nil)
+		}
+	}
+
+	setExistingField(object, "amount_capturable", 0)
+	setExistingField(object, "amount_received", 0)
+	setExistingField(object, "canceled_at", nil)
+	setExistingField(object, "last_payment_error", nil)
+
+	if hasPaymentMethod(object) {
+		setPaymentIntentStatus(object, "requires_confirmation")
+	} else {
+		setPaymentIntentStatus(object, "requires_payment_method")
+	}
+
+	for _, key := range confirmKeys {
+		if confirm := params.RequestData[key]; confirm == true || confirm == "true" {
+			return confirmPaymentIntent(object)
+		}
+	}
+	return nil
+}
+
+// transitionPaymentIntent takes an action on a stored
+// PaymentIntent like `confirm`, moving it to its next status (see
+// paymentIntentTransitions). The action must already have been checked with
+// checkPaymentIntentAction.
+func (g *DataGenerator) transitionPaymentIntent(params *GenerateParams, action string,
+	object map[string]interface{}) error {
+
+	switch action {
+	case "cancel":
+		setPaymentIntentStatus(object, "canceled")
+		setExistingField(object, "amount_capturable", 0)
+		setExistingField(object, "canceled_at", g.requestTime())
+
+	case "capture":
+		amount, ok := params.RequestData["amount_to_capture"]
+		if !ok {
+			amount = object["amount_capturable"]
+		}
+		setPaymentIntentStatus(object, "succeeded")
+		setExistingField(object, "amount_capturable", 0)
+		setExistingField(object, "amount_received", amount)
+
+	case "confirm":
+		// A payment method may be given as the PaymentIntent is confirmed
+		for _, key := range paymentMethodKeys {
+			if value, ok := params.RequestData[key]; ok {
+				setExistingField(object, key, value)
+			}
+		}
+		return confirmPaymentIntent(object)
+	}
+	return nil
+}
+
+//
+// Private values
+//
+
+const (
+	paymentIntentMissingPaymentMethod = "You cannot confirm this " +
+		"PaymentIntent because it's missing a payment method. Update the " +
+		"PaymentIntent with a payment method and then confirm it again."
+
+	paymentIntentUnexpectedState = "You cannot %s this PaymentIntent because " +
+		"it has a status of %s. Only a PaymentIntent with one of the following " +
+		"statuses may be %s: %s."
+
+	paymentIntentUnexpectedStateCode = "payment_intent_unexpected_state"
+)
+
+// paymentIntentResource is the name of the PaymentIntent resource.
+const paymentIntentResource = "payment_intent"
+
+// confirmKeys are the parameters that confirm a PaymentIntent as it's
+// created, depending on the API version.
+var confirmKeys = []string{"attempt_confirmation", "confirm"}
+
+// legacyPaymentIntentStatuses maps PaymentIntent statuses to the names that
+// they had in API versions from before PaymentIntents used payment methods
+// (whose PaymentIntents have a `source` instead).
+var legacyPaymentIntentStatuses = map[string]string{
+	"requires_action":         "requires_source_action",
+	"requires_payment_method": "requires_source",
+}
+
+// paymentIntentTransitions maps the actions that can be taken on a stored
+// PaymentIntent to the statuses that it must have for them to be taken.
+var paymentIntentTransitions = map[string]*paymentIntentTransition{
+	"cancel": {
+		from: []string{"requires_payment_method", "requires_capture",
+			"requires_confirmation", "requires_action"},
+		participle: "canceled",
+	},
+	"capture": {
+		from:       []string{"requires_capture"},
+		participle: "captured",
+	},
+	"confirm": {
+		from: []string{"requires_payment_method", "requires_confirmation",
+			"requires_action"},
+		participle: "confirmed",
+	},
+}
+
+// paymentMethodKeys are the fields of a PaymentIntent that hold its payment
+// method, depending on the API version.
+var paymentMethodKeys = []string{"payment_method", "source"}
+
+//
+// Private functions
+//
+
+// hasPaymentMethod checks whether a PaymentIntent has a payment method (or a
+// source in older API versions).
+func hasPaymentMethod(object map[string]interface{}) bool {
+	for _, key := range paymentMethodKeys {
+		if value, ok := object[key]; ok && value != nil && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isLegacyPaymentIntent checks whether a PaymentIntent is from an API version
+// that uses the legacy names of statuses (see legacyPaymentIntentStatuses).
+func isLegacyPaymentIntent(object map[string]interface{}) bool {
+	_, ok := object["payment_method"]
+	return !ok
+}
+
+// paymentIntentStatus gets the status of a PaymentIntent, translating a
+// legacy status into its current name.
+func paymentIntentStatus(object map[string]interface{}) string {
+	status, _ := object["status"].(string)
+	for current, legacy := range legacyPaymentIntentStatuses {
+		if status == legacy {
+			return current
+		}
+	}
+	return status
+}
+
+// paymentIntentStatusName gets the name of a status for the API version of a
+// PaymentIntent.
+func paymentIntentStatusName(object map[string]interface{}, status string) string {
+	if legacy, ok := legacyPaymentIntentStatuses[status]; ok &&
+		isLegacyPaymentIntent(object) {
+
+		return legacy
+	}
+	return status
+}
+
+// setExistingField sets a field of an object, but only if the object already
+// has it, so that fields that aren't in its schema aren't added.
+func setExistingField(object map[string]interface{}, key string, value interface{}) {
+	if _, ok := object[key]; ok {
+		object[key] = value
+	}
+}
+
+// setPaymentIntentDeclined leaves a PaymentIntent needing a new payment
+// method after a payment with it failed with a card error, which is recorded
+// as its `last_payment_error`.
+func setPaymentIntentDeclined(object map[string]interface{}, e *errors.Error) {
+	setPaymentIntentStatus(object, "requires_payment_method")
+	setExistingField(object, "last_payment_error", map[string]interface{}{
+		"code":         e.Code,
+		"decline_code": e.DeclineCode,
+		"message":      e.Message,
+		"type":         e.Type,
+	})
+}
+
+// setPaymentIntentStatus sets the status of a PaymentIntent using the name
+// of the status for its API version.
+func setPaymentIntentStatus(object map[string]interface{}, status string) {
+	object["status"] = paymentIntentStatusName(object, status)
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/errors"
+	"github.com/stripe/stripe-mock/store"
+)
+
+//
+// Tests
+//
+
+func TestCheckPaymentIntentAction(t *testing.T) {
+	object := map[string]interface{}{
+		"payment_method": nil,
+		"status":         "requires_capture",
+	}
+	assert.NoError(t, checkPaymentIntentAction("capture", object))
+	assert.NoError(t, checkPaymentIntentAction("cancel", object))
+
+	err := checkPaymentIntentAction("confirm", object)
+	assert.Equal(t, &invalidRequestError{
+		code: paymentIntentUnexpectedStateCode,
+		message: "You cannot confirm this PaymentIntent because it has a " +
+			"status of requires_capture. Only a PaymentIntent with one of the " +
+			"following statuses may be confirmed: requires_payment_method, " +
+			"requires_confirmation, requires_action.",
+	}, err)
+
+	// Actions without any transitions are always allowed
+	assert.NoError(t, checkPaymentIntentAction("", object))
+}
+
+func TestCheckPaymentIntentAction_Legacy(t *testing.T) {
+	// Without `payment_method`, the PaymentIntent is from an API version that
+	// names statuses after sources
+	object := map[string]interface{}{
+		"source": nil,
+		"status": "requires_source",
+	}
+	assert.NoError(t, checkPaymentIntentAction("confirm", object))
+
+	err := checkPaymentIntentAction("capture", object)
+	assert.Equal(t, "You cannot capture this PaymentIntent because it has a "+
+		"status of requires_source. Only a PaymentIntent with one of the "+
+		"following statuses may be captured: requires_capture.", err.Error())
+}
+
+func TestDeclinePaymentIntent(t *testing.T) {
+	resourceStore := store.NewResourceStore()
+	resourceStore.Put(paymentIntentResource, "pi_123", map[string]interface{}{
+		"id":                 "pi_123",
+		"last_payment_error": nil,
+		"payment_method":     "pm_123",
+		"status":             "requires_confirmation",
+	})
+	id := "pi_123"
+	pathParams := &PathParamsMap{PrimaryID: &id}
+	e := &errors.Error{
+		Code:        "card_declined",
+		DeclineCode: "generic_decline",
+		Message:     "Your card was declined.",
+		Type:        "card_error",
+	}
+
+	err := declinePaymentIntent(resourceStore, pathParams,
+		"/v1/payment_intents/pi_123/confirm", e)
+	assert.NoError(t, err)
+
+	stored, ok := resourceStore.Get(paymentIntentResource, "pi_123")
+	assert.True(t, ok)
+	assert.Equal(t, "requires_payment_method", stored["status"])
+	assert.Equal(t, map[string]interface{}{
+		"code":         "card_declined",
+		"decline_code": "generic_decline",
+		"message":      "Your card was declined.",
+		"type":         "card_error",
+	}, stored["last_payment_error"])
+
+	// A PaymentIntent that can't be confirmed isn't declined
+	resourceStore.Put(paymentIntentResource, "pi_123", map[string]interface{}{
+		"id":             "pi_123",
+		"payment_method": "pm_123",
+		"status":         "succeeded",
+	})
+	err = declinePaymentIntent(resourceStore, pathParams,
+		"/v1/payment_intents/pi_123/confirm", e)
+	assert.Error(t, err)
+
+	// Nor is one for a request other than a confirmation
+	err = declinePaymentIntent(resourceStore, nil, "/v1/payment_intents", e)
+	assert.NoError(t, err)
+}
+
+func TestInitializePaymentIntent(t *testing.T) {
+	generator := DataGenerator{}
+	newObject := func() map[string]interface{} {
+		return map[string]interface{}{
+			"amount":          100,
+			"amount_received": 100,
+			"capture_method":  "automatic",
+			"payment_method":  "pm_fixture",
+			"status":          "succeeded",
+		}
+	}
+
+	object := newObject()
+	err := generator.initializePaymentIntent(&GenerateParams{
+		RequestData: map[string]interface{}{},
+	}, object)
+	assert.NoError(t, err)
+	assert.Equal(t, "requires_payment_method", object["status"])
+	assert.Nil(t, object["payment_method"])
+	assert.Equal(t, 0, object["amount_received"])
+
+	object = newObject()
+	err = generator.initializePaymentIntent(&GenerateParams{
+		RequestData: map[string]interface{}{"payment_method": "pm_123"},
+	}, object)
+	assert.NoError(t, err)
+	assert.Equal(t, "requires_confirmation", object["status"])
+	assert.Equal(t, "pm_123", object["payment_method"])
+
+	object = newObject()
+	err = generator.initializePaymentIntent(&GenerateParams{
+		RequestData: map[string]interface{}{
+			"confirm":        true,
+			"payment_method": "pm_123",
+		},
+	}, object)
+	assert.NoError(t, err)
+	assert.Equal(t, "succeeded", object["status"])
+	assert.Equal(t, 100, object["amount_received"])
+
+	// Confirming without a payment method fails
+	object = newObject()
+	err = generator.initializePaymentIntent(&GenerateParams{
+		RequestData: map[string]interface{}{"confirm": "true"},
+	}, object)
+	assert.Equal(t, paymentIntentMissingPaymentMethod, err.Error())
+}
+
+func TestTransitionPaymentIntent(t *testing.T) {
+	generator := DataGenerator{now: time.Unix(1500000000, 0)}
+	object := map[string]interface{}{
+		"amount":            100,
+		"amount_capturable": 0,
+		"amount_received":   0,
+		"canceled_at":       nil,
+		"capture_method":    "manual",
+		"payment_method":    nil,
+		"status":            "requires_payment_method",
+	}
+
+	err := generator.transitionPaymentIntent(&GenerateParams{
+		RequestData: map[string]interface{}{"payment_method": "pm_123"},
+	}, "confirm", object)
+	assert.NoError(t, err)
+	assert.Equal(t, "requires_capture", object["status"])
+	assert.Equal(t, "pm_123", object["payment_method"])
+	assert.Equal(t, 100, object["amount_capturable"])
+
+	err = generator.transitionPaymentIntent(&GenerateParams{
+		RequestData: map[string]interface{}{"amount_to_capture": 60},
+	}, "capture", object)
+	assert.NoError(t, err)
+	assert.Equal(t, "succeeded", object["status"])
+	assert.Equal(t, 0, object["amount_capturable"])
+	assert.Equal(t, 60, object["amount_received"])
+
+	object["status"] = "requires_confirmation"
+	err = generator.transitionPaymentIntent(&GenerateParams{
+		RequestData: map[string]interface{}{},
+	}, "cancel", object)
+	assert.NoError(t, err)
+	assert.Equal(t, "canceled", object["status"])
+	assert.Equal(t, int64(1500000000), object["canceled_at"])
+}
+
+func TestStubServer_PaymentIntentTransitions(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=1000&currency=usd&allowed_source_types[]=card&capture_method=manual",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data := decodeResponse(t, body)
+	assert.Equal(t, "requires_source", data["status"])
+	path := "/v1/payment_intents/" + data["id"].(string)
+
+	// Capturing before confirming isn't allowed
+	resp, body = sendRequestToServer(t, server, "POST", path+"/capture", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, paymentIntentUnexpectedStateCode, errorInfo["code"])
+
+	// A declined card leaves the PaymentIntent needing another source
+	resp, body = sendRequestToServer(t, server, "POST", path+"/confirm",
+		"source=tok_chargeDeclined", getDefaultHeaders())
+	assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	paymentIntent := errorInfo["payment_intent"].(map[string]interface{})
+	assert.Equal(t, data["id"], paymentIntent["id"])
+	assert.Equal(t, "requires_source", paymentIntent["status"])
+
+	resp, body = sendRequestToServer(t, server, "GET", path, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "requires_source", decodeResponse(t, body)["status"])
+
+	resp, body = sendRequestToServer(t, server, "POST", path+"/confirm",
+		"source=tok_visa", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data = decodeResponse(t, body)
+	assert.Equal(t, "requires_capture", data["status"])
+	assert.Equal(t, 1000.0, data["amount_capturable"])
+
+	resp, body = sendRequestToServer(t, server, "POST", path+"/capture", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data = decodeResponse(t, body)
+	assert.Equal(t, "succeeded", data["status"])
+	assert.Equal(t, 1000.0, data["amount_received"])
+
+	// A PaymentIntent that succeeded can't be canceled
+	resp, _ = sendRequestToServer(t, server, "POST", path+"/cancel", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
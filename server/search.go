@@ -0,0 +1,472 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stripe/stripe-mock/spec"
+)
+
+// searchClause is a single condition of a searchQuery, like `amount>1000` or
+// `-metadata["order_id"]:"123"`.
+type searchClause struct {
+	// field is the path to the compared field, like `["metadata",
+	// "order_id"]` for `metadata["order_id"]`.
+	field []string
+
+	// negated inverts the clause, so that it matches objects that the
+	// comparison doesn't. It's written as a leading `-`.
+	negated bool
+
+	// operator is the comparison: `:` for equality, `~` for a substring, or
+	// one of `>`, `<`, `>=`, and `<=` for numbers.
+	operator string
+
+	// value is what the field is compared to. It's a string, a float64, a
+	// bool, or nil for `null`.
+	value interface{}
+}
+
+// matches checks whether an object matches the clause.
+func (c *searchClause) matches(object map[string]interface{}) bool {
+	return c.compare(lookupSearchField(object, c.field)) != c.negated
+}
+
+// compare compares the value of the clause's field in an object to the
+// clause's value. Strings are compared case-insensitively.
+func (c *searchClause) compare(fieldValue interface{}) bool {
+	switch c.operator {
+	case ":":
+		switch value := c.value.(type) {
+		case string:
+			fieldString, ok := fieldValue.(string)
+			return ok && strings.EqualFold(fieldString, value)
+		case float64:
+			number, ok := searchNumber(fieldValue)
+			return ok && number == value
+		}
+		return fieldValue == c.value
+
+	case "~":
+		fieldString, ok := fieldValue.(string)
+		return ok && strings.Contains(strings.ToLower(fieldString),
+			strings.ToLower(c.value.(string)))
+	}
+
+	number, ok := searchNumber(fieldValue)
+	if !ok {
+		return false
+	}
+	value := c.value.(float64)
+
+	switch c.operator {
+	case ">":
+		return number > value
+	case "<":
+		return number < value
+	case ">=":
+		return number >= value
+	case "<=":
+		return number <= value
+	}
+	return false
+}
+
+// searchQuery is a parsed query of the Search API, like
+// `email:"jenny@example.com" AND metadata["order_id"]:"123"`.
+type searchQuery struct {
+	clauses []*searchClause
+
+	// or is whether objects need to match any of the clauses instead of all
+	// of them. A query can't combine `AND` and `OR`.
+	or bool
+}
+
+// matches checks whether an object matches the query.
+func (q *searchQuery) matches(object map[string]interface{}) bool {
+	for _, clause := range q.clauses {
+		if clause.matches(object) == q.or {
+			return q.or
+		}
+	}
+	return !q.or
+}
+
+// searchQueryParser parses a query of the Search API (see parseSearchQuery).
+type searchQueryParser struct {
+	input string
+	pos   int
+}
+
+// done checks whether the whole query was parsed.
+func (p *searchQueryParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+// errorf produces an error for a malformed query at the current position.
+func (p *searchQueryParser) errorf(format string, args ...interface{}) error {
+	reason := fmt.Sprintf(format, args...)
+	if !p.done() {
+		reason = fmt.Sprintf("%s at character %d", reason, p.pos+1)
+	}
+	return &invalidRequestError{
+		message: fmt.Sprintf(searchQueryInvalid, reason, p.input),
+		param:   "query",
+	}
+}
+
+// parse parses the whole query.
+func (p *searchQueryParser) parse() (*searchQuery, error) {
+	query := &searchQuery{}
+	var combinator string
+
+	p.skipSpace()
+	if p.done() {
+		return nil, p.errorf("the query is empty")
+	}
+
+	for {
+		clause, err := p.parseClause()
+		if err != nil {
+			return nil, err
+		}
+		query.clauses = append(query.clauses, clause)
+
+		if !p.skipSpace() {
+			if p.done() {
+				break
+			}
+			return nil, p.errorf("expected a space after a clause")
+		}
+		if p.done() {
+			break
+		}
+
+		wordPos := p.pos
+		word := p.readUntilSpace()
+		if word != "AND" && word != "OR" {
+			p.pos = wordPos
+			return nil, p.errorf("expected AND or OR between clauses, but got %q", word)
+		}
+		if combinator != "" && word != combinator {
+			p.pos = wordPos
+			return nil, p.errorf("AND and OR can't be combined in the same query")
+		}
+		combinator = word
+
+		if !p.skipSpace() || p.done() {
+			return nil, p.errorf("expected a clause after %s", word)
+		}
+	}
+
+	if len(query.clauses) > searchMaxClauses {
+		return nil, p.errorf("a query can have at most %d clauses", searchMaxClauses)
+	}
+
+	query.or = combinator == "OR"
+	return query, nil
+}
+
+// parseClause parses a clause like `amount>1000`.
+func (p *searchQueryParser) parseClause() (*searchClause, error) {
+	clause := &searchClause{}
+	if strings.HasPrefix(p.input[p.pos:], "-") {
+		clause.negated = true
+		p.pos++
+	}
+
+	field, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+	clause.field = field
+
+	for _, operator := range []string{">=", "<=", ":", "~", ">", "<"} {
+		if strings.HasPrefix(p.input[p.pos:], operator) {
+			clause.operator = operator
+			p.pos += len(operator)
+			break
+		}
+	}
+	if clause.operator == "" {
+		return nil, p.errorf("expected an operator like : after %s",
+			strings.Join(field, "."))
+	}
+
+	valuePos := p.pos
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	clause.value = value
+
+	switch clause.operator {
+	case "~":
+		if s, ok := value.(string); !ok || len(s) < searchSubstringMinLength {
+			p.pos = valuePos
+			return nil, p.errorf("~ needs a string of at least %d characters",
+				searchSubstringMinLength)
+		}
+	case ">", "<", ">=", "<=":
+		if _, ok := value.(float64); !ok {
+			p.pos = valuePos
+			return nil, p.errorf("%s needs a number", clause.operator)
+		}
+	}
+
+	return clause, nil
+}
+
+// parseField parses the field of a clause, which is a dotted path like
+// `shipping.address.city`, or a key of a map like `metadata["order_id"]`.
+func (p *searchQueryParser) parseField() ([]string, error) {
+	var field []string
+	for {
+		start := p.pos
+		for !p.done() && isSearchFieldChar(p.input[p.pos]) {
+			p.pos++
+		}
+		if p.pos == start {
+			return nil, p.errorf("expected a field")
+		}
+		field = append(field, p.input[start:p.pos])
+
+		if !strings.HasPrefix(p.input[p.pos:], ".") {
+			break
+		}
+		p.pos++
+	}
+
+	if strings.HasPrefix(p.input[p.pos:], "[") {
+		p.pos++
+		key, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(p.input[p.pos:], "]") {
+			return nil, p.errorf("expected ] after a key")
+		}
+		p.pos++
+		field = append(field, key)
+	}
+
+	return field, nil
+}
+
+// parseQuoted parses a string surrounded by double or single quotes. A quote
+// inside it can be escaped with a backslash.
+func (p *searchQueryParser) parseQuoted() (string, error) {
+	if p.done() || (p.input[p.pos] != '"' && p.input[p.pos] != '\'') {
+		return "", p.errorf("expected a quoted string")
+	}
+	quote := p.input[p.pos]
+	p.pos++
+
+	var value strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		p.pos++
+		switch {
+		case c == '\\' && !p.done():
+			value.WriteByte(p.input[p.pos])
+			p.pos++
+		case c == quote:
+			return value.String(), nil
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// parseValue parses the value of a clause: a quoted string, a number, true,
+// false, or null.
+func (p *searchQueryParser) parseValue() (interface{}, error) {
+	if !p.done() && (p.input[p.pos] == '"' || p.input[p.pos] == '\'') {
+		return p.parseQuoted()
+	}
+
+	start := p.pos
+	word := p.readUntilSpace()
+	switch word {
+	case "":
+		return nil, p.errorf("expected a value")
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	number, err := strconv.ParseFloat(word, 64)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("strings must be quoted")
+	}
+	return number, nil
+}
+
+// readUntilSpace reads up to the next space or the end of the query.
+func (p *searchQueryParser) readUntilSpace() string {
+	start := p.pos
+	for !p.done() && p.input[p.pos] != ' ' {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// skipSpace skips over spaces, returning whether there were any.
+func (p *searchQueryParser) skipSpace() bool {
+	start := p.pos
+	for !p.done() && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// searchStore produces a page of search results from the stored objects that
+// match the request's query, most recently created first. Returns false if
+// the results can't contain stored objects, in which case they should be
+// generated from fixtures instead.
+//
+// Pages are requested with `page`, a cursor returned as `next_page` by the
+// previous page.
+func (g *DataGenerator) searchStore(params *GenerateParams,
+	schema *spec.Schema) (interface{}, bool, error) {
+
+	query, err := parseSearchRequest(params.RequestData)
+	if err != nil {
+		return nil, false, err
+	}
+
+	itemSchemas, err := g.findListItemSchemas(schema.Properties["data"].Items)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(itemSchemas) == 0 {
+		return nil, false, nil
+	}
+
+	pagination, err := parseListPagination(params.RequestData)
+	if err != nil {
+		return nil, false, err
+	}
+
+	start := 0
+	if page, ok := params.RequestData["page"].(string); ok && page != "" {
+		start, err = strconv.Atoi(strings.TrimPrefix(page, searchPagePrefix))
+		if err != nil || !strings.HasPrefix(page, searchPagePrefix) || start < 0 {
+			return nil, false, &invalidRequestError{
+				message: fmt.Sprintf("Invalid page: %s", page),
+				param:   "page",
+			}
+		}
+	}
+
+	objects, _ := g.listStoredObjects(params, itemSchemas)
+	var results []map[string]interface{}
+	for _, object := range objects {
+		if query.matches(object) {
+			results = append(results, object)
+		}
+	}
+
+	start = minInt(start, len(results))
+	end := minInt(start+pagination.limit, len(results))
+	itemData, err := g.expandStoredItems(params, itemSchemas, results[start:end])
+	if err != nil {
+		return nil, false, err
+	}
+
+	searchData := buildListResource(&GenerateParams{
+		RequestPath: params.RequestPath,
+		Schema:      schema,
+	}, itemData)
+	hasMore := end < len(results)
+	setListPageInfo(searchData, hasMore, len(results))
+	if _, ok := searchData["next_page"]; ok && hasMore {
+		searchData["next_page"] = searchPagePrefix + strconv.Itoa(end)
+	}
+	setListURL(searchData, params.RequestPath)
+	return searchData, true, nil
+}
+
+//
+// Private values
+//
+
+// searchMaxClauses is the maximum number of clauses in a search query.
+const searchMaxClauses = 10
+
+// searchPagePrefix is the prefix of the cursors of pages of search results.
+// It's followed by the position of the page's first result.
+const searchPagePrefix = "page_"
+
+// searchQueryInvalid is the message of the error produced for a malformed
+// search query.
+const searchQueryInvalid = "Invalid search query: %s. Query: %s"
+
+// searchSubstringMinLength is the minimum length of the string that a field
+// is searched for with `~`.
+const searchSubstringMinLength = 3
+
+//
+// Private functions
+//
+
+// isSearchFieldChar checks whether a character can be part of the name of a
+// field in a search query.
+func isSearchFieldChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9'
+}
+
+// lookupSearchField gets the value at a path of fields in an object, or nil
+// if it doesn't exist.
+func lookupSearchField(object map[string]interface{}, field []string) interface{} {
+	var value interface{} = object
+	for _, key := range field {
+		mapValue, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = mapValue[key]
+	}
+	return value
+}
+
+// parseSearchQuery parses a query of the Search API, returning an
+// invalidRequestError describing the problem with one that's malformed.
+func parseSearchQuery(query string) (*searchQuery, error) {
+	parser := &searchQueryParser{input: query}
+	return parser.parse()
+}
+
+// parseSearchRequest parses the query of a request to a search endpoint,
+// which is required.
+func parseSearchRequest(requestData map[string]interface{}) (*searchQuery, error) {
+	query, ok := requestData["query"].(string)
+	if !ok {
+		return nil, &invalidRequestError{
+			message: "Missing required param: query.",
+			param:   "query",
+		}
+	}
+	return parseSearchQuery(query)
+}
+
+// searchNumber gets a numeric field of a stored object as a float64, whatever
+// its type.
+func searchNumber(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case int:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	}
+	return 0, false
+}
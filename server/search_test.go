@@ -0,0 +1,242 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+//
+// Tests
+//
+
+func TestParseSearchQuery(t *testing.T) {
+	query, err := parseSearchQuery(`email:"jenny@example.com"`)
+	assert.NoError(t, err)
+	assert.Equal(t, &searchQuery{clauses: []*searchClause{
+		{field: []string{"email"}, operator: ":", value: "jenny@example.com"},
+	}}, query)
+
+	query, err = parseSearchQuery(`metadata['order_id']:'12\'3' AND ` +
+		`-shipping.address.city~"Paris" AND amount>=1000 AND refunded:false`)
+	assert.NoError(t, err)
+	assert.Equal(t, &searchQuery{clauses: []*searchClause{
+		{field: []string{"metadata", "order_id"}, operator: ":", value: "12'3"},
+		{field: []string{"shipping", "address", "city"}, negated: true,
+			operator: "~", value: "Paris"},
+		{field: []string{"amount"}, operator: ">=", value: 1000.0},
+		{field: []string{"refunded"}, operator: ":", value: false},
+	}}, query)
+
+	query, err = parseSearchQuery(`  status:"failed" OR customer:null  `)
+	assert.NoError(t, err)
+	assert.Equal(t, &searchQuery{clauses: []*searchClause{
+		{field: []string{"status"}, operator: ":", value: "failed"},
+		{field: []string{"customer"}, operator: ":", value: nil},
+	}, or: true}, query)
+}
+
+func TestParseSearchQuery_Invalid(t *testing.T) {
+	testCases := map[string]string{
+		``:                        "the query is empty",
+		`email`:                   "expected an operator like : after email",
+		`:"foo"`:                  "expected a field at character 1",
+		`email:jenny`:             "strings must be quoted at character 7",
+		`email:"jenny`:            "unterminated string",
+		`email:"a"status:"b"`:     "expected a space after a clause at character 10",
+		`email:"a" status:"b"`:    `expected AND or OR between clauses, but got "status:\"b\"" at character 11`,
+		`email:"a" AND`:           "expected a clause after AND",
+		`a:1 AND b:2 OR c:3`:      "AND and OR can't be combined in the same query at character 13",
+		`amount>"1000"`:           "> needs a number at character 8",
+		`email~"je"`:              "~ needs a string of at least 3 characters at character 7",
+		`metadata["order_id":"1"`: "expected ] after a key at character 20",
+		`a:1 OR a:2 OR a:3 OR a:4 OR a:5 OR a:6 OR a:7 OR a:8 OR a:9 OR a:10 OR a:11`: "a query can have at most 10 clauses",
+	}
+	for query, reason := range testCases {
+		t.Run(query, func(t *testing.T) {
+			_, err := parseSearchQuery(query)
+			assert.Equal(t, &invalidRequestError{
+				message: "Invalid search query: " + reason + ". Query: " + query,
+				param:   "query",
+			}, err)
+		})
+	}
+}
+
+func TestSearchQuery_Matches(t *testing.T) {
+	object := map[string]interface{}{
+		"amount":   1000,
+		"customer": nil,
+		"email":    "Jenny@example.com",
+		"metadata": map[string]interface{}{"order_id": "123"},
+		"refunded": false,
+	}
+
+	testCases := map[string]bool{
+		`email:"jenny@example.com"`:                     true,
+		`email:"jenny"`:                                 false,
+		`email~"JENNY"`:                                 true,
+		`-email~"jenny"`:                                false,
+		`metadata["order_id"]:"123"`:                    true,
+		`metadata["order_id"]:"456"`:                    false,
+		`metadata["missing"]:null`:                      true,
+		`customer:null`:                                 true,
+		`refunded:false`:                                true,
+		`amount:1000`:                                   true,
+		`amount>999 AND amount<=1000`:                   true,
+		`amount>1000`:                                   false,
+		`email>1`:                                       false,
+		`amount>1000 AND email:"jenny@example.com"`:     false,
+		`amount>1000 OR email:"jenny@example.com"`:      true,
+		`amount>1000 OR metadata["order_id"]:"456"`:     false,
+		`metadata.order_id:"123" AND -customer:"cus_1"`: true,
+	}
+	for raw, expected := range testCases {
+		t.Run(raw, func(t *testing.T) {
+			query, err := parseSearchQuery(raw)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, query.matches(object))
+		})
+	}
+}
+
+func TestStubServer_Search(t *testing.T) {
+	server := &StubServer{spec: getSearchSpec(), fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// Without stored objects, the generated object is found by any query
+	resp, body := sendRequestToServer(t, server, "GET",
+		"/v1/charges/search?query="+url.QueryEscape(`status:"succeeded"`), "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data := decodeResponse(t, body)
+	assert.Equal(t, "search_result", data["object"])
+	assert.Equal(t, "/v1/charges/search", data["url"])
+	assert.Equal(t, false, data["has_more"])
+	assert.Nil(t, data["next_page"])
+	assert.Equal(t, 1, len(data["data"].([]interface{})))
+
+	// But the query must be valid
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/charges/search?query="+url.QueryEscape(`status:succeeded`), "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "query", errorInfo["param"])
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges/search", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStubServer_SearchStateful(t *testing.T) {
+	server := &StubServer{spec: getSearchSpec(), fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	var ids []string
+	for _, params := range []string{
+		"amount=100&metadata[order]=a",
+		"amount=200&metadata[order]=b",
+		"amount=300&metadata[order]=a",
+		"amount=400&metadata[order]=a",
+	} {
+		resp, body := sendRequestToServer(t, server, "POST", "/v1/charges", params,
+			getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		ids = append(ids, decodeResponse(t, body)["id"].(string))
+	}
+
+	search := func(query string, page string) map[string]interface{} {
+		path := "/v1/charges/search?limit=2&query=" + url.QueryEscape(query)
+		if page != "" {
+			path += "&page=" + page
+		}
+		resp, body := sendRequestToServer(t, server, "GET", path, "",
+			getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		return decodeResponse(t, body)
+	}
+	resultIDs := func(data map[string]interface{}) []string {
+		var resultIDs []string
+		for _, item := range data["data"].([]interface{}) {
+			resultIDs = append(resultIDs, item.(map[string]interface{})["id"].(string))
+		}
+		return resultIDs
+	}
+
+	// Results are the most recently created matching objects first, and are
+	// paged through with next_page
+	data := search(`metadata['order']:'a'`, "")
+	assert.Equal(t, []string{ids[3], ids[2]}, resultIDs(data))
+	assert.Equal(t, true, data["has_more"])
+	nextPage := data["next_page"].(string)
+
+	data = search(`metadata['order']:'a'`, nextPage)
+	assert.Equal(t, []string{ids[0]}, resultIDs(data))
+	assert.Equal(t, false, data["has_more"])
+	assert.Nil(t, data["next_page"])
+
+	data = search(`amount>150 AND -metadata['order']:'a'`, "")
+	assert.Equal(t, []string{ids[1]}, resultIDs(data))
+
+	data = search(`amount:999`, "")
+	assert.Equal(t, []interface{}{}, data["data"])
+
+	// A page that wasn't returned by a search is rejected
+	resp, _ := sendRequestToServer(t, server, "GET",
+		"/v1/charges/search?page=foo&query=amount:100", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+//
+// Private functions
+//
+
+// getSearchSpec gets a spec with the charge endpoints of the real spec along
+// with a search endpoint for charges, which the real spec predates.
+func getSearchSpec() *spec.Spec {
+	return &spec.Spec{
+		Components: realSpec.Components,
+		Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+			"/v1/charges":          realSpec.Paths["/v1/charges"],
+			"/v1/charges/{charge}": realSpec.Paths["/v1/charges/{charge}"],
+			"/v1/charges/search": {
+				"get": &spec.Operation{
+					Responses: map[spec.StatusCode]spec.Response{
+						"200": {
+							Content: map[string]spec.MediaType{
+								"application/json": {
+									Schema: &spec.Schema{
+										Type: "object",
+										Properties: map[string]*spec.Schema{
+											"data": {
+												Items: &spec.Schema{
+													Ref: "#/components/schemas/charge",
+												},
+												Type: "array",
+											},
+											"has_more":  {Type: "boolean"},
+											"next_page": {Nullable: true, Type: "string"},
+											"object": {
+												Enum: []interface{}{"search_result"},
+												Type: "string",
+											},
+											"url": {Type: "string"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+// handleSeed handles a request to the internal seed endpoint, which puts
+// many objects in the store at once so that a test suite can set up its data
+// without making a request to create each one.
+//
+// The body is a JSON object that maps resources (like `charge`) to arrays of
+// their objects. Each object is merged into one generated from the resource's
+// fixture, so it only needs the fields that matter to the test, and is given
+// an ID if it doesn't have one. Every object is validated against the
+// resource's schema before any are stored, so a request that fails stores
+// nothing. Objects of the same type can't be given the same ID.
+//
+// It responds with the number of objects stored for each resource.
+func (s *StubServer) handleSeed(w http.ResponseWriter, r *http.Request, start time.Time) {
+	if s.store == nil {
+		stripeError := createStripeError(typeInvalidRequestError, seedNotStateful)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	seed, err := readSeedRequest(r)
+	if isBodyTooLarge(err) {
+		writeResponse(w, r, start, http.StatusRequestEntityTooLarge,
+			createBodyTooLargeError(s.maxBodySize))
+		return
+	}
+	if err != nil {
+		message := fmt.Sprintf(invalidSeedBody, err)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	// Objects of connected accounts are stored separately, like those that
+	// are created by requests
+	resourceStore := s.store
+	if account := r.Header.Get("Stripe-Account"); account != "" {
+		resourceStore = resourceStore.Account(account)
+	}
+
+	// Resources are seeded in a stable order so that generated IDs are the
+	// same every time with -seed
+	resources := make([]string, 0, len(seed))
+	for resource := range seed {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	generator := DataGenerator{
+		definitions: s.spec.Components.Schemas,
+		fixtures:    s.fixtures,
+		noFixtures:  s.noFixtures,
+		now:         s.generationTime(time.Now()),
+		rand:        s.newRand(),
+		strings:     s.syntheticStrings,
+	}
+	componentsForValidation := spec.GetComponentsForValidation(&s.spec.Components)
+
+	var objects []seededObject
+	ids := make(map[seededID]bool)
+	inserted := make(map[string]interface{}, len(resources))
+	for _, resource := range resources {
+		resourceObjects, err := s.seedObjects(&generator, componentsForValidation,
+			resource, seed[resource], ids)
+		if err != nil {
+			stripeError := createStripeError(typeInvalidRequestError, err.Error())
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+		objects = append(objects, resourceObjects...)
+		inserted[resource] = len(resourceObjects)
+	}
+
+	// IDs are only generated once all of those given in the request are
+	// known so that a generated one can't take an ID that's given to a later
+	// object. They may also come from a seeded source that's in the same
+	// state for every request, so make sure that one isn't taken in the store
+	// either.
+	for _, seeded := range objects {
+		if seeded.exampleID == "" {
+			continue
+		}
+
+		resourceID := seeded.object["object"].(string)
+		for {
+			id := seededID{
+				id:         generateObjectID(generator.rand, seeded.exampleID),
+				resourceID: resourceID,
+			}
+			_, exists := resourceStore.Get(resourceID, id.id)
+			if !exists && !resourceStore.Deleted(resourceID, id.id) && !ids[id] {
+				ids[id] = true
+				seeded.object["id"] = id.id
+				break
+			}
+		}
+	}
+
+	for _, seeded := range objects {
+		resourceStore.Put(seeded.object["object"].(string),
+			seeded.object["id"].(string), seeded.object)
+	}
+
+	writeResponse(w, r, start, http.StatusOK, map[string]interface{}{
+		"inserted": inserted,
+	})
+}
+
+// seedObjects builds the objects of a resource to be stored by the internal
+// seed endpoint from those given in its request (see handleSeed).
+//
+// IDs given in the request are added to ids, which holds those given to
+// objects of earlier resources. Objects that weren't given one are returned
+// with an exampleID, and should be given a new ID once all objects have been
+// built.
+//
+// Returns an error describing the problem if the resource isn't known or one
+// of its objects isn't valid or has an ID that's already taken.
+func (s *StubServer) seedObjects(generator *DataGenerator,
+	componentsForValidation *spec.ComponentsForValidation, resource string,
+	seeds []map[string]interface{}, ids map[seededID]bool) ([]seededObject, error) {
+
+	schema, ok := s.spec.Components.Schemas[resource]
+	if !ok || schema.XResourceID == "" {
+		return nil, fmt.Errorf(invalidSeedResource, resource)
+	}
+
+	schemaRef := &spec.Schema{Ref: "#/components/schemas/" + resource}
+	validator, err := spec.GetValidatorForOpenAPI3Schema(schemaRef,
+		componentsForValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]seededObject, 0, len(seeds))
+	for i, seed := range seeds {
+		data, err := generator.Generate(&GenerateParams{
+			RequestMethod: http.MethodGet,
+			Schema:        schemaRef,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The object is copied because it may share values with the fixture
+		object, ok := store.CopyValue(data).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf(invalidSeedResource, resource)
+		}
+		exampleID, _ := object["id"].(string)
+		if _, ok := object["object"].(string); !ok || exampleID == "" {
+			return nil, fmt.Errorf(invalidSeedResource, resource)
+		}
+		mergeOverrides(seed, object)
+
+		err = validateAsJSON(validator, object)
+		if err != nil {
+			return nil, fmt.Errorf(invalidSeedObject, i, resource, err)
+		}
+
+		if _, ok := seed["id"]; !ok {
+			objects = append(objects, seededObject{exampleID: exampleID, object: object})
+			continue
+		}
+
+		// Two objects with the same ID would overwrite each other in the
+		// store
+		id := seededID{id: object["id"].(string), resourceID: object["object"].(string)}
+		if ids[id] {
+			return nil, fmt.Errorf(duplicateSeedID, i, resource, id.id)
+		}
+		ids[id] = true
+
+		objects = append(objects, seededObject{object: object})
+	}
+	return objects, nil
+}
+
+//
+// Private values
+//
+
+const (
+	duplicateSeedID = "Object %d of %s has the ID %s, which is already " +
+		"given to another object in the request."
+
+	invalidSeedBody = "Couldn't decode the seed request's body as a JSON " +
+		"object mapping resources to arrays of objects: %v"
+
+	invalidSeedObject = "Object %d of %s isn't valid: %v"
+
+	invalidSeedResource = "Unrecognized resource: %s. Resources should be " +
+		"named like `charge`."
+
+	seedNotStateful = "Objects can only be seeded in stateful mode. Start " +
+		"stripe-mock with the `-stateful` option."
+)
+
+// seedPath is the path of stripe-mock's internal endpoint for putting objects
+// in the store.
+const seedPath = "/v1/_stripe_mock/seed"
+
+//
+// Private types
+//
+
+// seededID identifies an object to be stored by the internal seed endpoint.
+type seededID struct {
+	id         string
+	resourceID string
+}
+
+// seededObject is an object to be stored by the internal seed endpoint.
+type seededObject struct {
+	// exampleID is the ID of the object that the one in the request was
+	// merged into. It's empty if the request gave the object an ID, and
+	// otherwise the object is given a new ID with the same prefix.
+	exampleID string
+
+	object map[string]interface{}
+}
+
+//
+// Private functions
+//
+
+// readSeedRequest decodes the body of a request to the internal seed
+// endpoint.
+func readSeedRequest(r *http.Request) (map[string][]map[string]interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	var seed map[string][]map[string]interface{}
+	err = json.Unmarshal(body, &seed)
+	if err != nil {
+		return nil, err
+	}
+	if seed == nil {
+		return nil, fmt.Errorf("body is empty")
+	}
+	return seed, nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/store"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_SeedObjects(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/seed",
+		`{"charge": [{"id": "ch_seeded", "amount": 1234}, {"amount": 5678}],
+		  "customer": [{"email": "jenny@example.com"}]}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{
+		"inserted": map[string]interface{}{"charge": 2.0, "customer": 1.0},
+	}, decodeResponse(t, body))
+	assert.Equal(t, 3, server.store.Len())
+
+	// Seeded objects keep their ID and are otherwise filled in from fixtures
+	charge, ok := server.store.Get("charge", "ch_seeded")
+	assert.True(t, ok)
+	assert.Equal(t, 1234.0, charge["amount"])
+	assert.Equal(t, realFixtures.Resources["charge"].(map[string]interface{})["currency"],
+		charge["currency"])
+
+	// Or get a new ID with the prefix of the fixture's
+	customers := server.store.List("customer")
+	assert.Equal(t, 1, len(customers))
+	assert.True(t, strings.HasPrefix(customers[0]["id"].(string), "cus_"))
+	assert.Equal(t, "jenny@example.com", customers[0]["email"])
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_seeded", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1234.0, decodeResponse(t, body)["amount"])
+}
+
+func TestStubServer_SeedObjects_UniqueIDs(t *testing.T) {
+	seed := int64(1)
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures, seed: &seed}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/seed",
+		`{"customer": [{"email": "first@example.com"}, {}]}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	customers := server.store.List("customer")
+	assert.Equal(t, 2, len(customers))
+	assert.NotEqual(t, customers[0]["id"], customers[1]["id"])
+
+	// With the same seed, the ID generated for the first object would be the
+	// one that's given to the second
+	var generatedID string
+	for _, customer := range customers {
+		if customer["email"] == "first@example.com" {
+			generatedID = customer["id"].(string)
+		}
+	}
+	server.store = store.NewResourceStore()
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/seed",
+		`{"customer": [{}, {"id": "`+generatedID+`", "email": "jenny@example.com"}]}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{
+		"inserted": map[string]interface{}{"customer": 2.0},
+	}, decodeResponse(t, body))
+	assert.Equal(t, 2, server.store.Len())
+
+	customer, ok := server.store.Get("customer", generatedID)
+	assert.True(t, ok)
+	assert.Equal(t, "jenny@example.com", customer["email"])
+}
+
+func TestStubServer_SeedObjects_Invalid(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// Seeding needs somewhere to put the objects
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/seed",
+		`{"charge": [{"amount": 1234}]}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	server.store = store.NewResourceStore()
+
+	testCases := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"InvalidBody", `["charge"]`, "Couldn't decode the seed request's body"},
+		{"UnknownResource", `{"widget": [{}]}`, "Unrecognized resource: widget."},
+		{"InvalidObject", `{"charge": [{"amount": 123}, {"amount": "abc"}]}`,
+			"Object 1 of charge isn't valid"},
+		{"DuplicateID", `{"charge": [{"id": "ch_seeded"}, {"id": "ch_seeded"}]}`,
+			"Object 1 of charge has the ID ch_seeded, which is already given"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			resp, body := sendRequestToServer(t, server, "POST",
+				"/v1/_stripe_mock/seed", testCase.body, getDefaultHeaders())
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+			assert.Contains(t, errorInfo["message"], testCase.message)
+
+			// Nothing is stored when any object is invalid
+			assert.Equal(t, 0, server.store.Len())
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/lestrrat/go-jsval"
+	"github.com/stripe/stripe-mock/spec"
+)
+
+// validateResponse checks a generated response against the schema of the
+// route's response with the given status, which catches generator bugs that
+// would otherwise produce objects clients can't decode.
+//
+// Returns an error describing how the response doesn't match its schema, or
+// nil if it does or the route doesn't have a validator for the response.
+func (route *stubServerRoute) validateResponse(status int, data interface{}) error {
+	validator, ok := route.responseValidators[spec.StatusCode(strconv.Itoa(status))]
+	if !ok {
+		return nil
+	}
+	return validateAsJSON(validator, data)
+}
+
+//
+// Private values
+//
+
+// invalidGeneratedResponse is the message of the error sent instead of a
+// generated response that doesn't match its schema in strict self-validate
+// mode.
+const invalidGeneratedResponse = "The generated response doesn't match " +
+	"the spec's schema for it: %v"
+
+//
+// Private functions
+//
+
+// getResponseValidators builds validators for the JSON responses of an
+// operation, keyed by their status codes.
+func getResponseValidators(operation *spec.Operation,
+	componentsForValidation *spec.ComponentsForValidation) (map[spec.StatusCode]*jsval.JSVal, error) {
+
+	validators := make(map[spec.StatusCode]*jsval.JSVal)
+	for status, response := range operation.Responses {
+		responseContent, ok := response.Content["application/json"]
+		if !ok || responseContent.Schema == nil {
+			continue
+		}
+
+		validator, err := spec.GetValidatorForOpenAPI3Schema(responseContent.Schema,
+			componentsForValidation)
+		if err != nil {
+			return nil, err
+		}
+		validators[status] = validator
+	}
+	return validators, nil
+}
+
+// validateAsJSON validates a generated value as it'll be decoded by clients,
+// with all of its numbers as floats.
+func validateAsJSON(validator *jsval.JSVal, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	var decoded interface{}
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		return err
+	}
+
+	return validator.Validate(decoded)
+}
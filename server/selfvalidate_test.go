@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_SelfValidate(t *testing.T) {
+	// Fixtures that generate a charge that doesn't match its schema
+	fixtures := &spec.Fixtures{Resources: map[spec.ResourceID]interface{}{}}
+	for resource, fixture := range realFixtures.Resources {
+		fixtures.Resources[resource] = fixture
+	}
+	charge := store.CopyValue(realFixtures.Resources["charge"]).(map[string]interface{})
+	charge["amount"] = "a lot"
+	fixtures.Resources["charge"] = charge
+
+	testCases := []struct {
+		mode   string
+		status int
+	}{
+		{SelfValidateModeOff, http.StatusOK},
+		{SelfValidateModeLog, http.StatusOK},
+		{SelfValidateModeStrict, http.StatusInternalServerError},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.mode, func(t *testing.T) {
+			server := &StubServer{
+				fixtures:         &realFixtures,
+				selfValidateMode: testCase.mode,
+				spec:             &realSpec,
+			}
+			err := server.initializeRouter()
+			assert.NoError(t, err)
+
+			// Responses generated from the bundled fixtures are valid
+			resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+				getDefaultHeaders())
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			server.fixtures = fixtures
+			resp, body := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+				getDefaultHeaders())
+			assert.Equal(t, testCase.status, resp.StatusCode)
+			if testCase.status != http.StatusOK {
+				errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+				assert.Equal(t, "api_error", errorInfo["type"])
+				assert.Contains(t, errorInfo["message"],
+					fmt.Sprintf(invalidGeneratedResponse, ""))
+			}
+		})
+	}
+}
+
+func TestGetResponseValidators(t *testing.T) {
+	operation := realSpec.Paths["/v1/charges/{charge}"]["get"]
+	validators, err := getResponseValidators(operation, realComponentsForValidation)
+	assert.NoError(t, err)
+	assert.Equal(t, len(operation.Responses), len(validators))
+
+	route := &stubServerRoute{responseValidators: validators}
+	charge := store.CopyValue(realFixtures.Resources["charge"])
+	assert.NoError(t, route.validateResponse(http.StatusOK, charge))
+
+	charge.(map[string]interface{})["amount"] = "a lot"
+	assert.Error(t, route.validateResponse(http.StatusOK, charge))
+
+	// Responses without a validator aren't checked
+	assert.NoError(t, route.validateResponse(http.StatusTeapot, charge))
+}
@@ -0,0 +1,2648 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lestrrat/go-jsval"
+	"github.com/stripe/stripe-mock/errors"
+	"github.com/stripe/stripe-mock/idempotency"
+	"github.com/stripe/stripe-mock/logging"
+	"github.com/stripe/stripe-mock/metrics"
+	"github.com/stripe/stripe-mock/param"
+	"github.com/stripe/stripe-mock/param/coercer"
+	"github.com/stripe/stripe-mock/ratelimit"
+	"github.com/stripe/stripe-mock/recording"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+//
+// Public types
+//
+
+// ExpansionLevel represents expansions on a single "level" of resource. It may
+// have subexpansions that are meant to take effect on resources that are
+// nested below it (on other levels).
+type ExpansionLevel struct {
+	expansions map[string]*ExpansionLevel
+
+	// wildcard specifies that everything should be expanded.
+	wildcard bool
+}
+
+// PathParamsMap holds a collection of parameter that values that have been
+// extracted from the path of a request. This is useful to hand off to the data
+// generator so that it can use these IDs while generating results.
+type PathParamsMap struct {
+	// PrimaryID contains a value for a primary ID extracted from a request
+	// path. A "primary" object is the one being enacted on and which will be
+	// directly returned with the API's response.
+	//
+	// Note that not all endpoints have a primary ID, and in those cases this
+	// value will be nil. Examples of endpoints without a primary ID are
+	// "create" and "list" methods.
+	PrimaryID *string
+
+	// SecondaryIDs contains a collection of "secondary IDs" (i.e., not the
+	// primary ID) extracted from the request path.
+	SecondaryIDs []*PathParamsSecondaryID
+
+	// replacedPrimaryID is the old value of an ID field that's had its value
+	// replaced by PrimaryID. This is used so that we can look for other
+	// instances of this replaced ID, and also replace them.
+	//
+	// For example, if we're handling a charge and replaced an old ID `ch_old`
+	// with the new value `ch_123` (from PrimaryID), this field would contain
+	// `ch_old`. If we found another instance of `ch_old` in another field's
+	// value (say if there was embedded refund with a field called `charge`
+	// that pointed back to its parent charge ID), we'd recognize it via this
+	// field and replace it with PrimaryID.
+	//
+	// nil if no ID has been replaced.
+	replacedPrimaryID *string
+}
+
+// PathParamsSecondaryID holds the name and value for a "secondary ID" (i.e.,
+// one that is not the primary ID) found in a request path.
+type PathParamsSecondaryID struct {
+	// ID is the value of the parameter extracted from the request path.
+	ID string
+
+	// Name is the name of the parameter according to the enclosing `{}` in the
+	// OpenAPI specification.
+	//
+	// For example, it might read `fee` if extracted from:
+	//
+	//     /v1/application_fees/{fee}/refunds
+	//
+	Name string
+
+	// replacedIDs is a slice of old values for an ID field that's had its
+	// value replaced by this secondary parameter's new ID. This is used so
+	// that we can look for other instances of this
+	// replaced ID, and also replace them.
+	//
+	// This is a slice as opposed to a single value because it's possible that
+	// we could encounter multiple fields while generating a response that all
+	// represent the same entity. Say for example that a series of nested
+	// expansions have been requested, each that internalizes an entity of a
+	// parameter's type -- we load a fixture for each but there's no guarantee
+	// that the entity in each one references the same ID.
+	//
+	// For more information, see PathParamsMap.replacedPrimaryID.
+	replacedIDs []string
+}
+
+// appendReplacedID appends a replaced ID to the secondary ID's internal slice
+// of replaced IDs.
+//
+// This function skips the case of an empty string value, so its use should be
+// preferred over using the internal slice directly.
+func (p *PathParamsSecondaryID) appendReplacedID(replacedID string) {
+	if replacedID != "" {
+		p.replacedIDs = append(p.replacedIDs, replacedID)
+	}
+}
+
+// ResponseError is a JSON-serializable structure representing an error
+// returned from Stripe's API.
+type ResponseError struct {
+	ErrorInfo struct {
+		Code        string `json:"code,omitempty"`
+		DeclineCode string `json:"decline_code,omitempty"`
+		Message     string `json:"message"`
+		Param       string `json:"param,omitempty"`
+		Type        string `json:"type"`
+
+		// Charge is the ID of the charge, and PaymentIntent the
+		// PaymentIntent, of a payment that failed with a card error.
+		Charge        string                 `json:"charge,omitempty"`
+		PaymentIntent map[string]interface{} `json:"payment_intent,omitempty"`
+
+		// RequestLogURL links to the request in the Dashboard, like it does
+		// for errors from the Stripe API. It's filled in as the error is
+		// written.
+		RequestLogURL string `json:"request_log_url,omitempty"`
+	} `json:"error"`
+}
+
+// StubServer handles incoming HTTP requests and responds to them appropriately
+// based off the set of OpenAPI routes that it's been configured with.
+type StubServer struct {
+	// apiVersion is the API version of the server's spec.
+	apiVersion string
+
+	// creatableResources are the resources whose objects can be created with
+	// a `POST` to one of the routes (see DataGenerator.creatableResources).
+	// It's built along with the routing table.
+	creatableResources map[string]bool
+
+	// cors sets the headers that allow browsers to make cross-origin requests
+	// and responds to their preflight requests.
+	//
+	// nil if cross-origin requests aren't supported.
+	cors *corsConfig
+
+	// failures picks requests to fail with a `500` to simulate transient
+	// failures.
+	//
+	// nil if requests are never failed.
+	failures *failureInjector
+
+	fixtures *spec.Fixtures
+
+	// generateTimeout is how long a response may take to generate before the
+	// request gets a 503.
+	//
+	// 0 if generation isn't limited.
+	generateTimeout time.Duration
+
+	// idempotencyCache holds responses to `POST` requests that included an
+	// `Idempotency-Key` header so that they can be replayed.
+	//
+	// nil if responses shouldn't be replayed.
+	idempotencyCache *idempotency.Cache
+
+	// idMode is how the IDs of created objects are generated (see
+	// Config.IDMode).
+	//
+	// Empty to use random IDs.
+	idMode string
+
+	// latency is artificial latency added before responding to requests.
+	//
+	// nil if no latency should be added.
+	latency *latencyConfig
+
+	// maintenance is whether API requests get a `503` as if the Stripe API
+	// were down for maintenance. It's shared by the servers of every API
+	// version so that toggling it affects all of them.
+	//
+	// nil if maintenance can't be simulated.
+	maintenance *maintenanceMode
+
+	// maxBodySize is the maximum size in bytes of a request body. Requests
+	// with larger bodies are rejected with a 413.
+	//
+	// 0 if body size isn't limited.
+	maxBodySize int64
+
+	// maxExpansionDepth is the maximum number of levels that a single
+	// requested expansion may descend (e.g. `customer.default_source` has
+	// two). Requests exceeding it are rejected.
+	//
+	// 0 if expansion depth isn't limited.
+	maxExpansionDepth int
+
+	// maxListSize is the maximum number of objects in a synthetic list (see
+	// Config.MaxListSize).
+	//
+	// 0 if lists always have the same number of objects.
+	maxListSize int
+
+	// metrics collects metrics about handled requests.
+	//
+	// nil if metrics aren't being collected.
+	metrics *metrics.Metrics
+
+	// mutators modify generated responses before they're sent (see
+	// Server.RegisterResponseMutator).
+	//
+	// nil if there's no way to register any.
+	mutators *responseMutators
+
+	// nestedLists are the paths of lists of sub-resources nested in objects
+	// (see DataGenerator.nestedLists). They're found along with the routing
+	// table.
+	nestedLists map[string]map[string]string
+
+	// noExpand is whether requested expansions are ignored (see
+	// Config.NoExpand).
+	noExpand bool
+
+	// noFixtures is whether fixtures are ignored so that every object is
+	// synthetic (see Config.NoFixtures).
+	noFixtures bool
+
+	// nullableMode is how nullable fields are generated (see
+	// Config.NullableMode).
+	//
+	// Empty to use the values in fixtures.
+	nullableMode string
+
+	// mu guards fixtures, routes, and spec, which are replaced when the spec
+	// is reloaded (see reload). A request holds a read lock while it's
+	// handled so that it sees a consistent set of them.
+	mu sync.RWMutex
+
+	// rateLimiter limits how quickly requests can be made. Requests beyond
+	// the limit get a `429 Too Many Requests`.
+	//
+	// nil if requests aren't rate limited.
+	rateLimiter *ratelimit.Limiter
+
+	// rateLimitPerKey makes rateLimiter limit requests made with each API key
+	// separately instead of all requests together.
+	rateLimitPerKey bool
+
+	// recorder records requests and the responses generated for them.
+	//
+	// nil if requests aren't recorded.
+	recorder *recording.Recorder
+
+	// replayer holds recorded responses that are sent back to matching
+	// requests instead of generated ones.
+	//
+	// nil if responses aren't replayed.
+	replayer *recording.Replayer
+
+	// requestIDs generates the IDs sent back in the `Request-Id` header.
+	//
+	// nil if they should be generated from the global source of randomness.
+	requestIDs *requestIDGenerator
+
+	// restrictedKeysReadOnly limits requests made with restricted keys
+	// (`rk_test_...`) to `GET`s. Other requests get a `403 Forbidden`.
+	restrictedKeysReadOnly bool
+
+	// responseFormat describes how response bodies are encoded.
+	//
+	// nil if they're encoded the default way.
+	responseFormat *responseFormat
+
+	routes map[spec.HTTPVerb][]stubServerRoute
+
+	// seed seeds the source of randomness used to generate each response so
+	// that the same request always gets the same response.
+	//
+	// nil if responses should be random.
+	seed *int64
+
+	// selfValidateMode is whether generated responses are checked against
+	// their schemas (see Config.SelfValidateMode).
+	//
+	// Empty if they aren't.
+	selfValidateMode string
+
+	spec *spec.Spec
+
+	// strictAuth makes authentication errors look like the Stripe API's: a
+	// missing key gets an `authentication_required` error and a malformed one
+	// gets an `invalid_api_key` error.
+	strictAuth bool
+
+	// strictIDs makes requests for objects whose IDs don't have the prefix
+	// of their resource get a `404` (see checkPrimaryID).
+	strictIDs bool
+
+	// store holds objects that were created while running in stateful mode.
+	//
+	// nil if stateful mode is disabled.
+	store *store.ResourceStore
+
+	// syntheticStrings is how strings in synthetic objects are generated
+	// (see Config.StringLength).
+	//
+	// nil if they're as short as their schemas allow.
+	syntheticStrings *syntheticStrings
+
+	// webhookSecret is the secret used to sign webhooks sent to webhookURL.
+	webhookSecret string
+
+	// webhookURL is the URL to which events created through the trigger
+	// endpoint are sent.
+	//
+	// Empty if no webhook URL was configured.
+	webhookURL string
+
+	// versions contains servers for every available API version keyed by
+	// version. A request specifying a `Stripe-Version` header other than
+	// apiVersion is handled using the spec of the matching server instead.
+	versions map[string]*StubServer
+}
+
+// HandleRequest handes an HTTP request directed at the API stub.
+func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	// Every response gets a Request-Id header, including errors
+	requestID := s.newRequestID()
+	w.Header().Set("Request-Id", requestID)
+	logging.Info("Request", "method", r.Method, "path", r.URL.Path,
+		"request_id", requestID)
+
+	// Some clients send headers under names that the Stripe API used to
+	// accept, which are read under their proper names from here on
+	normalizeHeaderAliases(r.Header)
+
+	// The format of responses is carried by the request so that it reaches
+	// every place that writes one
+	if s.responseFormat != nil {
+		r = r.WithContext(context.WithValue(r.Context(), responseFormatKey{},
+			s.responseFormat))
+	}
+
+	// Preflight requests don't carry an API key, so they're answered before
+	// anything else
+	if s.cors != nil && s.cors.handle(w, r) {
+		logging.Info("Response", "status", http.StatusNoContent,
+			"elapsed", time.Since(start))
+		return
+	}
+
+	// A request may ask for its own latency, which replaces any that's
+	// configured. A header that can't be parsed is ignored.
+	latencyHeader := r.Header.Get("Stripe-Mock-Latency")
+	if latencyHeader == "" {
+		latencyHeader = r.Header.Get("X-Stripe-Mock-Latency")
+	}
+	waited := true
+	if latency, ok := parseLatencyHeader(latencyHeader); ok {
+		waited = waitLatency(r.Context(), latency)
+	} else if s.latency != nil {
+		waited = s.latency.wait(r.Context(), r.URL.Path)
+	}
+	if !waited {
+		logging.Info("Client disconnected while waiting to respond")
+		return
+	}
+
+	// The status of every response is recorded along with the route it was
+	// for. Note that `s` may be replaced below, so the metrics are captured
+	// now.
+	routePath := "(unmatched)"
+	if serverMetrics := s.metrics; serverMetrics != nil {
+		statusWriter := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		w = statusWriter
+		defer func() {
+			serverMetrics.ObserveRequest(r.Method, routePath, statusWriter.status)
+		}()
+	}
+
+	// Compressed bodies are decompressed before they're limited in size so
+	// that the limit applies to what's actually parsed
+	if s.responseFormat != nil && s.responseFormat.compress &&
+		isGzipEncoding(r.Header.Get("Content-Encoding")) {
+
+		body, err := gzip.NewReader(r.Body)
+		if err != nil {
+			message := fmt.Sprintf(invalidCompressedBody, err)
+			stripeError := createStripeError(typeInvalidRequestError, message)
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+		r.Body = body
+		r.ContentLength = -1
+	}
+
+	// Bodies are limited in size so that a huge one can't exhaust memory. One
+	// that's declared to be too large is rejected right away, and one that
+	// turns out to be while it's read is rejected when it's parsed.
+	if s.maxBodySize > 0 {
+		if r.ContentLength > s.maxBodySize {
+			writeResponse(w, r, start, http.StatusRequestEntityTooLarge,
+				createBodyTooLargeError(s.maxBodySize))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if s.strictAuth {
+		if stripeError := validateStrictAuth(auth); stripeError != nil {
+			writeResponse(w, r, start, http.StatusUnauthorized, stripeError)
+			return
+		}
+	} else if !validateAuth(auth) {
+		message := fmt.Sprintf(invalidAuthorization, auth)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusUnauthorized, stripeError)
+		return
+	}
+
+	// Restricted keys may be limited to requests that don't change anything
+	key := extractAPIKey(auth)
+	if s.restrictedKeysReadOnly && strings.HasPrefix(key, "rk_") &&
+		r.Method != http.MethodGet {
+
+		message := fmt.Sprintf(restrictedKeyPermission, redactAPIKey(key),
+			r.Method, r.URL.Path)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusForbidden, stripeError)
+		return
+	}
+
+	if s.rateLimiter != nil {
+		var rateLimitKey string
+		if s.rateLimitPerKey {
+			rateLimitKey = key
+		}
+
+		allowed, retryAfter := s.rateLimiter.Allow(rateLimitKey)
+		if !allowed {
+			e, err := errors.Parse("rate_limit_error:rate_limit")
+			if err != nil {
+				panic(err)
+			}
+
+			// Retry-After is in whole seconds, so round up
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeResponse(w, r, start, e.Status, createCatalogError(e))
+			return
+		}
+	}
+
+	// The idempotency key is reflected back into response headers like the
+	// Stripe API does. It's also used below to replay responses.
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		w.Header().Set("Idempotency-Key", idempotencyKey)
+	}
+
+	// A Connect platform may make a request on behalf of one of its
+	// connected accounts.
+	account := r.Header.Get("Stripe-Account")
+	if account != "" && !accountIDPattern.MatchString(account) {
+		message := fmt.Sprintf(invalidStripeAccount, account)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	// Objects may be localized to the country of the account that the
+	// request was made for
+	country, err := s.accountCountry(r, account)
+	if err != nil {
+		stripeError := createStripeError(typeInvalidRequestError, err.Error())
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	// A request may select a different spec to use by specifying an API
+	// version. Note that from here on, `s` may be a different server.
+	apiVersion := r.Header.Get("Stripe-Version")
+	if apiVersion != "" && apiVersion != s.apiVersion {
+		versionServer, ok := s.versions[apiVersion]
+		if !ok {
+			message := fmt.Sprintf(invalidAPIVersion, apiVersion,
+				strings.Join(s.availableAPIVersions(), ", "))
+			stripeError := createStripeError(typeInvalidRequestError, message)
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+		s = versionServer
+	}
+
+	// Webhooks may take a while to deliver, so triggers lock the server
+	// themselves rather than holding the lock until they're done
+	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, triggerPathPrefix) {
+		s.handleTrigger(w, r, start)
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if r.Method == http.MethodPost && r.URL.Path == resetPath {
+		s.handleReset(w, r, start)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == seedPath {
+		s.handleSeed(w, r, start)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == validatePath {
+		s.handleValidate(w, r, start)
+		return
+	}
+
+	if s.maintenance != nil {
+		if r.Method == http.MethodPost && r.URL.Path == maintenancePath {
+			s.handleMaintenance(w, r, start)
+			return
+		}
+
+		// Internal endpoints keep working during maintenance so that it can
+		// be turned off, but the API itself is unavailable
+		if s.maintenance.isEnabled() {
+			s.maintenance.writeUnavailable(w, r, start)
+			return
+		}
+	}
+
+	route, pathParams := s.routeRequest(r)
+	if route == nil {
+		// A path that's known for other methods gets a different error so
+		// that it's clear what went wrong
+		if methods := s.allowedMethods(r.URL.Path); len(methods) > 0 {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			message := fmt.Sprintf(methodNotAllowed, r.Method, r.URL.Path,
+				joinWithOr(methods))
+			stripeError := createStripeError(typeInvalidRequestError, message)
+			writeResponse(w, r, start, http.StatusMethodNotAllowed, stripeError)
+			return
+		}
+
+		message := fmt.Sprintf(invalidRoute, r.Method, r.URL.Path)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusNotFound, stripeError)
+		return
+	}
+	routePath = string(route.path)
+	logging.Debug("Matched route", "path", route.path,
+		"operation", route.operation.OperationID, "api_version", s.apiVersion)
+
+	// A request may ask for an error to be simulated instead of getting a
+	// normal response.
+	simulatedError := r.Header.Get("Stripe-Mock-Error")
+	if simulatedError == "" {
+		simulatedError = r.Header.Get("X-Stripe-Mock-Error")
+	}
+	if simulatedError != "" {
+		e, err := errors.Parse(simulatedError)
+		if err != nil {
+			stripeError := createStripeError(typeInvalidRequestError, err.Error())
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+
+		writeResponse(w, r, start, e.Status,
+			s.createPaymentError(r, pathParams, nil, nil, e))
+		return
+	}
+
+	// Some requests may be picked to fail as if the Stripe API had a
+	// transient failure, which clients should retry
+	if s.failures != nil {
+		fail, err := s.failures.fail(r.Header.Get("Stripe-Mock-Fail-Rate"))
+		if err != nil {
+			stripeError := createStripeError(typeInvalidRequestError, err.Error())
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+		if fail {
+			e, err := errors.Parse("api_error")
+			if err != nil {
+				panic(err)
+			}
+
+			w.Header().Set("Stripe-Should-Retry", "true")
+			writeResponse(w, r, start, e.Status, createCatalogError(e))
+			return
+		}
+	}
+
+	// A request may prefer one of the operation's other responses, like
+	// `Prefer: code=402`. The successful one is used otherwise.
+	status := http.StatusOK
+	if code, ok := parsePreferredCode(r.Header.Get("Prefer")); ok {
+		if _, ok := route.operation.Responses[spec.StatusCode(strconv.Itoa(code))]; ok {
+			status = code
+		} else {
+			logging.Info("Preferred response isn't in spec; using 200",
+				"code", code, "operation", route.operation.OperationID)
+		}
+	}
+
+	response, ok := route.operation.Responses[spec.StatusCode(strconv.Itoa(status))]
+	if !ok {
+		logging.Error("Couldn't find response in spec", "code", status,
+			"operation", route.operation.OperationID)
+		writeResponse(w, r, start, http.StatusInternalServerError,
+			createInternalServerError())
+		return
+	}
+	responseContent, ok := response.Content["application/json"]
+	if !ok || responseContent.Schema == nil {
+		logging.Error("Couldn't find application/json in response",
+			"operation", route.operation.OperationID)
+		writeResponse(w, r, start, http.StatusInternalServerError,
+			createInternalServerError())
+		return
+	}
+
+	if pathParams != nil {
+		var secondaryIDs []string
+		for _, secondaryID := range pathParams.SecondaryIDs {
+			secondaryIDs = append(secondaryIDs, secondaryID.Name+"="+secondaryID.ID)
+		}
+		var primaryID string
+		if pathParams.PrimaryID != nil {
+			primaryID = *pathParams.PrimaryID
+		}
+		logging.Debug("Extracted IDs from route",
+			"primary_id", stringOrEmpty(primaryID),
+			"secondary_ids", stringOrEmpty(strings.Join(secondaryIDs, ",")))
+	}
+	logging.Debug("Using response schema", "schema", schemaName(responseContent.Schema))
+
+	if s.strictIDs {
+		err := s.checkPrimaryID(responseContent.Schema, pathParams)
+		if notFound, ok := err.(*notFoundError); ok {
+			writeResponse(w, r, start, http.StatusNotFound, createNotFoundError(notFound))
+			return
+		}
+	}
+
+	requestData, err := param.ParseParams(r)
+	if isBodyTooLarge(err) {
+		writeResponse(w, r, start, http.StatusRequestEntityTooLarge,
+			createBodyTooLargeError(s.maxBodySize))
+		return
+	}
+	if err != nil {
+		message := fmt.Sprintf("Couldn't parse query/body: %v", err)
+		logging.Info(message)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	if logging.DebugEnabled() {
+		if requestData != nil {
+			logging.Debug("Parsed request data", "data", fmt.Sprintf("%+v", requestData))
+		} else {
+			logging.Debug("Parsed request data", "data", "(none)")
+		}
+	}
+
+	// Recordings are matched by the parameters that were sent, before
+	// requestData is modified in place from here on
+	var recordedParams json.RawMessage
+	if s.recorder != nil || s.replayer != nil {
+		recordedParams = recording.EncodeParams(requestData)
+	}
+	if s.replayer != nil {
+		recorded := s.replayer.Lookup(r.Method, r.URL.Path, recordedParams)
+		if recorded != nil {
+			w.Header().Set("Stripe-Mock-Replayed", "true")
+			writeResponse(w, r, start, recorded.Status,
+				filterResponseFields(r, recorded.Response))
+			return
+		}
+	}
+
+	// A retried request replays the response of the original one. The
+	// fingerprint is taken now because requestData is modified in place from
+	// here on. Like in the Stripe API, keys are scoped to the API key and
+	// account that they're used with.
+	var idempotencyFingerprint, idempotencyScope string
+	idempotent := s.idempotencyCache != nil && idempotencyKey != "" &&
+		r.Method == http.MethodPost
+	if idempotent {
+		idempotencyFingerprint = idempotency.Fingerprint(requestData)
+		idempotencyScope = key + " " + account
+		cached, err := s.idempotencyCache.Lookup(idempotencyScope, idempotencyKey,
+			r.URL.Path, idempotencyFingerprint)
+		if err == idempotency.ErrParamsMismatch {
+			message := fmt.Sprintf(idempotencyKeyReused, idempotencyKey)
+			stripeError := createStripeError(typeIdempotencyError, message)
+			writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+			return
+		}
+		if cached != nil {
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeResponse(w, r, start, cached.Status, cached.Data)
+			return
+		}
+	}
+
+	// Note that requestData is actually manipulated in place, but we show it
+	// returned here to make it clear that this function will be manipulating
+	// it.
+	requestData, stripeError := validateAndCoerceRequest(r, route, requestData)
+	if stripeError != nil {
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	// Objects of connected accounts are stored separately so that each
+	// account only sees its own.
+	resourceStore := s.store
+	if resourceStore != nil && account != "" {
+		resourceStore = resourceStore.Account(account)
+	}
+
+	// Some test cards always produce an error when used to make a payment. A
+	// stored PaymentIntent that's confirmed with one is left needing a new
+	// payment method.
+	if e := findTestCardError(r, requestData); e != nil {
+		if resourceStore != nil {
+			err := declinePaymentIntent(resourceStore, pathParams, r.URL.Path, e)
+			if invalidRequest, ok := err.(*invalidRequestError); ok {
+				writeResponse(w, r, start, http.StatusBadRequest,
+					createInvalidRequestError(invalidRequest))
+				return
+			}
+		}
+		writeResponse(w, r, start, e.Status,
+			s.createPaymentError(r, pathParams, requestData, resourceStore, e))
+		return
+	}
+
+	expansions, rawExpansions := extractExpansions(requestData)
+	if len(rawExpansions) > 0 {
+		logging.Debug("Requested expansions", "expand", strings.Join(rawExpansions, ","),
+			"ignored", s.noExpand)
+	}
+	if s.noExpand {
+		expansions, rawExpansions = nil, nil
+	}
+
+	// Like in the Stripe API, malformed expansions are reported before ones
+	// that are too deep, which are reported before ones that can't be
+	// expanded
+	if expansion := findInvalidExpansion(rawExpansions); expansion != "" {
+		message := fmt.Sprintf(invalidExpansion, expansion)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		stripeError.ErrorInfo.Param = "expand"
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	if s.maxExpansionDepth > 0 {
+		for _, expansion := range rawExpansions {
+			if strings.Count(expansion, ".")+1 > s.maxExpansionDepth {
+				message := fmt.Sprintf(expansionTooDeep, s.maxExpansionDepth, expansion)
+				stripeError := createStripeError(typeInvalidRequestError, message)
+				writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+				return
+			}
+		}
+	}
+
+	// Generation is abandoned if it overruns its timeout, like it might for a
+	// pathological schema, so that the request doesn't hang
+	ctx := r.Context()
+	if s.generateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.generateTimeout)
+		defer cancel()
+	}
+
+	generator := DataGenerator{
+		country:            country,
+		creatableResources: s.creatableResources,
+		ctx:                ctx,
+		definitions:        s.spec.Components.Schemas,
+		fixtures:           s.fixtures,
+		idMode:             s.idMode,
+		maxListSize:        s.maxListSize,
+		nestedLists:        s.nestedLists,
+		noFixtures:         s.noFixtures,
+		now:                s.generationTime(start),
+		nullableMode:       s.nullableMode,
+		rand:               s.newRand(),
+		store:              resourceStore,
+		strings:            s.syntheticStrings,
+	}
+	generateStart := time.Now()
+	responseData, err := generator.Generate(&GenerateParams{
+		Account:       account,
+		Expansions:    expansions,
+		PathParams:    pathParams,
+		RequestData:   requestData,
+		RequestMethod: r.Method,
+		RequestPath:   r.URL.Path,
+		Schema:        responseContent.Schema,
+	})
+	if s.metrics != nil {
+		s.metrics.ObserveGeneration(routePath, time.Since(generateStart))
+	}
+	if invalidRequest, ok := err.(*invalidRequestError); ok {
+		writeResponse(w, r, start, http.StatusBadRequest,
+			createInvalidRequestError(invalidRequest))
+		return
+	}
+	if notFound, ok := err.(*notFoundError); ok {
+		writeResponse(w, r, start, http.StatusNotFound, createNotFoundError(notFound))
+		return
+	}
+	if err == context.DeadlineExceeded {
+		logging.Error("Generating response timed out", "path", r.URL.Path,
+			"timeout", s.generateTimeout)
+		message := fmt.Sprintf(generationTimedOut, s.generateTimeout)
+		writeResponse(w, r, start, http.StatusServiceUnavailable,
+			createStripeError(typeAPIError, message))
+		return
+	}
+	if err != nil {
+		logging.Error("Couldn't generate response", "error", err)
+		writeResponse(w, r, start, http.StatusInternalServerError,
+			createInternalServerError())
+		return
+	}
+
+	// Checked before fields are filtered out, which leaves responses that
+	// are expected not to match their schemas
+	if s.selfValidates() {
+		err := route.validateResponse(status, responseData)
+		if err != nil {
+			logging.Error("Generated response doesn't match its schema",
+				"path", r.URL.Path, "operation", route.operation.OperationID,
+				"status", status, "error", err)
+
+			if s.selfValidateMode == SelfValidateModeStrict {
+				message := fmt.Sprintf(invalidGeneratedResponse, err)
+				writeResponse(w, r, start, http.StatusInternalServerError,
+					createStripeError(typeAPIError, message))
+				return
+			}
+		}
+	}
+
+	// Mutators registered by programs embedding stripe-mock run after
+	// self-validation, which is only meant to catch problems with generation
+	if s.mutators != nil {
+		responseData = s.mutators.apply(r.URL.Path, responseData)
+	}
+
+	// Responses are recorded whole, since the fields that a request selects
+	// aren't part of what identifies it when it's replayed
+	if s.recorder != nil {
+		err := s.recorder.Record(&recording.Recording{
+			Method:   r.Method,
+			Params:   recordedParams,
+			Path:     r.URL.Path,
+			Response: responseData,
+			Status:   status,
+		})
+		if err != nil {
+			logging.Error("Couldn't record response", "error", err)
+		}
+	}
+
+	responseData = filterResponseFields(r, responseData)
+
+	if logging.DebugEnabled() {
+		responseDataJSON, err := json.Marshal(responseData)
+		if err != nil {
+			panic(err)
+		}
+		logging.Debug("Generated response data", "data", string(responseDataJSON))
+	}
+	if idempotent {
+		s.idempotencyCache.Save(idempotencyScope, idempotencyKey, r.URL.Path,
+			idempotencyFingerprint,
+			&idempotency.Response{Data: responseData, Status: status})
+	}
+	writeResponse(w, r, start, status, responseData)
+}
+
+// allowedMethods returns a sorted list of the methods with a route matching
+// the given path. It's empty if the path isn't known at all.
+func (s *StubServer) allowedMethods(path string) []string {
+	var methods []string
+	for verb, verbRoutes := range s.routes {
+		for _, route := range verbRoutes {
+			if route.pattern.MatchString(path) {
+				methods = append(methods, string(verb))
+				break
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// availableAPIVersions returns a sorted list of all the API versions that the
+// server can handle.
+func (s *StubServer) availableAPIVersions() []string {
+	var apiVersions []string
+	for apiVersion := range s.versions {
+		apiVersions = append(apiVersions, apiVersion)
+	}
+	if _, ok := s.versions[s.apiVersion]; !ok && s.apiVersion != "" {
+		apiVersions = append(apiVersions, s.apiVersion)
+	}
+	sort.Strings(apiVersions)
+	return apiVersions
+}
+
+// checkPrimaryID checks that the primary ID in a request's path has the prefix
+// of the resource in the response (see objectIDPrefixes), returning a
+// notFoundError like the Stripe API's for an ID that obviously can't belong
+// to it. IDs of resources without a known prefix, or that may be chosen by
+// the user, aren't checked.
+func (s *StubServer) checkPrimaryID(schema *spec.Schema, pathParams *PathParamsMap) error {
+	if pathParams == nil || pathParams.PrimaryID == nil {
+		return nil
+	}
+
+	generator := DataGenerator{definitions: s.spec.Components.Schemas}
+	schema, _, err := generator.maybeDereference(schema, "")
+	if err != nil {
+		return err
+	}
+
+	// The object of a response that can be one of several resources isn't
+	// known ahead of time
+	if len(schema.AnyOf) > 0 {
+		return nil
+	}
+
+	resourceID := strings.TrimPrefix(schema.XResourceID, "deleted_")
+	prefix, ok := objectIDPrefixes[resourceID]
+	if !ok || customIDResources[resourceID] {
+		return nil
+	}
+
+	id := *pathParams.PrimaryID
+	if strings.HasPrefix(id, prefix+"_") {
+		return nil
+	}
+	return &notFoundError{object: resourceID, id: id}
+}
+
+// createPaymentError creates a Stripe-style error like createCatalogError for
+// a request that made a payment, which includes the charge or PaymentIntent
+// of the payment if it failed with a card error (see generateFailedPayment).
+func (s *StubServer) createPaymentError(r *http.Request, pathParams *PathParamsMap,
+	requestData map[string]interface{}, resourceStore *store.ResourceStore,
+	e *errors.Error) *ResponseError {
+
+	stripeError := createCatalogError(e)
+
+	payment, err := s.generateFailedPayment(r, pathParams, requestData, resourceStore, e)
+	if err != nil {
+		logging.Error("Couldn't generate failed payment", "error", err)
+		return stripeError
+	}
+	if payment == nil {
+		return stripeError
+	}
+
+	if payment["object"] == paymentIntentResource {
+		stripeError.ErrorInfo.PaymentIntent = payment
+	} else if id, ok := payment["id"].(string); ok {
+		stripeError.ErrorInfo.Charge = id
+	}
+	return stripeError
+}
+
+// responseResourceNames gets the names of the resources of the objects in an
+// operation's response. Those are the ones that it creates if it's a `POST`
+// to a collection.
+func (s *StubServer) responseResourceNames(operation *spec.Operation) []string {
+	response, ok := operation.Responses["200"]
+	if !ok {
+		return nil
+	}
+	responseContent, ok := response.Content["application/json"]
+	if !ok || responseContent.Schema == nil {
+		return nil
+	}
+
+	generator := DataGenerator{definitions: s.spec.Components.Schemas}
+	names, err := generator.resourceObjectNames(responseContent.Schema)
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+// findNestedLists finds the properties of resources that are lists of
+// sub-resources with a path of their own, like a charge's `refunds`, which
+// are listed at `/v1/charges/{charge}/refunds`. The paths are keyed by
+// resource and then by property. It must be called once the routes are built.
+func (s *StubServer) findNestedLists() map[string]map[string]string {
+	generator := DataGenerator{definitions: s.spec.Components.Schemas}
+
+	// The resources retrieved by paths with a single parameter, keyed by the
+	// path without the parameter's name (like `/v1/charges/{}`) so that a
+	// sub-resource's path can name it differently
+	parents := make(map[string]string)
+	for _, route := range s.routes[http.MethodGet] {
+		if len(route.pathParamNames) != 1 || !strings.HasSuffix(string(route.path), "}") {
+			continue
+		}
+		names := s.responseResourceNames(route.operation)
+		if len(names) != 1 {
+			continue
+		}
+		parents[pathParameterPattern.ReplaceAllString(string(route.path), "{}")] = names[0]
+	}
+
+	nestedLists := make(map[string]map[string]string)
+	for _, route := range s.routes[http.MethodGet] {
+		path := string(route.path)
+		if len(route.pathParamNames) != 1 || strings.HasSuffix(path, "}") {
+			continue
+		}
+
+		i := strings.LastIndex(path, "/")
+		parent, ok := parents[pathParameterPattern.ReplaceAllString(path[:i], "{}")]
+		if !ok {
+			continue
+		}
+
+		parentSchema, ok := generator.definitions[parent]
+		if !ok {
+			continue
+		}
+		key := path[i+1:]
+		propertySchema, ok := parentSchema.Properties[key]
+		if !ok {
+			continue
+		}
+		propertySchema, _, err := generator.maybeDereference(propertySchema, "")
+		if err != nil || !isListResource(propertySchema) {
+			continue
+		}
+
+		if nestedLists[parent] == nil {
+			nestedLists[parent] = make(map[string]string)
+		}
+		nestedLists[parent][key] = path
+	}
+	return nestedLists
+}
+
+// handleReset handles a request to the internal reset endpoint. It removes
+// all objects stored in stateful mode and all saved idempotent responses so
+// that a test suite can start each test case from a clean slate.
+//
+// It responds with a `204 No Content` even when there was nothing to reset.
+func (s *StubServer) handleReset(w http.ResponseWriter, r *http.Request, start time.Time) {
+	if s.store != nil {
+		s.store.Reset()
+	}
+	if s.idempotencyCache != nil {
+		s.idempotencyCache.Reset()
+	}
+
+	writeResponse(w, r, start, http.StatusNoContent, nil)
+}
+
+func (s *StubServer) initializeRouter() error {
+	var numEndpoints int
+	var numPaths int
+	var numValidators int
+
+	s.creatableResources = make(map[string]bool)
+	s.routes = make(map[spec.HTTPVerb][]stubServerRoute)
+
+	componentsForValidation := spec.GetComponentsForValidation(&s.spec.Components)
+
+	for path, verbs := range s.spec.Paths {
+		numPaths++
+
+		pathPattern, pathParamNames := compilePath(path)
+
+		logging.Debug("Compiled path", "pattern", pathPattern)
+
+		for verb, operation := range verbs {
+			numEndpoints++
+
+			_, requestBodySchema := getRequestBodySchema(operation)
+			var requestBodyValidator *jsval.JSVal
+			if requestBodySchema != nil {
+				var err error
+				requestBodyValidator, err = spec.GetValidatorForOpenAPI3Schema(
+					requestBodySchema, componentsForValidation)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Note that this may be nil if no suitable validator could be
+			// generated.
+			if requestBodyValidator != nil {
+				numValidators++
+			}
+
+			var responseValidators map[spec.StatusCode]*jsval.JSVal
+			if s.selfValidates() {
+				var err error
+				responseValidators, err = getResponseValidators(operation,
+					componentsForValidation)
+				if err != nil {
+					return err
+				}
+				numValidators += len(responseValidators)
+			}
+
+			// We use whether the route ends with a parameter as a heuristic as
+			// to whether we should expect an object's primary ID in the URL.
+			var hasPrimaryID bool
+			for _, suffix := range hasPrimaryIDSuffixes {
+				if strings.HasSuffix(string(path), suffix) {
+					hasPrimaryID = true
+					break
+				}
+			}
+
+			route := stubServerRoute{
+				hasPrimaryID:         hasPrimaryID,
+				path:                 path,
+				pattern:              pathPattern,
+				operation:            operation,
+				pathParamNames:       pathParamNames,
+				requestBodyValidator: requestBodyValidator,
+				responseValidators:   responseValidators,
+			}
+
+			// net/http will always give us verbs in uppercase, so build our
+			// routing table this way too
+			verb = spec.HTTPVerb(strings.ToUpper(string(verb)))
+
+			s.routes[verb] = append(s.routes[verb], route)
+
+			// A `POST` to a collection (like `/v1/charges`, as opposed to
+			// `/v1/account` or an action like `/v1/events/{id}/retry`)
+			// creates an object
+			if verb == http.MethodPost && !hasPrimaryID &&
+				strings.HasSuffix(string(path), "s") {
+
+				for _, name := range s.responseResourceNames(operation) {
+					s.creatableResources[name] = true
+				}
+			}
+		}
+	}
+
+	// A path with fewer parameters is more specific, so it's tried first.
+	// That way `/v1/charges/search` isn't taken for a charge with the ID
+	// `search`.
+	for _, routes := range s.routes {
+		sort.Slice(routes, func(i, j int) bool {
+			if len(routes[i].pathParamNames) != len(routes[j].pathParamNames) {
+				return len(routes[i].pathParamNames) < len(routes[j].pathParamNames)
+			}
+			return routes[i].path < routes[j].path
+		})
+	}
+
+	s.nestedLists = s.findNestedLists()
+
+	logging.Info("Initialized router", "api_version", s.apiVersion, "paths", numPaths,
+		"endpoints", numEndpoints, "validators", numValidators)
+	return nil
+}
+
+// newRequestID generates an ID for a request like `req_Zu2k4G0fW8bIEiDZ3WK7pSPE`.
+func (s *StubServer) newRequestID() string {
+	if s.requestIDs == nil {
+		return generateObjectID(nil, requestIDPrefix)
+	}
+	return s.requestIDs.next()
+}
+
+// newRand creates a source of randomness for generating a response.
+//
+// With a seed, every source starts in the same state, so generating a
+// response to a request doesn't depend on any that came before it (or are
+// being handled concurrently).
+func (s *StubServer) newRand() *rand.Rand {
+	if s.seed != nil {
+		return rand.New(rand.NewSource(*s.seed))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// generationTime returns the time at which objects generated for a request
+// received at start are created. With a seed it's always seededTime, so that
+// the same request gets the same timestamps every time.
+func (s *StubServer) generationTime(start time.Time) time.Time {
+	if s.seed != nil {
+		return seededTime
+	}
+	return start
+}
+
+// reload replaces the server's spec and fixtures, rebuilding its routing
+// table for the new spec. Requests that are already being handled finish
+// with the old ones.
+//
+// The server is left as it was if the new spec can't be routed.
+func (s *StubServer) reload(newSpec *spec.Spec, fixtures *spec.Fixtures) error {
+	next := &StubServer{selfValidateMode: s.selfValidateMode, spec: newSpec}
+	err := next.initializeRouter()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.creatableResources = next.creatableResources
+	s.fixtures = fixtures
+	s.nestedLists = next.nestedLists
+	s.routes = next.routes
+	s.spec = newSpec
+	return nil
+}
+
+// routeRequest tries to find a matching route for the given request. If
+// successful, it returns the matched route and where possible, an extracted ID
+// which comes from the last capture group in the URL. An ID is only returned
+// if it looks like it's supposed to be the primary identifier of the returned
+// object (i.e., the route's pattern ended with a parameter). A nil is returned
+// as the second return value when no primary ID is available.
+func (s *StubServer) routeRequest(r *http.Request) (*stubServerRoute, *PathParamsMap) {
+	verbRoutes := s.routes[spec.HTTPVerb(r.Method)]
+	for _, route := range verbRoutes {
+		matches := route.pattern.FindAllStringSubmatch(r.URL.Path, -1)
+
+		if len(matches) < 1 {
+			continue
+		}
+
+		// There are no path parameters. Return the route only.
+		if len(route.pathParamNames) < 1 {
+			return &route, nil
+		}
+
+		// There will only ever be a single match in the string (this match
+		// contains the entire match plus all capture groups).
+		firstMatch := matches[0]
+
+		// Secondary IDs are any IDs in the URL that are *not* the primary ID
+		// (which you'll see if say a resource is nested under another
+		// resource).
+		//
+		// Normally, we can calculate the number of secondary IDs based on the
+		// number of path parameters by subtracting one for the primary ID.
+		// There's a special case if the path doesn't have a primary ID in
+		// which the number of secondary IDs equals the number of path
+		// parameters.
+		var numSecondaryIDs int
+		if route.hasPrimaryID {
+			numSecondaryIDs = len(route.pathParamNames) - 1
+		} else {
+			numSecondaryIDs = len(route.pathParamNames)
+		}
+
+		var secondaryIDs []*PathParamsSecondaryID
+		if numSecondaryIDs > 0 {
+			secondaryIDs = make([]*PathParamsSecondaryID, numSecondaryIDs)
+			for i := 0; i < numSecondaryIDs; i++ {
+				secondaryIDs[i] = &PathParamsSecondaryID{
+					// Note that the first position of `firstMatch` is the
+					// entire matching string. Capture groups start at position
+					// 1, so we add one to `i`.
+					ID: firstMatch[i+1],
+
+					Name: route.pathParamNames[i],
+				}
+			}
+		}
+
+		// Not all routes have a primary ID even if they might have secondary
+		// IDs. Consider for example a list endpoint nested under another
+		// resource:
+		//
+		//     GET "/v1/application_fees/fee_123/refunds
+		//
+		var primaryID *string
+		if route.hasPrimaryID {
+			primaryID = &firstMatch[len(firstMatch)-1]
+		}
+
+		// Return the route along with any IDs that matched in the path.
+		return &route, &PathParamsMap{
+			PrimaryID:    primaryID,
+			SecondaryIDs: secondaryIDs,
+		}
+	}
+	return nil, nil
+}
+
+// selfValidates checks whether generated responses are checked against their
+// schemas.
+func (s *StubServer) selfValidates() bool {
+	return s.selfValidateMode != "" && s.selfValidateMode != SelfValidateModeOff
+}
+
+//
+// Private values
+//
+
+const (
+	bodyTooLarge = "Request body is too large. The maximum size is %d bytes."
+
+	contentTypeEmpty      = "Request's `Content-Type` header was empty. Expected: `%s`."
+	contentTypeMismatched = "Request's `Content-Type` didn't match the path's expected media type. Expected: `%s`. Was: `%s`."
+
+	expansionTooDeep = "You cannot expand more than %d levels of a property. " +
+		"Property: %s."
+
+	idempotencyKeyReused = "Keys for idempotent requests can only be used " +
+		"with the same parameters they were first used with. Try using a key " +
+		"other than '%s' if you meant to execute a different request."
+
+	authenticationRequired = "You did not provide an API key. You need to " +
+		"provide your API key in the Authorization header, using Bearer auth " +
+		"(e.g. 'Authorization: Bearer YOUR_SECRET_KEY')."
+
+	invalidAPIKey = "Invalid API Key provided: %s"
+
+	invalidExpansion = "Invalid expand property: %s. Properties should be " +
+		"field names separated by `.`, like `customer.default_source`."
+
+	invalidAuthorization = "Please authenticate by specifying an " +
+		"`Authorization` header with any valid looking testmode secret API " +
+		"key. For example, `Authorization: Bearer sk_test_123`. " +
+		"Authorization was '%s'."
+
+	invalidAPIVersion = "Invalid Stripe API version: %s. Available versions " +
+		"are: %s."
+
+	invalidParamValue = "Invalid %s: %s."
+
+	// invalidRoute is worded exactly like the Stripe API's so that tests of
+	// how clients handle unknown routes can match on it.
+	invalidRoute = "Unrecognized request URL (%s: %s). Please see " +
+		"https://stripe.com/docs or we can help at https://support.stripe.com/."
+
+	invalidStripeAccount = "Invalid `Stripe-Account` header: '%s'. Connected " +
+		"account IDs look like `acct_123`."
+
+	methodNotAllowed = "Unrecognized request method for URL (%s: %s). The URL " +
+		"can be requested with %s."
+
+	generationTimedOut = "The response took too long to generate and was " +
+		"abandoned after %v."
+
+	internalServerError = "An internal error occurred."
+
+	invalidCompressedBody = "Couldn't decompress request body with " +
+		"`Content-Encoding: gzip`: %v"
+
+	missingRequiredParam = "Missing required param: %s."
+
+	// missingParamAlternatives and exactlyOneParamAlternatives are used for
+	// operations that take one of several sets of parameters, and are filled
+	// in with the sets (like "customer or source").
+	missingParamAlternatives    = "Must provide at least one of these params: %s."
+	exactlyOneParamAlternatives = "Must provide exactly one of these params: %s."
+
+	receivedUnknownParam = "Received unknown parameter: %s"
+
+	// requestIDPrefix is the prefix of the IDs sent back in the `Request-Id`
+	// header.
+	requestIDPrefix = "req_"
+
+	// requestLogURL is the format of the link to a request in the Dashboard
+	// that's included in errors. It's filled in with the request's ID and the
+	// time at which it was made.
+	requestLogURL = "https://dashboard.stripe.com/test/logs/%s?t=%d"
+
+	// resetPath is the path of stripe-mock's internal endpoint for resetting
+	// stored state.
+	resetPath = "/v1/_stripe_mock/reset"
+
+	restrictedKeyPermission = "The provided key '%s' does not have the " +
+		"required permissions for this endpoint (%s %s). Restricted keys can " +
+		"only make read-only requests."
+
+	typeAPIError            = "api_error"
+	typeIdempotencyError    = "idempotency_error"
+	typeInvalidRequestError = "invalid_request_error"
+)
+
+// apiKeyPattern matches the API keys accepted with -strict-auth.
+var apiKeyPattern = regexp.MustCompile(`\A(rk|sk)_test_[a-zA-Z0-9]+\z`)
+
+// accountIDPattern matches the IDs of connected accounts given with a
+// `Stripe-Account` header.
+var accountIDPattern = regexp.MustCompile(`\Aacct_[a-zA-Z0-9]+\z`)
+
+// expansionPartPattern matches a field name in one of the `.`-separated parts
+// of an expansion.
+var expansionPartPattern = regexp.MustCompile(`\A[a-z0-9_]+\z`)
+
+// Suffixes for which we will try to exact an object's ID from the path.
+var hasPrimaryIDSuffixes = [...]string{
+	// The general case: we're looking for the end of an OpenAPI URL parameter.
+	"}",
+
+	// These are resource "actions". They don't take the standard form, but we
+	// can expect an object's primary ID to live right before them in a path.
+	"/approve",
+	"/capture",
+	"/cancel",
+	"/close",
+	"/confirm",
+	"/decline",
+	"/pay",
+	"/refund",
+	"/reject",
+	"/verify",
+}
+
+// seededTime is the time at which objects are created when there's a seed
+// (see generationTime). It's arbitrary but fixed.
+var seededTime = time.Unix(1500000000, 0)
+
+// customIDResources are resources whose objects may be created with IDs
+// chosen by the user, so their IDs can have any prefix.
+var customIDResources = map[string]bool{
+	"coupon":  true,
+	"plan":    true,
+	"product": true,
+	"sku":     true,
+}
+
+// formMediaType is the `Content-Type` of a form-encoded request body.
+const formMediaType = "application/x-www-form-urlencoded"
+
+// headerAliases are the names that legacy clients send some of the headers
+// read by the Stripe API under, keyed by the headers' proper names. Names are
+// matched case-insensitively.
+var headerAliases = map[string][]string{
+	"Idempotency-Key": {"Idempotency_Key"},
+	"Stripe-Account":  {"Stripe_Account"},
+	"Stripe-Version":  {"Stripe_Version"},
+}
+
+var pathParameterPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+//
+// Private types
+//
+
+// requestIDGenerator generates the IDs of requests. With a seed, the same
+// sequence of IDs is generated every time that stripe-mock is run, so that
+// they're predictable for requests made in the same order.
+type requestIDGenerator struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// newRequestIDGenerator initializes a requestIDGenerator, which is seeded with
+// seed if it's given.
+func newRequestIDGenerator(seed *int64) *requestIDGenerator {
+	source := rand.NewSource(time.Now().UnixNano())
+	if seed != nil {
+		source = rand.NewSource(*seed)
+	}
+	return &requestIDGenerator{rand: rand.New(source)}
+}
+
+// next generates the next request ID.
+func (g *requestIDGenerator) next() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return generateObjectID(g.rand, requestIDPrefix)
+}
+
+// stubServerRoute is a single route in a StubServer's routing table. It has a
+// pattern to match an incoming path and a description of the method that would
+// be executed in the event of a match.
+type stubServerRoute struct {
+	hasPrimaryID         bool
+	path                 spec.Path
+	pattern              *regexp.Regexp
+	operation            *spec.Operation
+	pathParamNames       []string
+	requestBodyValidator *jsval.JSVal
+
+	// responseValidators validate the generated JSON responses of the
+	// operation, keyed by status code. They're only built when generated
+	// responses are checked (see Config.SelfValidateMode).
+	responseValidators map[spec.StatusCode]*jsval.JSVal
+}
+
+// responseFormat describes how writeResponse encodes response bodies. It's
+// carried by the context of the request being responded to.
+type responseFormat struct {
+	// compress compresses responses with gzip for requests that accept it.
+	// Request bodies compressed with gzip are decompressed too.
+	compress bool
+
+	// contentType is the `Content-Type` header of responses with a body.
+	// It's left to net/http to detect if empty.
+	contentType string
+
+	// pretty indents responses.
+	pretty bool
+}
+
+// newResponseFormat gets the format of responses from the configuration of a
+// server. Returns nil if responses are encoded the default way.
+func newResponseFormat(config *Config) *responseFormat {
+	if !config.Compression && !config.Pretty && config.ResponseContentType == "" {
+		return nil
+	}
+	return &responseFormat{
+		compress:    config.Compression,
+		contentType: config.ResponseContentType,
+		pretty:      config.Pretty,
+	}
+}
+
+// responseFormatKey is the key of the responseFormat in a request's context.
+type responseFormatKey struct{}
+
+// statusRecorder is an http.ResponseWriter that remembers the status of the
+// response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush flushes the underlying http.ResponseWriter if it supports it, so that
+// streamed responses still reach the client as they're written.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+//
+// Private functions
+//
+
+// acceptsGzip checks whether the `Accept-Encoding` header of a request allows
+// a response compressed with gzip. An encoding given a quality of 0 (like
+// `gzip;q=0`) isn't acceptable.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(encoding, ";")
+		name := strings.TrimSpace(parts[0])
+		if name != "*" && !isGzipEncoding(name) {
+			continue
+		}
+
+		acceptable := true
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				quality, err := strconv.ParseFloat(param[2:], 64)
+				acceptable = err == nil && quality > 0
+			}
+		}
+		return acceptable
+	}
+	return false
+}
+
+// checkParamValue checks a scalar parameter value against the constraints of
+// its schema: enums, numeric ranges, string lengths, and patterns. Returns a
+// message describing the problem (e.g. "must be one of day, month, or year"),
+// or an empty string if the value is allowed.
+//
+// A schema with an `anyOf` allows values allowed by any of its branches, but
+// only constraints that every branch has (like an enum) are checked in that
+// case. Others are left to the validator.
+func checkParamValue(schema *spec.Schema, value interface{}) string {
+	if len(schema.AnyOf) > 0 {
+		var enum []interface{}
+		for _, subSchema := range schema.AnyOf {
+			if len(subSchema.Enum) == 0 {
+				return ""
+			}
+			enum = append(enum, subSchema.Enum...)
+		}
+		return checkParamValue(&spec.Schema{Enum: enum}, value)
+	}
+
+	if len(schema.Enum) > 0 {
+		var allowed []string
+		for _, enumValue := range schema.Enum {
+			// Values are compared as strings because form-encoded values
+			// have been coerced to the schema's type while enum values are
+			// decoded from JSON (e.g. 1 and 1.0)
+			if fmt.Sprint(enumValue) == fmt.Sprint(value) {
+				return ""
+			}
+			allowed = append(allowed, fmt.Sprint(enumValue))
+		}
+		return "must be one of " + joinWithOr(allowed)
+	}
+
+	// Coerced integers are compared against bounds as floats
+	if i, ok := value.(int); ok {
+		value = float64(i)
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if schema.MinLength != 0 && length < schema.MinLength {
+			return fmt.Sprintf("must be at least %d characters", schema.MinLength)
+		}
+		if schema.MaxLength != 0 && length > schema.MaxLength {
+			return fmt.Sprintf("must be at most %d characters", schema.MaxLength)
+		}
+
+		// Patterns that Go can't compile are left to the validator
+		if schema.Pattern != "" {
+			pattern, err := regexp.Compile(schema.Pattern)
+			if err == nil && !pattern.MatchString(v) {
+				return "must match the pattern " + schema.Pattern
+			}
+		}
+
+	case float64:
+		if schema.Minimum != nil && v < *schema.Minimum {
+			return "must be at least " + formatNumber(*schema.Minimum)
+		}
+		if schema.Maximum != nil && v > *schema.Maximum {
+			return "must be at most " + formatNumber(*schema.Maximum)
+		}
+	}
+
+	return ""
+}
+
+// applyParamDefaults fills in the parameters that a request left out with
+// the `default` of their schema, like the Stripe API does, so that the
+// defaults are reflected into the response. Parameters of nested objects that
+// were given are filled in too.
+func applyParamDefaults(schema *spec.Schema, data map[string]interface{}) {
+	for name, subSchema := range schema.Properties {
+		value, ok := data[name]
+		if !ok {
+			if subSchema.Default != nil {
+				data[name] = store.CopyValue(subSchema.Default)
+			}
+			continue
+		}
+
+		if valueMap, ok := value.(map[string]interface{}); ok {
+			if objectSchema := findObjectSchema(subSchema, valueMap); objectSchema != nil {
+				applyParamDefaults(objectSchema, valueMap)
+			}
+		}
+	}
+}
+
+// compilePath compiles a path extracted from OpenAPI into a regular expression
+// that we can use for matching against incoming HTTP requests.
+//
+// The first return value is a regular expression. The second is a slice of
+// names for the parameters included in the path in order of their appearance.
+// This slice is `nil` if the path had no parameters.
+func compilePath(path spec.Path) (*regexp.Regexp, []string) {
+	var pathParamNames []string
+	parts := strings.Split(string(path), "/")
+	pattern := `\A`
+
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+
+		submatches := pathParameterPattern.FindAllStringSubmatch(part, -1)
+		if submatches == nil {
+			pattern += `/` + part
+		} else {
+			pattern += `/(?P<` + submatches[0][1] + `>[\w-_.]+)`
+			pathParamNames = append(pathParamNames, submatches[0][1])
+		}
+	}
+
+	return regexp.MustCompile(pattern + `\z`), pathParamNames
+}
+
+// createCatalogError creates a Stripe-style error from an error in the errors
+// package's catalog.
+func createCatalogError(e *errors.Error) *ResponseError {
+	stripeError := createStripeError(e.Type, e.Message)
+	stripeError.ErrorInfo.Code = e.Code
+	stripeError.ErrorInfo.DeclineCode = e.DeclineCode
+	return stripeError
+}
+
+// createInvalidRequestError creates an error for a request whose parameters
+// were found to be invalid while generating a response for it.
+func createInvalidRequestError(e *invalidRequestError) *ResponseError {
+	stripeError := createStripeError(typeInvalidRequestError, e.message)
+	stripeError.ErrorInfo.Code = e.code
+	stripeError.ErrorInfo.Param = e.param
+	return stripeError
+}
+
+// createNotFoundError creates an error for a request for an object that
+// doesn't exist.
+func createNotFoundError(e *notFoundError) *ResponseError {
+	stripeError := createStripeError(typeInvalidRequestError, e.Error())
+	stripeError.ErrorInfo.Code = "resource_missing"
+	stripeError.ErrorInfo.Param = "id"
+	return stripeError
+}
+
+// Helper to create an internal server error for API issues.
+func createInternalServerError() *ResponseError {
+	return createStripeError(typeInvalidRequestError, internalServerError)
+}
+
+// createBodyTooLargeError creates an error for a request whose body exceeded
+// the maximum size.
+func createBodyTooLargeError(maxBodySize int64) *ResponseError {
+	return createStripeError(typeInvalidRequestError,
+		fmt.Sprintf(bodyTooLarge, maxBodySize))
+}
+
+// This creates a Stripe error to return in case of API errors.
+func createStripeError(errorType string, errorMessage string) *ResponseError {
+	return &ResponseError{
+		ErrorInfo: struct {
+			Code        string `json:"code,omitempty"`
+			DeclineCode string `json:"decline_code,omitempty"`
+			Message     string `json:"message"`
+			Param       string `json:"param,omitempty"`
+			Type        string `json:"type"`
+
+			Charge        string                 `json:"charge,omitempty"`
+			PaymentIntent map[string]interface{} `json:"payment_intent,omitempty"`
+
+			RequestLogURL string `json:"request_log_url,omitempty"`
+		}{
+			Message: errorMessage,
+			Type:    errorType,
+		},
+	}
+}
+
+// extractAPIKey gets the API key from the value of an `Authorization` header
+// using either bearer or basic authentication. Returns an empty string if the
+// header is malformed.
+//
+// Like in the Stripe API, the scheme is case-insensitive, and the credentials
+// of basic authentication may leave out base64 padding, which some older
+// libraries do.
+func extractAPIKey(auth string) string {
+	parts := strings.Split(auth, " ")
+
+	// Expect ["Bearer", "sk_test_123"] or ["Basic", "aaaaa"]
+	if len(parts) != 2 || parts[1] == "" {
+		return ""
+	}
+
+	switch strings.ToLower(parts[0]) {
+	case "basic":
+		keyBytes, err := base64.RawStdEncoding.DecodeString(
+			strings.TrimRight(parts[1], "="))
+		if err != nil {
+			return ""
+		}
+
+		// The key is the username, and the password is normally empty
+		return strings.SplitN(string(keyBytes), ":", 2)[0]
+
+	case "bearer":
+		return parts[1]
+	}
+
+	return ""
+}
+
+func extractExpansions(data map[string]interface{}) (*ExpansionLevel, []string) {
+	expand, ok := data["expand"]
+	if !ok {
+		return nil, nil
+	}
+
+	var expansions []string
+
+	expandStr, ok := expand.(string)
+	if ok {
+		expansions = append(expansions, expandStr)
+		return parseExpansionLevel(expansions), expansions
+	}
+
+	expandArr, ok := expand.([]interface{})
+	if ok {
+		for _, expand := range expandArr {
+			expandStr := expand.(string)
+			expansions = append(expansions, expandStr)
+		}
+		return parseExpansionLevel(expansions), expansions
+	}
+
+	return nil, nil
+}
+
+// findInvalidExpansion looks for an expansion that isn't syntactically valid,
+// like one with an empty part (`customer..source`) or characters that can't
+// appear in a field name. Each part must be a field name or the `*` wildcard.
+//
+// Returns the first invalid expansion, or an empty string if all are valid.
+func findInvalidExpansion(expansions []string) string {
+	for _, expansion := range expansions {
+		for _, part := range strings.Split(expansion, ".") {
+			if part == "*" {
+				continue
+			}
+			if !expansionPartPattern.MatchString(part) {
+				return expansion
+			}
+		}
+	}
+	return ""
+}
+
+// findInvalidParam looks for a parameter in data whose value isn't allowed by
+// its schema in schema, like one that isn't a member of its enum. Parameters
+// of nested objects and arrays are checked too.
+//
+// Returns the name of the first invalid parameter found in the form used by
+// the Stripe API (e.g. `card[number]`) along with a message describing what's
+// wrong with it, or empty strings if all parameters are valid. prefix is the
+// name of the parameter that data was found under, and should be empty at the
+// top level.
+func findInvalidParam(schema *spec.Schema, data map[string]interface{},
+	prefix string) (string, string) {
+
+	// Iterate in a stable order so that the same parameter is reported for the
+	// same request every time.
+	var names []string
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		subSchema, ok := schema.Properties[name]
+		if !ok {
+			continue
+		}
+
+		param, message := findInvalidParamValue(subSchema, data[name],
+			param.NestedParamName(prefix, name))
+		if param != "" {
+			return param, message
+		}
+	}
+
+	return "", ""
+}
+
+// findInvalidParamValue is like findInvalidParam, but checks a single value
+// described by schema that was found under paramName.
+func findInvalidParamValue(schema *spec.Schema, value interface{},
+	paramName string) (string, string) {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		objectSchema := findObjectSchema(schema, v)
+		if objectSchema == nil {
+			return "", ""
+		}
+		return findInvalidParam(objectSchema, v, paramName)
+
+	case []interface{}:
+		if schema.Items == nil {
+			return "", ""
+		}
+		for i, item := range v {
+			itemParam, message := findInvalidParamValue(schema.Items, item,
+				param.NestedParamName(paramName, strconv.Itoa(i)))
+			if itemParam != "" {
+				return itemParam, message
+			}
+		}
+		return "", ""
+	}
+
+	message := checkParamValue(schema, value)
+	if message != "" {
+		return paramName, fmt.Sprintf(invalidParamValue, paramName, message)
+	}
+	return "", ""
+}
+
+// findMissingRequiredParam looks for a parameter that's required by schema,
+// but which is missing from data. Parameters of nested objects (including
+// those in arrays) are checked as long as their parent was included.
+//
+// Returns the name of the first missing parameter found in the form used by
+// the Stripe API (e.g. `card[number]`), or an empty string if none are
+// missing. prefix is the name of the parameter that data was found under, and
+// should be empty at the top level.
+func findMissingRequiredParam(schema *spec.Schema, data map[string]interface{},
+	prefix string) string {
+
+	for _, name := range schema.Required {
+		if _, ok := data[name]; !ok {
+			return param.NestedParamName(prefix, name)
+		}
+	}
+
+	// Iterate in a stable order so that the same parameter is reported for the
+	// same request every time.
+	var names []string
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		subSchema, ok := schema.Properties[name]
+		if !ok {
+			continue
+		}
+
+		switch value := data[name].(type) {
+		case map[string]interface{}:
+			objectSchema := findObjectSchema(subSchema, value)
+			if objectSchema == nil {
+				continue
+			}
+			missingParam := findMissingRequiredParam(objectSchema, value,
+				param.NestedParamName(prefix, name))
+			if missingParam != "" {
+				return missingParam
+			}
+
+		case []interface{}:
+			if subSchema.Items == nil {
+				continue
+			}
+			for i, item := range value {
+				itemMap, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				objectSchema := findObjectSchema(subSchema.Items, itemMap)
+				if objectSchema == nil {
+					continue
+				}
+				missingParam := findMissingRequiredParam(objectSchema, itemMap,
+					param.NestedParamName(param.NestedParamName(prefix, name), strconv.Itoa(i)))
+				if missingParam != "" {
+					return missingParam
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// findUnsatisfiedParamAlternatives checks data against the sets of
+// parameters that schema requires one of (see requiredParamAlternatives).
+//
+// Returns the name of a parameter to blame, which is empty if none were
+// given, along with a message describing the alternatives, or empty strings
+// if they're satisfied.
+func findUnsatisfiedParamAlternatives(schema *spec.Schema,
+	data map[string]interface{}) (string, string) {
+
+	alternatives, exactlyOne := requiredParamAlternatives(schema)
+	if alternatives == nil {
+		return "", ""
+	}
+
+	var names []string
+	var given [][]string
+	for _, alternative := range alternatives {
+		names = append(names, strings.Join(alternative, " and "))
+
+		complete := true
+		for _, name := range alternative {
+			if _, ok := data[name]; !ok {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			given = append(given, alternative)
+		}
+	}
+
+	switch {
+	case exactlyOne && len(given) == 0:
+		return "", fmt.Sprintf(exactlyOneParamAlternatives, joinWithOr(names))
+	case exactlyOne && len(given) > 1:
+		return given[1][0], fmt.Sprintf(exactlyOneParamAlternatives, joinWithOr(names))
+	case len(given) == 0:
+		return "", fmt.Sprintf(missingParamAlternatives, joinWithOr(names))
+	}
+	return "", ""
+}
+
+// findUnknownParam looks for a parameter in data that isn't one of the
+// properties of schema in cases where schema disallows additional properties
+// (i.e. its `additionalProperties` is `false`), or that's one of its read-only
+// properties, which can't be set. Parameters of nested objects
+// (including those in arrays) are checked too, but polymorphic ones are left
+// to the validator because it's not clear which of their branches was meant.
+//
+// Returns the name of the first unknown parameter found in the form used by
+// the Stripe API (e.g. `card[foo]`), or an empty string if there are none.
+// prefix is the name of the parameter that data was found under, and should be
+// empty at the top level.
+func findUnknownParam(schema *spec.Schema, data map[string]interface{},
+	prefix string) string {
+
+	// Iterate in a stable order so that the same parameter is reported for the
+	// same request every time.
+	var names []string
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		subSchema, ok := schema.Properties[name]
+		if !ok {
+			// Either `true` or a schema allows the parameter, and in the latter
+			// case its value is checked by the validator.
+			if schema.AdditionalProperties == false {
+				return param.NestedParamName(prefix, name)
+			}
+			continue
+		}
+
+		// Read-only properties can't be set, which the Stripe API reports
+		// the same way as parameters that it doesn't know about
+		if subSchema.ReadOnly {
+			return param.NestedParamName(prefix, name)
+		}
+
+		switch value := data[name].(type) {
+		case map[string]interface{}:
+			if subSchema.Properties == nil {
+				continue
+			}
+			unknownParam := findUnknownParam(subSchema, value,
+				param.NestedParamName(prefix, name))
+			if unknownParam != "" {
+				return unknownParam
+			}
+
+		case []interface{}:
+			if subSchema.Items == nil || subSchema.Items.Properties == nil {
+				continue
+			}
+			for i, item := range value {
+				itemMap, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				unknownParam := findUnknownParam(subSchema.Items, itemMap,
+					param.NestedParamName(param.NestedParamName(prefix, name), strconv.Itoa(i)))
+				if unknownParam != "" {
+					return unknownParam
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// findObjectSchema finds a schema describing an object with properties,
+// either the given schema itself or one of the branches of its `anyOf`. If
+// data includes the discriminator property of a polymorphic schema (see
+// spec.Schema.DiscriminatorValue), the branch that it identifies is preferred.
+// Returns nil if there isn't one.
+func findObjectSchema(schema *spec.Schema, data map[string]interface{}) *spec.Schema {
+	if schema.Properties != nil {
+		return schema
+	}
+
+	discriminatorValue, _ := data[schema.DiscriminatorPropertyName()].(string)
+
+	var firstBranch *spec.Schema
+	for _, subSchema := range schema.AnyOf {
+		if subSchema.Properties == nil {
+			continue
+		}
+		if discriminatorValue == "" ||
+			schema.DiscriminatorValue(subSchema.Ref, subSchema) == discriminatorValue {
+			return subSchema
+		}
+		if firstBranch == nil {
+			firstBranch = subSchema
+		}
+	}
+	return firstBranch
+}
+
+// filterResponseFields filters a response down to the fields selected with
+// the request's `Stripe-Mock-Fields` header (see parseFieldSelection). A
+// response is returned as is if no fields were selected.
+func filterResponseFields(r *http.Request, data interface{}) interface{} {
+	fields := r.Header.Get("Stripe-Mock-Fields")
+	if fields == "" {
+		fields = r.Header.Get("X-Stripe-Mock-Fields")
+	}
+	if selection := parseFieldSelection(fields); selection != nil {
+		return filterFields(data, selection)
+	}
+	return data
+}
+
+// formatNumber formats a numeric bound from a schema for an error message,
+// without a trailing `.0` or exponent.
+func formatNumber(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+// getRequestBodySchema gets the media type and expected request schema for the
+// given operation. We don't expect any endpoint in the Stripe API to have
+// multiple supported media types, so the operation's first media type and
+// request schema is always the one that's returned.
+//
+// The first value is a media type like "application/x-www-form-urlencoded", or
+// nil if the operation has no request schemas.
+func getRequestBodySchema(operation *spec.Operation) (*string, *spec.Schema) {
+	if operation.RequestBody == nil {
+		return nil, nil
+	}
+
+	for mediaType, spec := range operation.RequestBody.Content {
+		return &mediaType, spec.Schema
+	}
+
+	return nil, nil
+}
+
+// gzipData compresses data with gzip.
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hasRequiredParams checks whether a request body schema requires any
+// parameters, which means that a request can't omit its body entirely.
+func hasRequiredParams(schema *spec.Schema) bool {
+	if schema == nil {
+		return false
+	}
+	alternatives, _ := requiredParamAlternatives(schema)
+	return len(schema.Required) > 0 || alternatives != nil
+}
+
+// isBodyTooLarge checks whether an error came from reading a request body
+// that exceeded the maximum size. Errors wrapping it (like those from parsing
+// multipart forms) are checked too.
+func isBodyTooLarge(err error) bool {
+	for err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return true
+		}
+
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
+
+func isCurl(userAgent string) bool {
+	return strings.HasPrefix(userAgent, "curl/")
+}
+
+// isGzipEncoding checks whether the value of a `Content-Encoding` header (or
+// one of the encodings in an `Accept-Encoding` header) is gzip.
+func isGzipEncoding(encoding string) bool {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	return encoding == "gzip" || encoding == "x-gzip"
+}
+
+// joinWithOr joins values into a human-readable list like `a, b, or c`.
+func joinWithOr(values []string) string {
+	switch len(values) {
+	case 0:
+		return ""
+	case 1:
+		return values[0]
+	case 2:
+		return values[0] + " or " + values[1]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + ", or " +
+		values[len(values)-1]
+}
+
+// normalizeHeaderAliases renames headers sent under one of headerAliases to
+// their proper names, so that they're read like any other. A header that's
+// also sent under its proper name is left alone.
+func normalizeHeaderAliases(header http.Header) {
+	for name, aliases := range headerAliases {
+		for _, alias := range aliases {
+			values, ok := header[http.CanonicalHeaderKey(alias)]
+			if !ok {
+				continue
+			}
+			header.Del(alias)
+			if _, ok := header[name]; !ok {
+				header[name] = values
+			}
+		}
+	}
+}
+
+// parsePreferredCode parses the status code of the response that a request
+// prefers from its `Prefer` header, which is a comma-separated list of
+// preferences like `code=402`. Parameters of a preference after a `;` are
+// ignored. Returns false if the header has no valid code.
+func parsePreferredCode(prefer string) (int, bool) {
+	for _, preference := range strings.Split(prefer, ",") {
+		preference = strings.SplitN(preference, ";", 2)[0]
+		parts := strings.SplitN(strings.TrimSpace(preference), "=", 2)
+		if len(parts) != 2 || strings.ToLower(strings.TrimSpace(parts[0])) != "code" {
+			continue
+		}
+
+		code, err := strconv.Atoi(strings.Trim(strings.TrimSpace(parts[1]), `"`))
+		if err != nil || code < 100 || code > 599 {
+			return 0, false
+		}
+		return code, true
+	}
+	return 0, false
+}
+
+// parseExpansionLevel parses a set of raw expansions from a request query
+// string or form and produces a structure more useful for performing actual
+// expansions.
+func parseExpansionLevel(raw []string) *ExpansionLevel {
+	sort.Strings(raw)
+
+	level := &ExpansionLevel{expansions: make(map[string]*ExpansionLevel)}
+	groups := make(map[string][]string)
+
+	for _, expansion := range raw {
+		parts := strings.Split(expansion, ".")
+		if len(parts) == 1 {
+			if parts[0] == "*" {
+				level.wildcard = true
+			} else {
+				level.expansions[parts[0]] =
+					&ExpansionLevel{expansions: make(map[string]*ExpansionLevel)}
+			}
+		} else {
+			groups[parts[0]] = append(groups[parts[0]], strings.Join(parts[1:], "."))
+		}
+	}
+
+	for key, subexpansions := range groups {
+		level.expansions[key] = parseExpansionLevel(subexpansions)
+	}
+
+	return level
+}
+
+// redactAPIKey hides most of an API key for inclusion in an error message,
+// like `sk_test_****1234`.
+func redactAPIKey(key string) string {
+	if len(key) <= 4 {
+		return key
+	}
+
+	var prefix string
+	if i := strings.LastIndex(key, "_"); i != -1 && i < len(key)-4 {
+		prefix = key[:i+1]
+	}
+	return prefix + "****" + key[len(key)-4:]
+}
+
+// requiredParamAlternatives gets the sets of parameters that a request body
+// schema requires one of with a `oneOf` or `anyOf` whose branches do nothing
+// but list required parameters, like `oneOf: [{required: [customer]},
+// {required: [source]}]`. exactlyOne is true for a `oneOf`, which allows
+// only one of the sets to be given.
+//
+// Returns nil if schema doesn't require any such alternatives. Branches
+// that describe objects of their own are left to findObjectSchema and the
+// validator.
+func requiredParamAlternatives(schema *spec.Schema) ([][]string, bool) {
+	branches, exactlyOne := schema.AnyOf, false
+	if len(schema.OneOf) > 0 {
+		branches, exactlyOne = schema.OneOf, true
+	}
+
+	if schema.Properties == nil || len(branches) == 0 {
+		return nil, false
+	}
+
+	var alternatives [][]string
+	for _, branch := range branches {
+		if len(branch.Required) == 0 || branch.Properties != nil ||
+			branch.Ref != "" || branch.Type != "" {
+			return nil, false
+		}
+		alternatives = append(alternatives, branch.Required)
+	}
+	return alternatives, exactlyOne
+}
+
+// schemaName describes a schema in logs. That's its reference if it's one, and
+// otherwise its resource ID or the names of its `anyOf` branches.
+func schemaName(schema *spec.Schema) string {
+	if schema.Ref != "" {
+		return schema.Ref
+	}
+
+	if schema.XResourceID != "" {
+		return schema.XResourceID
+	}
+
+	if len(schema.AnyOf) > 0 {
+		names := make([]string, len(schema.AnyOf))
+		for i, subSchema := range schema.AnyOf {
+			names[i] = schemaName(subSchema)
+		}
+		return "anyOf(" + strings.Join(names, ", ") + ")"
+	}
+
+	return "(inline schema)"
+}
+
+// validateAndCoerceRequest validates an incoming request against an OpenAPI
+// schema and does parameter coercion.
+//
+// Firstly, `Content-Type` is checked against the schema's media type, then
+// string-encoded parameters are coerced to expected types (where possible).
+// Finally, we validate the incoming payload against the schema.
+func validateAndCoerceRequest(
+	r *http.Request,
+	route *stubServerRoute,
+	requestData map[string]interface{}) (map[string]interface{}, *ResponseError) {
+
+	// Currently we only validate parameters in the request body, but we should
+	// really validate query and URL parameters as well now that we've
+	// transitioned to OpenAPI 3.0.
+	mediaType, bodySchema := getRequestBodySchema(route.operation)
+
+	// There are no parameters on this route to validate.
+	if mediaType == nil {
+		return requestData, nil
+	}
+
+	contentType := r.Header.Get("Content-Type")
+
+	if contentType == "" {
+		// A special case: if a `DELETE` operation comes in with no request
+		// payload, we allow it. Most `DELETE` operations take no parameters,
+		// but a few of them take some optional ones.
+		if r.Method == http.MethodDelete {
+			return requestData, nil
+		}
+
+		// Similarly, action endpoints like invoice pay or charge capture are
+		// often called with no body at all. That's fine as long as the
+		// operation doesn't require any parameters.
+		if len(requestData) == 0 && !hasRequiredParams(bodySchema) {
+			return requestData, nil
+		}
+
+		message := fmt.Sprintf(contentTypeEmpty, *mediaType)
+		logging.Info(message)
+		return nil, createStripeError(typeInvalidRequestError, message)
+	}
+
+	// Truncate content type parameters. For example, given:
+	//
+	//     application/json; charset=utf-8
+	//
+	// We want to chop off the `; charset=utf-8` at the end.
+	contentType = strings.Split(contentType, ";")[0]
+
+	// Parameters can be sent as JSON in place of a form-encoded body. They're
+	// parsed into the same structure, so they're validated just the same.
+	if contentType != *mediaType &&
+		!(contentType == param.JSONMediaType && *mediaType == formMediaType) {
+
+		message := fmt.Sprintf(contentTypeMismatched, *mediaType, contentType)
+		logging.Info(message)
+		return nil, createStripeError(typeInvalidRequestError, message)
+	}
+
+	err := coercer.CoerceParams(bodySchema, requestData)
+	if invalidValue, ok := err.(*coercer.InvalidValueError); ok {
+		message := invalidValue.Error()
+		logging.Info(message)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		stripeError.ErrorInfo.Param = invalidValue.Param
+		return nil, stripeError
+	}
+	if err != nil {
+		message := fmt.Sprintf("Request coercion error: %v", err)
+		logging.Info(message)
+		return nil, createStripeError(typeInvalidRequestError, message)
+	}
+
+	// Check for missing parameters before running the validator so that we can
+	// produce an error that names the specific parameter like the Stripe API
+	// does.
+	missingParam := findMissingRequiredParam(bodySchema, requestData, "")
+	if missingParam != "" {
+		message := fmt.Sprintf(missingRequiredParam, missingParam)
+		logging.Info(message)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		stripeError.ErrorInfo.Param = missingParam
+		return nil, stripeError
+	}
+
+	// Some operations instead require one of several sets of parameters, like
+	// either a `customer` or a `source`
+	alternativeParam, message := findUnsatisfiedParamAlternatives(bodySchema,
+		requestData)
+	if message != "" {
+		logging.Info(message)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		stripeError.ErrorInfo.Param = alternativeParam
+		return nil, stripeError
+	}
+
+	// Parameters that the schema doesn't know about are likely typos, so name
+	// them specifically too.
+	unknownParam := findUnknownParam(bodySchema, requestData, "")
+	if unknownParam != "" {
+		message := fmt.Sprintf(receivedUnknownParam, unknownParam)
+		logging.Info(message)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		stripeError.ErrorInfo.Param = unknownParam
+		return nil, stripeError
+	}
+
+	// Similarly, check values against constraints like enums so that the
+	// error can name the parameter and describe what's allowed.
+	invalidParam, message := findInvalidParam(bodySchema, requestData, "")
+	if invalidParam != "" {
+		logging.Info(message)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		stripeError.ErrorInfo.Param = invalidParam
+		return nil, stripeError
+	}
+
+	if logging.DebugEnabled() {
+		logging.Debug("Validating request data", "data", fmt.Sprintf("%+v", requestData))
+	}
+	err = route.requestBodyValidator.Validate(requestData)
+	if err != nil {
+		message := fmt.Sprintf("Request validation error: %v", err)
+		logging.Info(message)
+		return nil, createStripeError(typeInvalidRequestError, message)
+	}
+
+	// All checks were successful.
+	applyParamDefaults(bodySchema, requestData)
+	return requestData, nil
+}
+
+func validateAuth(auth string) bool {
+	key := extractAPIKey(auth)
+	if key == "" {
+		return false
+	}
+
+	keyParts := strings.Split(key, "_")
+
+	// Expect ["sk", "test", "123"]
+	if len(keyParts) != 3 {
+		return false
+	}
+
+	if keyParts[0] != "rk" && keyParts[0] != "sk" {
+		return false
+	}
+
+	if keyParts[1] != "test" {
+		return false
+	}
+
+	// Expect something (anything but an empty string) in the third position
+	if len(keyParts[2]) == 0 {
+		return false
+	}
+
+	return true
+}
+
+// validateStrictAuth checks the value of an `Authorization` header under
+// -strict-auth. Returns nil if it contains a valid looking testmode secret or
+// restricted key, and otherwise the error to respond with.
+func validateStrictAuth(auth string) *ResponseError {
+	if auth == "" {
+		stripeError := createStripeError(typeInvalidRequestError,
+			authenticationRequired)
+		stripeError.ErrorInfo.Code = "authentication_required"
+		return stripeError
+	}
+
+	key := extractAPIKey(auth)
+	if !apiKeyPattern.MatchString(key) {
+		stripeError := createStripeError(typeInvalidRequestError,
+			fmt.Sprintf(invalidAPIKey, redactAPIKey(key)))
+		stripeError.ErrorInfo.Code = "invalid_api_key"
+		return stripeError
+	}
+
+	return nil
+}
+
+func writeResponse(w http.ResponseWriter, r *http.Request, start time.Time, status int, data interface{}) {
+	// A `204 No Content` can't include a body
+	if status == http.StatusNoContent {
+		w.Header().Set("Stripe-Mock-Version", Version)
+		w.WriteHeader(status)
+		logging.Info("Response", "status", status, "elapsed", time.Now().Sub(start))
+		return
+	}
+
+	if data == nil {
+		data = http.StatusText(status)
+	}
+
+	if stripeError, ok := data.(*ResponseError); ok {
+		if requestID := w.Header().Get("Request-Id"); requestID != "" {
+			stripeError.ErrorInfo.RequestLogURL = fmt.Sprintf(requestLogURL,
+				requestID, start.Unix())
+		}
+	}
+
+	format, _ := r.Context().Value(responseFormatKey{}).(*responseFormat)
+	if format == nil {
+		format = &responseFormat{}
+	}
+
+	pretty := format.pretty || isCurl(r.Header.Get("User-Agent"))
+	gzipped := format.compress && acceptsGzip(r.Header.Get("Accept-Encoding"))
+
+	// A large list is streamed to the client instead of being encoded in full
+	// first, unless it's compressed, which needs the whole encoding
+	list, streamed := streamableList(data)
+	streamed = streamed && !gzipped
+
+	var encodedData []byte
+	var err error
+
+	switch {
+	case streamed:
+		// Encoded as it's written below
+	case !pretty:
+		encodedData, err = json.Marshal(&data)
+	default:
+		encodedData, err = json.MarshalIndent(&data, "", "  ")
+		encodedData = append(encodedData, '\n')
+	}
+
+	if err != nil {
+		logging.Error("Couldn't serialize response", "error", err)
+		writeResponse(w, r, start, http.StatusInternalServerError, nil)
+		return
+	}
+
+	// Whether a response is compressed depends on the request, so caches
+	// need to keep them apart
+	if format.compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if gzipped {
+		encodedData, err = gzipData(encodedData)
+		if err != nil {
+			logging.Error("Couldn't compress response", "error", err)
+			writeResponse(w, r, start, http.StatusInternalServerError, nil)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(len(encodedData)))
+	}
+
+	if format.contentType != "" {
+		w.Header().Set("Content-Type", format.contentType)
+	}
+	w.Header().Set("Stripe-Mock-Version", Version)
+
+	w.WriteHeader(status)
+	if streamed {
+		err = writeStreamedList(w, list, pretty)
+	} else {
+		_, err = w.Write(encodedData)
+	}
+	if err != nil {
+		logging.Error("Couldn't write to client", "error", err)
+	}
+	logging.Info("Response", "status", status, "elapsed", time.Now().Sub(start))
+}
@@ -0,0 +1,2777 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/idempotency"
+	"github.com/stripe/stripe-mock/metrics"
+	"github.com/stripe/stripe-mock/ratelimit"
+	"github.com/stripe/stripe-mock/recording"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+//
+// Tests
+//
+
+func TestStubServer(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	_, ok := data["id"]
+	assert.True(t, ok)
+}
+
+func TestStubServer_MissingParam(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo, ok := data["error"].(map[string]interface{})
+	assert.True(t, ok)
+	errorType, ok := errorInfo["type"]
+	assert.Equal(t, errorType, "invalid_request_error")
+	assert.True(t, ok)
+	assert.Equal(t, "Missing required param: amount.", errorInfo["message"])
+	assert.Equal(t, "amount", errorInfo["param"])
+}
+
+func TestStubServer_ExtraParam(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=123&doesntexist=foo", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo, ok := data["error"].(map[string]interface{})
+	assert.True(t, ok)
+	errorType, ok := errorInfo["type"]
+	assert.Equal(t, errorType, "invalid_request_error")
+	assert.True(t, ok)
+	message, ok := errorInfo["message"]
+	assert.True(t, ok)
+	assert.Equal(t, "Received unknown parameter: doesntexist", message)
+	assert.Equal(t, "doesntexist", errorInfo["param"])
+}
+
+func TestStubServer_ReflectsParams(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=777", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 777.0, decodeResponse(t, body)["amount"])
+}
+
+func TestStubServer_EchoesMetadata(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=123&metadata[order_id]=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"order_id": "123"},
+		decodeResponse(t, body)["metadata"])
+}
+
+func TestStubServer_FiltersFields(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Stripe-Mock-Fields"] = "amount"
+
+	resp, body := sendRequest(t, "GET", "/v1/charges/ch_123", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data := decodeResponse(t, body)
+	assert.Equal(t, 3, len(data))
+	assert.Equal(t, "ch_123", data["id"])
+	assert.Equal(t, "charge", data["object"])
+	_, ok := data["amount"]
+	assert.True(t, ok)
+}
+
+func TestStubServer_InvalidParamType(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=abc", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t, "Invalid integer: abc", errorInfo["message"])
+	assert.Equal(t, "amount", errorInfo["param"])
+}
+
+func TestStubServer_SimulatesErrors(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Stripe-Mock-Error"] = "card_error:card_declined:insufficient_funds"
+
+	resp, body := sendRequest(t, "POST", "/v1/charges", "amount=123", headers)
+	assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "card_error", errorInfo["type"])
+	assert.Equal(t, "card_declined", errorInfo["code"])
+	assert.Equal(t, "insufficient_funds", errorInfo["decline_code"])
+	assert.Equal(t, "Your card has insufficient funds.", errorInfo["message"])
+	assert.True(t, strings.HasPrefix(errorInfo["charge"].(string), "ch_"))
+
+	// The header with an `X-` prefix works too
+	headers = getDefaultHeaders()
+	headers["X-Stripe-Mock-Error"] = "rate_limit_error:rate_limit"
+
+	resp, body = sendRequest(t, "GET", "/v1/charges", "", headers)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "rate_limit_error", errorInfo["type"])
+	_, ok := errorInfo["decline_code"]
+	assert.False(t, ok)
+
+	// An unknown error
+	headers = getDefaultHeaders()
+	headers["Stripe-Mock-Error"] = "card_error:not_a_code"
+
+	resp, body = sendRequest(t, "GET", "/v1/charges", "", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+}
+
+func TestStubServer_PreferredResponse(t *testing.T) {
+	operation := *realSpec.Paths["/v1/charges"]["post"]
+	operation.Responses = map[spec.StatusCode]spec.Response{
+		"200": operation.Responses["200"],
+		"402": {Content: map[string]spec.MediaType{
+			"application/json": {Schema: &spec.Schema{Ref: "#/components/schemas/error"}},
+		}},
+	}
+	s := realSpec
+	s.Paths = map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+		"/v1/charges": {"post": &operation},
+	}
+	server := &StubServer{spec: &s, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// The successful response is used by default
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&currency=usd", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "charge", decodeResponse(t, body)["object"])
+
+	// Another response can be preferred
+	headers := getDefaultHeaders()
+	headers["Prefer"] = "code=402"
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&currency=usd", headers)
+	assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	_, ok := decodeResponse(t, body)["error"]
+	assert.True(t, ok)
+
+	// One that the operation doesn't have falls back to the successful one
+	headers["Prefer"] = "code=404"
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&currency=usd", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_GenerateTimeout(t *testing.T) {
+	server := getStubServer(t)
+	server.generateTimeout = time.Hour
+
+	resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A timeout that's too short for any response to be generated
+	server.generateTimeout = time.Nanosecond
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "api_error", errorInfo["type"])
+	assert.Equal(t, fmt.Sprintf(generationTimedOut, time.Nanosecond), errorInfo["message"])
+}
+
+func TestStubServer_DeclinesTestCards(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=123&source=tok_chargeDeclined", getDefaultHeaders())
+	assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "card_error", errorInfo["type"])
+	assert.Equal(t, "card_declined", errorInfo["code"])
+	assert.Equal(t, "generic_decline", errorInfo["decline_code"])
+	assert.True(t, strings.HasPrefix(errorInfo["charge"].(string), "ch_"))
+	_, ok := errorInfo["payment_intent"]
+	assert.False(t, ok)
+
+	resp, _ = sendRequest(t, "POST", "/v1/charges",
+		"amount=123&source=tok_visa", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A PaymentIntent is included in the error, left needing a new source
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/payment_intents",
+		"amount=123&currency=usd&allowed_source_types[]=card&source=tok_chargeDeclined",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	paymentIntent := errorInfo["payment_intent"].(map[string]interface{})
+	assert.Equal(t, "payment_intent", paymentIntent["object"])
+	assert.Equal(t, 123.0, paymentIntent["amount"])
+	assert.Equal(t, "requires_source", paymentIntent["status"])
+	_, ok = errorInfo["charge"]
+	assert.False(t, ok)
+}
+
+func TestStubServer_InvalidAuthorization(t *testing.T) {
+	resp, body := sendRequest(t, "GET", "/a", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo, ok := data["error"].(map[string]interface{})
+	assert.True(t, ok)
+	errorType, ok := errorInfo["type"]
+	assert.Equal(t, errorType, "invalid_request_error")
+	assert.True(t, ok)
+	_, ok = errorInfo["message"]
+	assert.True(t, ok)
+}
+
+func TestStubServer_StrictAuth(t *testing.T) {
+	server := getStubServer(t)
+	server.strictAuth = true
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "authentication_required", errorInfo["code"])
+
+	headers := getDefaultHeaders()
+	headers["Authorization"] = "Bearer not_a_key"
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "", headers)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_api_key", errorInfo["code"])
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_StrictIDs(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// Any ID is accepted by default
+	resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges/cus_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	server.strictIDs = true
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges/cus_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "No such charge: cus_123", errorInfo["message"])
+
+	// Actions and deletions are checked against their resource too
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges/cus_123/capture",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = sendRequestToServer(t, server, "DELETE", "/v1/customers/ch_123",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "No such customer: ch_123", errorInfo["message"])
+
+	// IDs that may be chosen by the user aren't checked, and neither are
+	// those of responses that can be one of several resources
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/plans/gold", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET",
+		"/v1/customers/cus_123/sources/card_123", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_RestrictedKeysReadOnly(t *testing.T) {
+	server := getStubServer(t)
+	server.restrictedKeysReadOnly = true
+
+	headers := getDefaultHeaders()
+	headers["Authorization"] = "Bearer rk_test_123"
+
+	resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+
+	// Secret keys aren't affected
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_AllowsContentTypeWithParameters(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = "application/x-www-form-urlencoded; charset=utf-8"
+
+	resp, _ := sendRequest(t, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_SetsSpecialHeaders(t *testing.T) {
+	resp, _ := sendRequest(t, "POST", "/", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, Version, resp.Header.Get("Stripe-Mock-Version"))
+	unauthorizedRequestID := resp.Header.Get("Request-Id")
+	assert.Regexp(t, `\Areq_[a-zA-Z0-9]+\z`, unauthorizedRequestID)
+
+	resp, _ = sendRequest(t, "POST", "/", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, Version, resp.Header.Get("Stripe-Mock-Version"))
+	assert.Regexp(t, `\Areq_[a-zA-Z0-9]+\z`, resp.Header.Get("Request-Id"))
+
+	// Every request gets its own ID
+	assert.NotEqual(t, unauthorizedRequestID, resp.Header.Get("Request-Id"))
+}
+
+func TestStubServer_RequestLogURL(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	requestID := resp.Header.Get("Request-Id")
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Regexp(t, `\Ahttps://dashboard.stripe.com/test/logs/`+requestID+`\?t=\d+\z`,
+		errorInfo["request_log_url"])
+}
+
+func TestStubServer_SeededRequestIDs(t *testing.T) {
+	seed := int64(123)
+	requestIDs := func() []string {
+		server := getStubServer(t)
+		server.requestIDs = newRequestIDGenerator(&seed)
+
+		var ids []string
+		for i := 0; i < 3; i++ {
+			resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges", "",
+				getDefaultHeaders())
+			ids = append(ids, resp.Header.Get("Request-Id"))
+		}
+		return ids
+	}
+
+	// The same sequence of unique IDs is generated every time
+	ids := requestIDs()
+	assert.Equal(t, ids, requestIDs())
+	assert.NotEqual(t, ids[0], ids[1])
+	assert.NotEqual(t, ids[1], ids[2])
+}
+
+func TestStubServer_ParameterValidation(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/charges", "", getDefaultHeaders())
+	assert.Contains(t, string(body), "Missing required param: amount.")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStubServer_FormatsForCurl(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["User-Agent"] = "curl/1.2.3"
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=123", headers)
+
+	// Note the two spaces in front of "id" which indicate that our JSON is
+	// pretty printed.
+	assert.Contains(t, string(body), `  "id"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_ResponseFormat(t *testing.T) {
+	server := &StubServer{spec: &testSpec, fixtures: &testFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// By default responses are compact and have no Content-Type
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, string(body), "\n")
+	assert.Equal(t, "", resp.Header.Get("Content-Type"))
+	compact := body
+
+	server.responseFormat = newResponseFormat(&Config{
+		Pretty:              true,
+		ResponseContentType: "application/json; charset=utf-8",
+	})
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `  "id"`)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, decodeResponse(t, compact), decodeResponse(t, body))
+
+	// Errors are formatted the same way
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		map[string]string{})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, string(body), `  "error"`)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// Nothing changes if neither option is given
+	assert.Nil(t, newResponseFormat(&Config{}))
+}
+
+func TestStubServer_Compression(t *testing.T) {
+	server := &StubServer{spec: &testSpec, fixtures: &testFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	headers := getDefaultHeaders()
+	headers["Accept-Encoding"] = "gzip"
+
+	// Responses aren't compressed by default
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	uncompressed := decodeResponse(t, body)
+
+	server.responseFormat = newResponseFormat(&Config{Compression: true})
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	assert.NoError(t, err)
+	body, err = ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, uncompressed, decodeResponse(t, body))
+
+	// But only for requests that accept it
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, uncompressed, decodeResponse(t, body))
+
+	// Compressed request bodies are decompressed
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write([]byte("amount=123"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	headers = getDefaultHeaders()
+	headers["Content-Encoding"] = "gzip"
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		compressed.String(), headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 123.0, decodeResponse(t, body)["amount"])
+
+	// And ones that can't be are rejected
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStubServer_ErrorsOnEmptyContentType(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = ""
+
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo, ok := data["error"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t,
+		fmt.Sprintf(contentTypeEmpty, "application/x-www-form-urlencoded"),
+		errorInfo["message"])
+}
+
+func TestStubServer_AllowsEmptyContentTypeOnDelete(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = ""
+
+	resp, _ := sendRequest(t, "DELETE", "/v1/charges/ch_123", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_AllowsEmptyBodyWithoutRequiredParams(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	server.initializeRouter()
+
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = ""
+
+	// `invoicePayMethod` takes only optional parameters, so it can be called
+	// with no body at all
+	resp, body := sendRequestToServer(t, server, "POST",
+		"/v1/invoices/in_123/pay", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "invoice", decodeResponse(t, body)["object"])
+
+	// Operations with required parameters still need a body to carry them
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/coupons", "",
+		headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t,
+		fmt.Sprintf(contentTypeEmpty, "application/x-www-form-urlencoded"),
+		errorInfo["message"])
+}
+
+func TestStubServer_ErrorsOnMismatchedContentType(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = "text/plain"
+
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		"amount=123", headers)
+	fmt.Printf("body = %+v\n", string(body))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo, ok := data["error"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t,
+		fmt.Sprintf(contentTypeMismatched,
+			"application/x-www-form-urlencoded",
+			"text/plain"),
+		errorInfo["message"])
+}
+
+func TestStubServer_JSONBody(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = "application/json"
+
+	resp, body := sendRequest(t, "POST", "/v1/charges",
+		`{"amount": 123, "metadata": {"foo": "bar"}}`, headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data := decodeResponse(t, body)
+	assert.Equal(t, 123.0, data["amount"])
+	assert.Equal(t, map[string]interface{}{"foo": "bar"}, data["metadata"])
+
+	// Parameters are validated just like form-encoded ones
+	resp, body = sendRequest(t, "POST", "/v1/charges", `{"amount": 12.5}`, headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "amount", errorInfo["param"])
+	assert.Equal(t, "Invalid integer: 12.5", errorInfo["message"])
+
+	resp, body = sendRequest(t, "POST", "/v1/charges", `{"amount": 123, "foo": 1}`, headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "foo", errorInfo["param"])
+
+	resp, _ = sendRequest(t, "POST", "/v1/charges", `[123]`, headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStubServer_RateLimit(t *testing.T) {
+	server := getStubServer(t)
+	server.rateLimiter = ratelimit.NewLimiter(1)
+
+	resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "rate_limit_error", errorInfo["type"])
+	assert.Equal(t, "rate_limit", errorInfo["code"])
+
+	// With per-key limits, another key has its own allowance
+	server.rateLimitPerKey = true
+	headers := getDefaultHeaders()
+	headers["Authorization"] = "Bearer sk_test_456"
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStubServer_ReflectsIdempotencyKey(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers["Idempotency-Key"] = "my-key"
+
+	resp, _ := sendRequest(t, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, "my-key", resp.Header.Get("Idempotency-Key"))
+}
+
+func TestStubServer_ReplaysIdempotentRequests(t *testing.T) {
+	// Stateful mode gives each created object a unique ID, so we can tell
+	// whether a response was replayed
+	server := getStubServer(t)
+	server.idempotencyCache = idempotency.NewCache(time.Hour)
+	server.store = store.NewResourceStore()
+
+	headers := getDefaultHeaders()
+	headers["Idempotency-Key"] = "my-key"
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Idempotent-Replayed"))
+	chargeID := decodeResponse(t, body)["id"]
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, chargeID, decodeResponse(t, body)["id"])
+
+	// Reusing the key with different parameters is an error
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=456", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "idempotency_error", errorInfo["type"])
+
+	// Without the key, a new object is created
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, chargeID, decodeResponse(t, body)["id"])
+}
+
+func TestStubServer_IdempotencyKeyScope(t *testing.T) {
+	server := getStubServer(t)
+	server.idempotencyCache = idempotency.NewCache(time.Hour)
+	server.store = store.NewResourceStore()
+
+	send := func(apiKey, account, params string) (*http.Response, map[string]interface{}) {
+		headers := getDefaultHeaders()
+		headers["Authorization"] = "Bearer " + apiKey
+		headers["Idempotency-Key"] = "my-key"
+		if account != "" {
+			headers["Stripe-Account"] = account
+		}
+		resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+			params, headers)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp, decodeResponse(t, body)
+	}
+
+	// Each API key gets its own response for the same idempotency key, even
+	// with different parameters
+	_, charge1 := send("sk_test_123", "", "amount=123")
+	_, charge2 := send("sk_test_456", "", "amount=456")
+	assert.NotEqual(t, charge1["id"], charge2["id"])
+	assert.Equal(t, 456.0, charge2["amount"])
+
+	// And replays its own
+	resp, replayed := send("sk_test_123", "", "amount=123")
+	assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, charge1["id"], replayed["id"])
+
+	resp, replayed = send("sk_test_456", "", "amount=456")
+	assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, charge2["id"], replayed["id"])
+
+	// Requests made on behalf of a connected account are scoped to it
+	resp, _ = send("sk_test_123", "acct_123", "amount=789")
+	assert.Equal(t, "", resp.Header.Get("Idempotent-Replayed"))
+}
+
+func TestStubServer_Seed(t *testing.T) {
+	// Created objects are given random IDs in stateful mode
+	newServer := func(seed int64) *StubServer {
+		server := getStubServer(t)
+		server.seed = &seed
+		server.store = store.NewResourceStore()
+		return server
+	}
+
+	server1 := newServer(123)
+	server2 := newServer(123)
+
+	_, body1 := sendRequestToServer(t, server1, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	_, body2 := sendRequestToServer(t, server2, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, string(body1), string(body2))
+
+	// A second object from the same server doesn't reuse the ID of the first
+	_, body3 := sendRequestToServer(t, server1, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.NotEqual(t, decodeResponse(t, body1)["id"], decodeResponse(t, body3)["id"])
+
+	// A different seed gives a different ID
+	_, body4 := sendRequestToServer(t, newServer(456), "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.NotEqual(t, decodeResponse(t, body1)["id"], decodeResponse(t, body4)["id"])
+}
+
+func TestStubServer_SeedTimestamps(t *testing.T) {
+	// Objects are created at a fixed time with a seed, so the same request
+	// always gets the same bytes
+	seed := int64(42)
+	send := func() []byte {
+		server := &StubServer{spec: &realSpec, fixtures: &realFixtures, seed: &seed}
+		err := server.initializeRouter()
+		assert.NoError(t, err)
+
+		resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+			"amount=123&currency=usd", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		return body
+	}
+
+	body := send()
+	assert.Equal(t, string(body), string(send()))
+	assert.Equal(t, float64(seededTime.Unix()), decodeResponse(t, body)["created"])
+}
+
+func TestStubServer_Stateful(t *testing.T) {
+	server := getStubServer(t)
+	server.store = store.NewResourceStore()
+
+	// Lists start out empty
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list := decodeResponse(t, body)
+	assert.Equal(t, []interface{}{}, list["data"])
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&metadata[foo]=bar", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	created := decodeResponse(t, body)
+	id := created["id"].(string)
+	assert.NotEqual(t, "ch_123", id)
+	assert.Equal(t, map[string]interface{}{"foo": "bar"}, created["metadata"])
+
+	// The created object can be retrieved
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/"+id, "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, created, decodeResponse(t, body))
+
+	// And it's included in lists
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list = decodeResponse(t, body)
+	assert.Equal(t, []interface{}{created}, list["data"])
+
+	// Lists page through stored objects
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=456", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	newerID := decodeResponse(t, body)["id"].(string)
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges?limit=1", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list = decodeResponse(t, body)
+	assert.Equal(t, 1, len(list["data"].([]interface{})))
+	assert.Equal(t, newerID, list["data"].([]interface{})[0].(map[string]interface{})["id"])
+	assert.Equal(t, true, list["has_more"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/charges?limit=1&starting_after="+newerID, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list = decodeResponse(t, body)
+	assert.Equal(t, []interface{}{created}, list["data"])
+	assert.Equal(t, false, list["has_more"])
+
+	resp, _ = sendRequestToServer(t, server, "GET",
+		"/v1/charges?starting_after=ch_unknown", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "DELETE", "/v1/charges/"+newerID, "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "DELETE", "/v1/charges/"+id, "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// After being deleted, the object can no longer be retrieved
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/"+id, "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "No such charge: "+id, errorInfo["message"])
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list = decodeResponse(t, body)
+	assert.Equal(t, []interface{}{}, list["data"])
+
+	// Objects that were never created can't be retrieved either
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_other", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "resource_missing", errorInfo["code"])
+	assert.Equal(t, "No such charge: ch_other", errorInfo["message"])
+	assert.Equal(t, "id", errorInfo["param"])
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+
+	resp, _ = sendRequestToServer(t, server, "DELETE", "/v1/charges/ch_other", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestStubServer_ContentHashIDs(t *testing.T) {
+	server := getStubServer(t)
+
+	// Without a mode, a created object has its fixture's ID
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	fixtureID := decodeResponse(t, body)["id"]
+
+	server.idMode = IDModeContentHash
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	id := decodeResponse(t, body)["id"].(string)
+	assert.NotEqual(t, fixtureID, id)
+	assert.True(t, strings.HasPrefix(id, "ch_"))
+
+	// The same request gets the same ID every time
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, id, decodeResponse(t, body)["id"])
+
+	// And a different one gets a different ID
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=124", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, id, decodeResponse(t, body)["id"])
+}
+
+func TestStubServer_ReflectsExpandableID(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// A charge's `customer` is either an ID or an expanded customer, and an
+	// ID that's sent is reflected as it is
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&currency=usd&customer=cus_123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "cus_123", decodeResponse(t, body)["customer"])
+}
+
+func TestStubServer_PolymorphicNestedList(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// A customer's sources can be one of several resources, and the list is
+	// generated with one that refers back to the customer
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/customers/cus_123/sources",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list := decodeResponse(t, body)
+	assert.NotEmpty(t, list["data"])
+	for _, item := range list["data"].([]interface{}) {
+		assert.Equal(t, "cus_123", item.(map[string]interface{})["customer"])
+	}
+}
+
+func TestStubServer_StatefulNotCreatable(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	// Objects of resources that can't be created are still generated from
+	// fixtures
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/events/evt_other", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "evt_other", decodeResponse(t, body)["id"])
+
+	// And so are those of responses that can be one of several resources
+	resp, _ = sendRequestToServer(t, server, "GET",
+		"/v1/customers/cus_123/sources/card_other", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/customers/cus_other", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestStubServer_StatefulNestedList(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges/ch_1/refunds",
+		"amount=100", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	refund := decodeResponse(t, body)
+	assert.Equal(t, "ch_1", refund["charge"])
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/refunds",
+		"charge=ch_2", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Only the refunds of the charge in the path are listed
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_1/refunds",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list := decodeResponse(t, body)
+	assert.Equal(t, []interface{}{refund}, list["data"])
+	assert.Equal(t, "/v1/charges/ch_1/refunds", list["url"])
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/refunds", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, len(decodeResponse(t, body)["data"].([]interface{})))
+}
+
+func TestStubServer_StatefulPolymorphicNestedList(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/customers/cus_1/sources",
+		"source=tok_123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	source := decodeResponse(t, body)
+	assert.Equal(t, "cus_1", source["customer"])
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/customers/cus_2/sources",
+		"source=tok_123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Accounts can be among a customer's sources too, but can't refer to a
+	// customer, so they don't belong to any
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/accounts", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/customers/cus_1/sources",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{source}, decodeResponse(t, body)["data"])
+}
+func TestStubServer_FindsNestedLists(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/v1/charges/{charge}/refunds", server.nestedLists["charge"]["refunds"])
+	assert.Equal(t, "/v1/application_fees/{id}/refunds",
+		server.nestedLists["application_fee"]["refunds"])
+	assert.Equal(t, "/v1/customers/{customer}/sources",
+		server.nestedLists["customer"]["sources"])
+
+	// Paths that don't list a property of their parent aren't included
+	_, ok := server.nestedLists["customer"]["cards"]
+	assert.False(t, ok)
+	_, ok = server.nestedLists["charge"]["dispute"]
+	assert.False(t, ok)
+}
+
+func TestStubServer_StatefulNestedListInObject(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	// A new charge doesn't have any refunds yet
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=100&currency=usd", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	charge := decodeResponse(t, body)
+	chargeID := charge["id"].(string)
+	assert.Equal(t, map[string]interface{}{
+		"data":     []interface{}{},
+		"has_more": false,
+		"object":   "list",
+		"url":      "/v1/charges/" + chargeID + "/refunds",
+	}, charge["refunds"])
+
+	var refundIDs []string
+	for _, request := range []struct{ path, params string }{
+		{"/v1/refunds", "charge=" + chargeID},
+		{"/v1/charges/" + chargeID + "/refunds", ""},
+	} {
+		resp, body = sendRequestToServer(t, server, "POST", request.path,
+			request.params, getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		refundIDs = append([]string{decodeResponse(t, body)["id"].(string)}, refundIDs...)
+	}
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/refunds", "charge=ch_other",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Its refunds are the ones created for it, newest first, and they can be
+	// expanded further
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/charges/"+chargeID+"?expand[]=refunds.data.charge", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	refunds := decodeResponse(t, body)["refunds"].(map[string]interface{})
+	assert.Equal(t, "/v1/charges/"+chargeID+"/refunds", refunds["url"])
+	var ids []string
+	for _, item := range refunds["data"].([]interface{}) {
+		refund := item.(map[string]interface{})
+		ids = append(ids, refund["id"].(string))
+		assert.Equal(t, chargeID, refund["charge"].(map[string]interface{})["id"])
+	}
+	assert.Equal(t, refundIDs, ids)
+
+	// So are those of a charge in a list
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	item := decodeResponse(t, body)["data"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, 2, len(item["refunds"].(map[string]interface{})["data"].([]interface{})))
+}
+
+func TestStubServer_StatefulEmptyList(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/customers/cus_123/sources",
+		"source=tok_123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/reset", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Even a list of polymorphic objects is still a well-formed list once
+	// there's nothing in it
+	for _, path := range []string{"/v1/charges", "/v1/customers/cus_123/sources"} {
+		resp, body := sendRequestToServer(t, server, "GET", path, "",
+			getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, map[string]interface{}{
+			"data":     []interface{}{},
+			"has_more": false,
+			"object":   "list",
+			"url":      path,
+		}, decodeResponse(t, body))
+	}
+}
+
+func TestStubServer_StatefulCreatedFilter(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/seed",
+		`{"charge": [{"id": "ch_100", "created": 100}, {"id": "ch_200", "created": 200},
+			{"id": "ch_300", "created": 300}]}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	testCases := []struct {
+		query string
+		ids   []interface{}
+	}{
+		{"", []interface{}{"ch_300", "ch_200", "ch_100"}},
+		{"created=200", []interface{}{"ch_200"}},
+		{"created[gte]=200", []interface{}{"ch_300", "ch_200"}},
+		{"created[gt]=200", []interface{}{"ch_300"}},
+		{"created[gt]=100&created[lte]=200", []interface{}{"ch_200"}},
+		{"created[lt]=200", []interface{}{"ch_100"}},
+		{"created[gt]=300", []interface{}{}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.query, func(t *testing.T) {
+			resp, body := sendRequestToServer(t, server, "GET",
+				"/v1/charges?"+testCase.query, "", getDefaultHeaders())
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			list := decodeResponse(t, body)
+			ids := []interface{}{}
+			for _, charge := range list["data"].([]interface{}) {
+				ids = append(ids, charge.(map[string]interface{})["id"])
+			}
+			assert.Equal(t, testCase.ids, ids)
+		})
+	}
+
+	// Malformed filters are rejected whether or not there's a store
+	for _, stateful := range []bool{true, false} {
+		if !stateful {
+			server.store = nil
+		}
+		for _, query := range []string{"created[gteq]=200", "created[gte]=abc"} {
+			resp, body := sendRequestToServer(t, server, "GET", "/v1/charges?"+query, "",
+				getDefaultHeaders())
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+			assert.Equal(t, strings.SplitN(query, "=", 2)[0], errorInfo["param"])
+		}
+	}
+}
+
+func TestStubServer_StatefulPolymorphicList(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/customers/cus_123/sources",
+		"source=tok_123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	source := decodeResponse(t, body)
+	assert.Equal(t, "cus_123", source["customer"])
+
+	// The created source is listed under its customer, but not others
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/customers/cus_123/sources",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{source}, decodeResponse(t, body)["data"])
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/customers/cus_456/sources",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{}, decodeResponse(t, body)["data"])
+}
+
+func TestStubServer_DeleteNestedResource(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// The deleted object has the ID from the path, and is of the resource
+	// that the ID belongs to
+	resp, body := sendRequestToServer(t, server, "DELETE",
+		"/v1/customers/cus_123/sources/card_123", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data := decodeResponse(t, body)
+	assert.Equal(t, "card_123", data["id"])
+	assert.Equal(t, "card", data["object"])
+	assert.Equal(t, true, data["deleted"])
+
+	resp, body = sendRequestToServer(t, server, "DELETE",
+		"/v1/customers/cus_123/sources/ba_123", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data = decodeResponse(t, body)
+	assert.Equal(t, "ba_123", data["id"])
+	assert.Equal(t, "bank_account", data["object"])
+}
+
+func TestStubServer_StatefulDeleteNestedResource(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	// A card, which isn't the first of the resources that a source can be
+	server.store.Put("card", "card_123", map[string]interface{}{
+		"customer": "cus_123",
+		"id":       "card_123",
+		"object":   "card",
+	})
+
+	resp, body := sendRequestToServer(t, server, "GET",
+		"/v1/customers/cus_123/sources", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, len(decodeResponse(t, body)["data"].([]interface{})))
+
+	resp, body = sendRequestToServer(t, server, "DELETE",
+		"/v1/customers/cus_123/sources/card_123", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data := decodeResponse(t, body)
+	assert.Equal(t, "card_123", data["id"])
+	assert.Equal(t, "card", data["object"])
+
+	// It's gone from its customer's sources
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/customers/cus_123/sources", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{}, decodeResponse(t, body)["data"])
+
+	resp, _ = sendRequestToServer(t, server, "GET",
+		"/v1/customers/cus_123/sources/card_123", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestStubServer_Action(t *testing.T) {
+	resp, body := sendRequest(t, "POST", "/v1/invoices/in_456/pay", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{
+		"id":     "in_456",
+		"object": "invoice",
+		"paid":   true,
+		"status": "paid",
+	}, decodeResponse(t, body))
+}
+
+func TestStubServer_CORS(t *testing.T) {
+	cors, err := newCORSConfig(nil, "")
+	assert.NoError(t, err)
+	server := getStubServer(t)
+	server.cors = cors
+
+	// Preflight requests are answered without an API key
+	resp, _ := sendRequestToServer(t, server, "OPTIONS", "/v1/charges", "",
+		map[string]string{
+			"Access-Control-Request-Method": "POST",
+			"Origin":                        "http://localhost:3000",
+		})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "http://localhost:3000",
+		resp.Header.Get("Access-Control-Allow-Origin"))
+
+	headers := getDefaultHeaders()
+	headers["Origin"] = "http://localhost:3000"
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/charges", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "http://localhost:3000",
+		resp.Header.Get("Access-Control-Allow-Origin"))
+
+	// Without CORS, a preflight request is like any other
+	server.cors = nil
+	resp, _ = sendRequestToServer(t, server, "OPTIONS", "/v1/charges", "",
+		map[string]string{
+			"Access-Control-Request-Method": "POST",
+			"Origin":                        "http://localhost:3000",
+		})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestStubServer_StatefulAction(t *testing.T) {
+	server := getStubServer(t)
+	server.store = store.NewResourceStore()
+	server.store.Put("invoice", "in_456", map[string]interface{}{
+		"amount_due": 100,
+		"id":         "in_456",
+		"object":     "invoice",
+		"paid":       false,
+		"status":     "open",
+	})
+
+	// The stored object is the one that's paid
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/invoices/in_456/pay",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	expected := map[string]interface{}{
+		"amount_due": 100.0,
+		"id":         "in_456",
+		"object":     "invoice",
+		"paid":       true,
+		"status":     "paid",
+	}
+	assert.Equal(t, expected, decodeResponse(t, body))
+
+	stored, ok := server.store.Get("invoice", "in_456")
+	assert.True(t, ok)
+	assert.Equal(t, "paid", stored["status"])
+}
+
+func TestStubServer_StripeAccount(t *testing.T) {
+	server := getStubServer(t)
+	server.store = store.NewResourceStore()
+
+	accountHeaders := getDefaultHeaders()
+	accountHeaders["Stripe-Account"] = "acct_123"
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", accountHeaders)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The object is visible to the account that created it
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		accountHeaders)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, len(decodeResponse(t, body)["data"].([]interface{})))
+
+	// Including when the header is sent under its legacy name
+	legacyHeaders := getDefaultHeaders()
+	legacyHeaders["stripe_account"] = "acct_123"
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		legacyHeaders)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, len(decodeResponse(t, body)["data"].([]interface{})))
+
+	// But not to the platform or to another account
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{}, decodeResponse(t, body)["data"])
+
+	otherHeaders := getDefaultHeaders()
+	otherHeaders["Stripe-Account"] = "acct_456"
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		otherHeaders)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{}, decodeResponse(t, body)["data"])
+
+	// A malformed account ID
+	badHeaders := getDefaultHeaders()
+	badHeaders["Stripe-Account"] = "cus_123"
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		badHeaders)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+}
+
+func TestStubServer_MaxBodySize(t *testing.T) {
+	server := getStubServer(t)
+	server.maxBodySize = 20
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A body that's declared to be too large
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&description=too-long", getDefaultHeaders())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t, fmt.Sprintf(bodyTooLarge, 20), errorInfo["message"])
+
+	// And one without a declared length that's too large once it's read
+	req := httptest.NewRequest("POST", "https://stripe.com/v1/charges",
+		bytes.NewBufferString("amount=123&description=too-long"))
+	req.ContentLength = -1
+	for k, v := range getDefaultHeaders() {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	server.HandleRequest(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestStubServer_Metrics(t *testing.T) {
+	server := getStubServer(t)
+	server.store = store.NewResourceStore()
+	server.metrics = metrics.NewMetrics(server.store.Len)
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v1/doesnt-exist", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var out bytes.Buffer
+	err := server.metrics.Write(&out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(),
+		`stripe_mock_requests_total{method="POST",path="/v1/charges",status="200"} 1`)
+	assert.Contains(t, out.String(),
+		`stripe_mock_requests_total{method="GET",path="(unmatched)",status="404"} 1`)
+	assert.Contains(t, out.String(),
+		`stripe_mock_response_generation_seconds_count{path="/v1/charges"} 1`)
+	assert.Contains(t, out.String(), "stripe_mock_stored_objects 1")
+}
+
+func TestStubServer_Reset(t *testing.T) {
+	server := getStubServer(t)
+	server.idempotencyCache = idempotency.NewCache(time.Hour)
+	server.store = store.NewResourceStore()
+
+	headers := getDefaultHeaders()
+	headers["Idempotency-Key"] = "key_123"
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/reset",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, 0, len(body))
+
+	// Stored objects are gone
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{}, decodeResponse(t, body)["data"])
+
+	// And the idempotency key can be reused with different parameters
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=456", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Resetting works in stateless mode too
+	resp, _ = sendRequest(t, "POST", "/v1/_stripe_mock/reset", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestStubServer_SelectsAPIVersion(t *testing.T) {
+	server := getStubServer(t)
+	server.apiVersion = "2018-07-27"
+
+	// A spec for an old version that doesn't know about charges
+	oldServer := &StubServer{
+		apiVersion: "2017-01-01",
+		fixtures:   &testFixtures,
+		spec:       &spec.Spec{},
+	}
+	err := oldServer.initializeRouter()
+	assert.NoError(t, err)
+
+	server.versions = map[string]*StubServer{
+		"2017-01-01": oldServer,
+		"2018-07-27": server,
+	}
+
+	// No version uses the default
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	headers := getDefaultHeaders()
+	headers["Stripe-Version"] = "2018-07-27"
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	headers["Stripe-Version"] = "2017-01-01"
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	headers["Stripe-Version"] = "2099-01-01"
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t,
+		fmt.Sprintf(invalidAPIVersion, "2099-01-01", "2017-01-01, 2018-07-27"),
+		errorInfo["message"])
+}
+
+func TestStubServer_ShapesResponseForAPIVersion(t *testing.T) {
+	server, newServer := getVersionedStubServers(t)
+
+	headers := getDefaultHeaders()
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotNil(t, decodeResponse(t, body)["metadata"])
+
+	// The field was removed from the newer version's charge, so it's not in
+	// the response even though it's in the fixture
+	headers["Stripe-Version"] = newServer.apiVersion
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data := decodeResponse(t, body)
+	assert.Nil(t, data["metadata"])
+	assert.Equal(t, "ch_123", data["id"])
+}
+
+func TestStubServer_StatefulShapesResponseForAPIVersion(t *testing.T) {
+	server, newServer := getVersionedStubServers(t)
+	resourceStore := store.NewResourceStore()
+	server.store = resourceStore
+	newServer.store = resourceStore
+
+	// Created with the older version, which includes the field
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	created := decodeResponse(t, body)
+	assert.NotNil(t, created["metadata"])
+	id := created["id"].(string)
+
+	headers := getDefaultHeaders()
+	headers["Stripe-Version"] = newServer.apiVersion
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/"+id, "",
+		headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data := decodeResponse(t, body)
+	assert.Nil(t, data["metadata"])
+	assert.Equal(t, id, data["id"])
+	assert.Equal(t, 123.0, data["amount"])
+
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges", "", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list := decodeResponse(t, body)["data"].([]interface{})
+	assert.Equal(t, 1, len(list))
+	assert.Nil(t, list[0].(map[string]interface{})["metadata"])
+
+	// Stored objects aren't changed by being shaped for another version
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/"+id, "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotNil(t, decodeResponse(t, body)["metadata"])
+}
+
+func TestStubServer_ListPaginationCursorConflict(t *testing.T) {
+	resp, body := sendRequest(t, "GET",
+		"/v1/charges?starting_after=ch_123&ending_before=ch_456", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t,
+		"You may only specify one of these parameters: ending_before, starting_after.",
+		errorInfo["message"])
+}
+
+func TestStubServer_MaxListSize(t *testing.T) {
+	server := getStubServer(t)
+	server.maxListSize = 5
+	seed := int64(42)
+	server.seed = &seed
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges?limit=100",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list := decodeResponse(t, body)
+	length := len(list["data"].([]interface{}))
+	assert.True(t, length >= 1 && length <= 5)
+	assert.Equal(t, false, list["has_more"])
+
+	// With a seed, the list is the same size every time, so it can be paged
+	// through consistently
+	var ids []string
+	var cursor string
+	for {
+		resp, body = sendRequestToServer(t, server, "GET",
+			"/v1/charges?limit=1&starting_after="+cursor, "", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		list = decodeResponse(t, body)
+		data := list["data"].([]interface{})
+		assert.Equal(t, 1, len(data))
+		cursor = data[0].(map[string]interface{})["id"].(string)
+		ids = append(ids, cursor)
+
+		if list["has_more"] == false {
+			break
+		}
+		assert.True(t, len(ids) < length)
+	}
+	assert.Equal(t, length, len(ids))
+}
+
+func TestStubServer_RecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recordings.jsonl")
+
+	recorder, err := recording.NewRecorder(path)
+	assert.NoError(t, err)
+	server := getStubServer(t)
+	server.recorder = recorder
+
+	headers := getDefaultHeaders()
+	headers["Idempotency-Key"] = "key_123"
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	recorded := decodeResponse(t, body)
+	assert.NoError(t, recorder.Close())
+
+	replayer, err := recording.LoadReplayer(path)
+	assert.NoError(t, err)
+	server = getStubServer(t)
+	server.replayer = replayer
+
+	// Headers like the idempotency key don't need to match
+	headers["Idempotency-Key"] = "key_456"
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Stripe-Mock-Replayed"))
+	assert.Equal(t, recorded, decodeResponse(t, body))
+
+	// The whole response was recorded, so fields can still be selected
+	headers["Stripe-Mock-Fields"] = "id"
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"id": recorded["id"], "object": "charge"},
+		decodeResponse(t, body))
+
+	// Other requests are generated as usual
+	resp, _ = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=456", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Stripe-Mock-Replayed"))
+}
+
+func TestStubServer_Expansion(t *testing.T) {
+	server := getStubServer(t)
+	server.maxExpansionDepth = 2
+
+	resp, body := sendRequestToServer(t, server, "GET",
+		"/v1/charges/ch_123?expand[]=customer", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	charge := decodeResponse(t, body)
+	assert.Equal(t,
+		testFixtures.Resources["customer"].(map[string]interface{})["id"],
+		charge["customer"].(map[string]interface{})["id"])
+
+	// Without an expansion, only the customer's ID is included
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, charge["customer"].(map[string]interface{})["id"],
+		decodeResponse(t, body)["customer"])
+	assert.Equal(t, "ch_123", charge["id"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/charges/ch_123?expand[]=customer.id", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t, "This property cannot be expanded (customer.id).",
+		errorInfo["message"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/charges/ch_123?expand[]=amount", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "This property cannot be expanded (amount).",
+		errorInfo["message"])
+
+	// Malformed expansions get a different error, which takes precedence over
+	// the others
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/charges/ch_123?expand[]=amount&expand[]=customer..id.id", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t, "expand", errorInfo["param"])
+	assert.Equal(t, fmt.Sprintf(invalidExpansion, "customer..id.id"),
+		errorInfo["message"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/charges/ch_123?expand[]=customer.id.id", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t,
+		"You cannot expand more than 2 levels of a property. Property: customer.id.id.",
+		errorInfo["message"])
+}
+
+func TestStubServer_NoExpand(t *testing.T) {
+	server := getStubServer(t)
+	server.noExpand = true
+
+	// Expansions are ignored, even ones that would otherwise be rejected
+	for _, expand := range []string{"customer", "customer.id", "customer..id"} {
+		resp, body := sendRequestToServer(t, server, "GET",
+			"/v1/charges/ch_123?expand[]="+expand, "", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t,
+			testFixtures.Resources["customer"].(map[string]interface{})["id"],
+			decodeResponse(t, body)["customer"])
+	}
+}
+
+func TestStubServer_StatefulExpansion(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	server.store = store.NewResourceStore()
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=777&currency=usd", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	charge := decodeResponse(t, body)
+	chargeID := charge["id"].(string)
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/refunds",
+		"charge="+chargeID, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	refundID := decodeResponse(t, body)["id"].(string)
+
+	// The charge now lists the refund
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/charges/"+chargeID,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	charge = decodeResponse(t, body)
+	refunds := charge["refunds"].(map[string]interface{})["data"].([]interface{})
+	assert.Equal(t, refundID, refunds[0].(map[string]interface{})["id"])
+
+	// The stored charge is expanded into the stored refund
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/refunds/"+refundID+"?expand[]=charge", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	refund := decodeResponse(t, body)
+	assert.Equal(t, refundID, refund["id"])
+	assert.Equal(t, charge, refund["charge"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/refunds?expand[]=data.charge", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{refund}, decodeResponse(t, body)["data"])
+
+	// But stays unexpanded in storage
+	resp, body = sendRequestToServer(t, server, "GET", "/v1/refunds/"+refundID,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, chargeID, decodeResponse(t, body)["charge"])
+
+	resp, body = sendRequestToServer(t, server, "GET",
+		"/v1/refunds/"+refundID+"?expand[]=amount", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "This property cannot be expanded (amount).", errorInfo["message"])
+}
+
+func TestStubServer_UnknownRoute(t *testing.T) {
+	server := getStubServer(t)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		resp, body := sendRequestToServer(t, server, method, "/v1/nope", "",
+			getDefaultHeaders())
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+		assert.Equal(t, "invalid_request_error", errorInfo["type"])
+		assert.Equal(t, "Unrecognized request URL ("+method+": /v1/nope). "+
+			"Please see https://stripe.com/docs or we can help at "+
+			"https://support.stripe.com/.", errorInfo["message"])
+		assert.Nil(t, errorInfo["param"])
+	}
+
+}
+
+func TestStubServer_MethodNotAllowed(t *testing.T) {
+	server := getStubServer(t)
+
+	resp, body := sendRequestToServer(t, server, http.MethodDelete, "/v1/charges",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "GET, POST", resp.Header.Get("Allow"))
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	assert.Equal(t, "Unrecognized request method for URL (DELETE: /v1/charges). "+
+		"The URL can be requested with GET or POST.", errorInfo["message"])
+
+	// Paths with parameters are matched too
+	resp, _ = sendRequestToServer(t, server, http.MethodPut, "/v1/charges/ch_123",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.NotEqual(t, "", resp.Header.Get("Allow"))
+
+	// Unknown paths are still just unrecognized
+	resp, _ = sendRequestToServer(t, server, http.MethodDelete, "/v1/nope", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Allow"))
+}
+
+func TestStubServer_RoutesRequest(t *testing.T) {
+	server := getStubServer(t)
+
+	{
+		route, pathParams := server.routeRequest(
+			&http.Request{Method: "GET", URL: &url.URL{Path: "/v1/charges"}})
+		assert.NotNil(t, route)
+		assert.Equal(t, chargeAllMethod, route.operation)
+		assert.Nil(t, pathParams)
+	}
+
+	{
+		route, pathParams := server.routeRequest(
+			&http.Request{Method: "POST", URL: &url.URL{Path: "/v1/charges"}})
+		assert.NotNil(t, route)
+		assert.Equal(t, chargeCreateMethod, route.operation)
+		assert.Nil(t, pathParams)
+	}
+
+	{
+		route, pathParams := server.routeRequest(
+			&http.Request{Method: "GET", URL: &url.URL{Path: "/v1/charges/ch_123"}})
+		assert.NotNil(t, route)
+		assert.Equal(t, chargeGetMethod, route.operation)
+		assert.Equal(t, "ch_123", *(*pathParams).PrimaryID)
+		assert.Equal(t, []*PathParamsSecondaryID(nil), (*pathParams).SecondaryIDs)
+	}
+
+	{
+		route, pathParams := server.routeRequest(
+			&http.Request{Method: "DELETE", URL: &url.URL{Path: "/v1/charges/ch_123"}})
+		assert.NotNil(t, route)
+		assert.Equal(t, chargeDeleteMethod, route.operation)
+		assert.Equal(t, "ch_123", *(*pathParams).PrimaryID)
+		assert.Equal(t, []*PathParamsSecondaryID(nil), (*pathParams).SecondaryIDs)
+	}
+
+	{
+		route, pathParams := server.routeRequest(
+			&http.Request{Method: "GET", URL: &url.URL{Path: "/v1/doesnt-exist"}})
+		assert.Equal(t, (*stubServerRoute)(nil), route)
+		assert.Nil(t, pathParams)
+	}
+
+	// Route with a parameter, but not an object's primary ID
+	{
+		route, pathParams := server.routeRequest(
+			&http.Request{Method: "POST",
+				URL: &url.URL{Path: "/v1/invoices/in_123/pay"}})
+		assert.NotNil(t, route)
+		assert.Equal(t, invoicePayMethod, route.operation)
+		assert.Equal(t, "in_123", *(*pathParams).PrimaryID)
+		assert.Equal(t, []*PathParamsSecondaryID(nil), (*pathParams).SecondaryIDs)
+	}
+
+	// Route with a parameter, but not an object's primary ID
+	{
+		route, pathParams := server.routeRequest(
+			&http.Request{Method: "GET",
+				URL: &url.URL{Path: "/v1/application_fees/fee_123/refunds"}})
+		assert.NotNil(t, route)
+		assert.Equal(t, applicationFeeRefundCreateMethod, route.operation)
+		assert.Equal(t, (*string)(nil), (*pathParams).PrimaryID)
+		assert.Equal(t, 1, len((*pathParams).SecondaryIDs))
+		assert.Equal(t, "fee_123", (*pathParams).SecondaryIDs[0].ID)
+		assert.Equal(t, "fee", (*pathParams).SecondaryIDs[0].Name)
+	}
+
+	// Route with multiple parameters in its URL
+	{
+		route, pathParams := server.routeRequest(
+			&http.Request{Method: "GET",
+				URL: &url.URL{Path: "/v1/application_fees/fee_123/refunds/fr_123"}})
+		assert.NotNil(t, route)
+		assert.Equal(t, applicationFeeRefundGetMethod, route.operation)
+		assert.Equal(t, "fr_123", *(*pathParams).PrimaryID)
+		assert.Equal(t, 1, len((*pathParams).SecondaryIDs))
+		assert.Equal(t, "fee_123", (*pathParams).SecondaryIDs[0].ID)
+		assert.Equal(t, "fee", (*pathParams).SecondaryIDs[0].Name)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	testCases := []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"*", true},
+		{"GZIP", true},
+		{"", false},
+		{"deflate, br", false},
+		{"gzip;q=0", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tc.want, acceptsGzip(tc.acceptEncoding))
+		})
+	}
+}
+
+func TestApplyParamDefaults(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"capture": {Default: true, Type: "boolean"},
+			"card": {
+				Properties: map[string]*spec.Schema{
+					"exp_year": {Type: "integer"},
+					"object":   {Default: "card", Type: "string"},
+				},
+				Type: "object",
+			},
+			"description": {Type: "string"},
+			"metadata": {
+				Properties: map[string]*spec.Schema{
+					"source": {Default: "stripe-mock", Type: "string"},
+				},
+				Type: "object",
+			},
+		},
+		Type: "object",
+	}
+
+	data := map[string]interface{}{
+		"card": map[string]interface{}{"exp_year": 2030},
+	}
+	applyParamDefaults(schema, data)
+	assert.Equal(t, map[string]interface{}{
+		"capture": true,
+		"card":    map[string]interface{}{"exp_year": 2030, "object": "card"},
+	}, data)
+
+	// Parameters that were given are left alone
+	data = map[string]interface{}{"capture": false}
+	applyParamDefaults(schema, data)
+	assert.Equal(t, false, data["capture"])
+}
+
+func TestCheckParamValue(t *testing.T) {
+	schema := &spec.Schema{
+		Enum: []interface{}{"day", "month", "year"},
+		Type: "string",
+	}
+	assert.Equal(t, "", checkParamValue(schema, "month"))
+	assert.Equal(t, "must be one of day, month, or year",
+		checkParamValue(schema, "week"))
+
+	// An anyOf whose branches all have enums allows members of any of them
+	schema = &spec.Schema{
+		AnyOf: []*spec.Schema{
+			{Enum: []interface{}{"automatic", "manual"}, Type: "string"},
+			{Enum: []interface{}{""}, Type: "string"},
+		},
+	}
+	assert.Equal(t, "", checkParamValue(schema, ""))
+	assert.Equal(t, "", checkParamValue(schema, "manual"))
+	assert.Equal(t, "must be one of automatic, manual, or ",
+		checkParamValue(schema, "other"))
+
+	// But other anyOfs are left to the validator
+	schema = &spec.Schema{
+		AnyOf: []*spec.Schema{
+			{Enum: []interface{}{""}, Type: "string"},
+			{Type: "integer"},
+		},
+	}
+	assert.Equal(t, "", checkParamValue(schema, "other"))
+}
+
+func TestCheckParamValue_Range(t *testing.T) {
+	minimum := 1.0
+	maximum := 100.0
+	schema := &spec.Schema{
+		Maximum: &maximum,
+		Minimum: &minimum,
+		Type:    "integer",
+	}
+	assert.Equal(t, "", checkParamValue(schema, 1))
+	assert.Equal(t, "", checkParamValue(schema, 100))
+	assert.Equal(t, "must be at least 1", checkParamValue(schema, 0))
+	assert.Equal(t, "must be at most 100", checkParamValue(schema, 101))
+	assert.Equal(t, "must be at most 100", checkParamValue(schema, 100.5))
+}
+
+func TestCheckParamValue_String(t *testing.T) {
+	schema := &spec.Schema{
+		MaxLength: 5,
+		MinLength: 2,
+		Type:      "string",
+	}
+	assert.Equal(t, "", checkParamValue(schema, "abcde"))
+	assert.Equal(t, "must be at least 2 characters", checkParamValue(schema, "a"))
+	assert.Equal(t, "must be at most 5 characters",
+		checkParamValue(schema, "abcdef"))
+
+	// Lengths are in characters rather than bytes
+	assert.Equal(t, "", checkParamValue(schema, "ééééé"))
+
+	schema = &spec.Schema{
+		Pattern: "^[A-Z]{2}$",
+		Type:    "string",
+	}
+	assert.Equal(t, "", checkParamValue(schema, "US"))
+	assert.Equal(t, "must match the pattern ^[A-Z]{2}$",
+		checkParamValue(schema, "usa"))
+
+	// Patterns that can't be compiled are ignored
+	schema = &spec.Schema{
+		Pattern: "(?<=a)b",
+		Type:    "string",
+	}
+	assert.Equal(t, "", checkParamValue(schema, "c"))
+}
+
+func TestFindInvalidParam(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"items": {
+				Items: &spec.Schema{
+					Properties: map[string]*spec.Schema{
+						"tax_behavior": {
+							Enum: []interface{}{"exclusive", "inclusive"},
+							Type: "string",
+						},
+					},
+					Type: "object",
+				},
+				Type: "array",
+			},
+			"recurring": {
+				Properties: map[string]*spec.Schema{
+					"interval": {
+						Enum: []interface{}{"day", "month"},
+						Type: "string",
+					},
+				},
+				Type: "object",
+			},
+			"statuses": {
+				Items: &spec.Schema{
+					Enum: []interface{}{"active", "canceled"},
+					Type: "string",
+				},
+				Type: "array",
+			},
+		},
+		Type: "object",
+	}
+
+	testCases := []struct {
+		data            map[string]interface{}
+		expectedParam   string
+		expectedMessage string
+	}{
+		{map[string]interface{}{}, "", ""},
+		{
+			map[string]interface{}{
+				"recurring": map[string]interface{}{"interval": "month"},
+			},
+			"", "",
+		},
+		{
+			map[string]interface{}{
+				"recurring": map[string]interface{}{"interval": "week"},
+			},
+			"recurring[interval]",
+			"Invalid recurring[interval]: must be one of day or month.",
+		},
+		{
+			map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{"tax_behavior": "exclusive"},
+					map[string]interface{}{"tax_behavior": "other"},
+				},
+			},
+			"items[1][tax_behavior]",
+			"Invalid items[1][tax_behavior]: must be one of exclusive or inclusive.",
+		},
+		{
+			map[string]interface{}{
+				"statuses": []interface{}{"active", "past_due"},
+			},
+			"statuses[1]",
+			"Invalid statuses[1]: must be one of active or canceled.",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.data), func(t *testing.T) {
+			param, message := findInvalidParam(schema, tc.data, "")
+			assert.Equal(t, tc.expectedParam, param)
+			assert.Equal(t, tc.expectedMessage, message)
+		})
+	}
+}
+
+func TestFindMissingRequiredParam(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"amount": {Type: "integer"},
+			"items": {
+				Items: &spec.Schema{
+					Properties: map[string]*spec.Schema{
+						"price":    {Type: "string"},
+						"quantity": {Type: "integer"},
+					},
+					Required: []string{"price"},
+					Type:     "object",
+				},
+				Type: "array",
+			},
+			"source": {
+				AnyOf: []*spec.Schema{
+					{Type: "string"},
+					{
+						Properties: map[string]*spec.Schema{
+							"exp_month": {Type: "integer"},
+							"number":    {Type: "string"},
+						},
+						Required: []string{"number"},
+						Type:     "object",
+					},
+				},
+			},
+		},
+		Required: []string{"amount"},
+		Type:     "object",
+	}
+
+	testCases := []struct {
+		data     map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"amount": 123}, ""},
+		{map[string]interface{}{}, "amount"},
+
+		// Nested objects are only checked when they're present
+		{map[string]interface{}{"amount": 123, "source": "tok_123"}, ""},
+		{map[string]interface{}{
+			"amount": 123,
+			"source": map[string]interface{}{"number": "4242424242424242"},
+		}, ""},
+		{map[string]interface{}{
+			"amount": 123,
+			"source": map[string]interface{}{"exp_month": 12},
+		}, "source[number]"},
+
+		// Objects in arrays
+		{map[string]interface{}{
+			"amount": 123,
+			"items": []interface{}{
+				map[string]interface{}{"price": "price_123"},
+				map[string]interface{}{"quantity": 2},
+			},
+		}, "items[1][price]"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.expected, func(t *testing.T) {
+			assert.Equal(t, testCase.expected,
+				findMissingRequiredParam(schema, testCase.data, ""))
+		})
+	}
+}
+
+func TestFindMissingRequiredParam_Discriminator(t *testing.T) {
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"payment_method_data": {
+				AnyOf: []*spec.Schema{
+					{
+						Properties: map[string]*spec.Schema{
+							"card": {Type: "string"},
+							"type": {Enum: []interface{}{"card"}, Type: "string"},
+						},
+						Required: []string{"card", "type"},
+						Type:     "object",
+					},
+					{
+						Properties: map[string]*spec.Schema{
+							"iban": {Type: "string"},
+							"type": {Enum: []interface{}{"sepa_debit"}, Type: "string"},
+						},
+						Required: []string{"iban", "type"},
+						Type:     "object",
+					},
+				},
+				Discriminator: &spec.Discriminator{PropertyName: "type"},
+			},
+		},
+		Type: "object",
+	}
+
+	// The branch named by the discriminator is the one that's checked
+	assert.Equal(t, "payment_method_data[iban]",
+		findMissingRequiredParam(schema, map[string]interface{}{
+			"payment_method_data": map[string]interface{}{"type": "sepa_debit"},
+		}, ""))
+	assert.Equal(t, "payment_method_data[card]",
+		findMissingRequiredParam(schema, map[string]interface{}{
+			"payment_method_data": map[string]interface{}{"type": "card"},
+		}, ""))
+}
+
+func TestFindUnsatisfiedParamAlternatives(t *testing.T) {
+	properties := map[string]*spec.Schema{
+		"account":  {Type: "string"},
+		"customer": {Type: "string"},
+		"source":   {Type: "string"},
+	}
+
+	// Exactly one of the alternatives
+	schema := &spec.Schema{
+		OneOf: []*spec.Schema{
+			{Required: []string{"customer"}},
+			{Required: []string{"source"}},
+		},
+		Properties: properties,
+		Type:       "object",
+	}
+
+	param, message := findUnsatisfiedParamAlternatives(schema,
+		map[string]interface{}{"customer": "cus_123"})
+	assert.Equal(t, "", param)
+	assert.Equal(t, "", message)
+
+	param, message = findUnsatisfiedParamAlternatives(schema,
+		map[string]interface{}{})
+	assert.Equal(t, "", param)
+	assert.Equal(t, "Must provide exactly one of these params: customer or source.",
+		message)
+
+	param, message = findUnsatisfiedParamAlternatives(schema,
+		map[string]interface{}{"customer": "cus_123", "source": "tok_123"})
+	assert.Equal(t, "source", param)
+	assert.Equal(t, "Must provide exactly one of these params: customer or source.",
+		message)
+
+	// At least one of the alternatives, which may take several parameters
+	schema = &spec.Schema{
+		AnyOf: []*spec.Schema{
+			{Required: []string{"account", "customer"}},
+			{Required: []string{"source"}},
+		},
+		Properties: properties,
+		Type:       "object",
+	}
+
+	param, message = findUnsatisfiedParamAlternatives(schema,
+		map[string]interface{}{"account": "acct_123", "customer": "cus_123",
+			"source": "tok_123"})
+	assert.Equal(t, "", param)
+	assert.Equal(t, "", message)
+
+	param, message = findUnsatisfiedParamAlternatives(schema,
+		map[string]interface{}{"customer": "cus_123"})
+	assert.Equal(t, "", param)
+	assert.Equal(t,
+		"Must provide at least one of these params: account and customer or source.",
+		message)
+
+	// Branches that describe objects aren't alternatives
+	schema = &spec.Schema{
+		AnyOf: []*spec.Schema{
+			{Properties: properties, Required: []string{"customer"}, Type: "object"},
+			{Type: "string"},
+		},
+	}
+	param, message = findUnsatisfiedParamAlternatives(schema,
+		map[string]interface{}{})
+	assert.Equal(t, "", param)
+	assert.Equal(t, "", message)
+}
+
+func TestStubServer_ParamAlternatives(t *testing.T) {
+	server := &StubServer{
+		spec: &spec.Spec{
+			Components: testSpec.Components,
+			Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+				"/v1/charges": {
+					"post": &spec.Operation{
+						RequestBody: &spec.RequestBody{
+							Content: map[string]spec.MediaType{
+								"application/x-www-form-urlencoded": {
+									Schema: &spec.Schema{
+										OneOf: []*spec.Schema{
+											{Required: []string{"customer"}},
+											{Required: []string{"source"}},
+										},
+										Properties: map[string]*spec.Schema{
+											"amount":   {Type: "integer"},
+											"customer": {Type: "string"},
+											"source":   {Type: "string"},
+										},
+										Required: []string{"amount"},
+										Type:     "object",
+									},
+								},
+							},
+						},
+						Responses: chargeCreateMethod.Responses,
+					},
+				},
+			},
+		},
+		fixtures: &testFixtures,
+	}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&customer=cus_123", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "Must provide exactly one of these params: customer or source.",
+		errorInfo["message"])
+
+	resp, body = sendRequestToServer(t, server, "POST", "/v1/charges",
+		"amount=123&customer=cus_123&source=tok_123", getDefaultHeaders())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorInfo = decodeResponse(t, body)["error"].(map[string]interface{})
+	assert.Equal(t, "source", errorInfo["param"])
+}
+
+func TestFindUnknownParam(t *testing.T) {
+	schema := &spec.Schema{
+		AdditionalProperties: false,
+		Properties: map[string]*spec.Schema{
+			"amount":  {Type: "integer"},
+			"created": {ReadOnly: true, Type: "integer"},
+			"items": {
+				Items: &spec.Schema{
+					AdditionalProperties: false,
+					Properties: map[string]*spec.Schema{
+						"price": {Type: "string"},
+					},
+					Type: "object",
+				},
+				Type: "array",
+			},
+			"metadata": {
+				AdditionalProperties: map[string]interface{}{"type": "string"},
+				Type:                 "object",
+			},
+			"shipping": {
+				AdditionalProperties: true,
+				Properties: map[string]*spec.Schema{
+					"address": {
+						AdditionalProperties: false,
+						Properties: map[string]*spec.Schema{
+							"city":     {Type: "string"},
+							"verified": {ReadOnly: true, Type: "boolean"},
+						},
+						Type: "object",
+					},
+				},
+				Type: "object",
+			},
+		},
+		Type: "object",
+	}
+
+	testCases := []struct {
+		data     map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"amount": 123}, ""},
+		{map[string]interface{}{"amount": 123, "foo": "bar"}, "foo"},
+
+		// The first unknown parameter in order is reported
+		{map[string]interface{}{"bar": "baz", "foo": "bar"}, "bar"},
+
+		// Additional properties described by a schema or `true` are allowed
+		{map[string]interface{}{
+			"metadata": map[string]interface{}{"foo": "bar"},
+		}, ""},
+		{map[string]interface{}{
+			"shipping": map[string]interface{}{"foo": "bar"},
+		}, ""},
+
+		// Nested objects, including those in arrays
+		{map[string]interface{}{
+			"shipping": map[string]interface{}{
+				"address": map[string]interface{}{"town": "Ottawa"},
+			},
+		}, "shipping[address][town]"},
+		{map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"price": "price_123"},
+				map[string]interface{}{"prize": "price_123"},
+			},
+		}, "items[1][prize]"},
+
+		// Read-only properties can't be set, even where additional
+		// properties are allowed
+		{map[string]interface{}{"amount": 123, "created": 123}, "created"},
+		{map[string]interface{}{
+			"shipping": map[string]interface{}{
+				"address": map[string]interface{}{"verified": true},
+			},
+		}, "shipping[address][verified]"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.expected, func(t *testing.T) {
+			assert.Equal(t, testCase.expected,
+				findUnknownParam(schema, testCase.data, ""))
+		})
+	}
+}
+
+func TestGetValidator(t *testing.T) {
+	operation := &spec.Operation{RequestBody: &spec.RequestBody{
+		Content: map[string]spec.MediaType{
+			"application/x-www-form-urlencoded": {
+				Schema: &spec.Schema{
+					Properties: map[string]*spec.Schema{
+						"name": {
+							Type: "string",
+						},
+					},
+				},
+			},
+		},
+	}}
+	mediaType, schema := getRequestBodySchema(operation)
+	assert.NotNil(t, mediaType)
+	assert.Equal(t, "application/x-www-form-urlencoded", *mediaType)
+	assert.NotNil(t, schema)
+
+	validator, err := spec.GetValidatorForOpenAPI3Schema(schema, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, validator)
+
+	goodData := map[string]interface{}{
+		"name": "foo",
+	}
+	assert.NoError(t, validator.Validate(goodData))
+
+	badData := map[string]interface{}{
+		"name": 7,
+	}
+	assert.Error(t, validator.Validate(badData))
+}
+
+func TestGetValidator_NoSuitableParameter(t *testing.T) {
+	method := &spec.Operation{Parameters: []*spec.Parameter{
+		{Schema: nil},
+	}}
+	mediaType, schema := getRequestBodySchema(method)
+	assert.Nil(t, mediaType)
+	assert.Nil(t, schema)
+}
+
+func TestIsCurl(t *testing.T) {
+	testCases := []struct {
+		userAgent string
+		want      bool
+	}{
+		{"curl/7.51.0", true},
+
+		// false because it's not something (to my knowledge) that cURL would
+		// ever return
+		{"curl", false},
+
+		{"Mozilla", false},
+		{"", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.userAgent, func(t *testing.T) {
+			assert.Equal(t, tc.want, isCurl(tc.userAgent))
+		})
+	}
+}
+
+func TestJoinWithOr(t *testing.T) {
+	assert.Equal(t, "", joinWithOr(nil))
+	assert.Equal(t, "a", joinWithOr([]string{"a"}))
+	assert.Equal(t, "a or b", joinWithOr([]string{"a", "b"}))
+	assert.Equal(t, "a, b, or c", joinWithOr([]string{"a", "b", "c"}))
+}
+
+func TestRedactAPIKey(t *testing.T) {
+	assert.Equal(t, "sk_test_****6789", redactAPIKey("sk_test_123456789"))
+	assert.Equal(t, "****6789", redactAPIKey("123456789"))
+	assert.Equal(t, "123", redactAPIKey("123"))
+}
+
+func TestValidateStrictAuth(t *testing.T) {
+	assert.Nil(t, validateStrictAuth("Bearer sk_test_123"))
+	assert.Nil(t, validateStrictAuth("Bearer rk_test_123"))
+	assert.Nil(t, validateStrictAuth("Basic "+encode64("sk_test_123:")))
+	assert.Nil(t, validateStrictAuth("basic "+encode64("rk_test_123:")))
+
+	stripeError := validateStrictAuth("")
+	assert.Equal(t, "authentication_required", stripeError.ErrorInfo.Code)
+
+	stripeError = validateStrictAuth("Bearer sk_live_123456789")
+	assert.Equal(t, "invalid_api_key", stripeError.ErrorInfo.Code)
+	assert.Equal(t, "Invalid API Key provided: sk_live_****6789",
+		stripeError.ErrorInfo.Message)
+
+	stripeError = validateStrictAuth("Bearer sk_test_123_extra")
+	assert.Equal(t, "invalid_api_key", stripeError.ErrorInfo.Code)
+
+	// Keys given with basic authentication are checked just the same
+	stripeError = validateStrictAuth("Basic " + encode64("sk_live_123456789:"))
+	assert.Equal(t, "invalid_api_key", stripeError.ErrorInfo.Code)
+	assert.Equal(t, "Invalid API Key provided: sk_live_****6789",
+		stripeError.ErrorInfo.Message)
+}
+
+func TestValidateAuth(t *testing.T) {
+	testCases := []struct {
+		auth string
+		want bool
+	}{
+		{"Basic " + encode64("sk_test_123"), true},
+		{"Basic " + encode64("sk_test_123:"), true},
+		{"Basic " + encode64("sk_test_123:password"), true},
+		{"Basic " + strings.TrimRight(encode64("sk_test_1234:"), "="), true},
+		{"basic " + encode64("sk_test_123:"), true},
+		{"bearer sk_test_123", true},
+		{"Bearer sk_test_123", true},
+		{"Bearer rk_test_123", true},
+		{"", false},
+		{"Bearer", false},
+		{"Basic", false},
+		{"Bearer ", false},
+		{"Basic ", false},
+		{"Basic 123", false}, // "123" is not a valid key when base64 decoded
+		{"Basic " + encode64("sk_test"), false},
+		{"Basic " + encode64("sk_test_123:") + "!", false},
+		{"Digest sk_test_123", false},
+		{"Bearer sk_test_123 extra", false},
+		{"Bearer sk_test", false},
+		{"Bearer sk_test_123_extra", false},
+		{"Bearer sk_live_123", false},
+		{"Bearer pk_test_123", false},
+		{"Bearer sk_test_", false},
+	}
+	for _, tc := range testCases {
+		t.Run("Authorization: "+tc.auth, func(t *testing.T) {
+			assert.Equal(t, tc.want, validateAuth(tc.auth))
+		})
+	}
+}
+
+//
+// Tests for private functions
+//
+
+func TestCompilePath(t *testing.T) {
+	{
+		pattern, pathParamNames := compilePath(spec.Path("/v1/charges"))
+		assert.Equal(t, `\A/v1/charges\z`, pattern.String())
+		assert.Equal(t, []string(nil), pathParamNames)
+	}
+
+	{
+		pattern, pathParamNames := compilePath(spec.Path("/v1/charges/{id}"))
+		assert.Equal(t, `\A/v1/charges/(?P<id>[\w-_.]+)\z`, pattern.String())
+		assert.Equal(t, []string{"id"}, pathParamNames)
+	}
+}
+
+func TestNormalizeHeaderAliases(t *testing.T) {
+	header := http.Header{}
+	header.Set("stripe_version", "2018-07-27")
+	header.Set("Stripe_Account", "acct_123")
+	normalizeHeaderAliases(header)
+	assert.Equal(t, http.Header{
+		"Stripe-Account": {"acct_123"},
+		"Stripe-Version": {"2018-07-27"},
+	}, header)
+
+	// The proper name wins over an alias
+	header = http.Header{}
+	header.Set("Idempotency-Key", "key_123")
+	header.Set("Idempotency_Key", "key_456")
+	normalizeHeaderAliases(header)
+	assert.Equal(t, http.Header{"Idempotency-Key": {"key_123"}}, header)
+}
+
+func TestParsePreferredCode(t *testing.T) {
+	testCases := []struct {
+		prefer string
+		code   int
+		ok     bool
+	}{
+		{"code=402", 402, true},
+		{"code=\"402\"", 402, true},
+		{"dynamic=true, code=404", 404, true},
+		{"code=201; foo=bar", 201, true},
+		{"", 0, false},
+		{"dynamic=true", 0, false},
+		{"code=abc", 0, false},
+		{"code=1000", 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.prefer, func(t *testing.T) {
+			code, ok := parsePreferredCode(tc.prefer)
+			assert.Equal(t, tc.code, code)
+			assert.Equal(t, tc.ok, ok)
+		})
+	}
+}
+
+func TestFindInvalidExpansion(t *testing.T) {
+	assert.Equal(t, "", findInvalidExpansion(nil))
+	assert.Equal(t, "", findInvalidExpansion(
+		[]string{"customer", "data.customer.default_source", "*", "data.*"}))
+
+	for _, expansion := range []string{
+		"", ".customer", "customer.", "customer..source", "Customer", "customer[id]",
+		"customer source", "custom*",
+	} {
+		assert.Equal(t, expansion,
+			findInvalidExpansion([]string{"customer", expansion}), expansion)
+	}
+}
+
+func TestParseExpansionLevel(t *testing.T) {
+	emptyExpansionLevel := &ExpansionLevel{
+		expansions: make(map[string]*ExpansionLevel),
+	}
+	testCases := []struct {
+		expansions []string
+		want       *ExpansionLevel
+	}{
+		{
+			[]string{"charge", "customer"},
+			&ExpansionLevel{expansions: map[string]*ExpansionLevel{
+				"charge":   emptyExpansionLevel,
+				"customer": emptyExpansionLevel,
+			}},
+		},
+		{
+			[]string{"charge.customer", "customer", "charge.source"},
+			&ExpansionLevel{expansions: map[string]*ExpansionLevel{
+				"charge": {expansions: map[string]*ExpansionLevel{
+					"customer": emptyExpansionLevel,
+					"source":   emptyExpansionLevel,
+				}},
+				"customer": emptyExpansionLevel,
+			}},
+		},
+		{
+			[]string{"charge.customer.default_source", "charge"},
+			&ExpansionLevel{expansions: map[string]*ExpansionLevel{
+				"charge": {expansions: map[string]*ExpansionLevel{
+					"customer": {expansions: map[string]*ExpansionLevel{
+						"default_source": emptyExpansionLevel,
+					}},
+				}},
+			}},
+		},
+		{
+			[]string{"*"},
+			&ExpansionLevel{
+				expansions: map[string]*ExpansionLevel{},
+				wildcard:   true,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.expansions), func(t *testing.T) {
+			assert.Equal(t, tc.want, parseExpansionLevel(tc.expansions))
+		})
+	}
+}
+
+//
+// Private functions
+//
+
+func decodeResponse(t *testing.T, body []byte) map[string]interface{} {
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	return data
+}
+
+func encode64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func getDefaultHeaders() map[string]string {
+	headers := make(map[string]string)
+	headers["Authorization"] = "Bearer sk_test_123"
+	headers["Content-Type"] = "application/x-www-form-urlencoded"
+	return headers
+}
+
+func getStubServer(t *testing.T) *StubServer {
+	server := &StubServer{spec: &testSpec, fixtures: &testFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	return server
+}
+
+func sendRequest(t *testing.T, method string, url string, params string,
+	headers map[string]string) (*http.Response, []byte) {
+
+	return sendRequestToServer(t, getStubServer(t), method, url, params, headers)
+}
+
+// getVersionedStubServers gets a server for the test spec along with one for
+// a newer API version whose charges don't have `metadata`. Requests for either
+// version can be sent to the first one.
+func getVersionedStubServers(t *testing.T) (*StubServer, *StubServer) {
+	server := getStubServer(t)
+	server.apiVersion = "2018-07-27"
+
+	newSpec := testSpec
+	newSpec.Components.Schemas = make(map[string]*spec.Schema)
+	for name, schema := range testSpec.Components.Schemas {
+		newSpec.Components.Schemas[name] = schema
+	}
+
+	newCharge := *testSpec.Components.Schemas["charge"]
+	newCharge.Properties = make(map[string]*spec.Schema)
+	for name, schema := range testSpec.Components.Schemas["charge"].Properties {
+		if name != "metadata" {
+			newCharge.Properties[name] = schema
+		}
+	}
+	newSpec.Components.Schemas["charge"] = &newCharge
+
+	newServer := &StubServer{
+		apiVersion: "2019-02-19",
+		fixtures:   &testFixtures,
+		spec:       &newSpec,
+	}
+	err := newServer.initializeRouter()
+	assert.NoError(t, err)
+
+	server.versions = map[string]*StubServer{
+		server.apiVersion:    server,
+		newServer.apiVersion: newServer,
+	}
+	return server, newServer
+}
+
+func sendRequestToServer(t *testing.T, server *StubServer, method string,
+	url string, params string, headers map[string]string) (*http.Response, []byte) {
+
+	fullURL := fmt.Sprintf("https://stripe.com%s", url)
+	req := httptest.NewRequest(method, fullURL, bytes.NewBufferString(params))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	server.HandleRequest(w, req)
+
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, body
+}
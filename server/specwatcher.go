@@ -0,0 +1,290 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stripe/stripe-mock/logging"
+)
+
+// specWatchDebounce is how long the files watched with -watch have to go
+// without changing before they're reloaded, so that the several events of a
+// single save cause only one reload.
+const specWatchDebounce = 100 * time.Millisecond
+
+// fileWatcher detects changes to a set of files with notifications from the
+// operating system. The directory containing each file is watched rather
+// than the file itself, so that a file keeps being watched after it's
+// replaced (like many editors do on save) or removed and created again.
+//
+// A path may be a directory, in which case the JSON files in it are watched,
+// including ones that are added or removed.
+type fileWatcher struct {
+	// dirs are the watched directories, all of whose JSON files are watched.
+	dirs map[string]bool
+
+	// files are the watched files.
+	files map[string]bool
+
+	watcher *fsnotify.Watcher
+}
+
+// newFileWatcher initializes a fileWatcher for the given paths. Empty paths
+// and URLs are ignored. It should be closed when it's no longer needed.
+func newFileWatcher(paths ...string) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &fileWatcher{
+		dirs:    make(map[string]bool),
+		files:   make(map[string]bool),
+		watcher: watcher,
+	}
+	watched := make(map[string]bool)
+	for _, path := range paths {
+		if path == "" || isURL(path) {
+			continue
+		}
+		path = filepath.Clean(path)
+
+		dir := filepath.Dir(path)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dir = path
+			w.dirs[path] = true
+		} else {
+			w.files[path] = true
+		}
+
+		if watched[dir] {
+			continue
+		}
+		err := watcher.Add(dir)
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		watched[dir] = true
+	}
+	return w, nil
+}
+
+// close stops watching the files.
+func (w *fileWatcher) close() error {
+	return w.watcher.Close()
+}
+
+// isWatched checks whether a file that an event was received for is one of
+// the watched files, or a JSON file in one of the watched directories.
+func (w *fileWatcher) isWatched(name string) bool {
+	name = filepath.Clean(name)
+	return w.files[name] || (w.dirs[filepath.Dir(name)] && isJSONFile(name))
+}
+
+// wait blocks until any of the watched files changes, and then until they've
+// gone specWatchDebounce without changing again. It returns true once they
+// have, or false if done is closed first.
+func (w *fileWatcher) wait(done <-chan struct{}) bool {
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-done:
+			return false
+
+		case <-settled:
+			return true
+
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return false
+			}
+
+			// A change of permissions alone doesn't change what's loaded
+			if event.Op == fsnotify.Chmod || !w.isWatched(event.Name) {
+				continue
+			}
+			settled = time.After(specWatchDebounce)
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return false
+			}
+			logging.Error("Couldn't watch spec files", "error", err)
+		}
+	}
+}
+
+// urlWatcher detects changes to a set of spec and fixtures URLs by fetching
+// them again. Requests are made conditional on the `ETag` of the last
+// response, and content is compared by its hash, so a server that doesn't
+// send ETags works too.
+type urlWatcher struct {
+	urls   []string
+	etags  map[string]string
+	hashes map[string][sha256.Size]byte
+}
+
+// newURLWatcher initializes a urlWatcher for the given paths, fetching each
+// to record its current content. Paths that aren't URLs are ignored.
+func newURLWatcher(paths ...string) *urlWatcher {
+	w := &urlWatcher{
+		etags:  make(map[string]string),
+		hashes: make(map[string][sha256.Size]byte),
+	}
+	for _, path := range paths {
+		if !isURL(path) {
+			continue
+		}
+		w.urls = append(w.urls, path)
+		w.check(path)
+	}
+	return w
+}
+
+// changed checks whether the content of any of the watched URLs has changed
+// since the last time it was called (or since the watcher was initialized).
+func (w *urlWatcher) changed() bool {
+	changed := false
+	for _, url := range w.urls {
+		if w.check(url) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// check fetches a URL and returns true if its content is different from the
+// last time it was fetched successfully. A URL that can't be fetched is
+// logged and counts as unchanged, so the last good version is kept.
+func (w *urlWatcher) check(url string) bool {
+	data, etag, err := w.fetch(url)
+	if err != nil {
+		logging.Error("Couldn't fetch spec", "url", url, "error", err)
+		return false
+	}
+
+	// Not modified since the last response with this ETag
+	if data == nil {
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+	previous, ok := w.hashes[url]
+	w.etags[url] = etag
+	w.hashes[url] = hash
+	return !ok || hash != previous
+}
+
+// fetch gets the content of a URL along with its `ETag`. The content is nil
+// if the server responded that it's unchanged since the last ETag.
+func (w *urlWatcher) fetch(url string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag := w.etags[url]; etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := specClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("responded with status %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// watchSpecFiles reloads the spec and fixtures of a server from the files in
+// config (SpecPath, FixturesPath, FixturesDir, and FixturesOverridePath)
+// whenever watcher detects that any of them changed. Any path may be empty
+// to keep using the bundled version. It returns once done is closed, so it
+// should be run in a goroutine, and closes watcher when it does.
+func watchSpecFiles(server *StubServer, config *Config, watcher *fileWatcher,
+	done <-chan struct{}) {
+
+	defer watcher.close()
+
+	for watcher.wait(done) {
+		reloadSpec(server, config)
+	}
+}
+
+// watchSpecURLs is like watchSpecFiles, but polls the paths in config that
+// are URLs every SpecPollInterval.
+func watchSpecURLs(server *StubServer, config *Config, done <-chan struct{}) {
+	watcher := newURLWatcher(config.SpecPath, config.FixturesPath,
+		config.FixturesOverridePath)
+
+	ticker := time.NewTicker(config.SpecPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if watcher.changed() {
+			reloadSpec(server, config)
+		}
+	}
+}
+
+// reloadSpec reloads the spec and fixtures of a server from config after a
+// change was detected, logging whether it succeeded.
+func reloadSpec(server *StubServer, config *Config) {
+	err := reloadSpecFiles(server, config)
+	if err != nil {
+		logging.Error("Couldn't reload spec", "error", err)
+		return
+	}
+	logging.Info("Reloaded spec and fixtures")
+}
+
+// reloadSpecFiles loads the spec and fixtures from the files (or URLs) in
+// config and swaps them into a server.
+func reloadSpecFiles(server *StubServer, config *Config) error {
+	newSpec, err := getSpec(config.SpecPath)
+	if err != nil {
+		return err
+	}
+
+	fixtures, err := getFixtures(config.FixturesPath)
+	if err != nil {
+		return err
+	}
+
+	dirFixtures, err := getFixturesDir(config.FixturesDir)
+	if err != nil {
+		return err
+	}
+	fixtures = overrideFixtures(fixtures, dirFixtures, false)
+
+	override, err := getFixturesOverride(config.FixturesOverridePath)
+	if err != nil {
+		return err
+	}
+
+	return server.reload(newSpec,
+		overrideFixtures(fixtures, override, config.FixturesOverrideReplace))
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/spec"
+)
+
+//
+// Tests
+//
+
+func TestFileWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "spec.json")
+	err = ioutil.WriteFile(path, []byte("{}"), 0644)
+	assert.NoError(t, err)
+
+	watcher, err := newFileWatcher(path, "", "https://example.com/spec3.json")
+	assert.NoError(t, err)
+	defer watcher.close()
+	assert.False(t, waitForChange(watcher))
+
+	err = ioutil.WriteFile(path, []byte(`{"paths": {}}`), 0644)
+	assert.NoError(t, err)
+	assert.True(t, waitForChange(watcher))
+	assert.False(t, waitForChange(watcher))
+
+	// Other files in the same directory aren't watched
+	err = ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte("{}"), 0644)
+	assert.NoError(t, err)
+	assert.False(t, waitForChange(watcher))
+
+	// Replacing a file like an editor saving it is a change, and the new file
+	// keeps being watched
+	replacementPath := filepath.Join(dir, "spec.json.tmp")
+	err = ioutil.WriteFile(replacementPath, []byte(`{"paths": {"/": {}}}`), 0644)
+	assert.NoError(t, err)
+	err = os.Rename(replacementPath, path)
+	assert.NoError(t, err)
+	assert.True(t, waitForChange(watcher))
+
+	err = ioutil.WriteFile(path, []byte("{}"), 0644)
+	assert.NoError(t, err)
+	assert.True(t, waitForChange(watcher))
+
+	// Removing a file is a change too
+	err = os.Remove(path)
+	assert.NoError(t, err)
+	assert.True(t, waitForChange(watcher))
+
+	// As are adding and removing JSON files in a watched directory
+	fixturesDir := filepath.Join(dir, "fixtures")
+	err = os.Mkdir(fixturesDir, 0755)
+	assert.NoError(t, err)
+
+	watcher, err = newFileWatcher(fixturesDir)
+	assert.NoError(t, err)
+	defer watcher.close()
+	assert.False(t, waitForChange(watcher))
+
+	fixturePath := filepath.Join(fixturesDir, "charge.json")
+	err = ioutil.WriteFile(fixturePath, []byte("{}"), 0644)
+	assert.NoError(t, err)
+	assert.True(t, waitForChange(watcher))
+	assert.False(t, waitForChange(watcher))
+
+	err = ioutil.WriteFile(filepath.Join(fixturesDir, "README.md"), nil, 0644)
+	assert.NoError(t, err)
+	assert.False(t, waitForChange(watcher))
+
+	err = os.Remove(fixturePath)
+	assert.NoError(t, err)
+	assert.True(t, waitForChange(watcher))
+}
+
+func TestReloadSpecFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server := getStubServer(t)
+
+	// A spec with only a subset of the test spec's paths
+	newSpec := spec.Spec{
+		Components: testSpec.Components,
+		Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+			"/v1/charges": testSpec.Paths["/v1/charges"],
+		},
+	}
+	specPath := filepath.Join(dir, "spec.json")
+	data, err := json.Marshal(&newSpec)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(specPath, data, 0644)
+	assert.NoError(t, err)
+
+	err = reloadSpecFiles(server, &Config{SpecPath: specPath})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(server.spec.Paths))
+
+	resp, _ := sendRequestToServer(t, server, "GET", "/v1/charges/ch_123", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// A broken spec leaves the server as it was
+	err = ioutil.WriteFile(specPath, []byte(`{"paths":`), 0644)
+	assert.NoError(t, err)
+
+	err = reloadSpecFiles(server, &Config{SpecPath: specPath})
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(server.spec.Paths))
+}
+
+func TestURLWatcher(t *testing.T) {
+	content := "{}"
+	etag := `"1"`
+	requests := 0
+	notModified := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if etag != "" && r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if content == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	// Paths that aren't URLs are ignored
+	watcher := newURLWatcher(ts.URL+"/spec.json", "spec.json", "")
+	assert.Equal(t, 1, requests)
+	assert.False(t, watcher.changed())
+	assert.Equal(t, 1, notModified)
+
+	// A new ETag with new content is a change
+	content = `{"paths": {}}`
+	etag = `"2"`
+	assert.True(t, watcher.changed())
+	assert.False(t, watcher.changed())
+
+	// Without ETags, content is compared
+	etag = ""
+	assert.False(t, watcher.changed())
+	content = `{"paths": {"/v1/charges": {}}}`
+	assert.True(t, watcher.changed())
+
+	// A failed request keeps the last good version
+	content = ""
+	assert.False(t, watcher.changed())
+	content = `{"paths": {"/v1/charges": {}}}`
+	assert.False(t, watcher.changed())
+}
+
+func TestReloadSpecFiles_URL(t *testing.T) {
+	server := getStubServer(t)
+
+	newSpec := spec.Spec{
+		Components: testSpec.Components,
+		Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+			"/v1/charges": testSpec.Paths["/v1/charges"],
+		},
+	}
+	data, err := json.Marshal(&newSpec)
+	assert.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	err = reloadSpecFiles(server, &Config{SpecPath: ts.URL + "/spec3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(server.spec.Paths))
+
+	// A URL that can't be fetched leaves the server as it was
+	ts.Close()
+	err = reloadSpecFiles(server, &Config{SpecPath: ts.URL + "/spec3"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(server.spec.Paths))
+}
+
+//
+// Private functions
+//
+
+// waitForChange waits a short time for a fileWatcher to detect a change,
+// returning whether it did.
+func waitForChange(watcher *fileWatcher) bool {
+	done := make(chan struct{})
+	timer := time.AfterFunc(500*time.Millisecond, func() { close(done) })
+	defer timer.Stop()
+	return watcher.wait(done)
+}
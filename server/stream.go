@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// streamableList checks whether response data is a list that's large enough
+// to be streamed to the client (see writeStreamedList), returning it if so.
+func streamableList(data interface{}) (map[string]interface{}, bool) {
+	list, ok := data.(map[string]interface{})
+	if !ok || list["object"] != "list" {
+		return nil, false
+	}
+	items, ok := list["data"].([]interface{})
+	if !ok || len(items) < streamedListMinLength {
+		return nil, false
+	}
+	return list, true
+}
+
+// writeStreamedList writes a list to w as JSON, encoding the objects in its
+// `data` one at a time so that the encoding of the whole list is never held
+// in memory. w is flushed every streamedListFlushInterval objects if it's an
+// http.Flusher so that the client receives the list as it's written.
+//
+// The output is the same as that of json.Marshal, or of json.MarshalIndent
+// followed by a newline if pretty is set.
+func writeStreamedList(w io.Writer, list map[string]interface{}, pretty bool) error {
+	// Keys are written in the same sorted order that json.Marshal uses
+	keys := make([]string, 0, len(list))
+	for key := range list {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	stream := &jsonStream{pretty: pretty, w: w}
+	stream.write("{")
+	for i, key := range keys {
+		if i > 0 {
+			stream.write(",")
+		}
+		stream.newline(1)
+		stream.encode(key, 1)
+		if pretty {
+			stream.write(": ")
+		} else {
+			stream.write(":")
+		}
+
+		items, ok := list[key].([]interface{})
+		if key != "data" || !ok || len(items) == 0 {
+			stream.encode(list[key], 1)
+			continue
+		}
+
+		stream.write("[")
+		for j, item := range items {
+			if j > 0 {
+				stream.write(",")
+			}
+			stream.newline(2)
+			stream.encode(item, 2)
+
+			if flusher, ok := w.(http.Flusher); ok && stream.err == nil &&
+				(j+1)%streamedListFlushInterval == 0 {
+
+				flusher.Flush()
+			}
+		}
+		stream.newline(1)
+		stream.write("]")
+	}
+	stream.newline(0)
+	stream.write("}")
+	if pretty {
+		stream.write("\n")
+	}
+	return stream.err
+}
+
+//
+// Private values
+//
+
+// streamedListFlushInterval is the number of objects in a streamed list that
+// are written between flushes.
+const streamedListFlushInterval = 10
+
+// streamedListMinLength is the minimum number of objects in a list for it to
+// be streamed. Smaller lists are encoded in full, which costs less than
+// writing them piece by piece.
+const streamedListMinLength = 50
+
+//
+// Private types
+//
+
+// jsonStream writes the pieces of a JSON document to a writer. The first
+// error is kept and every write after it is skipped, so that a document can
+// be written without checking each piece.
+type jsonStream struct {
+	err error
+
+	// pretty indents the document like json.MarshalIndent with two spaces.
+	pretty bool
+
+	w io.Writer
+}
+
+// encode writes the encoding of a value that's nested the given number of
+// levels deep in the document.
+func (s *jsonStream) encode(value interface{}, depth int) {
+	if s.err != nil {
+		return
+	}
+
+	var encoded []byte
+	if s.pretty {
+		encoded, s.err = json.MarshalIndent(value, strings.Repeat("  ", depth), "  ")
+	} else {
+		encoded, s.err = json.Marshal(value)
+	}
+	if s.err != nil {
+		return
+	}
+	_, s.err = s.w.Write(encoded)
+}
+
+// newline starts a new line indented to the given depth when the document is
+// pretty.
+func (s *jsonStream) newline(depth int) {
+	if s.pretty {
+		s.write("\n" + strings.Repeat("  ", depth))
+	}
+}
+
+// write writes a piece of the document as it is.
+func (s *jsonStream) write(piece string) {
+	if s.err != nil {
+		return
+	}
+	_, s.err = io.WriteString(s.w, piece)
+}
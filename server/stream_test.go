@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStreamableList(t *testing.T) {
+	_, ok := streamableList(makeStreamTestList(streamedListMinLength))
+	assert.True(t, ok)
+
+	_, ok = streamableList(makeStreamTestList(streamedListMinLength - 1))
+	assert.False(t, ok)
+
+	_, ok = streamableList(map[string]interface{}{"object": "charge"})
+	assert.False(t, ok)
+
+	_, ok = streamableList("Not Found")
+	assert.False(t, ok)
+}
+
+func TestWriteStreamedList(t *testing.T) {
+	for _, length := range []int{0, 1, streamedListMinLength} {
+		list := makeStreamTestList(length)
+
+		// The output is the same as if the list were encoded in full
+		var buf bytes.Buffer
+		err := writeStreamedList(&buf, list, false)
+		assert.NoError(t, err)
+		expected, err := json.Marshal(list)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), buf.String())
+
+		buf.Reset()
+		err = writeStreamedList(&buf, list, true)
+		assert.NoError(t, err)
+		expected, err = json.MarshalIndent(list, "", "  ")
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected)+"\n", buf.String())
+	}
+
+	// Responses are flushed as they're written
+	recorder := httptest.NewRecorder()
+	err := writeStreamedList(recorder, makeStreamTestList(streamedListMinLength), false)
+	assert.NoError(t, err)
+	assert.True(t, recorder.Flushed)
+}
+
+func TestStubServer_StreamedList(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	resp, body := sendRequestToServer(t, server, "GET", "/v1/charges?limit=100", "",
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list := decodeResponse(t, body)
+	assert.Equal(t, "list", list["object"])
+	assert.Equal(t, 100, len(list["data"].([]interface{})))
+}
+
+//
+// Private functions
+//
+
+// makeStreamTestList makes a list with the given number of objects, which
+// have characters that JSON escapes and nested values.
+func makeStreamTestList(length int) map[string]interface{} {
+	items := make([]interface{}, length)
+	for i := range items {
+		items[i] = map[string]interface{}{
+			"description": "<Lorem & ipsum>",
+			"id":          "ch_123",
+			"metadata":    map[string]interface{}{"foo": []interface{}{1, "bar"}},
+			"refunded":    false,
+		}
+	}
+	return map[string]interface{}{
+		"data":     items,
+		"has_more": true,
+		"object":   "list",
+		"url":      "/v1/charges",
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/stripe/stripe-mock/errors"
+	"github.com/stripe/stripe-mock/spec"
+	"github.com/stripe/stripe-mock/store"
+)
+
+// testCard is one of the test cards documented by Stripe that produces an
+// error when it's used to make a payment.
+type testCard struct {
+	// err describes the error that the card produces in the form understood
+	// by errors.Parse.
+	err string
+
+	// number is the card's number.
+	number string
+
+	// tokens are tokens, payment methods, and other IDs that stand in for the
+	// card.
+	tokens []string
+}
+
+// testCards are the test cards recognized in payment requests. They're
+// documented at https://stripe.com/docs/testing.
+var testCards = []testCard{
+	{
+		err:    "card_error:card_declined:generic_decline",
+		number: "4000000000000002",
+		tokens: []string{"tok_chargeDeclined", "pm_card_chargeDeclined"},
+	},
+	{
+		err:    "card_error:card_declined:insufficient_funds",
+		number: "4000000000009995",
+		tokens: []string{"tok_chargeDeclinedInsufficientFunds", "pm_card_chargeDeclinedInsufficientFunds"},
+	},
+	{
+		err:    "card_error:card_declined:lost_card",
+		number: "4000000000009987",
+		tokens: []string{"tok_chargeDeclinedLostCard", "pm_card_chargeDeclinedLostCard"},
+	},
+	{
+		err:    "card_error:card_declined:stolen_card",
+		number: "4000000000009979",
+		tokens: []string{"tok_chargeDeclinedStolenCard", "pm_card_chargeDeclinedStolenCard"},
+	},
+	{
+		err:    "card_error:card_declined:fraudulent",
+		number: "4100000000000019",
+		tokens: []string{"tok_chargeDeclinedFraudulent", "pm_card_chargeDeclinedFraudulent"},
+	},
+	{
+		err:    "card_error:expired_card",
+		number: "4000000000000069",
+		tokens: []string{"tok_chargeDeclinedExpiredCard", "pm_card_chargeDeclinedExpiredCard"},
+	},
+	{
+		err:    "card_error:incorrect_cvc",
+		number: "4000000000000127",
+		tokens: []string{"tok_chargeDeclinedIncorrectCvc", "pm_card_chargeDeclinedIncorrectCvc"},
+	},
+	{
+		err:    "card_error:processing_error",
+		number: "4000000000000119",
+		tokens: []string{"tok_chargeDeclinedProcessingError", "pm_card_chargeDeclinedProcessingError"},
+	},
+	{
+		err:    "card_error:incorrect_number",
+		number: "4242424242424241",
+	},
+}
+
+// testCardPaths are the paths of endpoints that make a payment, and which
+// therefore respond with an error when given one of testCards.
+var testCardPaths = []*regexp.Regexp{
+	regexp.MustCompile(`\A/v1/charges\z`),
+	regexp.MustCompile(`\A/v1/payment_intents\z`),
+	regexp.MustCompile(`\A/v1/payment_intents/[^/]+/confirm\z`),
+}
+
+// findTestCardError looks for one of testCards in the parameters of a
+// payment request and returns the error that it produces.
+//
+// Cards are looked for as tokens or IDs given as `card`, `payment_method`, or
+// `source`, and as card numbers given under those parameters or under
+// `payment_method_data[card]`.
+//
+// Returns nil if the request isn't a payment or doesn't use one of the cards.
+func findTestCardError(r *http.Request, requestData map[string]interface{}) *errors.Error {
+	if r.Method != http.MethodPost || !isTestCardPath(r.URL.Path) {
+		return nil
+	}
+
+	var numbers, tokens []string
+	for _, key := range []string{"card", "payment_method", "source"} {
+		switch value := requestData[key].(type) {
+		case string:
+			tokens = append(tokens, value)
+		case map[string]interface{}:
+			if number, ok := value["number"].(string); ok {
+				numbers = append(numbers, number)
+			}
+		}
+	}
+
+	if paymentMethodData, ok := requestData["payment_method_data"].(map[string]interface{}); ok {
+		if card, ok := paymentMethodData["card"].(map[string]interface{}); ok {
+			if number, ok := card["number"].(string); ok {
+				numbers = append(numbers, number)
+			}
+		}
+	}
+
+	for _, card := range testCards {
+		if indexOfString(numbers, card.number) == -1 && !hasAnyString(tokens, card.tokens) {
+			continue
+		}
+
+		e, err := errors.Parse(card.err)
+		if err != nil {
+			// The table above should only ever refer to errors that exist
+			panic(err)
+		}
+		return e
+	}
+
+	return nil
+}
+
+// generateFailedPayment generates the charge or PaymentIntent of a payment
+// that failed with a card error, which the Stripe API includes in the error
+// that it responds with. The charge failed with the error, and the
+// PaymentIntent is left needing a new payment method. A stored PaymentIntent
+// that was being confirmed is used as it is, since declinePaymentIntent has
+// already recorded the failure on it.
+//
+// Returns nil if the request isn't a payment or the error isn't a card error.
+func (s *StubServer) generateFailedPayment(r *http.Request, pathParams *PathParamsMap,
+	requestData map[string]interface{}, resourceStore *store.ResourceStore,
+	e *errors.Error) (map[string]interface{}, error) {
+
+	if r.Method != http.MethodPost || !isTestCardPath(r.URL.Path) || !e.IsPaymentFailure() {
+		return nil, nil
+	}
+
+	resource := paymentIntentResource
+	if r.URL.Path == "/v1/charges" {
+		resource = "charge"
+	}
+
+	var id string
+	if pathParams != nil && pathParams.PrimaryID != nil {
+		id = *pathParams.PrimaryID
+		if resourceStore != nil {
+			if stored, ok := resourceStore.Get(resource, id); ok {
+				return store.CopyValue(stored).(map[string]interface{}), nil
+			}
+		}
+	}
+
+	generator := DataGenerator{
+		definitions: s.spec.Components.Schemas,
+		fixtures:    s.fixtures,
+		noFixtures:  s.noFixtures,
+		now:         s.generationTime(time.Now()),
+		rand:        s.newRand(),
+		strings:     s.syntheticStrings,
+	}
+	generated, err := generator.Generate(&GenerateParams{
+		RequestMethod: http.MethodGet,
+		Schema:        &spec.Schema{Ref: "#/components/schemas/" + resource},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := generated.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("generated %s wasn't an object", resource)
+	}
+
+	if id != "" {
+		object["id"] = id
+	}
+	for _, key := range []string{"amount", "currency"} {
+		if value, ok := requestData[key]; ok {
+			setExistingField(object, key, value)
+		}
+	}
+
+	if resource == paymentIntentResource {
+		setPaymentIntentDeclined(object, e)
+	} else {
+		setExistingField(object, "captured", false)
+		setExistingField(object, "failure_code", e.Code)
+		setExistingField(object, "failure_message", e.Message)
+		setExistingField(object, "paid", false)
+		setExistingField(object, "status", "failed")
+	}
+	return object, nil
+}
+
+// hasAnyString checks whether any of candidates are in slice.
+func hasAnyString(slice []string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if indexOfString(slice, candidate) != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// isTestCardPath checks whether a request path is one of testCardPaths.
+func isTestCardPath(path string) bool {
+	for _, pattern := range testCardPaths {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
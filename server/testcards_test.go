@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/errors"
+)
+
+//
+// Tests
+//
+
+func TestTestCards(t *testing.T) {
+	for _, card := range testCards {
+		t.Run(card.number, func(t *testing.T) {
+			_, err := errors.Parse(card.err)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFindTestCardError(t *testing.T) {
+	chargeRequest := httptest.NewRequest(http.MethodPost, "/v1/charges", nil)
+
+	// A token
+	e := findTestCardError(chargeRequest, map[string]interface{}{
+		"source": "tok_chargeDeclinedInsufficientFunds",
+	})
+	assert.NotNil(t, e)
+	assert.Equal(t, "card_declined", e.Code)
+	assert.Equal(t, "insufficient_funds", e.DeclineCode)
+
+	// A card number
+	e = findTestCardError(chargeRequest, map[string]interface{}{
+		"source": map[string]interface{}{"number": "4000000000000069"},
+	})
+	assert.NotNil(t, e)
+	assert.Equal(t, "expired_card", e.Code)
+
+	// A card number for a PaymentIntent
+	e = findTestCardError(
+		httptest.NewRequest(http.MethodPost, "/v1/payment_intents/pi_123/confirm", nil),
+		map[string]interface{}{
+			"payment_method_data": map[string]interface{}{
+				"card": map[string]interface{}{"number": "4000000000000002"},
+			},
+		})
+	assert.NotNil(t, e)
+	assert.Equal(t, "generic_decline", e.DeclineCode)
+
+	// A card that works
+	e = findTestCardError(chargeRequest, map[string]interface{}{
+		"source": map[string]interface{}{"number": "4242424242424242"},
+	})
+	assert.Nil(t, e)
+
+	// An endpoint that doesn't make a payment
+	e = findTestCardError(
+		httptest.NewRequest(http.MethodPost, "/v1/customers", nil),
+		map[string]interface{}{"source": "tok_chargeDeclined"})
+	assert.Nil(t, e)
+}
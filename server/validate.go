@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-mock/logging"
+)
+
+// handleValidate handles a request to the internal validation endpoint. It
+// runs the request described by the JSON object in the body through routing
+// and parameter validation, but doesn't generate a response for it, which
+// makes it a quick way to check the shape of a request.
+//
+// It responds with `{"valid": true}` if the described request is valid, or
+// otherwise with the error and status that the request would have gotten.
+func (s *StubServer) handleValidate(w http.ResponseWriter, r *http.Request, start time.Time) {
+	validation, err := readValidationRequest(r)
+	if isBodyTooLarge(err) {
+		writeResponse(w, r, start, http.StatusRequestEntityTooLarge,
+			createBodyTooLargeError(s.maxBodySize))
+		return
+	}
+	if err != nil {
+		message := fmt.Sprintf(invalidValidationBody, err)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	described, err := http.NewRequest(validation.Method, validation.Path, nil)
+	if err != nil {
+		message := fmt.Sprintf(invalidValidationBody, err)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	route, _ := s.routeRequest(described)
+	if route == nil {
+		message := fmt.Sprintf(invalidRoute, described.Method, described.URL.Path)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusNotFound, stripeError)
+		return
+	}
+	logging.Debug("Validating request for route", "path", route.path,
+		"operation", route.operation.OperationID)
+
+	// The parameters are given as JSON rather than in the encoding that the
+	// route expects, so the described request is treated as if it had been
+	// sent with the right content type.
+	if mediaType, _ := getRequestBodySchema(route.operation); mediaType != nil {
+		described.Header.Set("Content-Type", *mediaType)
+	}
+
+	requestData, _ := stringifyParam(validation.Params).(map[string]interface{})
+	if requestData == nil {
+		requestData = make(map[string]interface{})
+	}
+
+	_, stripeError := validateAndCoerceRequest(described, route, requestData)
+	if stripeError != nil {
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	writeResponse(w, r, start, http.StatusOK, map[string]interface{}{"valid": true})
+}
+
+//
+// Private values
+//
+
+const (
+	invalidValidationBody = "Couldn't decode the validation request's body as " +
+		"a JSON object with a method, path, and params: %v"
+)
+
+// validatePath is the path of stripe-mock's internal endpoint for validating
+// a request without generating a response for it.
+const validatePath = "/v1/_stripe_mock/validate"
+
+//
+// Private types
+//
+
+// validationRequest describes a request to be validated by the internal
+// validation endpoint.
+type validationRequest struct {
+	// Method is the HTTP method of the request, like `POST`.
+	Method string `json:"method"`
+
+	// Params are the request's parameters. They're interpreted in the same way
+	// as if they'd been form-encoded, so numbers and booleans may also be
+	// given as strings.
+	Params map[string]interface{} `json:"params"`
+
+	// Path is the path of the request, like `/v1/charges`.
+	Path string `json:"path"`
+}
+
+//
+// Private functions
+//
+
+// readValidationRequest decodes the body of a request to the internal
+// validation endpoint.
+func readValidationRequest(r *http.Request) (*validationRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	var validation validationRequest
+	err = json.Unmarshal(body, &validation)
+	if err != nil {
+		return nil, err
+	}
+
+	if validation.Method == "" || validation.Path == "" {
+		return nil, fmt.Errorf("method and path are required")
+	}
+	validation.Method = strings.ToUpper(validation.Method)
+
+	return &validation, nil
+}
+
+// stringifyParam converts the scalar values in a parameter decoded from JSON
+// to the strings that they'd be if the parameter had been form-encoded, so
+// that it's coerced and validated like any other request's parameters.
+func stringifyParam(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		stringified := make(map[string]interface{}, len(v))
+		for key, subValue := range v {
+			stringified[key] = stringifyParam(subValue)
+		}
+		return stringified
+
+	case []interface{}:
+		stringified := make([]interface{}, len(v))
+		for i, item := range v {
+			stringified[i] = stringifyParam(item)
+		}
+		return stringified
+
+	case bool:
+		return strconv.FormatBool(v)
+
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+
+	case nil:
+		return ""
+	}
+
+	return value
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-mock/store"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_Validate(t *testing.T) {
+	server := getStubServer(t)
+	server.store = store.NewResourceStore()
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v1/_stripe_mock/validate",
+		`{"method": "post", "path": "/v1/charges", "params": {"amount": 123}}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"valid": true}, decodeResponse(t, body))
+
+	// Nothing was generated or stored for the described request
+	assert.Equal(t, 0, server.store.Len())
+}
+
+func TestStubServer_Validate_Invalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		status   int
+		message  string
+		errParam string
+	}{
+		{"MissingParam",
+			`{"method": "POST", "path": "/v1/charges", "params": {}}`,
+			http.StatusBadRequest, "Missing required param: amount.", "amount"},
+		{"UnknownParam",
+			`{"method": "POST", "path": "/v1/charges", "params": {"amount": 123, "foo": "bar"}}`,
+			http.StatusBadRequest, "Received unknown parameter: foo", "foo"},
+		{"InvalidValue",
+			`{"method": "POST", "path": "/v1/charges", "params": {"amount": "abc"}}`,
+			http.StatusBadRequest, "Invalid integer: abc", "amount"},
+		{"UnknownRoute",
+			`{"method": "POST", "path": "/v1/doesnt-exist"}`,
+			http.StatusNotFound, "Unrecognized request URL (POST: /v1/doesnt-exist). " +
+				"Please see https://stripe.com/docs or we can help at " +
+				"https://support.stripe.com/.", ""},
+		{"MissingPath",
+			`{"method": "POST"}`,
+			http.StatusBadRequest, "Couldn't decode the validation request's body " +
+				"as a JSON object with a method, path, and params: method and path " +
+				"are required", ""},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			resp, body := sendRequest(t, "POST", "/v1/_stripe_mock/validate",
+				testCase.body, getDefaultHeaders())
+			assert.Equal(t, testCase.status, resp.StatusCode)
+
+			errorInfo := decodeResponse(t, body)["error"].(map[string]interface{})
+			assert.Equal(t, "invalid_request_error", errorInfo["type"])
+			assert.Equal(t, testCase.message, errorInfo["message"])
+			if testCase.errParam != "" {
+				assert.Equal(t, testCase.errParam, errorInfo["param"])
+			}
+		})
+	}
+}
+
+func TestStringifyParam(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{
+		"amount":  "123",
+		"capture": "false",
+		"items": []interface{}{
+			map[string]interface{}{"quantity": "2.5"},
+		},
+		"description": "",
+		"currency":    "usd",
+	}, stringifyParam(map[string]interface{}{
+		"amount":  123.0,
+		"capture": false,
+		"items": []interface{}{
+			map[string]interface{}{"quantity": 2.5},
+		},
+		"description": nil,
+		"currency":    "usd",
+	}))
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-mock/logging"
+	"github.com/stripe/stripe-mock/spec"
+)
+
+// handleTrigger handles a request to the internal trigger endpoint. It
+// generates an event of the requested type wrapping a generated object, sends
+// it to the configured webhook URL, and responds with the event.
+//
+// The request may include a JSON object in its body with fields that override
+// those of the wrapped object.
+//
+// Unlike other handlers, it's called without the server locked, and only
+// locks it while generating the event (see HandleRequest).
+func (s *StubServer) handleTrigger(w http.ResponseWriter, r *http.Request, start time.Time) {
+	if s.webhookURL == "" {
+		stripeError := createStripeError(typeInvalidRequestError, webhookURLMissing)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	overrides, err := readTriggerOverrides(r)
+	if isBodyTooLarge(err) {
+		writeResponse(w, r, start, http.StatusRequestEntityTooLarge,
+			createBodyTooLargeError(s.maxBodySize))
+		return
+	}
+	if err != nil {
+		message := fmt.Sprintf(invalidTriggerBody, err)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+
+	// The server is only locked while the event is generated, so that a slow
+	// webhook endpoint can't hold up a reload of the spec, and with it every
+	// request that arrives while the reload waits for the lock
+	eventType := strings.TrimPrefix(r.URL.Path, triggerPathPrefix)
+	s.mu.RLock()
+	schemaName := s.eventObjectSchemaName(eventType)
+	var event map[string]interface{}
+	if schemaName != "" {
+		event, err = s.generateEvent(eventType, schemaName, overrides)
+	}
+	s.mu.RUnlock()
+
+	if schemaName == "" {
+		message := fmt.Sprintf(invalidEventType, eventType)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadRequest, stripeError)
+		return
+	}
+	if err != nil {
+		logging.Error("Couldn't generate event", "error", err)
+		writeResponse(w, r, start, http.StatusInternalServerError,
+			createInternalServerError())
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logging.Error("Couldn't serialize event", "error", err)
+		writeResponse(w, r, start, http.StatusInternalServerError,
+			createInternalServerError())
+		return
+	}
+
+	err = sendWebhook(s.webhookURL, s.webhookSecret, payload, time.Now())
+	if err != nil {
+		message := fmt.Sprintf(webhookDeliveryFailed, s.webhookURL, err)
+		logging.Error(message)
+		stripeError := createStripeError(typeInvalidRequestError, message)
+		writeResponse(w, r, start, http.StatusBadGateway, stripeError)
+		return
+	}
+
+	logging.Debug("Sent event", "type", eventType, "url", s.webhookURL)
+
+	writeResponse(w, r, start, http.StatusOK, event)
+}
+
+// eventObjectSchemaName finds the name of the schema for the object wrapped
+// by an event of the given type. For example, a `charge.succeeded` event
+// wraps a `charge` and a `customer.subscription.created` event wraps a
+// `subscription`.
+//
+// Returns an empty string if no suitable schema could be found.
+func (s *StubServer) eventObjectSchemaName(eventType string) string {
+	i := strings.LastIndex(eventType, ".")
+	if i < 1 {
+		return ""
+	}
+	resource := eventType[:i]
+
+	candidates := []string{
+		resource,
+
+		// Namespaced resources use an underscore in event types, but a dot
+		// in schema names (e.g. `issuing_card.created` wraps `issuing.card`).
+		strings.Replace(resource, "_", ".", 1),
+
+		// Some resources are nested under others in event types (e.g.
+		// `customer.subscription.created`).
+		resource[strings.LastIndex(resource, ".")+1:],
+	}
+
+	for _, candidate := range candidates {
+		schema, ok := s.spec.Components.Schemas[candidate]
+		if ok && schema.XResourceID != "" {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// generateEvent generates an event of the given type that wraps a generated
+// object of the given schema. Overrides are merged into the wrapped object.
+func (s *StubServer) generateEvent(eventType string, schemaName string,
+	overrides map[string]interface{}) (map[string]interface{}, error) {
+
+	generator := DataGenerator{
+		definitions: s.spec.Components.Schemas,
+		fixtures:    s.fixtures,
+		noFixtures:  s.noFixtures,
+		now:         s.generationTime(time.Now()),
+		rand:        s.newRand(),
+		strings:     s.syntheticStrings,
+	}
+
+	object, err := generator.Generate(&GenerateParams{
+		RequestMethod: http.MethodGet,
+		Schema:        &spec.Schema{Ref: "#/components/schemas/" + schemaName},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	objectMap, ok := object.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("generated %s wasn't an object", schemaName)
+	}
+	mergeOverrides(overrides, objectMap)
+
+	eventData, err := generator.Generate(&GenerateParams{
+		RequestMethod: http.MethodGet,
+		Schema:        &spec.Schema{Ref: "#/components/schemas/event"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	event, ok := eventData.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("generated event wasn't an object")
+	}
+
+	if eventID, ok := event["id"].(string); ok {
+		event["id"] = generateObjectID(generator.rand, eventID)
+	}
+	if _, ok := event["api_version"]; ok && s.apiVersion != "" {
+		event["api_version"] = s.apiVersion
+	}
+	event["created"] = generator.requestTime()
+	event["data"] = map[string]interface{}{"object": objectMap}
+	event["type"] = eventType
+
+	return event, nil
+}
+
+//
+// Private values
+//
+
+const (
+	invalidEventType = "Unrecognized event type: %s. Event types should look " +
+		"like `charge.succeeded`."
+
+	invalidTriggerBody = "Couldn't decode the trigger request's body as a JSON " +
+		"object: %v"
+
+	webhookDeliveryFailed = "Couldn't deliver webhook to %s: %v"
+
+	webhookURLMissing = "No webhook URL is configured. Start stripe-mock " +
+		"with the `-webhook-url` option to send events."
+)
+
+// triggerPathPrefix is the path prefix of stripe-mock's internal endpoint for
+// triggering events. It's followed by an event type, like
+// `/v1/_stripe_mock/trigger/charge.succeeded`.
+const triggerPathPrefix = "/v1/_stripe_mock/trigger/"
+
+// webhookClient is the HTTP client used to deliver webhooks.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+//
+// Private functions
+//
+
+// computeWebhookSignature computes the value of a `Stripe-Signature` header
+// for the given payload using Stripe's scheme, so that the signature can be
+// verified by Stripe's libraries.
+func computeWebhookSignature(secret string, payload []byte, timestamp time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp.Unix())))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s",
+		timestamp.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// mergeOverrides recursively merges overrides into the given object. Unlike
+// datareplacer.ReplaceData, values are set regardless of whether their key
+// was already present or what their type was.
+func mergeOverrides(overrides map[string]interface{}, object map[string]interface{}) {
+	for key, overrideValue := range overrides {
+		overrideMap, overrideIsMap := overrideValue.(map[string]interface{})
+		objectMap, objectIsMap := object[key].(map[string]interface{})
+
+		if overrideIsMap && objectIsMap {
+			mergeOverrides(overrideMap, objectMap)
+		} else {
+			object[key] = overrideValue
+		}
+	}
+}
+
+// readTriggerOverrides reads overrides for an event's object from the JSON
+// body of a trigger request. Returns nil if the body was empty.
+func readTriggerOverrides(r *http.Request) (map[string]interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	var overrides map[string]interface{}
+	err = json.Unmarshal(body, &overrides)
+	if err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// sendWebhook sends a webhook with the given payload to url, signed with
+// secret.
+func sendWebhook(url string, secret string, payload []byte, timestamp time.Time) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature",
+		computeWebhookSignature(secret, payload, timestamp))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
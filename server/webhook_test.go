@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_TriggersWebhook(t *testing.T) {
+	var receivedBody []byte
+	var receivedSignature string
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		receivedBody, err = ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		receivedSignature = r.Header.Get("Stripe-Signature")
+	}))
+	defer endpoint.Close()
+
+	server := getStubServer(t)
+	server.webhookSecret = "whsec_123"
+	server.webhookURL = endpoint.URL
+
+	headers := getDefaultHeaders()
+	headers["Content-Type"] = "application/json"
+	resp, body := sendRequestToServer(t, server, "POST",
+		"/v1/_stripe_mock/trigger/charge.succeeded", `{"amount": 999}`, headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The event is both returned and sent to the webhook URL
+	assert.Equal(t, string(body), string(receivedBody))
+
+	var event map[string]interface{}
+	err := json.Unmarshal(receivedBody, &event)
+	assert.NoError(t, err)
+	assert.Equal(t, "event", event["object"])
+	assert.Equal(t, "charge.succeeded", event["type"])
+	assert.NotEqual(t, "evt_123", event["id"])
+
+	object := event["data"].(map[string]interface{})["object"].(map[string]interface{})
+	assert.Equal(t, "charge", object["object"])
+	assert.Equal(t, 999.0, object["amount"])
+
+	// The signature can be verified with the secret
+	parts := strings.Split(receivedSignature, ",")
+	assert.Equal(t, 2, len(parts))
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(parts[0], "t="), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		computeWebhookSignature("whsec_123", receivedBody, time.Unix(timestamp, 0)),
+		receivedSignature)
+}
+
+func TestStubServer_TriggerUnlocksBeforeDelivery(t *testing.T) {
+	delivering := make(chan struct{})
+	release := make(chan struct{})
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(delivering)
+		<-release
+	}))
+	defer endpoint.Close()
+
+	server := getStubServer(t)
+	server.webhookURL = endpoint.URL
+
+	statuses := make(chan int)
+	go func() {
+		resp, _ := sendRequestToServer(t, server, "POST",
+			"/v1/_stripe_mock/trigger/charge.succeeded", "", getDefaultHeaders())
+		statuses <- resp.StatusCode
+	}()
+
+	// A reload can take the lock while the webhook is being delivered
+	<-delivering
+	locked := make(chan struct{})
+	go func() {
+		server.mu.Lock()
+		server.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server was locked while delivering webhook")
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-statuses)
+}
+
+func TestStubServer_TriggerErrors(t *testing.T) {
+	// No webhook URL configured
+	{
+		resp, _ := sendRequest(t, "POST",
+			"/v1/_stripe_mock/trigger/charge.succeeded", "", getDefaultHeaders())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// Unknown event type
+	{
+		server := getStubServer(t)
+		server.webhookURL = "http://localhost"
+
+		resp, body := sendRequestToServer(t, server, "POST",
+			"/v1/_stripe_mock/trigger/foo.succeeded", "", getDefaultHeaders())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, string(body), "Unrecognized event type: foo.succeeded")
+	}
+
+	// Undeliverable webhook
+	{
+		endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer endpoint.Close()
+
+		server := getStubServer(t)
+		server.webhookURL = endpoint.URL
+
+		resp, _ := sendRequestToServer(t, server, "POST",
+			"/v1/_stripe_mock/trigger/charge.succeeded", "", getDefaultHeaders())
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	}
+}
+
+//
+// Tests for private functions
+//
+
+func TestComputeWebhookSignature(t *testing.T) {
+	// Computed independently with:
+	//
+	//     echo -n '1234567890.{}' | openssl dgst -sha256 -hmac whsec_123
+	//
+	assert.Equal(t,
+		"t=1234567890,v1=328378c20e64a6c2fb8300ca217ff3f26d085c2b3cc7b50c65c3ab1a0d6d6229",
+		computeWebhookSignature("whsec_123", []byte("{}"), time.Unix(1234567890, 0)))
+}
+
+func TestMergeOverrides(t *testing.T) {
+	object := map[string]interface{}{
+		"amount":   100,
+		"metadata": map[string]interface{}{"foo": "bar"},
+		"source":   "card_123",
+	}
+	mergeOverrides(map[string]interface{}{
+		"amount":   999.0,
+		"metadata": map[string]interface{}{"baz": "qux"},
+		"new":      nil,
+		"source":   map[string]interface{}{"id": "card_456"},
+	}, object)
+
+	assert.Equal(t, map[string]interface{}{
+		"amount":   999.0,
+		"metadata": map[string]interface{}{"foo": "bar", "baz": "qux"},
+		"new":      nil,
+		"source":   map[string]interface{}{"id": "card_456"},
+	}, object)
+}
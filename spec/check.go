@@ -0,0 +1,224 @@
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//
+// Public functions
+//
+
+// Check looks for problems in a spec and its fixtures that would keep them
+// from being served properly, like references to schemas that don't exist.
+// It returns a description of each problem that it finds, in a stable order,
+// or nothing if there aren't any.
+//
+// fixtures may be nil to only check the spec.
+func Check(s *Spec, fixtures *Fixtures) []string {
+	var problems []string
+
+	// Schemas are checked in order of their names so that problems are
+	// reported in the same order every time
+	names := make([]string, 0, len(s.Components.Schemas))
+	for name := range s.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resourceSchemas := make(map[string][]string)
+	for _, name := range names {
+		schema := s.Components.Schemas[name]
+		problems = append(problems,
+			checkRefs(s, schema, "#/components/schemas/"+name)...)
+
+		if schema != nil && schema.XResourceID != "" {
+			resourceSchemas[schema.XResourceID] = append(
+				resourceSchemas[schema.XResourceID], name)
+		}
+	}
+
+	resourceIDs := make([]string, 0, len(resourceSchemas))
+	for resourceID := range resourceSchemas {
+		resourceIDs = append(resourceIDs, resourceID)
+	}
+	sort.Strings(resourceIDs)
+	for _, resourceID := range resourceIDs {
+		if len(resourceSchemas[resourceID]) > 1 {
+			problems = append(problems, fmt.Sprintf(
+				"x-resourceId %s is used by more than one schema: %s",
+				resourceID, strings.Join(resourceSchemas[resourceID], ", ")))
+		}
+	}
+
+	paths := make([]string, 0, len(s.Paths))
+	for path := range s.Paths {
+		paths = append(paths, string(path))
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		problems = append(problems, checkPath(s, Path(path))...)
+	}
+
+	if fixtures != nil {
+		fixtureIDs := make([]string, 0, len(fixtures.Resources))
+		for resourceID := range fixtures.Resources {
+			fixtureIDs = append(fixtureIDs, string(resourceID))
+		}
+		sort.Strings(fixtureIDs)
+		for _, resourceID := range fixtureIDs {
+			if _, ok := resourceSchemas[resourceID]; !ok {
+				problems = append(problems, fmt.Sprintf(
+					"Fixture %s has no schema with a matching x-resourceId",
+					resourceID))
+			}
+		}
+	}
+
+	return problems
+}
+
+//
+// Private values
+//
+
+// checkedHTTPVerbs are the verbs that an operation in a spec can be for.
+var checkedHTTPVerbs = map[HTTPVerb]bool{
+	"delete": true,
+	"get":    true,
+	"patch":  true,
+	"post":   true,
+	"put":    true,
+}
+
+// pathParameterPattern matches the parameters in a path, like `{charge}`.
+var pathParameterPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+//
+// Private functions
+//
+
+// checkPath checks the operations of a path in a spec. Each must be for a
+// known verb, declare the path's parameters, describe a `200` response with
+// a JSON schema, and only refer to schemas that exist.
+func checkPath(s *Spec, path Path) []string {
+	var problems []string
+
+	verbs := make([]string, 0, len(s.Paths[path]))
+	for verb := range s.Paths[path] {
+		verbs = append(verbs, string(verb))
+	}
+	sort.Strings(verbs)
+
+	for _, verb := range verbs {
+		operation := s.Paths[path][HTTPVerb(verb)]
+		location := fmt.Sprintf("%s %s", strings.ToUpper(verb), path)
+
+		if !checkedHTTPVerbs[HTTPVerb(verb)] {
+			problems = append(problems, fmt.Sprintf(
+				"%s: unsupported HTTP verb", location))
+			continue
+		}
+		if operation == nil {
+			problems = append(problems, fmt.Sprintf(
+				"%s: missing operation", location))
+			continue
+		}
+
+		declared := make(map[string]bool)
+		for _, parameter := range operation.Parameters {
+			if parameter == nil {
+				continue
+			}
+			if parameter.In == "path" {
+				declared[parameter.Name] = true
+			}
+			problems = append(problems, checkRefs(s, parameter.Schema,
+				fmt.Sprintf("%s parameter %s", location, parameter.Name))...)
+		}
+		for _, submatches := range pathParameterPattern.FindAllStringSubmatch(string(path), -1) {
+			if !declared[submatches[1]] {
+				problems = append(problems, fmt.Sprintf(
+					"%s: path parameter %s isn't declared", location, submatches[1]))
+			}
+		}
+
+		response, ok := operation.Responses["200"]
+		if !ok || response.Content["application/json"].Schema == nil {
+			problems = append(problems, fmt.Sprintf(
+				"%s: no 200 response with an application/json schema", location))
+		}
+
+		// Bodies are keyed by media type and status, so their problems are
+		// sorted to keep them in a stable order
+		var bodyProblems []string
+		if operation.RequestBody != nil {
+			for mediaType, content := range operation.RequestBody.Content {
+				bodyProblems = append(bodyProblems, checkRefs(s, content.Schema,
+					fmt.Sprintf("%s request body (%s)", location, mediaType))...)
+			}
+		}
+		for status, response := range operation.Responses {
+			for mediaType, content := range response.Content {
+				bodyProblems = append(bodyProblems, checkRefs(s, content.Schema,
+					fmt.Sprintf("%s %s response (%s)", location, status, mediaType))...)
+			}
+		}
+		sort.Strings(bodyProblems)
+		problems = append(problems, bodyProblems...)
+	}
+
+	return problems
+}
+
+// checkRefs checks that every `$ref` in a schema, including those of the
+// schemas nested or composed in it, refers to one of the spec's component schemas.
+// location describes where the schema is for the problems that are returned.
+func checkRefs(s *Spec, schema *Schema, location string) []string {
+	if schema == nil {
+		return nil
+	}
+
+	var problems []string
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if _, ok := s.Components.Schemas[name]; !ok || name == schema.Ref {
+			problems = append(problems, fmt.Sprintf(
+				"%s: $ref %s doesn't resolve", location, schema.Ref))
+		}
+	}
+
+	keys := make([]string, 0, len(schema.Properties))
+	for key := range schema.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		problems = append(problems, checkRefs(s, schema.Properties[key],
+			location+"/properties/"+key)...)
+	}
+
+	problems = append(problems, checkRefs(s, schema.Items, location+"/items")...)
+	for i, subSchema := range schema.AllOf {
+		problems = append(problems, checkRefs(s, subSchema,
+			fmt.Sprintf("%s/allOf/%d", location, i))...)
+	}
+	for i, subSchema := range schema.AnyOf {
+		problems = append(problems, checkRefs(s, subSchema,
+			fmt.Sprintf("%s/anyOf/%d", location, i))...)
+	}
+	for i, subSchema := range schema.OneOf {
+		problems = append(problems, checkRefs(s, subSchema,
+			fmt.Sprintf("%s/oneOf/%d", location, i))...)
+	}
+	if schema.XExpansionResources != nil {
+		for i, subSchema := range schema.XExpansionResources.OneOf {
+			problems = append(problems, checkRefs(s, subSchema,
+				fmt.Sprintf("%s/x-expansionResources/oneOf/%d", location, i))...)
+		}
+	}
+
+	return problems
+}
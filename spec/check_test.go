@@ -0,0 +1,74 @@
+package spec
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	chargeResponse := map[StatusCode]Response{
+		"200": {Content: map[string]MediaType{
+			"application/json": {Schema: &Schema{Ref: "#/components/schemas/charge"}},
+		}},
+	}
+	s := &Spec{
+		Components: Components{Schemas: map[string]*Schema{
+			"charge": {
+				Properties: map[string]*Schema{
+					"customer": {Ref: "#/components/schemas/customer"},
+					"id":       {Type: TypeString},
+				},
+				XResourceID: "charge",
+			},
+			"customer": {XResourceID: "customer"},
+		}},
+		Paths: map[Path]map[HTTPVerb]*Operation{
+			"/v1/charges/{charge}": {
+				"get": {
+					Parameters: []*Parameter{{In: "path", Name: "charge"}},
+					Responses:  chargeResponse,
+				},
+			},
+		},
+	}
+	fixtures := &Fixtures{Resources: map[ResourceID]interface{}{
+		"charge": map[string]interface{}{"id": "ch_123"},
+	}}
+	assert.Nil(t, Check(s, fixtures))
+
+	// Break the spec in every way that's checked
+	s.Components.Schemas["charge"].Properties["refunds"] = &Schema{
+		Items: &Schema{Ref: "#/components/schemas/refund"},
+		Type:  TypeArray,
+	}
+	s.Components.Schemas["legacy_charge"] = &Schema{
+		AllOf:       []*Schema{{Ref: "#/components/schemas/source"}},
+		XResourceID: "charge",
+	}
+	s.Paths["/v1/charges/{charge}"]["get"].Parameters = nil
+	s.Paths["/v1/charges/{charge}"]["post"] = &Operation{
+		Parameters: []*Parameter{{In: "path", Name: "charge"}},
+		Responses: map[StatusCode]Response{
+			"200": {Content: map[string]MediaType{
+				"application/json": {Schema: &Schema{Ref: "charge"}},
+			}},
+		},
+	}
+	s.Paths["/v1/charges"] = map[HTTPVerb]*Operation{
+		"get":   {},
+		"trace": {Responses: chargeResponse},
+	}
+	fixtures.Resources["refund"] = map[string]interface{}{"id": "re_123"}
+
+	assert.Equal(t, []string{
+		"#/components/schemas/charge/properties/refunds/items: $ref #/components/schemas/refund doesn't resolve",
+		"#/components/schemas/legacy_charge/allOf/0: $ref #/components/schemas/source doesn't resolve",
+		"x-resourceId charge is used by more than one schema: charge, legacy_charge",
+		"GET /v1/charges: no 200 response with an application/json schema",
+		"TRACE /v1/charges: unsupported HTTP verb",
+		"GET /v1/charges/{charge}: path parameter charge isn't declared",
+		"POST /v1/charges/{charge} 200 response (application/json): $ref charge doesn't resolve",
+		"Fixture refund has no schema with a matching x-resourceId",
+	}, Check(s, fixtures))
+}
@@ -3,6 +3,7 @@ package spec
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 //
@@ -29,6 +30,18 @@ type Components struct {
 	Schemas map[string]*Schema `json:"schemas"`
 }
 
+// Discriminator is a struct for the discriminator of a polymorphic schema. It
+// names the property whose value identifies which of the schema's `anyOf`
+// branches an object is.
+type Discriminator struct {
+	// Mapping maps values of the property to references to the branches they
+	// identify. Branches that aren't in it are identified by the name of
+	// their schema.
+	Mapping map[string]string `json:"mapping,omitempty"`
+
+	PropertyName string `json:"propertyName"`
+}
+
 // ExpansionResources is a struct for possible expansions in a resource.
 type ExpansionResources struct {
 	OneOf []*Schema `json:"oneOf"`
@@ -49,18 +62,25 @@ type HTTPVerb string
 var supportedSchemaFields = []string{
 	"$ref",
 	"additionalProperties",
+	"allOf",
 	"anyOf",
 	"description",
+	"discriminator",
 	"enum",
 	"format",
 	"items",
 	"maxLength",
+	"maximum",
+	"minLength",
+	"minimum",
 	"nullable",
 	"pattern",
 	"properties",
+	"readOnly",
 	"required",
 	"title",
 	"type",
+	"writeOnly",
 	"x-expandableFields",
 	"x-expansionResources",
 	"x-resourceId",
@@ -77,20 +97,50 @@ type Schema struct {
 	// properties in the object are allowed (beyond what's in Properties), or a
 	// JSON schema that describes the expected format of any additional properties.
 	//
-	// We currently just read it as an `interface{}` because we're not using it
-	// for anything right now.
+	// We currently just read it as an `interface{}` because other than checking
+	// for `false`, we only pass it through to the validator.
 	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
 
-	AnyOf      []*Schema          `json:"anyOf,omitempty"`
-	Enum       []interface{}      `json:"enum,omitempty"`
-	Format     string             `json:"format,omitempty"`
-	Items      *Schema            `json:"items,omitempty"`
-	MaxLength  int                `json:"maxLength,omitempty"`
-	Nullable   bool               `json:"nullable,omitempty"`
-	Pattern    string             `json:"pattern,omitempty"`
-	Properties map[string]*Schema `json:"properties,omitempty"`
-	Required   []string           `json:"required,omitempty"`
-	Type       string             `json:"type,omitempty"`
+	// AllOf is a set of schemas that an object must satisfy all of. The
+	// generator merges their properties into a single schema.
+	AllOf []*Schema `json:"allOf,omitempty"`
+
+	// Default is the value that a property takes when it's left out. It's
+	// preferred over generated values, and fills in request parameters that
+	// weren't given.
+	//
+	// nil if the schema doesn't have one.
+	Default interface{} `json:"default,omitempty"`
+
+	AnyOf         []*Schema          `json:"anyOf,omitempty"`
+	Discriminator *Discriminator     `json:"discriminator,omitempty"`
+	Enum          []interface{}      `json:"enum,omitempty"`
+	Format        string             `json:"format,omitempty"`
+	Items         *Schema            `json:"items,omitempty"`
+	MaxItems      int                `json:"maxItems,omitempty"`
+	MaxLength     int                `json:"maxLength,omitempty"`
+	Maximum       *float64           `json:"maximum,omitempty"`
+	MinItems      int                `json:"minItems,omitempty"`
+	MinLength     int                `json:"minLength,omitempty"`
+	Minimum       *float64           `json:"minimum,omitempty"`
+	Nullable      bool               `json:"nullable,omitempty"`
+	Pattern       string             `json:"pattern,omitempty"`
+	Properties    map[string]*Schema `json:"properties,omitempty"`
+	Required      []string           `json:"required,omitempty"`
+	Type          string             `json:"type,omitempty"`
+
+	// OneOf is a set of schemas that a value must satisfy exactly one of. In
+	// a request body, it's used to require exactly one of several sets of
+	// parameters, like either `customer` or `source`.
+	OneOf []*Schema `json:"oneOf,omitempty"`
+
+	// ReadOnly marks a property that only appears in responses. Requests
+	// that try to set it are rejected.
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// WriteOnly marks a property that only appears in requests, like a raw
+	// card number. It's never included in a response.
+	WriteOnly bool `json:"writeOnly,omitempty"`
 
 	// Ref is populated if this JSON Schema is actually a JSON reference, and
 	// it defines the location of the actual schema definition.
@@ -101,6 +151,48 @@ type Schema struct {
 	XResourceID         string              `json:"x-resourceId,omitempty"`
 }
 
+// DiscriminatorPropertyName gets the name of the property that identifies
+// which of the schema's `anyOf` branches an object is. That's the one named by
+// the schema's discriminator, or `object` (which every Stripe resource has) if
+// it doesn't have one.
+func (s *Schema) DiscriminatorPropertyName() string {
+	if s.Discriminator != nil {
+		return s.Discriminator.PropertyName
+	}
+	return "object"
+}
+
+// DiscriminatorValue gets the value of the discriminator property that
+// identifies one of the schema's `anyOf` branches. ref is the reference that
+// the branch was given as (if it was one), and branch is its dereferenced
+// schema.
+//
+// Returns an empty string if the branch can't be identified.
+func (s *Schema) DiscriminatorValue(ref string, branch *Schema) string {
+	if s.Discriminator != nil && ref != "" {
+		for value, mappedRef := range s.Discriminator.Mapping {
+			if mappedRef == ref {
+				return value
+			}
+		}
+	}
+
+	// A property that can only have one value identifies the branch, like
+	// `object` does for Stripe resources
+	propertySchema, ok := branch.Properties[s.DiscriminatorPropertyName()]
+	if ok && len(propertySchema.Enum) == 1 {
+		if value, ok := propertySchema.Enum[0].(string); ok {
+			return value
+		}
+	}
+
+	if s.Discriminator != nil && ref != "" {
+		return ref[strings.LastIndex(ref, "/")+1:]
+	}
+
+	return ""
+}
+
 func (s *Schema) String() string {
 	js, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
@@ -141,6 +233,13 @@ func (s *Schema) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Info is a struct for the info section of an OpenAPI specification.
+type Info struct {
+	// Version is the version of the API described by the specification. For
+	// Stripe's specification, this is an API version like "2018-07-27".
+	Version string `json:"version"`
+}
+
 // MediaType is a struct bucketing a request or response by media type in an
 // OpenAPI specification.
 type MediaType struct {
@@ -151,7 +250,7 @@ type MediaType struct {
 // specification.
 type Operation struct {
 	Description string                  `json:"description"`
-	OperationID string                  `json:"operation_id"`
+	OperationID string                  `json:"operationId"`
 	Parameters  []*Parameter            `json:"parameters"`
 	RequestBody *RequestBody            `json:"requestBody"`
 	Responses   map[StatusCode]Response `json:"responses"`
@@ -191,6 +290,7 @@ type ResourceID string
 // Spec is a struct representing an OpenAPI specification.
 type Spec struct {
 	Components Components                       `json:"components"`
+	Info       Info                             `json:"info"`
 	Paths      map[Path]map[HTTPVerb]*Operation `json:"paths"`
 }
 
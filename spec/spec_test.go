@@ -22,3 +22,37 @@ func TestUnmarshal_UnsupportedField(t *testing.T) {
 	err := json.Unmarshal(data, &schema)
 	assert.Error(t, err)
 }
+
+func TestSchema_DiscriminatorValue(t *testing.T) {
+	card := &Schema{
+		Properties: map[string]*Schema{
+			"object": {Enum: []interface{}{"card"}, Type: "string"},
+		},
+	}
+
+	// Without a discriminator, branches are identified by `object`
+	schema := &Schema{AnyOf: []*Schema{card}}
+	assert.Equal(t, "object", schema.DiscriminatorPropertyName())
+	assert.Equal(t, "card", schema.DiscriminatorValue("", card))
+	assert.Equal(t, "", schema.DiscriminatorValue("#/components/schemas/other", &Schema{}))
+
+	// With one, by its mapping or else the name of the branch's schema
+	schema = &Schema{
+		Discriminator: &Discriminator{
+			Mapping:      map[string]string{"sepa": "#/components/schemas/sepa_debit"},
+			PropertyName: "type",
+		},
+	}
+	assert.Equal(t, "type", schema.DiscriminatorPropertyName())
+	assert.Equal(t, "sepa", schema.DiscriminatorValue("#/components/schemas/sepa_debit", &Schema{}))
+	assert.Equal(t, "ideal", schema.DiscriminatorValue("#/components/schemas/ideal", &Schema{}))
+}
+
+func TestUnmarshal_Discriminator(t *testing.T) {
+	data := []byte(`{"discriminator": {"propertyName": "type", "mapping": {"card": "#/components/schemas/card"}}}`)
+	var schema Schema
+	err := json.Unmarshal(data, &schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "type", schema.Discriminator.PropertyName)
+	assert.Equal(t, "#/components/schemas/card", schema.Discriminator.Mapping["card"])
+}
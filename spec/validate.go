@@ -70,6 +70,13 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 		// directly.
 		jss["additionalProperties"] = oai.AdditionalProperties
 	}
+	if len(oai.AllOf) != 0 {
+		var jssAllOf = make([]interface{}, len(oai.AllOf))
+		for index, oaiSubschema := range oai.AllOf {
+			jssAllOf[index] = getJSONSchemaForOpenAPI3Schema(oaiSubschema)
+		}
+		jss["allOf"] = jssAllOf
+	}
 	if len(oai.AnyOf) != 0 {
 		var jssAnyOf = make([]interface{}, len(oai.AnyOf))
 		for index, oaiSubschema := range oai.AnyOf {
@@ -96,12 +103,37 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 	if oai.Items != nil {
 		jss["items"] = getJSONSchemaForOpenAPI3Schema(oai.Items)
 	}
+	// Lengths are converted to float64 because that's how the schema
+	// extractor expects numbers to look after being decoded from JSON. It
+	// silently ignores them otherwise.
+	if oai.MaxItems != 0 {
+		jss["maxItems"] = float64(oai.MaxItems)
+	}
 	if oai.MaxLength != 0 {
-		jss["maxLength"] = oai.MaxLength
+		jss["maxLength"] = float64(oai.MaxLength)
+	}
+	if oai.Maximum != nil {
+		jss["maximum"] = *oai.Maximum
+	}
+	if oai.MinItems != 0 {
+		jss["minItems"] = float64(oai.MinItems)
+	}
+	if oai.MinLength != 0 {
+		jss["minLength"] = float64(oai.MinLength)
+	}
+	if oai.Minimum != nil {
+		jss["minimum"] = *oai.Minimum
 	}
 	if oai.Pattern != "" {
 		jss["pattern"] = oai.Pattern
 	}
+	if len(oai.OneOf) != 0 {
+		var jssOneOf = make([]interface{}, len(oai.OneOf))
+		for index, oaiSubschema := range oai.OneOf {
+			jssOneOf[index] = getJSONSchemaForOpenAPI3Schema(oaiSubschema)
+		}
+		jss["oneOf"] = jssOneOf
+	}
 	if len(oai.Properties) != 0 {
 		var jssProperties = make(map[string]interface{})
 		for key, oaiSubschema := range oai.Properties {
@@ -115,6 +147,21 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 			jssRequired[index] = oaiValue
 		}
 		jss["required"] = jssRequired
+
+		// The validator only checks that properties it has a schema for are
+		// present, so a schema that just lists required properties (like a
+		// branch of a oneOf requiring one of several parameters) needs
+		// schemas that allow anything for them.
+		jssProperties, _ := jss["properties"].(map[string]interface{})
+		if jssProperties == nil {
+			jssProperties = make(map[string]interface{})
+		}
+		for _, name := range oai.Required {
+			if _, ok := jssProperties[name]; !ok {
+				jssProperties[name] = map[string]interface{}{}
+			}
+		}
+		jss["properties"] = jssProperties
 	}
 	if oai.Type != "" {
 		if oai.Nullable {
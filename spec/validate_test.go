@@ -47,3 +47,71 @@ func TestValidator_Reference(t *testing.T) {
 	assert.NoError(t, v.Validate("hello"))
 	assert.Error(t, v.Validate(123))
 }
+
+func TestValidator_Range(t *testing.T) {
+	minimum := 1.0
+	maximum := 10.0
+	schema := Schema{
+		Type:    "integer",
+		Maximum: &maximum,
+		Minimum: &minimum,
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate(5))
+	assert.Error(t, v.Validate(0))
+	assert.Error(t, v.Validate(11))
+}
+
+func TestValidator_StringConstraints(t *testing.T) {
+	schema := Schema{
+		Type:      "string",
+		MaxLength: 3,
+		MinLength: 2,
+		Pattern:   "^[a-z]+$",
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate("abc"))
+	assert.Error(t, v.Validate("a"))
+	assert.Error(t, v.Validate("abcd"))
+	assert.Error(t, v.Validate("AB"))
+}
+
+func TestValidator_ArrayConstraints(t *testing.T) {
+	schema := Schema{
+		Type:     "array",
+		Items:    &Schema{Type: "string"},
+		MaxItems: 2,
+		MinItems: 1,
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate([]interface{}{"a"}))
+	assert.NoError(t, v.Validate([]interface{}{"a", "b"}))
+	assert.Error(t, v.Validate([]interface{}{}))
+	assert.Error(t, v.Validate([]interface{}{"a", "b", "c"}))
+}
+
+func TestValidator_OneOf(t *testing.T) {
+	schema := Schema{
+		OneOf: []*Schema{
+			{Required: []string{"customer"}},
+			{Required: []string{"source"}},
+		},
+		Properties: map[string]*Schema{
+			"customer": {Type: "string"},
+			"source":   {Type: "string"},
+		},
+		Type: "object",
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate(map[string]interface{}{"customer": "cus_123"}))
+	assert.NoError(t, v.Validate(map[string]interface{}{"source": "tok_123"}))
+	assert.Error(t, v.Validate(map[string]interface{}{}))
+	assert.Error(t, v.Validate(map[string]interface{}{
+		"customer": "cus_123",
+		"source":   "tok_123",
+	}))
+}
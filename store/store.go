@@ -0,0 +1,245 @@
+// Package store provides an in-memory store for objects created while
+// stripe-mock is running in stateful mode. It allows objects created with a
+// `POST` to be retrieved, updated, listed, and deleted by later requests.
+package store
+
+import (
+	"sync"
+)
+
+//
+// Public functions
+//
+
+// CopyValue makes a deep copy of a value decoded from JSON or form-encoded
+// parameters, or of a generated one. Maps and slices are copied recursively
+// while other values are returned as is.
+func CopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		valueCopy := make(map[string]interface{}, len(v))
+		for key, subValue := range v {
+			valueCopy[key] = CopyValue(subValue)
+		}
+		return valueCopy
+
+	case []interface{}:
+		valueCopy := make([]interface{}, len(v))
+		for i, subValue := range v {
+			valueCopy[i] = CopyValue(subValue)
+		}
+		return valueCopy
+	}
+
+	return value
+}
+
+//
+// Public types
+//
+
+// ResourceStore is a concurrency-safe, in-memory store of API objects.
+//
+// Objects are partitioned by a resource ID, which is the name of their type as
+// it appears in their `object` field (e.g. "charge" or "customer"). Within a
+// resource, objects are keyed by their own `id`.
+//
+// All objects going in or coming out of the store are deep copied so that a
+// caller can't accidentally mutate what's stored.
+type ResourceStore struct {
+	mu sync.RWMutex
+
+	// accounts contains separate stores for the objects of connected
+	// accounts, keyed by account ID.
+	accounts map[string]*ResourceStore
+
+	resources map[string]*resourceObjects
+}
+
+// NewResourceStore initializes a new empty ResourceStore.
+func NewResourceStore() *ResourceStore {
+	return &ResourceStore{
+		accounts:  make(map[string]*ResourceStore),
+		resources: make(map[string]*resourceObjects),
+	}
+}
+
+// Account gets the store for the objects of a connected account, initializing
+// it if it doesn't exist yet. Objects stored for one account aren't visible
+// from the stores of other accounts, or from this store.
+func (s *ResourceStore) Account(accountID string) *ResourceStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accountStore, ok := s.accounts[accountID]
+	if !ok {
+		accountStore = NewResourceStore()
+		s.accounts[accountID] = accountStore
+	}
+	return accountStore
+}
+
+// Delete removes an object from the store. The object is remembered as
+// deleted so that Deleted can report on it afterwards.
+//
+// Returns true if the object existed in the store before it was deleted.
+func (s *ResourceStore) Delete(resourceID, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects := s.getOrCreateResource(resourceID)
+	objects.deleted[id] = struct{}{}
+
+	if _, ok := objects.objects[id]; !ok {
+		return false
+	}
+
+	delete(objects.objects, id)
+	for i, existingID := range objects.ids {
+		if existingID == id {
+			objects.ids = append(objects.ids[:i], objects.ids[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Deleted checks whether the given object was once in the store, but has
+// since been deleted.
+func (s *ResourceStore) Deleted(resourceID, id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects, ok := s.resources[resourceID]
+	if !ok {
+		return false
+	}
+
+	_, ok = objects.deleted[id]
+	return ok
+}
+
+// Get retrieves an object from the store. The second return value is false if
+// no object with the given resource and ID was found.
+func (s *ResourceStore) Get(resourceID, id string) (map[string]interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects, ok := s.resources[resourceID]
+	if !ok {
+		return nil, false
+	}
+
+	object, ok := objects.objects[id]
+	if !ok {
+		return nil, false
+	}
+
+	return copyMap(object), true
+}
+
+// Len returns the number of objects in the store, including those of
+// connected accounts. Deleted objects aren't counted.
+func (s *ResourceStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var n int
+	for _, objects := range s.resources {
+		n += len(objects.objects)
+	}
+	for _, accountStore := range s.accounts {
+		n += accountStore.Len()
+	}
+	return n
+}
+
+// List returns all objects of the given resource in the store. Like the Stripe
+// API, objects are returned in reverse chronological order, so the most
+// recently created object comes first.
+//
+// Updating an object with Put doesn't change its position in the list.
+func (s *ResourceStore) List(resourceID string) []map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects, ok := s.resources[resourceID]
+	if !ok {
+		return nil
+	}
+
+	list := make([]map[string]interface{}, 0, len(objects.ids))
+	for i := len(objects.ids) - 1; i >= 0; i-- {
+		list = append(list, copyMap(objects.objects[objects.ids[i]]))
+	}
+	return list
+}
+
+// Put inserts an object into the store, or replaces it if an object with the
+// same resource and ID was already present.
+func (s *ResourceStore) Put(resourceID, id string, object map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects := s.getOrCreateResource(resourceID)
+	if _, ok := objects.objects[id]; !ok {
+		objects.ids = append(objects.ids, id)
+	}
+
+	objects.objects[id] = copyMap(object)
+	delete(objects.deleted, id)
+}
+
+// Reset removes all objects from the store, including those of connected
+// accounts, and forgets about deleted ones.
+func (s *ResourceStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts = make(map[string]*ResourceStore)
+	s.resources = make(map[string]*resourceObjects)
+}
+
+// getOrCreateResource gets the objects for a resource, initializing them if
+// they haven't been initialized yet. It should only be called while holding a
+// write lock.
+func (s *ResourceStore) getOrCreateResource(resourceID string) *resourceObjects {
+	objects, ok := s.resources[resourceID]
+	if !ok {
+		objects = &resourceObjects{
+			deleted: make(map[string]struct{}),
+			objects: make(map[string]map[string]interface{}),
+		}
+		s.resources[resourceID] = objects
+	}
+	return objects
+}
+
+//
+// Private types
+//
+
+// resourceObjects holds all the objects for a single resource.
+type resourceObjects struct {
+	// deleted is a set of IDs for objects that have been deleted.
+	deleted map[string]struct{}
+
+	// ids contains the IDs of the resource's objects in the order in which
+	// they were inserted. It's used to keep list order stable.
+	ids []string
+
+	// objects maps IDs to objects.
+	objects map[string]map[string]interface{}
+}
+
+//
+// Private functions
+//
+
+// copyMap makes a deep copy of the given map.
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return CopyValue(m).(map[string]interface{})
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestResourceStore_Account(t *testing.T) {
+	s := NewResourceStore()
+	accountStore := s.Account("acct_123")
+	assert.True(t, accountStore == s.Account("acct_123"))
+
+	accountStore.Put("charge", "ch_123", map[string]interface{}{"id": "ch_123"})
+
+	_, ok := accountStore.Get("charge", "ch_123")
+	assert.True(t, ok)
+
+	// Not visible from the platform or from other accounts
+	_, ok = s.Get("charge", "ch_123")
+	assert.False(t, ok)
+	_, ok = s.Account("acct_456").Get("charge", "ch_123")
+	assert.False(t, ok)
+}
+
+func TestResourceStore_PutAndGet(t *testing.T) {
+	s := NewResourceStore()
+
+	s.Put("charge", "ch_123", map[string]interface{}{
+		"id":       "ch_123",
+		"metadata": map[string]interface{}{"foo": "bar"},
+	})
+
+	object, ok := s.Get("charge", "ch_123")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{
+		"id":       "ch_123",
+		"metadata": map[string]interface{}{"foo": "bar"},
+	}, object)
+
+	_, ok = s.Get("charge", "ch_456")
+	assert.False(t, ok)
+
+	_, ok = s.Get("customer", "ch_123")
+	assert.False(t, ok)
+}
+
+func TestResourceStore_CopiesObjects(t *testing.T) {
+	s := NewResourceStore()
+
+	object := map[string]interface{}{
+		"id":       "ch_123",
+		"metadata": map[string]interface{}{"foo": "bar"},
+	}
+	s.Put("charge", "ch_123", object)
+
+	// Mutating the original object shouldn't affect what's stored
+	object["metadata"].(map[string]interface{})["foo"] = "baz"
+
+	stored, ok := s.Get("charge", "ch_123")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", stored["metadata"].(map[string]interface{})["foo"])
+
+	// And neither should mutating an object that was retrieved
+	stored["id"] = "ch_456"
+
+	stored, ok = s.Get("charge", "ch_123")
+	assert.True(t, ok)
+	assert.Equal(t, "ch_123", stored["id"])
+}
+
+func TestResourceStore_Delete(t *testing.T) {
+	s := NewResourceStore()
+
+	s.Put("charge", "ch_123", map[string]interface{}{"id": "ch_123"})
+	assert.False(t, s.Deleted("charge", "ch_123"))
+
+	assert.True(t, s.Delete("charge", "ch_123"))
+	assert.True(t, s.Deleted("charge", "ch_123"))
+
+	_, ok := s.Get("charge", "ch_123")
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(s.List("charge")))
+
+	// Deleting an object that was never stored
+	assert.False(t, s.Delete("charge", "ch_456"))
+
+	// Putting an object back removes its deleted status
+	s.Put("charge", "ch_123", map[string]interface{}{"id": "ch_123"})
+	assert.False(t, s.Deleted("charge", "ch_123"))
+}
+
+func TestResourceStore_Len(t *testing.T) {
+	s := NewResourceStore()
+	assert.Equal(t, 0, s.Len())
+
+	s.Put("charge", "ch_123", map[string]interface{}{"id": "ch_123"})
+	s.Put("charge", "ch_456", map[string]interface{}{"id": "ch_456"})
+	s.Put("customer", "cus_123", map[string]interface{}{"id": "cus_123"})
+	s.Account("acct_123").Put("charge", "ch_789",
+		map[string]interface{}{"id": "ch_789"})
+	assert.Equal(t, 4, s.Len())
+
+	s.Delete("charge", "ch_123")
+	assert.Equal(t, 3, s.Len())
+}
+
+func TestResourceStore_List(t *testing.T) {
+	s := NewResourceStore()
+
+	assert.Equal(t, 0, len(s.List("charge")))
+
+	s.Put("charge", "ch_1", map[string]interface{}{"id": "ch_1"})
+	s.Put("charge", "ch_2", map[string]interface{}{"id": "ch_2"})
+	s.Put("charge", "ch_3", map[string]interface{}{"id": "ch_3"})
+	s.Put("customer", "cus_1", map[string]interface{}{"id": "cus_1"})
+
+	// Updating an object doesn't change its position
+	s.Put("charge", "ch_2", map[string]interface{}{"id": "ch_2", "paid": true})
+
+	assert.Equal(t, []map[string]interface{}{
+		{"id": "ch_3"},
+		{"id": "ch_2", "paid": true},
+		{"id": "ch_1"},
+	}, s.List("charge"))
+}
+
+func TestResourceStore_Reset(t *testing.T) {
+	s := NewResourceStore()
+	s.Put("charge", "ch_123", map[string]interface{}{"id": "ch_123"})
+	s.Delete("charge", "ch_123")
+	s.Put("charge", "ch_456", map[string]interface{}{"id": "ch_456"})
+	s.Account("acct_123").Put("charge", "ch_789",
+		map[string]interface{}{"id": "ch_789"})
+
+	s.Reset()
+
+	assert.Equal(t, 0, len(s.List("charge")))
+	assert.False(t, s.Deleted("charge", "ch_123"))
+	assert.Equal(t, 0, len(s.Account("acct_123").List("charge")))
+}